@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/totiks2012/Cyan_audio_player/internal/mpris"
+)
+
+// cy answers the same newline-delimited JSON protocol as cyan's control
+// socket, so `cyan ctl` can drive either player.
+
+type ctlRequest struct {
+	ID   int      `json:"id,omitempty"`
+	Cmd  string   `json:"cmd"`
+	Args []string `json:"args,omitempty"`
+}
+
+type ctlResponse struct {
+	ID    int         `json:"id,omitempty"`
+	OK    bool        `json:"ok"`
+	Error string      `json:"error,omitempty"`
+	Data  interface{} `json:"data,omitempty"`
+}
+
+type playerStatus struct {
+	Title       string  `json:"title"`
+	Path        string  `json:"path"`
+	Index       int     `json:"index"`
+	Count       int     `json:"count"`
+	Position    float64 `json:"position"`
+	Duration    float64 `json:"duration"`
+	Paused      bool    `json:"paused"`
+	Volume      int     `json:"volume"`
+	StreamTitle string  `json:"stream_title,omitempty"`
+	StreamMsg   string  `json:"stream_msg,omitempty"`
+}
+
+type ctlHandler func(args []string) (interface{}, error)
+
+func runtimeSocket(name string) string {
+	if d := os.Getenv("XDG_RUNTIME_DIR"); d != "" {
+		return filepath.Join(d, name+".sock")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("%s-%d.sock", name, os.Getuid()))
+}
+
+func parseSeek(s string) (string, string, error) {
+	s = strings.TrimSpace(s)
+	mode := "absolute"
+	if strings.HasSuffix(s, "%") {
+		s, mode = strings.TrimSuffix(s, "%"), "absolute-percent"
+	} else if strings.HasPrefix(s, "+") || strings.HasPrefix(s, "-") {
+		mode = "relative"
+	}
+	sign := 1.0
+	if strings.HasPrefix(s, "-") {
+		sign = -1
+	}
+	var secs float64
+	for _, part := range strings.Split(strings.TrimLeft(s, "+-"), ":") {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil || v < 0 {
+			return "", "", fmt.Errorf("bad position %q", s)
+		}
+		secs = secs*60 + v
+	}
+	return strconv.FormatFloat(sign*secs, 'f', -1, 64), mode, nil
+}
+
+type ctlServer struct {
+	ln       net.Listener
+	path     string
+	handlers map[string]ctlHandler
+	mu       sync.Mutex
+	conns    map[net.Conn]bool
+}
+
+func listenControl(path string, handlers map[string]ctlHandler) (*ctlServer, error) {
+	if c, err := net.Dial("unix", path); err == nil {
+		c.Close()
+		return nil, fmt.Errorf("%s is already served by another player", path)
+	}
+	_ = os.Remove(path)
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	_ = os.Chmod(path, 0600)
+	s := &ctlServer{ln: ln, path: path, handlers: handlers, conns: map[net.Conn]bool{}}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.handle(c)
+		}
+	}()
+	return s, nil
+}
+
+func (s *ctlServer) handle(c net.Conn) {
+	s.mu.Lock()
+	s.conns[c] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		c.Close()
+	}()
+	enc := json.NewEncoder(c)
+	sc := bufio.NewScanner(c)
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	for sc.Scan() {
+		var req ctlRequest
+		resp := ctlResponse{}
+		if err := json.Unmarshal(sc.Bytes(), &req); err != nil {
+			resp.Error = "bad request: " + err.Error()
+		} else if h, ok := s.handlers[req.Cmd]; !ok {
+			resp.ID, resp.Error = req.ID, "unknown command "+strconv.Quote(req.Cmd)
+		} else {
+			data, err := h(req.Args)
+			resp = ctlResponse{ID: req.ID, OK: err == nil, Data: data}
+			if err != nil {
+				resp.Error = err.Error()
+			}
+		}
+		c.SetWriteDeadline(time.Now().Add(2 * time.Second))
+		if enc.Encode(resp) != nil {
+			return
+		}
+	}
+}
+
+func (s *ctlServer) Close() {
+	s.ln.Close()
+	s.mu.Lock()
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	_ = os.Remove(s.path)
+}
+
+func (st playerStatus) mpris() mpris.Status {
+	return mpris.Status{Title: st.Title, Path: st.Path, Index: st.Index, Position: st.Position, Duration: st.Duration,
+		Paused: st.Paused, Volume: st.Volume, StreamTitle: st.StreamTitle}
+}
+
+// handlerBackend lets MPRIS drive cy through the control-socket handlers.
+type handlerBackend map[string]ctlHandler
+
+func (h handlerBackend) Status() mpris.Status {
+	v, _ := h["status"](nil)
+	st, _ := v.(playerStatus)
+	return st.mpris()
+}
+
+func (h handlerBackend) Do(cmd string, args ...string) error {
+	_, err := h[cmd](args)
+	return err
+}
@@ -28,6 +28,14 @@ import (
 
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
+	"github.com/totiks2012/Cyan_audio_player/internal/cmdline"
+	"github.com/totiks2012/Cyan_audio_player/internal/fuzzy"
+	"github.com/totiks2012/Cyan_audio_player/internal/keymap"
+	"github.com/totiks2012/Cyan_audio_player/internal/libindex"
+	"github.com/totiks2012/Cyan_audio_player/internal/mpris"
+	"github.com/totiks2012/Cyan_audio_player/internal/stream"
+	"github.com/totiks2012/Cyan_audio_player/internal/tags"
+	"github.com/totiks2012/Cyan_audio_player/internal/theme"
 )
 
 const stateFileSuffix = ".cyan_player_state"
@@ -76,6 +84,17 @@ type PlayerState struct {
 	CurrentTrack string
 	Position     float64
 	Volume       int
+	Notice       string
+	NoticeAt     time.Time
+	Queue        []string
+	QueueFrom    string
+}
+
+func (p *PlayerState) notify(msg string) {
+	p.mu.Lock()
+	p.Notice = msg
+	p.NoticeAt = time.Now()
+	p.mu.Unlock()
 }
 
 func (p *PlayerState) save() {
@@ -153,15 +172,20 @@ func loadState(dir string) (track string, pos float64) {
 }
 
 type MpvEngine struct {
-	mpv      *C.mpv_handle
-	cmdChan  chan func()
-	endChan  chan struct{}
-	loadChan chan struct{}
-	stopChan chan struct{}
-	closed   int32
-	wg       sync.WaitGroup
-	posRaw   uint64
-	volRaw   int64
+	mpv       *C.mpv_handle
+	cmdChan   chan func()
+	endChan   chan stream.EndEvent
+	loadChan  chan struct{}
+	stopChan  chan struct{}
+	closed    int32
+	wg        sync.WaitGroup
+	posRaw    uint64
+	volRaw    int64
+	icyTitle  atomic.Value
+	loadedAt  int64
+	cacheWait int32
+	cachePct  int64
+	cacheRaw  uint64
 }
 
 func NewMpvEngine() *MpvEngine {
@@ -178,15 +202,27 @@ func NewMpvEngine() *MpvEngine {
 
 	cPos := C.CString("time-pos")
 	cVol := C.CString("volume")
+	cIcy := C.CString("metadata/by-key/icy-title")
+	cWait := C.CString("paused-for-cache")
+	cPct := C.CString("cache-buffering-state")
+	cCache := C.CString("demuxer-cache-duration")
 	C.mpv_observe_property(ctx, 10, cPos, C.MPV_FORMAT_DOUBLE)
 	C.mpv_observe_property(ctx, 20, cVol, C.MPV_FORMAT_INT64)
+	C.mpv_observe_property(ctx, 30, cIcy, C.MPV_FORMAT_STRING)
+	C.mpv_observe_property(ctx, 40, cWait, C.MPV_FORMAT_FLAG)
+	C.mpv_observe_property(ctx, 50, cPct, C.MPV_FORMAT_INT64)
+	C.mpv_observe_property(ctx, 60, cCache, C.MPV_FORMAT_DOUBLE)
 	C.free(unsafe.Pointer(cPos))
 	C.free(unsafe.Pointer(cVol))
+	C.free(unsafe.Pointer(cIcy))
+	C.free(unsafe.Pointer(cWait))
+	C.free(unsafe.Pointer(cPct))
+	C.free(unsafe.Pointer(cCache))
 
 	return &MpvEngine{
 		mpv:      ctx,
 		cmdChan:  make(chan func(), 32),
-		endChan:  make(chan struct{}, 1),
+		endChan:  make(chan stream.EndEvent, 1),
 		loadChan: make(chan struct{}, 1),
 		stopChan: make(chan struct{}),
 	}
@@ -214,6 +250,43 @@ func (e *MpvEngine) getInt(name string) int {
 	return int(val)
 }
 
+func (e *MpvEngine) streamTitle() string {
+	t, _ := e.icyTitle.Load().(string)
+	return strings.TrimSpace(t)
+}
+
+func (e *MpvEngine) getFlag(name string) bool {
+	if atomic.LoadInt32(&e.closed) == 1 {
+		return false
+	}
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+	var val C.int
+	C.mpv_get_property(e.mpv, cName, C.MPV_FORMAT_FLAG, unsafe.Pointer(&val))
+	return val == 1
+}
+
+func (e *MpvEngine) command(args ...string) {
+	cargs := make([]*C.char, len(args)+1)
+	for i, a := range args {
+		cargs[i] = C.CString(a)
+	}
+	C.mpv_command(e.mpv, &cargs[0])
+	for _, c := range cargs[:len(args)] {
+		C.free(unsafe.Pointer(c))
+	}
+}
+
+func (e *MpvEngine) cacheStatus() string {
+	if atomic.LoadInt32(&e.cacheWait) == 1 {
+		return fmt.Sprintf("Buf: %d%%", atomic.LoadInt64(&e.cachePct))
+	}
+	if sec := math.Float64frombits(atomic.LoadUint64(&e.cacheRaw)); sec > 0 {
+		return fmt.Sprintf("Cache: %ds", int(sec))
+	}
+	return ""
+}
+
 func (e *MpvEngine) Start() {
 	e.wg.Add(1)
 	go func() {
@@ -224,24 +297,49 @@ func (e *MpvEngine) Start() {
 			case C.MPV_EVENT_SHUTDOWN:
 				return
 			case C.MPV_EVENT_FILE_LOADED:
+				atomic.StoreInt64(&e.loadedAt, time.Now().UnixNano())
 				select {
 				case e.loadChan <- struct{}{}:
 				default:
 				}
 			case C.MPV_EVENT_END_FILE:
 				ef := (*C.mpv_event_end_file)(event.data)
-				if ef.reason == C.MPV_END_FILE_REASON_EOF {
+				ev := stream.EndEvent{Reason: stream.EndReason(ef.reason)}
+				if ev.Reason == stream.EndError {
+					ev.Err = C.GoString(C.mpv_error_string(ef.error))
+				}
+				if ev.Reason == stream.EndEOF || ev.Reason == stream.EndError {
 					select {
-					case e.endChan <- struct{}{}:
+					case e.endChan <- ev:
 					default:
 					}
 				}
 			case C.MPV_EVENT_PROPERTY_CHANGE:
 				prop := (*C.mpv_event_property)(event.data)
+				if event.reply_userdata == 30 {
+					title := ""
+					if prop.format == C.MPV_FORMAT_STRING && prop.data != nil {
+						title = C.GoString(*(**C.char)(prop.data))
+					}
+					e.icyTitle.Store(title)
+					continue
+				}
 				if prop.data == nil {
+					if event.reply_userdata == 40 {
+						atomic.StoreInt32(&e.cacheWait, 0)
+					} else if event.reply_userdata == 60 {
+						atomic.StoreUint64(&e.cacheRaw, 0)
+					}
 					continue
 				}
-				if event.reply_userdata == 10 {
+				if event.reply_userdata == 40 {
+					atomic.StoreInt32(&e.cacheWait, int32(*(*C.int)(prop.data)))
+				} else if event.reply_userdata == 50 {
+					atomic.StoreInt64(&e.cachePct, int64(*(*C.int64_t)(prop.data)))
+				} else if event.reply_userdata == 60 {
+					val := *(*C.double)(prop.data)
+					atomic.StoreUint64(&e.cacheRaw, math.Float64bits(float64(val)))
+				} else if event.reply_userdata == 10 {
 					val := *(*C.double)(prop.data)
 					atomic.StoreUint64(&e.posRaw, math.Float64bits(float64(val)))
 				} else if event.reply_userdata == 20 {
@@ -305,7 +403,7 @@ func buildList(dir, currentTrack string) []DirEntry {
 	var items []DirEntry
 	if dir != "/" {
 		items = append(items, DirEntry{
-			Display: "🔙 ..",
+			Display: glyphs.Up + "..",
 			Path:    filepath.Dir(dir),
 			IsDir:   true,
 		})
@@ -322,14 +420,14 @@ func buildList(dir, currentTrack string) []DirEntry {
 		fullPath := filepath.Join(dir, name)
 		if e.IsDir() {
 			items = append(items, DirEntry{
-				Display: "📁 " + name,
+				Display: glyphs.Dir + name,
 				Path:    fullPath,
 				IsDir:   true,
 			})
 		} else if isAudioFile(name) {
-			prefix := "🎵"
+			prefix := glyphs.File
 			if fullPath == currentTrack {
-				prefix = "▶"
+				prefix = glyphs.Playing
 			}
 			items = append(items, DirEntry{
 				Display: prefix + " " + name,
@@ -337,7 +435,7 @@ func buildList(dir, currentTrack string) []DirEntry {
 			})
 		} else if isPlaylist(name) {
 			items = append(items, DirEntry{
-				Display: "📋 " + name,
+				Display: glyphs.Playlist + name,
 				Path:    fullPath,
 			})
 		}
@@ -345,15 +443,33 @@ func buildList(dir, currentTrack string) []DirEntry {
 	return items
 }
 
-func fuzzyMatch(name, filter string) bool {
-	lower := strings.ToLower(name)
-	fi := 0
-	for ni := 0; ni < len(lower) && fi < len(filter); ni++ {
-		if lower[ni] == filter[fi] {
-			fi++
+func dirTracks(dir string) []string {
+	var tracks []string
+	for _, e := range buildList(dir, "") {
+		if !e.IsDir && isAudioFile(e.Path) {
+			tracks = append(tracks, e.Path)
 		}
 	}
-	return fi == len(filter)
+	return tracks
+}
+
+// neighbourTrack returns the track step positions away from track in dir,
+// wrapping around; "" when there is nothing else to play.
+func neighbourTrack(dir, track string, step int) string {
+	tracks := dirTracks(dir)
+	curIdx := -1
+	for i, t := range tracks {
+		if t == track {
+			curIdx = i
+		}
+	}
+	if len(tracks) == 0 || (len(tracks) == 1 && curIdx == 0) {
+		return ""
+	}
+	if curIdx < 0 {
+		return tracks[0]
+	}
+	return tracks[((curIdx+step)%len(tracks)+len(tracks))%len(tracks)]
 }
 
 func isPlaylist(name string) bool {
@@ -401,46 +517,52 @@ func parseM3U(path string) []M3UEntry {
 	return entries
 }
 
+// parseColor reads a theme colour; "" and anything unknown is the
+// terminal's own.
 func parseColor(s string) tcell.Color {
-	s = strings.TrimSpace(s)
-	if len(s) == 0 || s[0] != '#' {
-		switch strings.ToLower(s) {
-		case "black":
-			return tcell.ColorBlack
-		case "white":
-			return tcell.ColorWhite
-		case "red":
-			return tcell.ColorRed
-		case "green":
-			return tcell.ColorGreen
-		case "yellow":
-			return tcell.ColorYellow
-		case "blue":
-			return tcell.ColorBlue
-		case "teal":
-			return tcell.ColorTeal
-		case "aqua":
-			return tcell.ColorAqua
-		case "gray", "grey":
-			return tcell.ColorGray
-		case "purple":
-			return tcell.ColorPurple
-		}
+	s = theme.NormalizeColor(s)
+	if s == "" {
 		return tcell.ColorDefault
 	}
-	s = s[1:]
-	if len(s) == 3 {
-		s = string(s[0]) + string(s[0]) + string(s[1]) + string(s[1]) + string(s[2]) + string(s[2])
-	}
-	if len(s) != 6 {
-		return tcell.ColorDefault
+	if s[0] != '#' {
+		n, _ := strconv.Atoi(s)
+		return tcell.PaletteColor(n)
 	}
-	r, _ := strconv.ParseInt(s[0:2], 16, 32)
-	g, _ := strconv.ParseInt(s[2:4], 16, 32)
-	b, _ := strconv.ParseInt(s[4:6], 16, 32)
+	r, _ := strconv.ParseInt(s[1:3], 16, 32)
+	g, _ := strconv.ParseInt(s[3:5], 16, 32)
+	b, _ := strconv.ParseInt(s[5:7], 16, 32)
 	return tcell.NewRGBColor(int32(r), int32(g), int32(b))
 }
 
+// glyphs are the symbols of the current theme.
+var glyphs theme.Glyphs
+
+var cyGlyphs = theme.Glyphs{Up: "🔙 ", Dir: "📁 ", File: "🎵", Playlist: "📋 ", Playing: "▶", Mark: "✓ ",
+	Bar: "━", Star: "★", StarEmpty: "☆", Loved: "♥"}
+
+// cyTheme is cy's own look with the preset and the colour keys of the fzi
+// config on top; the shared theme file goes over it.
+func cyTheme(cfg map[string]string) (theme.Theme, error) {
+	t := theme.Theme{Glyphs: cyGlyphs}
+	t, _ = t.WithPreset("fzi")
+	t, err := t.WithPreset(cfg["theme"])
+	for key, field := range map[string]*string{
+		"background": &t.Background, "text": &t.Text, "border_color": &t.Border, "title_color": &t.Title,
+		"input_bg": &t.InputBg, "input_text": &t.InputText, "label_color": &t.Accent,
+		"selected_bg": &t.CursorBg, "selected_text": &t.CursorText,
+		"status_text": &t.StatusText, "status_bg": &t.StatusBg,
+	} {
+		if v, ok := cfg[key]; ok {
+			*field = v
+		}
+	}
+	t, ferr := theme.Load(t)
+	if ferr != nil {
+		err = ferr
+	}
+	return t, err
+}
+
 func main() {
 	os.Setenv("PIPEWIRE_DEBUG", "0")
 
@@ -475,50 +597,21 @@ func main() {
 	var telemetryMu sync.Mutex
 
 	cfg := loadConfig()
+	library := loadLibrary(libindex.File())
 
 	input := tview.NewInputField().
 		SetLabel("🔍 fzi> ").
 		SetFieldWidth(0)
-	if v, ok := cfg["input_bg"]; ok {
-		input.SetFieldBackgroundColor(parseColor(v))
-	} else {
-		input.SetFieldBackgroundColor(tcell.ColorBlack)
-	}
-	if v, ok := cfg["input_text"]; ok {
-		input.SetFieldTextColor(parseColor(v))
-	} else {
-		input.SetFieldTextColor(tcell.ColorWhite)
-	}
-	if v, ok := cfg["label_color"]; ok {
-		input.SetLabelColor(parseColor(v))
-	} else {
-		input.SetLabelColor(tcell.ColorYellow)
-	}
+	prompt := tview.NewInputField().
+		SetLabel(": ").
+		SetFieldWidth(0)
 
 	list := tview.NewList()
 	list.SetHighlightFullLine(true)
 	list.ShowSecondaryText(false)
-	if v, ok := cfg["selected_bg"]; ok {
-		list.SetSelectedBackgroundColor(parseColor(v))
-	} else {
-		list.SetSelectedBackgroundColor(tcell.ColorBlue)
-	}
-	if v, ok := cfg["selected_text"]; ok {
-		list.SetSelectedTextColor(parseColor(v))
-	} else {
-		list.SetSelectedTextColor(tcell.ColorWhite)
-	}
 
 	statusBar := tview.NewTextView()
 	statusBar.SetDynamicColors(true)
-	if v, ok := cfg["status_text"]; ok {
-		statusBar.SetTextColor(parseColor(v))
-	} else {
-		statusBar.SetTextColor(tcell.ColorYellow)
-	}
-	if v, ok := cfg["status_bg"]; ok {
-		statusBar.SetBackgroundColor(parseColor(v))
-	}
 	statusBar.SetText("▶ CY Player")
 
 	stopTel := func() {
@@ -555,12 +648,30 @@ func main() {
 					app.QueueUpdateDraw(func() {
 						player.mu.RLock()
 						track := filepath.Base(player.CurrentTrack)
+						if title := engine.streamTitle(); title != "" {
+							track = "♪ " + title
+						}
 						min := int(player.Position) / 60
 						sec := int(player.Position) % 60
 						text := fmt.Sprintf("Pos: %d:%02d | Vol: %d%%", min, sec, player.Volume)
 						if track != "" && track != "." {
+							if r := library.label(player.CurrentTrack); r != "" {
+								track += " " + r
+							}
 							text = track + " | " + text
 						}
+						if stream.IsStream(player.CurrentTrack) {
+							if cs := engine.cacheStatus(); cs != "" {
+								text += " | " + cs
+							}
+						}
+						notice := player.Notice
+						if notice != "" && atomic.LoadInt64(&engine.loadedAt) > player.NoticeAt.UnixNano() {
+							notice = ""
+						}
+						if notice != "" {
+							text += " | " + notice
+						}
 						player.mu.RUnlock()
 						statusBar.SetText(text)
 					})
@@ -584,27 +695,75 @@ func main() {
 
 	var entries []DirEntry
 	var filtered []DirEntry
-	var m3uEntries []M3UEntry
+	var m3uEntries, m3uShown []M3UEntry
 	var browsingM3U bool
+	var lastFilter string
+	var texts tagTexts
+	var finding bool
+	var found []findHit
 
+	// rebuild lists the folder or playlist again through the filter, best
+	// matches first. The highlight stays on its entry unless the filter
+	// was just edited, which moves it to the best match.
 	rebuild := func(filter string) {
+		selected := ""
+		if idx := list.GetCurrentItem(); browsingM3U && idx >= 0 && idx < len(m3uShown) {
+			selected = m3uShown[idx].URL
+		} else if finding && idx >= 0 && idx < len(found) {
+			selected = found[idx].Path
+		} else if !browsingM3U && !finding && idx >= 0 && idx < len(filtered) {
+			selected = filtered[idx].Path
+		}
+		keepSel := filter == lastFilter || strings.TrimSpace(filter) == ""
+		lastFilter = filter
 		list.Clear()
+		cur := 0
+		if finding {
+			found = nil
+			label := fmt.Sprintf("⌕ find %d> ", library.size())
+			q, keep := library.filter(filter)
+			if library.busy() {
+				label = "⟳ find> "
+			} else if strings.TrimSpace(q) != "" || keep != nil {
+				var total int
+				found, total = library.find(q, keep)
+				label = fmt.Sprintf("⌕ find %d/%d> ", len(found), total)
+			}
+			input.SetLabel(label)
+			for i, h := range found {
+				item := markMatches(h.Label, h.Pos)
+				if r := library.label(h.Path); r != "" {
+					item += "  " + r
+				}
+				list.AddItem(item, "", 0, nil)
+				if keepSel && h.Path == selected {
+					cur = i
+				}
+			}
+			list.SetCurrentItem(cur)
+			return
+		}
 		if browsingM3U {
-			var shown []M3UEntry
-			shown = m3uEntries
-			if filter != "" {
-				lower := strings.ToLower(filter)
-				var f []M3UEntry
-				for _, e := range shown {
-					if fuzzyMatch(e.Name, lower) {
-						f = append(f, e)
-					}
+			m3uShown = m3uEntries
+			var pos [][]int
+			if strings.TrimSpace(filter) != "" {
+				m3uShown = nil
+				for _, r := range fuzzy.Rank(len(m3uEntries), filter, func(i int) []string { return []string{m3uEntries[i].Name} }) {
+					m3uShown = append(m3uShown, m3uEntries[r.Index])
+					pos = append(pos, r.Pos)
 				}
-				shown = f
 			}
-			for _, e := range shown {
-				list.AddItem(e.Name, "", 0, nil)
+			for i, e := range m3uShown {
+				var p []int
+				if pos != nil {
+					p = pos[i]
+				}
+				list.AddItem(markMatches(e.Name, p), "", 0, nil)
+				if keepSel && e.URL == selected {
+					cur = i
+				}
 			}
+			list.SetCurrentItem(cur)
 			return
 		}
 		player.mu.RLock()
@@ -613,33 +772,77 @@ func main() {
 		player.mu.RUnlock()
 		entries = buildList(dir, track)
 		filtered = entries
-		if filter != "" {
-			lowerFilter := strings.ToLower(filter)
-			var f []DirEntry
-			for _, e := range filtered {
-				if fuzzyMatch(e.Display, lowerFilter) {
-					f = append(f, e)
+		var pos [][]int
+		filter, keep := library.filter(filter)
+		if strings.TrimSpace(filter) != "" || keep != nil {
+			var cand []DirEntry
+			for _, e := range entries {
+				if keep != nil && (e.IsDir || !isAudioFile(e.Path) || !keep(e.Path)) {
+					continue
 				}
+				cand = append(cand, e)
+			}
+			filtered = nil
+			for _, r := range fuzzy.Rank(len(cand), filter, func(i int) []string {
+				e := cand[i]
+				if e.IsDir || !isAudioFile(e.Path) {
+					return []string{e.Display}
+				}
+				return append([]string{e.Display}, texts.get(e.Path)...)
+			}) {
+				filtered = append(filtered, cand[r.Index])
+				pos = append(pos, r.Pos)
 			}
-			filtered = f
 		}
-		for _, e := range filtered {
-			list.AddItem(e.Display, "", 0, nil)
+		for i, e := range filtered {
+			var p []int
+			if pos != nil {
+				p = pos[i]
+			}
+			label := markMatches(e.Display, p)
+			if !e.IsDir {
+				if r := library.label(e.Path); r != "" {
+					label += "  " + r
+				}
+			}
+			list.AddItem(label, "", 0, nil)
+			if keepSel && e.Path == selected {
+				cur = i
+			}
 		}
+		list.SetCurrentItem(cur)
 	}
 
 	rebuild("")
 
+	playTrack := func(path string) {
+		player.mu.Lock()
+		player.CurrentTrack = path
+		player.mu.Unlock()
+		engine.Do(func() { engine.command("loadfile", path) })
+	}
+
 	handleSelect := func() {
 		idx := list.GetCurrentItem()
 		if idx < 0 {
 			return
 		}
+		if finding {
+			if idx < len(found) {
+				path := found[idx].Path
+				player.mu.Lock()
+				player.CurrentDir = filepath.Dir(path)
+				player.mu.Unlock()
+				playTrack(path)
+				rebuild(input.GetText())
+			}
+			return
+		}
 		if browsingM3U {
-			if idx >= len(m3uEntries) {
+			if idx >= len(m3uShown) {
 				return
 			}
-			entry := m3uEntries[idx]
+			entry := m3uShown[idx]
 			player.mu.Lock()
 			player.CurrentTrack = entry.URL
 			player.mu.Unlock()
@@ -688,130 +891,574 @@ func main() {
 		rebuild(input.GetText())
 	}
 
+	// rating applies to the highlighted file, or to the track that is
+	// playing when the highlight is on a folder
+	rateTarget := func() string {
+		if idx := list.GetCurrentItem(); finding && idx >= 0 && idx < len(found) {
+			return found[idx].Path
+		} else if !finding && !browsingM3U && idx >= 0 && idx < len(filtered) {
+			if e := filtered[idx]; !e.IsDir && isAudioFile(e.Path) {
+				return e.Path
+			}
+		}
+		player.mu.RLock()
+		defer player.mu.RUnlock()
+		if stream.IsStream(player.CurrentTrack) {
+			return ""
+		}
+		return player.CurrentTrack
+	}
+
+	rate := func(fn func(*libindex.Track)) {
+		path := rateTarget()
+		if path == "" {
+			return
+		}
+		idx := list.GetCurrentItem()
+		old := library.get(path)
+		e := library.update(path, fn)
+		label := strings.TrimSpace(stars(e.Rating, e.Loved))
+		if label == "" {
+			label = glyphs.StarEmpty
+		}
+		player.notify(label + " " + filepath.Base(path))
+		rebuild(input.GetText())
+		list.SetCurrentItem(idx)
+		if cfg["write_rating_tags"] == "true" && e.Rating != old.Rating {
+			go func() {
+				if err := tags.WriteRating(path, e.Rating); err != nil {
+					player.notify("tags not written: " + err.Error())
+				}
+			}()
+		}
+	}
+
 	input.SetChangedFunc(func(text string) {
 		rebuild(text)
 	})
 
+	// find searches the whole library instead of the folder; the index is
+	// brought up to date in the background when find opens.
+	setFinding := func(on bool) {
+		finding = on
+		browsingM3U = false
+		if !on {
+			input.SetLabel("🔍 fzi> ")
+		} else if library.rescan(libraryDirs(cfg), func() {
+			app.QueueUpdateDraw(func() {
+				if finding {
+					rebuild(input.GetText())
+				}
+			})
+		}) {
+			player.notify("scanning library…")
+		}
+		lastFilter = ""
+		input.SetText("")
+		rebuild("")
+	}
+
+	// enqueue puts files after the current track; when the queue runs out
+	// playback goes on in the folder it left.
+	enqueue := func(paths ...string) int {
+		n := 0
+		player.mu.Lock()
+		for _, p := range paths {
+			if stream.IsStream(p) || isAudioFile(p) {
+				player.Queue = append(player.Queue, p)
+				n++
+			}
+		}
+		player.mu.Unlock()
+		return n
+	}
+	selectedFile := func() string {
+		idx := list.GetCurrentItem()
+		if finding && idx >= 0 && idx < len(found) {
+			return found[idx].Path
+		}
+		if browsingM3U && idx >= 0 && idx < len(m3uShown) {
+			return m3uShown[idx].URL
+		}
+		if !finding && !browsingM3U && idx >= 0 && idx < len(filtered) && !filtered[idx].IsDir {
+			return filtered[idx].Path
+		}
+		return ""
+	}
+	// nextUp is what plays after track: the queue first, then the folder.
+	nextUp := func(dir, track string) string {
+		player.mu.Lock()
+		if len(player.Queue) > 0 {
+			next := player.Queue[0]
+			player.Queue = player.Queue[1:]
+			if player.QueueFrom == "" {
+				player.QueueFrom = track
+			}
+			player.mu.Unlock()
+			return next
+		}
+		if player.QueueFrom != "" {
+			track, dir = player.QueueFrom, filepath.Dir(player.QueueFrom)
+			player.QueueFrom = ""
+		}
+		player.mu.Unlock()
+		return neighbourTrack(dir, track, 1)
+	}
+
+	// the command prompt takes the place of the search field while open
 	flex := tview.NewFlex().SetDirection(tview.FlexRow)
-	flex.AddItem(input, 3, 0, true)
-	flex.AddItem(list, 0, 1, false)
-	flex.AddItem(statusBar, 1, 0, false)
+	layout := func(top tview.Primitive) {
+		flex.Clear()
+		flex.AddItem(top, 3, 0, true)
+		flex.AddItem(list, 0, 1, false)
+		flex.AddItem(statusBar, 1, 0, false)
+		app.SetFocus(top)
+	}
+	layout(input)
 
-	flex.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
-		switch event.Key() {
-		case tcell.KeyUp:
-			if list.GetItemCount() > 0 {
-				idx := list.GetCurrentItem()
-				if idx > 0 {
-					list.SetCurrentItem(idx - 1)
-				}
+	keys, keyWarn := keymap.New(cyKeyDefs, keyOverrides(cfg), cfg["leader"])
+	if len(keyWarn) > 0 {
+		player.notify("keys: " + strings.Join(keyWarn, "; "))
+	}
+	setVolume := func(delta int) {
+		engine.Do(func() {
+			cProp := C.CString("volume")
+			next := C.int64_t(engine.getInt("volume") + delta)
+			if next < 0 {
+				next = 0
 			}
-			return nil
-		case tcell.KeyDown:
-			if list.GetItemCount() > 0 {
-				idx := list.GetCurrentItem()
-				if idx < list.GetItemCount()-1 {
-					list.SetCurrentItem(idx + 1)
-				}
+			if next > 130 {
+				next = 130
+			}
+			C.mpv_set_property(engine.mpv, cProp, C.MPV_FORMAT_INT64, unsafe.Pointer(&next))
+			C.free(unsafe.Pointer(cProp))
+		})
+	}
+	seekBy := func(secs string) {
+		engine.Do(func() {
+			cSeek := C.CString("seek")
+			cVal := C.CString(secs)
+			C.mpv_cmd_string(engine.mpv, cSeek, cVal)
+			C.free(unsafe.Pointer(cSeek))
+			C.free(unsafe.Pointer(cVal))
+		})
+	}
+	moveBy := func(d int) {
+		if idx := list.GetCurrentItem() + d; idx >= 0 && idx < list.GetItemCount() {
+			list.SetCurrentItem(idx)
+		}
+	}
+
+	helpView := tview.NewTextView().SetDynamicColors(true)
+	helpView.SetBorder(true).SetTitle(" keys — remap with key.<action> in ~/.config/fzi/config, any key closes ")
+
+	var curTheme theme.Theme
+	applyTheme := func() {
+		t, err := cyTheme(cfg)
+		curTheme, glyphs = t, t.Glyphs
+		bg := tview.Styles.PrimitiveBackgroundColor
+		if t.Background != "" {
+			bg = parseColor(t.Background)
+		}
+		text := tview.Styles.PrimaryTextColor
+		if t.Text != "" {
+			text = parseColor(t.Text)
+		}
+		for _, f := range []*tview.InputField{input, prompt} {
+			f.SetFieldBackgroundColor(parseColor(t.InputBg))
+			f.SetFieldTextColor(parseColor(t.InputText))
+			f.SetLabelColor(parseColor(t.Accent))
+			f.SetBackgroundColor(bg)
+		}
+		list.SetMainTextColor(text)
+		list.SetSelectedBackgroundColor(parseColor(t.CursorBg))
+		list.SetSelectedTextColor(parseColor(t.CursorText))
+		list.SetBackgroundColor(bg)
+		statusBar.SetTextColor(parseColor(t.StatusText))
+		if t.StatusBg != "" {
+			statusBar.SetBackgroundColor(parseColor(t.StatusBg))
+		} else {
+			statusBar.SetBackgroundColor(bg)
+		}
+		helpView.SetBorderColor(parseColor(t.Border))
+		if t.Title != "" {
+			helpView.SetTitleColor(parseColor(t.Title))
+		} else {
+			helpView.SetTitleColor(parseColor(t.Accent))
+		}
+		helpView.SetTextColor(text)
+		helpView.SetBackgroundColor(bg)
+		if err != nil {
+			player.notify("theme: " + err.Error())
+		}
+	}
+	applyTheme()
+	rebuild(input.GetText())
+	go func() {
+		var w theme.Watcher
+		w.Changed()
+		for range time.Tick(time.Second) {
+			if w.Changed() {
+				app.QueueUpdateDraw(func() {
+					applyTheme()
+					rebuild(input.GetText())
+				})
+			}
+		}
+	}()
+	helpView.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch event.Key() {
+		case tcell.KeyUp, tcell.KeyDown, tcell.KeyPgUp, tcell.KeyPgDn:
+			return event
+		}
+		app.SetRoot(flex, true)
+		return nil
+	})
+
+	actions := map[string]func(){
+		"up":   func() { moveBy(-1) },
+		"down": func() { moveBy(1) },
+		"open": handleSelect,
+		"back": func() {
+			if finding {
+				setFinding(false)
+				return
 			}
-			return nil
-		case tcell.KeyEnter:
-			handleSelect()
-			return nil
-		case tcell.KeyEscape:
 			if browsingM3U {
 				browsingM3U = false
 				m3uEntries = nil
 				rebuild(input.GetText())
-				return nil
+				return
 			}
 			stopTel()
 			app.Stop()
-			return nil
-		case tcell.KeyCtrlZ:
+		},
+		"clear_search": func() { input.SetText("") },
+		"find":         func() { setFinding(!finding) },
+		"enqueue": func() {
+			if p := selectedFile(); p != "" && enqueue(p) > 0 {
+				player.notify("queued " + filepath.Base(p))
+			}
+		},
+		"reveal": func() {
+			p := selectedFile()
+			if p == "" || stream.IsStream(p) {
+				return
+			}
+			player.mu.Lock()
+			player.CurrentDir = filepath.Dir(p)
+			player.mu.Unlock()
+			setFinding(false)
+			for i, e := range filtered {
+				if e.Path == p {
+					list.SetCurrentItem(i)
+				}
+			}
+		},
+		"help": func() {
+			helpView.SetText(tview.Escape(strings.Join(keys.HelpLines(), "\n")))
+			helpView.ScrollToBeginning()
+			app.SetRoot(helpView, true)
+		},
+		"palette": func() {
+			prompt.SetText("")
+			layout(prompt)
+		},
+		"toggle": func() {
+			engine.Do(func() { engine.command("cycle", "pause") })
+		},
+		"seek_back":    func() { seekBy("-5") },
+		"seek_forward": func() { seekBy("5") },
+		"volume_down":  func() { setVolume(-5) },
+		"volume_up":    func() { setVolume(5) },
+		"love":         func() { rate(func(e *libindex.Track) { e.Loved = !e.Loved }) },
+		"reset_state": func() {
+			os.Remove(filepath.Join(player.CurrentDir, stateFileSuffix))
+			os.Remove(filepath.Join(sessionDir(), sessionFileName))
+			player.mu.Lock()
+			player.CurrentTrack = ""
+			player.Position = 0
+			player.mu.Unlock()
+			statusBar.SetText("Стейт сброшен, сорцы забыты")
+		},
+		"suspend": func() {
 			app.Suspend(func() {
 				syscall.Kill(syscall.Getpid(), syscall.SIGTSTP)
 			})
-			return nil
-		case tcell.KeyCtrlP:
-			engine.Do(func() {
-				cProp := C.CString("pause")
-				var cur C.int
-				C.mpv_get_property(engine.mpv, cProp, C.MPV_FORMAT_FLAG, unsafe.Pointer(&cur))
-				var next C.int = 1
-				if cur == 1 {
-					next = 0
-				}
-				C.mpv_set_property(engine.mpv, cProp, C.MPV_FORMAT_FLAG, unsafe.Pointer(&next))
-				C.free(unsafe.Pointer(cProp))
-			})
-			return nil
-		case tcell.KeyCtrlQ:
+		},
+		"quit": func() {
 			stopTel()
 			player.save()
 			app.Stop()
-			return nil
-		case tcell.KeyCtrlU:
-			input.SetText("")
-			return nil
-		default:
-			if event.Key() == tcell.KeyRune {
-				switch event.Rune() {
-				case '-':
-					engine.Do(func() {
-						cProp := C.CString("volume")
-						cur := engine.getInt("volume")
-						next := C.int64_t(cur - 5)
-						if next < 0 {
-							next = 0
-						}
-						C.mpv_set_property(engine.mpv, cProp, C.MPV_FORMAT_INT64, unsafe.Pointer(&next))
-						C.free(unsafe.Pointer(cProp))
-					})
-					return nil
-				case '=', '+':
-					engine.Do(func() {
-						cProp := C.CString("volume")
-						cur := engine.getInt("volume")
-						next := C.int64_t(cur + 5)
-						if next > 130 {
-							next = 130
-						}
-						C.mpv_set_property(engine.mpv, cProp, C.MPV_FORMAT_INT64, unsafe.Pointer(&next))
-						C.free(unsafe.Pointer(cProp))
-					})
-					return nil
-				case '[':
-					engine.Do(func() {
-						cSeek := C.CString("seek")
-						cVal := C.CString("-5")
-						C.mpv_cmd_string(engine.mpv, cSeek, cVal)
-						C.free(unsafe.Pointer(cSeek))
-						C.free(unsafe.Pointer(cVal))
-					})
-					return nil
-				case ']':
-					engine.Do(func() {
-						cSeek := C.CString("seek")
-						cVal := C.CString("5")
-						C.mpv_cmd_string(engine.mpv, cSeek, cVal)
-						C.free(unsafe.Pointer(cSeek))
-						C.free(unsafe.Pointer(cVal))
-					})
-					return nil
-				case 'd':
-					if event.Modifiers()&tcell.ModAlt != 0 {
-						os.Remove(filepath.Join(player.CurrentDir, stateFileSuffix))
-						os.Remove(filepath.Join(sessionDir(), sessionFileName))
-						player.mu.Lock()
-						player.CurrentTrack = ""
-						player.Position = 0
-						player.mu.Unlock()
-						statusBar.SetText("Стейт сброшен, сорцы забыты")
-						return nil
+		},
+	}
+	for n := 0; n <= 5; n++ {
+		n := n
+		actions["rate_"+strconv.Itoa(n)] = func() { rate(func(e *libindex.Track) { e.Rating = n }) }
+	}
+
+	commands := map[string]ctlHandler{
+		"status": func(args []string) (interface{}, error) {
+			player.mu.RLock()
+			track, dir, pos := player.CurrentTrack, player.CurrentDir, player.Position
+			notice := player.Notice
+			if atomic.LoadInt64(&engine.loadedAt) > player.NoticeAt.UnixNano() {
+				notice = ""
+			}
+			player.mu.RUnlock()
+			st := playerStatus{
+				Title:       filepath.Base(track),
+				Path:        track,
+				Index:       -1,
+				Position:    pos,
+				Duration:    engine.getFloat("duration"),
+				Paused:      engine.getFlag("pause"),
+				Volume:      int(atomic.LoadInt64(&engine.volRaw)),
+				StreamTitle: engine.streamTitle(),
+				StreamMsg:   notice,
+			}
+			if track == "" {
+				st.Title = ""
+			}
+			tracks := dirTracks(dir)
+			st.Count = len(tracks)
+			for i, t := range tracks {
+				if t == track {
+					st.Index = i
+				}
+			}
+			return st, nil
+		},
+		"play": func(args []string) (interface{}, error) {
+			if len(args) == 0 {
+				engine.Do(func() { engine.command("set", "pause", "no") })
+				return nil, nil
+			}
+			player.mu.RLock()
+			dir := player.CurrentDir
+			player.mu.RUnlock()
+			target := args[0]
+			if n, err := strconv.Atoi(target); err == nil {
+				tracks := dirTracks(dir)
+				if n < 1 || n > len(tracks) {
+					return nil, fmt.Errorf("no track #%d in a folder of %d", n, len(tracks))
+				}
+				target = tracks[n-1]
+			} else if !stream.IsStream(target) {
+				fi, err := os.Stat(target)
+				if err != nil {
+					return nil, err
+				}
+				if fi.IsDir() {
+					dir = target
+					tracks := dirTracks(dir)
+					if len(tracks) == 0 {
+						return nil, fmt.Errorf("no audio in %s", target)
 					}
+					target = tracks[0]
+				} else if isAudioFile(target) {
+					dir = filepath.Dir(target)
+				} else {
+					return nil, fmt.Errorf("%s is not an audio file", target)
+				}
+				player.mu.Lock()
+				player.CurrentDir = dir
+				player.mu.Unlock()
+			}
+			playTrack(target)
+			engine.Do(func() { engine.command("set", "pause", "no") })
+			app.QueueUpdateDraw(func() { rebuild(input.GetText()) })
+			return nil, nil
+		},
+		"pause": func(args []string) (interface{}, error) {
+			engine.Do(func() { engine.command("set", "pause", "yes") })
+			return nil, nil
+		},
+		"toggle": func(args []string) (interface{}, error) {
+			engine.Do(func() { engine.command("cycle", "pause") })
+			return nil, nil
+		},
+		"seek": func(args []string) (interface{}, error) {
+			if len(args) != 1 {
+				return nil, fmt.Errorf("usage: seek +SEC|-SEC|MM:SS|PCT%%")
+			}
+			v, mode, err := parseSeek(args[0])
+			if err != nil {
+				return nil, err
+			}
+			engine.Do(func() { engine.command("seek", v, mode) })
+			return nil, nil
+		},
+		"volume": func(args []string) (interface{}, error) {
+			cur := int(atomic.LoadInt64(&engine.volRaw))
+			if len(args) == 0 {
+				return cur, nil
+			}
+			v, err := strconv.Atoi(args[0])
+			if err != nil {
+				return nil, fmt.Errorf("bad volume %q", args[0])
+			}
+			if strings.HasPrefix(args[0], "+") || strings.HasPrefix(args[0], "-") {
+				v += cur
+			}
+			if v < 0 {
+				v = 0
+			} else if v > 130 {
+				v = 130
+			}
+			engine.Do(func() { engine.command("set", "volume", strconv.Itoa(v)) })
+			return v, nil
+		},
+		"add": func(args []string) (interface{}, error) {
+			if len(args) == 0 {
+				return nil, fmt.Errorf("usage: add PATH...")
+			}
+			n := 0
+			for _, a := range args {
+				if stream.IsStream(a) {
+					n += enqueue(a)
+					continue
+				}
+				p := cmdline.ExpandHome(a)
+				fi, err := os.Stat(p)
+				if err != nil {
+					return nil, err
+				}
+				p, _ = filepath.Abs(p)
+				if fi.IsDir() {
+					n += enqueue(dirTracks(p)...)
+				} else {
+					n += enqueue(p)
 				}
 			}
+			return fmt.Sprintf("queued %d", n), nil
+		},
+	}
+	for name, step := range map[string]int{"next": 1, "prev": -1} {
+		step := step
+		commands[name] = func(args []string) (interface{}, error) {
+			player.mu.RLock()
+			dir, track := player.CurrentDir, player.CurrentTrack
+			player.mu.RUnlock()
+			var t string
+			if step > 0 {
+				t = nextUp(dir, track)
+			} else {
+				t = neighbourTrack(dir, track, step)
+			}
+			if t == "" {
+				return nil, fmt.Errorf("nothing else to play in %s", dir)
+			}
+			if !stream.IsStream(t) {
+				player.mu.Lock()
+				player.CurrentDir = filepath.Dir(t)
+				player.mu.Unlock()
+			}
+			playTrack(t)
+			app.QueueUpdateDraw(func() { rebuild(input.GetText()) })
+			return nil, nil
+		}
+	}
+	commands["cd"] = func(args []string) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("usage: cd DIR")
+		}
+		player.mu.RLock()
+		dir := cmdline.ExpandHome(args[0])
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(player.CurrentDir, dir)
+		}
+		player.mu.RUnlock()
+		if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+			return nil, fmt.Errorf("not a folder: %s", args[0])
+		}
+		app.QueueUpdateDraw(func() {
+			player.mu.Lock()
+			player.CurrentDir = filepath.Clean(dir)
+			player.mu.Unlock()
+			browsingM3U = false
+			rebuild(input.GetText())
+		})
+		return nil, nil
+	}
+	commands["theme"] = func(args []string) (interface{}, error) {
+		if len(args) == 0 {
+			return curTheme.Preset, nil
+		}
+		return nil, theme.SavePreset(args[0])
+	}
+	// every key action is a command too; it runs on the UI goroutine
+	for name, fn := range actions {
+		if _, ok := commands[name]; !ok {
+			fn := fn
+			commands[name] = func(args []string) (interface{}, error) {
+				app.QueueUpdateDraw(fn)
+				return nil, nil
+			}
+		}
+	}
+
+	runAndShow := func(line string) {
+		if data, err := runLine(commands, line); err != nil {
+			player.notify("✗ " + err.Error())
+		} else if data != nil {
+			player.notify(formatResult(data))
+		}
+	}
+	closePrompt := func() {
+		layout(input)
+	}
+	prompt.SetAutocompleteFunc(func(text string) []string {
+		player.mu.RLock()
+		dir := player.CurrentDir
+		player.mu.RUnlock()
+		return paletteEntries(dir, text)
+	})
+	prompt.SetAutocompletedFunc(func(text string, index, source int) bool {
+		if source == tview.AutocompletedNavigate {
+			return false
+		}
+		if !strings.HasSuffix(text, "/") {
+			text += " "
+		}
+		prompt.SetText(text)
+		return false
+	})
+	prompt.SetDoneFunc(func(key tcell.Key) {
+		switch key {
+		case tcell.KeyEscape:
+			closePrompt()
+		case tcell.KeyEnter:
+			line := strings.TrimSpace(prompt.GetText())
+			closePrompt()
+			runAndShow(line)
+		}
+	})
+
+	flex.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if prompt.HasFocus() {
+			return event
+		}
+		name := keyName(event)
+		if name == "" {
+			return event
+		}
+		pending := keys.Typed() != ""
+		action, wait := keys.Feed(name)
+		if wait {
+			player.notify(keys.Typed() + " …")
+			return nil
+		}
+		if pending {
+			player.notify("")
+		}
+		if fn, ok := actions[action]; ok {
+			fn()
+			return nil
+		}
+		if action != "" {
+			runAndShow(action)
+			return nil
 		}
 		return event
 	})
@@ -847,9 +1494,28 @@ func main() {
 	}
 
 	go func() {
-		for range engine.endChan {
-			if browsingM3U {
+		var w stream.Watch
+		var loaded int64
+		tick := time.NewTicker(time.Second / 2)
+		defer tick.Stop()
+		for {
+			var ev stream.EndEvent
+			select {
+			case now := <-tick.C:
+				player.mu.RLock()
+				track := player.CurrentTrack
+				player.mu.RUnlock()
+				if w.Due(track, now) {
+					engine.Do(func() {
+						cLoad := C.CString("loadfile")
+						cTrack := C.CString(track)
+						C.mpv_cmd_string(engine.mpv, cLoad, cTrack)
+						C.free(unsafe.Pointer(cLoad))
+						C.free(unsafe.Pointer(cTrack))
+					})
+				}
 				continue
+			case ev = <-engine.endChan:
 			}
 			player.mu.RLock()
 			dir := player.CurrentDir
@@ -858,45 +1524,46 @@ func main() {
 			if track == "" {
 				continue
 			}
-
-			all := buildList(dir, "")
-			var tracks []string
-			curIdx := -1
-			for _, e := range all {
-				if !e.IsDir {
-					tracks = append(tracks, e.Path)
-					if e.Path == track {
-						curIdx = len(tracks) - 1
-					}
-				}
+			if at := atomic.LoadInt64(&engine.loadedAt); at > loaded {
+				loaded = at
+				w.Loaded(time.Unix(0, at))
 			}
-			if len(tracks) == 0 || (len(tracks) == 1 && curIdx == 0) {
+			if d, ok := w.Ended(track, ev, time.Now()); ok {
+				player.notify(fmt.Sprintf("⟳ stream lost (%s), retry #%d in %ds", ev, w.Attempt(), int(d.Seconds())))
 				continue
 			}
-			nextIdx := 0
-			if curIdx >= 0 && curIdx < len(tracks)-1 {
-				nextIdx = curIdx + 1
+			if ev.Reason == stream.EndError {
+				player.notify("Error: " + ev.String())
+				continue
+			}
+			player.mu.RLock()
+			queued := len(player.Queue) > 0 || player.QueueFrom != ""
+			player.mu.RUnlock()
+			if browsingM3U && !queued {
+				continue
 			}
-			nextTrack := tracks[nextIdx]
-
-			player.mu.Lock()
-			player.CurrentTrack = nextTrack
-			player.mu.Unlock()
-
-			engine.Do(func() {
-				cLoad := C.CString("loadfile")
-				cTrack := C.CString(nextTrack)
-				C.mpv_cmd_string(engine.mpv, cLoad, cTrack)
-				C.free(unsafe.Pointer(cLoad))
-				C.free(unsafe.Pointer(cTrack))
-			})
 
+			nextTrack := nextUp(dir, track)
+			if nextTrack == "" {
+				continue
+			}
+			if !stream.IsStream(nextTrack) {
+				player.mu.Lock()
+				player.CurrentDir = filepath.Dir(nextTrack)
+				player.mu.Unlock()
+			}
+			playTrack(nextTrack)
 			app.QueueUpdateDraw(func() {
 				rebuild(input.GetText())
 			})
 		}
 	}()
 
+	if srv, err := listenControl(runtimeSocket("cy"), commands); err == nil {
+		defer srv.Close()
+	}
+	defer mpris.Serve("cy", handlerBackend(commands))()
+
 	app.SetRoot(flex, true).EnableMouse(true)
 	if err := app.Run(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/totiks2012/Cyan_audio_player/internal/keymap"
+)
+
+// Letters go to the search field, so cy's defaults stay on control, alt,
+// function and punctuation keys.
+var cyKeyDefs = []keymap.Def{
+	{Action: "up", Help: "move up", Keys: []string{"up"}},
+	{Action: "down", Help: "move down", Keys: []string{"down"}},
+	{Action: "open", Help: "play the file / open the folder or playlist", Keys: []string{"enter"}},
+	{Action: "back", Help: "leave the playlist, or quit", Keys: []string{"esc"}},
+	{Action: "cd", Help: "browse a folder"},
+	{Action: "clear_search", Help: "clear the search field", Keys: []string{"ctrl+u"}},
+	{Action: "find", Help: "search the whole library / back to the folder", Keys: []string{"ctrl+f"}},
+	{Action: "enqueue", Help: "queue the selected track to play next", Keys: []string{"alt+enter"}},
+	{Action: "reveal", Help: "open the folder of the selected track", Keys: []string{"ctrl+g"}},
+	{Action: "palette", Help: "command prompt", Keys: []string{":"}},
+	{Action: "help", Help: "this screen", Keys: []string{"f1"}},
+	{Action: "theme", Help: "print the theme, or switch both players to a preset"},
+
+	{Action: "status", Help: "what is playing"},
+	{Action: "play", Help: "resume, play track N of the folder, or play PATH"},
+	{Action: "pause", Help: "pause playback"},
+	{Action: "toggle", Help: "play / pause", Keys: []string{"ctrl+p"}},
+	{Action: "next", Help: "next queued track, or the next in the folder"},
+	{Action: "prev", Help: "previous track in the folder"},
+	{Action: "add", Help: "queue files, folders or URLs after the current track"},
+	{Action: "seek", Help: "+SEC / -SEC, MM:SS or SEC, PCT% of the track"},
+	{Action: "seek_back", Help: "back 5 seconds", Keys: []string{"[", "alt+,"}},
+	{Action: "seek_forward", Help: "forward 5 seconds", Keys: []string{"]", "alt+."}},
+	{Action: "volume", Help: "print the volume, set it to V or change it by +V / -V"},
+	{Action: "volume_down", Help: "volume -5%", Keys: []string{"-"}},
+	{Action: "volume_up", Help: "volume +5%", Keys: []string{"=", "+"}},
+
+	{Action: "rate_0", Help: "clear the rating", Keys: []string{"alt+0"}},
+	{Action: "rate_1", Help: "rate ★", Keys: []string{"alt+1"}},
+	{Action: "rate_2", Help: "rate ★★", Keys: []string{"alt+2"}},
+	{Action: "rate_3", Help: "rate ★★★", Keys: []string{"alt+3"}},
+	{Action: "rate_4", Help: "rate ★★★★", Keys: []string{"alt+4"}},
+	{Action: "rate_5", Help: "rate ★★★★★", Keys: []string{"alt+5"}},
+	{Action: "love", Help: "toggle loved", Keys: []string{"alt+l"}},
+
+	{Action: "reset_state", Help: "forget the saved track and session", Keys: []string{"alt+d"}},
+	{Action: "suspend", Help: "suspend to the shell", Keys: []string{"ctrl+z"}},
+	{Action: "quit", Help: "save and quit", Keys: []string{"ctrl+q"}},
+}
+
+// cyUsage is the argument synopsis of the commands that take one.
+var cyUsage = map[string]string{"cd": "DIR", "play": "[N|PATH]", "seek": "POS", "volume": "[V]", "theme": "[NAME]", "add": "PATH..."}
+
+var commandAliases = map[string]string{"vol": "volume", "q": "quit", "h": "help"}
+
+var tcellKeyNames = map[tcell.Key]string{
+	tcell.KeyUp: "up", tcell.KeyDown: "down", tcell.KeyLeft: "left", tcell.KeyRight: "right",
+	tcell.KeyEnter: "enter", tcell.KeyEscape: "esc", tcell.KeyTab: "tab", tcell.KeyBacktab: "shift+tab",
+	tcell.KeyBackspace: "backspace", tcell.KeyBackspace2: "backspace", tcell.KeyDelete: "delete",
+	tcell.KeyInsert: "insert", tcell.KeyHome: "home", tcell.KeyEnd: "end",
+	tcell.KeyPgUp: "pgup", tcell.KeyPgDn: "pgdown",
+}
+
+// keyName spells a tcell key the way cyan's config does.
+func keyName(ev *tcell.EventKey) string {
+	k := ev.Key()
+	var name string
+	switch {
+	case k == tcell.KeyRune:
+		name = string(ev.Rune())
+		if name == " " {
+			name = "space"
+		}
+	case tcellKeyNames[k] != "":
+		name = tcellKeyNames[k]
+	case k >= tcell.KeyF1 && k <= tcell.KeyF12:
+		name = "f" + string(rune('1'+k-tcell.KeyF1))
+		if k >= tcell.KeyF10 {
+			name = "f1" + string(rune('0'+k-tcell.KeyF10))
+		}
+	case k >= tcell.KeyCtrlA && k <= tcell.KeyCtrlZ:
+		name = "ctrl+" + string(rune('a'+k-tcell.KeyCtrlA))
+	default:
+		return ""
+	}
+	if ev.Modifiers()&tcell.ModAlt != 0 {
+		name = "alt+" + name
+	}
+	return name
+}
+
+// keyOverrides reads "key.<action> = k1 | k2" lines from the config;
+// an empty value unbinds the action.
+func keyOverrides(cfg map[string]string) map[string][]string {
+	out := map[string][]string{}
+	for k, v := range cfg {
+		if !strings.HasPrefix(k, "key.") {
+			continue
+		}
+		var keys []string
+		for _, s := range strings.Split(v, "|") {
+			if s = strings.TrimSpace(s); s != "" {
+				keys = append(keys, s)
+			}
+		}
+		out[strings.TrimPrefix(k, "key.")] = keys
+	}
+	return out
+}
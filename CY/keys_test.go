@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+func TestKeyName(t *testing.T) {
+	for _, c := range []struct {
+		ev   *tcell.EventKey
+		want string
+	}{
+		{tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone), "enter"},
+		{tcell.NewEventKey(tcell.KeyTab, 0, tcell.ModNone), "tab"},
+		{tcell.NewEventKey(tcell.KeyBackspace, 0, tcell.ModNone), "backspace"},
+		{tcell.NewEventKey(tcell.KeyBackspace2, 0, tcell.ModNone), "backspace"},
+		// the bare control bytes are the keys themselves
+		{tcell.NewEventKey(tcell.KeyRune, '\r', tcell.ModNone), "enter"},
+		{tcell.NewEventKey(tcell.KeyRune, '\t', tcell.ModNone), "tab"},
+		{tcell.NewEventKey(tcell.KeyRune, '\b', tcell.ModNone), "backspace"},
+		{tcell.NewEventKey(tcell.KeyRune, 0x7f, tcell.ModNone), "backspace"},
+		// while a terminal that reports Ctrl apart keeps them bindable
+		{tcell.NewEventKey(tcell.KeyCtrlM, 0, tcell.ModCtrl), "ctrl+m"},
+		{tcell.NewEventKey(tcell.KeyCtrlI, 0, tcell.ModCtrl), "ctrl+i"},
+		{tcell.NewEventKey(tcell.KeyCtrlH, 0, tcell.ModCtrl), "ctrl+h"},
+		{tcell.NewEventKey(tcell.KeyRune, 'p', tcell.ModCtrl), "ctrl+p"},
+		{tcell.NewEventKey(tcell.KeyCtrlP, 0, tcell.ModCtrl), "ctrl+p"},
+		{tcell.NewEventKey(tcell.KeyCtrlA, 0, tcell.ModCtrl), "ctrl+a"},
+		{tcell.NewEventKey(tcell.KeyCtrlZ, 0, tcell.ModCtrl), "ctrl+z"},
+		{tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModAlt), "alt+enter"},
+		{tcell.NewEventKey(tcell.KeyF1, 0, tcell.ModNone), "f1"},
+		{tcell.NewEventKey(tcell.KeyF9, 0, tcell.ModNone), "f9"},
+		{tcell.NewEventKey(tcell.KeyF12, 0, tcell.ModNone), "f12"},
+		{tcell.NewEventKey(tcell.KeyRune, ' ', tcell.ModNone), "space"},
+		{tcell.NewEventKey(tcell.KeyRune, 'l', tcell.ModAlt), "alt+l"},
+		{tcell.NewEventKey(tcell.KeyRune, 'Й', tcell.ModNone), "Й"},
+		{tcell.NewEventKey(tcell.KeyEscape, 0, tcell.ModNone), "esc"},
+		{tcell.NewEventKey(tcell.KeyBacktab, 0, tcell.ModNone), "shift+tab"},
+		{tcell.NewEventKey(tcell.KeyF24, 0, tcell.ModNone), ""},
+	} {
+		if got := keyName(c.ev); got != c.want {
+			t.Errorf("%s = %q, want %q", c.ev.Name(), got, c.want)
+		}
+	}
+}
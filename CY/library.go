@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/totiks2012/Cyan_audio_player/internal/cmdline"
+	"github.com/totiks2012/Cyan_audio_player/internal/fuzzy"
+	"github.com/totiks2012/Cyan_audio_player/internal/libindex"
+)
+
+const (
+	libraryStale = time.Hour
+	findLimit    = 200
+)
+
+// libraryIndex is the index cy shares with cyan: the tags of every audio
+// file under the library folders, so find can search all of them without
+// touching the disk, and the ratings.
+type libraryIndex struct {
+	mu       sync.RWMutex
+	file     string
+	scanning bool
+	idx      *libindex.Index
+}
+
+// loadLibrary reads the shared index and takes in the ratings cy kept in
+// ratings.json before, renaming that file once they are saved.
+func loadLibrary(file string) *libraryIndex {
+	l := &libraryIndex{file: file, idx: libindex.Load(file)}
+	old := filepath.Join(filepath.Dir(file), ratingsFileName)
+	var legacy struct {
+		Tracks map[string]struct {
+			Rating int  `json:"rating"`
+			Loved  bool `json:"loved"`
+		} `json:"tracks"`
+	}
+	if data, err := os.ReadFile(old); err == nil && json.Unmarshal(data, &legacy) == nil {
+		for p, e := range legacy.Tracks {
+			if t := l.idx.Tracks[p]; t.Rating == 0 && !t.Loved {
+				l.idx.Update(p, func(t *libindex.Track) { t.Rating, t.Loved = e.Rating, e.Loved })
+			}
+		}
+		if l.idx.Save(file) == nil {
+			_ = os.Rename(old, old+".old")
+		}
+	}
+	return l
+}
+
+// libraryDirs are the folders of "library = ~/Music | ~/Podcasts".
+func libraryDirs(cfg map[string]string) []string {
+	var dirs []string
+	for _, s := range strings.Split(cfg["library"], "|") {
+		if s = strings.TrimSpace(s); s != "" {
+			dirs = append(dirs, cmdline.ExpandHome(s))
+		}
+	}
+	if len(dirs) == 0 {
+		dirs = []string{filepath.Join(os.Getenv("HOME"), "Music")}
+	}
+	return dirs
+}
+
+// rescan picks up what cyan changed in the index, then walks dirs unless a
+// scan is running or the last one is recent, reading tags only for new or
+// changed files; done runs when it has.
+func (l *libraryIndex) rescan(dirs []string, done func()) bool {
+	l.mu.Lock()
+	l.idx.Sync(l.file)
+	if l.scanning || time.Since(l.idx.Scanned) < libraryStale {
+		l.mu.Unlock()
+		return false
+	}
+	l.scanning = true
+	old := l.idx.Snapshot()
+	l.mu.Unlock()
+	go func() {
+		out := libindex.Scan(dirs, old, isAudioFile)
+		l.mu.Lock()
+		l.idx.Apply(dirs, out, time.Now())
+		_ = l.idx.Save(l.file)
+		l.scanning = false
+		l.mu.Unlock()
+		done()
+	}()
+	return true
+}
+
+func (l *libraryIndex) busy() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.scanning
+}
+
+// findLabel is how a track shows in the results: "Artist - Title · Album",
+// or the file name when it has no title tag.
+func findLabel(t libindex.Track) string {
+	s := strings.TrimSuffix(filepath.Base(t.Path), filepath.Ext(t.Path))
+	if t.Title != "" {
+		s = t.Title
+		if t.Artist != "" {
+			s = t.Artist + " - " + s
+		}
+	}
+	if t.Album != "" {
+		s += " · " + t.Album
+	}
+	return s
+}
+
+type findHit struct {
+	Path  string
+	Label string
+	Pos   []int
+}
+
+// find ranks the library by query, matching the label and the path; keep
+// drops paths the rating terms rule out. total counts every hit, the
+// result stops at findLimit.
+func (l *libraryIndex) find(query string, keep func(string) bool) (hits []findHit, total int) {
+	l.mu.RLock()
+	all := make(map[string]string, len(l.idx.Tracks))
+	for p, t := range l.idx.Tracks {
+		all[p] = findLabel(t)
+	}
+	l.mu.RUnlock()
+	var paths []string
+	for p := range all {
+		if keep == nil || keep(p) {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	labels := make([]string, len(paths))
+	for i, p := range paths {
+		labels[i] = all[p]
+	}
+	res := fuzzy.Rank(len(paths), query, func(i int) []string { return []string{labels[i], paths[i]} })
+	total = len(res)
+	if len(res) > findLimit {
+		res = res[:findLimit]
+	}
+	for _, r := range res {
+		hits = append(hits, findHit{paths[r.Index], labels[r.Index], r.Pos})
+	}
+	return hits, total
+}
+
+func (l *libraryIndex) size() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.idx.Tracks)
+}
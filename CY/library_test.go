@@ -0,0 +1,65 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/totiks2012/Cyan_audio_player/internal/libindex"
+)
+
+func testLibrary(t *testing.T, tracks ...libindex.Track) *libraryIndex {
+	l := loadLibrary(filepath.Join(t.TempDir(), "library.json"))
+	for _, tr := range tracks {
+		l.idx.Tracks[tr.Path] = tr
+	}
+	return l
+}
+
+func TestLibraryFind(t *testing.T) {
+	l := testLibrary(t,
+		libindex.Track{Path: "/music/kino/01.mp3", Meta: libindex.Meta{Artist: "Кино", Title: "Группа крови", Album: "Группа крови"}, Rating: 5},
+		libindex.Track{Path: "/music/kino/02.mp3", Meta: libindex.Meta{Artist: "Кино", Title: "Звезда по имени Солнце"}, Rating: 3, Loved: true},
+		libindex.Track{Path: "/music/aquarium/Город золотой.flac"},
+	)
+	for _, c := range []struct {
+		query string
+		paths []string
+	}{
+		{"кино", []string{"/music/kino/01.mp3", "/music/kino/02.mp3"}},
+		{"солнце звезда", []string{"/music/kino/02.mp3"}},
+		{"aquarium", []string{"/music/aquarium/Город золотой.flac"}},
+		{"кино r>=4", []string{"/music/kino/01.mp3"}},
+		{"loved", []string{"/music/kino/02.mp3"}},
+		{"битлз", nil},
+	} {
+		q, keep := l.filter(c.query)
+		hits, total := l.find(q, keep)
+		var paths []string
+		for _, h := range hits {
+			paths = append(paths, h.Path)
+		}
+		if !reflect.DeepEqual(paths, c.paths) || total != len(c.paths) {
+			t.Errorf("find %q = %q (%d), want %q", c.query, paths, total, c.paths)
+		}
+	}
+	if hits, _ := l.find("группа", nil); len(hits) != 1 || hits[0].Label != "Кино - Группа крови · Группа крови" {
+		t.Errorf("label %+v", hits)
+	}
+}
+
+func TestLibraryRatings(t *testing.T) {
+	l := testLibrary(t)
+	p := filepath.Join(t.TempDir(), "Кино - Кукушка.mp3")
+	l.update(p, func(e *libindex.Track) { e.Rating = 4 })
+	l.update(p, func(e *libindex.Track) { e.Loved = true })
+	if got := l.get(p); got.Rating != 4 || !got.Loved || got.Title != "Кукушка" {
+		t.Errorf("track %+v", got)
+	}
+	if got := loadLibrary(l.file).get(p); got.Rating != 4 || !got.Loved {
+		t.Errorf("saved track %+v", got)
+	}
+	if _, keep := l.filter("r=4 loved"); keep == nil || !keep(p) || keep("/elsewhere.mp3") {
+		t.Error("rating filter")
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/totiks2012/Cyan_audio_player/internal/cmdline"
+	"github.com/totiks2012/Cyan_audio_player/internal/theme"
+)
+
+// paletteEntries completes the last word of a ":" line: a command name, or
+// a path for the commands that take one. Entries are whole lines.
+func paletteEntries(cwd, line string) []string {
+	words, err := cmdline.Split(line)
+	if err != nil {
+		return nil
+	}
+	var base string
+	var matches []string
+	if len(words) == 0 || len(words) == 1 && !strings.HasSuffix(line, " ") {
+		prefix := ""
+		if len(words) == 1 {
+			prefix = words[0]
+		}
+		var names []string
+		for _, d := range cyKeyDefs {
+			names = append(names, d.Action)
+		}
+		matches = cmdline.CompleteWords(names, prefix)
+	} else {
+		arg := ""
+		if !strings.HasSuffix(line, " ") {
+			arg, words = words[len(words)-1], words[:len(words)-1]
+		}
+		switch resolveCommand(words[0]) {
+		case "play":
+			matches = cmdline.CompleteFiles(cwd, arg, false)
+		case "cd":
+			matches = cmdline.CompleteFiles(cwd, arg, true)
+		case "theme":
+			matches = cmdline.CompleteWords(theme.PresetNames(), arg)
+		}
+		var head []string
+		for _, w := range words {
+			head = append(head, cmdline.Quote(w))
+		}
+		base = strings.Join(head, " ") + " "
+	}
+	if len(matches) == 1 && matches[0] == strings.TrimPrefix(line, base) {
+		return nil
+	}
+	var out []string
+	for _, c := range matches {
+		out = append(out, base+cmdline.Quote(c))
+	}
+	return out
+}
+
+func resolveCommand(name string) string {
+	if a, ok := commandAliases[name]; ok {
+		return a
+	}
+	return name
+}
+
+// runLine runs a typed command through the same handlers the control
+// socket uses.
+func runLine(commands map[string]ctlHandler, line string) (interface{}, error) {
+	words, err := cmdline.Split(line)
+	if err != nil || len(words) == 0 {
+		return nil, err
+	}
+	h, ok := commands[resolveCommand(words[0])]
+	if !ok {
+		return nil, fmt.Errorf("unknown command %q", words[0])
+	}
+	return h(words[1:])
+}
+
+func formatResult(v interface{}) string {
+	switch v := v.(type) {
+	case playerStatus:
+		if v.Path == "" {
+			return fmt.Sprintf("■ stopped, vol %d%%", v.Volume)
+		}
+		icon := "▶"
+		if v.Paused {
+			icon = "⏸"
+		}
+		return fmt.Sprintf("%s %s %d:%02d/%d:%02d", icon, filepath.Base(v.Path),
+			int(v.Position)/60, int(v.Position)%60, int(v.Duration)/60, int(v.Duration)%60)
+	case string:
+		return v
+	case int:
+		return fmt.Sprint(v)
+	}
+	d, _ := json.Marshal(v)
+	return string(d)
+}
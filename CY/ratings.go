@@ -0,0 +1,104 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/totiks2012/Cyan_audio_player/internal/libindex"
+)
+
+// ratingsFileName is where cy kept ratings before the shared index.
+const ratingsFileName = "ratings.json"
+
+func (l *libraryIndex) get(path string) libindex.Track {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.idx.Tracks[path]
+}
+
+// update applies fn to the track for path and saves the index.
+func (l *libraryIndex) update(path string, fn func(*libindex.Track)) libindex.Track {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	t := l.idx.Update(path, fn)
+	_ = l.idx.Save(l.file)
+	return t
+}
+
+// label is the stars shown after a list entry, "" for unrated files.
+func (l *libraryIndex) label(path string) string {
+	e := l.get(path)
+	if e.Rating == 0 && !e.Loved {
+		return ""
+	}
+	return strings.TrimSpace(stars(e.Rating, e.Loved))
+}
+
+// filter takes the rating terms out of a search query: "r>=4", "r=5",
+// "r<3" and "loved". It returns the remaining text and a predicate for the
+// paths that pass, or nil when the query has no such terms.
+func (l *libraryIndex) filter(q string) (string, func(path string) bool) {
+	var rest []string
+	var tests []func(libindex.Track) bool
+	for _, w := range strings.Fields(q) {
+		lw := strings.ToLower(w)
+		if lw == "loved" || lw == "♥" {
+			tests = append(tests, func(e libindex.Track) bool { return e.Loved })
+			continue
+		}
+		if o, n, ok := parseRatingTerm(lw); ok {
+			tests = append(tests, func(e libindex.Track) bool {
+				switch o {
+				case ">=":
+					return e.Rating >= n
+				case "<=":
+					return e.Rating <= n
+				case ">":
+					return e.Rating > n
+				case "<":
+					return e.Rating < n
+				}
+				return e.Rating == n
+			})
+			continue
+		}
+		rest = append(rest, w)
+	}
+	if len(tests) == 0 {
+		return q, nil
+	}
+	return strings.Join(rest, " "), func(path string) bool {
+		e := l.get(path)
+		for _, test := range tests {
+			if !test(e) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func stars(rating int, loved bool) string {
+	s := "     "
+	if rating > 0 {
+		s = strings.Repeat(glyphs.Star, rating) + strings.Repeat(glyphs.StarEmpty, 5-rating)
+	}
+	if loved {
+		return s + " " + glyphs.Loved
+	}
+	return s + strings.Repeat(" ", 1+utf8.RuneCountInString(glyphs.Loved))
+}
+
+func parseRatingTerm(w string) (string, int, bool) {
+	if !strings.HasPrefix(w, "r") {
+		return "", 0, false
+	}
+	for _, o := range []string{">=", "<=", "=", ">", "<"} {
+		if strings.HasPrefix(w[1:], o) {
+			n, err := strconv.Atoi(w[1+len(o):])
+			return o, n, err == nil
+		}
+	}
+	return "", 0, false
+}
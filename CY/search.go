@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/rivo/tview"
+	"github.com/totiks2012/Cyan_audio_player/internal/fuzzy"
+	"github.com/totiks2012/Cyan_audio_player/internal/tags"
+)
+
+// tagTexts keeps the title, artist and album of the files the filter has
+// looked at, so typing reads each file once. It is only used from the UI
+// goroutine.
+type tagTexts map[string][]string
+
+func (t *tagTexts) get(path string) []string {
+	if v, ok := (*t)[path]; ok {
+		return v
+	}
+	var v []string
+	if tf, err := tags.Open(path); err == nil {
+		v = []string{tf.Get("title"), tf.Get("artist"), tf.Get("album")}
+	}
+	if *t == nil {
+		*t = tagTexts{}
+	}
+	(*t)[path] = v
+	return v
+}
+
+// markMatches escapes s for the list and underlines the matched runes.
+func markMatches(s string, pos []int) string {
+	var sb strings.Builder
+	for _, sp := range fuzzy.Spans(s, pos) {
+		if sp.Hit {
+			sb.WriteString("[::bu]" + tview.Escape(sp.Text) + "[::-]")
+		} else {
+			sb.WriteString(tview.Escape(sp.Text))
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/totiks2012/Cyan_audio_player/internal/cmdline"
+	"github.com/totiks2012/Cyan_audio_player/internal/keymap"
+	"github.com/totiks2012/Cyan_audio_player/internal/theme"
+)
+
+// keyAction is one command of the player. The same table drives the
+// keymap, the : prompt, the control socket and `cyan ctl`; keys run it
+// without arguments.
+type keyAction struct {
+	keymap.Def
+	Usage    string
+	complete func(m *model, arg string) []string
+	run      func(m *model, args []string) (interface{}, error)
+}
+
+func do(fn func(m *model)) func(m *model, args []string) (interface{}, error) {
+	return func(m *model, args []string) (interface{}, error) {
+		fn(m)
+		return nil, nil
+	}
+}
+
+// doCmd runs fn and hands its tea.Cmd to the update loop.
+func doCmd(fn func(m *model) tea.Cmd) func(m *model, args []string) (interface{}, error) {
+	return func(m *model, args []string) (interface{}, error) {
+		m.queue(fn(m))
+		return nil, nil
+	}
+}
+
+func rateAction(n int) keyAction {
+	d := string(rune('0' + n))
+	help := "rate the selection " + strings.Repeat("★", n)
+	if n == 0 {
+		help = "clear the rating"
+	}
+	return keyAction{Def: keymap.Def{Action: "rate_" + d, Help: help, Keys: []string{d}}, run: doCmd(func(m *model) tea.Cmd { return m.rate(n) })}
+}
+
+var sortFields = []string{"artist", "album", "title", "year", "genre", "duration", "path", "rating"}
+
+// keyActions is every command of the player, in the order the help screen
+// lists them.
+var keyActions = []keyAction{
+	{Def: keymap.Def{Action: "up", Help: "move up", Keys: []string{"up"}}, run: do(func(m *model) {
+		if m.focus == 0 {
+			m.fmCur--
+			m.holdLyrics()
+		} else {
+			m.plCur--
+		}
+	})},
+	{Def: keymap.Def{Action: "down", Help: "move down", Keys: []string{"down"}}, run: do(func(m *model) {
+		if m.focus == 0 {
+			m.fmCur++
+			m.holdLyrics()
+		} else {
+			m.plCur++
+		}
+	})},
+	{Def: keymap.Def{Action: "open", Help: "open / add / play the selection", Keys: []string{"enter", "right"}}, run: doCmd((*model).action)},
+	{Def: keymap.Def{Action: "back", Help: "parent folder", Keys: []string{"left"}}, run: do((*model).goUp)},
+	{Def: keymap.Def{Action: "cd", Help: "browse a folder"}, Usage: "DIR", complete: completeDirs, run: cdCommand},
+	{Def: keymap.Def{Action: "focus", Help: "switch panes", Keys: []string{"tab"}}, run: do(func(m *model) { m.focus = (m.focus + 1) % 2 })},
+	{Def: keymap.Def{Action: "search", Help: "search the list", Keys: []string{"/"}}, run: do((*model).startSearch)},
+	{Def: keymap.Def{Action: "find", Help: "search the whole library", Keys: []string{"F"}}, Usage: "[QUERY]", run: func(m *model, args []string) (interface{}, error) {
+		m.queue(m.openFind(strings.Join(args, " ")))
+		return nil, nil
+	}},
+	{Def: keymap.Def{Action: "reveal", Help: "show the selected track in its folder", Keys: []string{"G"}}, run: do((*model).reveal)},
+	{Def: keymap.Def{Action: "palette", Help: "command prompt", Keys: []string{":"}}, run: do((*model).openPalette)},
+	{Def: keymap.Def{Action: "help", Help: "this screen", Keys: []string{"?"}}, run: do((*model).toggleHelp)},
+	{Def: keymap.Def{Action: "theme", Help: "print the theme, or switch both players to a preset"}, Usage: "[NAME]",
+		complete: func(m *model, arg string) []string { return cmdline.CompleteWords(theme.PresetNames(), arg) }, run: themeCommand},
+
+	{Def: keymap.Def{Action: "status", Help: "what is playing"}, run: func(m *model, args []string) (interface{}, error) { return m.status(), nil }},
+	{Def: keymap.Def{Action: "play", Help: "resume, play track N, or add PATH and play it"}, Usage: "[N|PATH]", complete: completePaths, run: playCommand},
+	{Def: keymap.Def{Action: "pause", Help: "pause playback"}, run: do(func(m *model) { m.player.setProp("pause", "yes") })},
+	{Def: keymap.Def{Action: "toggle", Help: "play / pause", Keys: []string{"space"}}, run: do(func(m *model) { m.player.Command("cycle", "pause") })},
+	{Def: keymap.Def{Action: "next", Help: "next track", Keys: []string{"n"}}, run: do((*model).nextTrack)},
+	{Def: keymap.Def{Action: "prev", Help: "previous track"}, run: do((*model).prevTrack)},
+	{Def: keymap.Def{Action: "seek", Help: "+SEC / -SEC, MM:SS or SEC, PCT% of the track"}, Usage: "POS", run: seekCommand},
+	{Def: keymap.Def{Action: "seek_back", Help: "back 5 seconds", Keys: []string{",", "["}}, run: do(func(m *model) { m.player.Command("seek", "-5") })},
+	{Def: keymap.Def{Action: "seek_forward", Help: "forward 5 seconds", Keys: []string{".", "]"}}, run: do(func(m *model) { m.player.Command("seek", "5") })},
+	{Def: keymap.Def{Action: "jump", Help: "go to a percentage", Keys: []string{"g"}}, run: do(func(m *model) { m.jumpMode, m.jumpInput = true, "" })},
+	{Def: keymap.Def{Action: "volume", Help: "print the volume, set it to V or change it by +V / -V"}, Usage: "[V]", run: volumeCommand},
+	{Def: keymap.Def{Action: "volume_down", Help: "volume -5%", Keys: []string{"-", "_"}}, run: do(func(m *model) { m.changeVolume(-5) })},
+	{Def: keymap.Def{Action: "volume_up", Help: "volume +5%", Keys: []string{"=", "+"}}, run: do(func(m *model) { m.changeVolume(5) })},
+	{Def: keymap.Def{Action: "alarm", Help: "print the next alarm, add one at HH:MM, or cancel all with off"}, Usage: "[HH:MM [PLAYLIST]|off]", complete: completePaths, run: alarmCommand},
+	{Def: keymap.Def{Action: "sleep", Help: "pause after MIN minutes, or turn the sleep timer off"}, Usage: "[MIN|off]", run: sleepCommand},
+
+	{Def: keymap.Def{Action: "add", Help: "add the selection, or files, folders, playlists, URLs", Keys: []string{"f2"}}, Usage: "[PATH...]", complete: completePaths, run: addCommand},
+	{Def: keymap.Def{Action: "remove", Help: "remove / unsubscribe / delete", Keys: []string{"f3"}}, run: do(func(m *model) {
+		if m.focus == 0 && m.leftView == viewPodcasts {
+			m.unsubscribe()
+		} else if m.focus == 0 && m.leftView == viewSmart {
+			m.deleteSmart()
+		} else {
+			m.remove()
+		}
+	})},
+	{Def: keymap.Def{Action: "clear", Help: "clear the playlist", Keys: []string{"f5"}}, run: do((*model).clearPlaylist)},
+	{Def: keymap.Def{Action: "save", Help: "save the playlist as NAME.m3u"}, Usage: "NAME", complete: completePaths, run: saveCommand},
+	{Def: keymap.Def{Action: "sort", Help: "sort the playlist by " + strings.Join(sortFields, ", ")}, Usage: "FIELD [desc]",
+		complete: func(m *model, arg string) []string { return cmdline.CompleteWords(sortFields, arg) }, run: sortCommand},
+	{Def: keymap.Def{Action: "sort_column", Help: "sort by the next column, then reversed", Keys: []string{"o"}}, run: do((*model).sortNextColumn)},
+	{Def: keymap.Def{Action: "shuffle", Help: "shuffle the playlist"}, run: do((*model).shufflePlaylist)},
+
+	{Def: keymap.Def{Action: "stations", Help: "radio stations", Keys: []string{"r"}}, run: do(func(m *model) { m.switchView(viewStations) })},
+	{Def: keymap.Def{Action: "podcasts", Help: "podcasts", Keys: []string{"p"}}, run: do(func(m *model) { m.switchView(viewPodcasts) })},
+	{Def: keymap.Def{Action: "stats", Help: "listening stats", Keys: []string{"s"}}, run: do(func(m *model) { m.switchView(viewStats) })},
+	{Def: keymap.Def{Action: "smart", Help: "smart playlists", Keys: []string{"l"}}, run: do(func(m *model) { m.switchView(viewSmart) })},
+	{Def: keymap.Def{Action: "lyrics", Help: "lyrics", Keys: []string{"y"}}, run: doCmd(func(m *model) tea.Cmd {
+		m.switchView(viewLyrics)
+		return m.lyricsCmd()
+	})},
+	{Def: keymap.Def{Action: "lyrics_earlier", Help: "lyrics 0.25s sooner", Keys: []string{"<"}}, run: do(func(m *model) { m.shiftLyrics(-0.25) })},
+	{Def: keymap.Def{Action: "lyrics_later", Help: "lyrics 0.25s later", Keys: []string{">"}}, run: do(func(m *model) { m.shiftLyrics(0.25) })},
+
+	rateAction(0), rateAction(1), rateAction(2), rateAction(3), rateAction(4), rateAction(5),
+	{Def: keymap.Def{Action: "love", Help: "toggle loved", Keys: []string{"L"}}, run: do((*model).toggleLoved)},
+	{Def: keymap.Def{Action: "mark", Help: "mark for a batch", Keys: []string{"x"}}, run: do((*model).toggleMark)},
+	{Def: keymap.Def{Action: "unmark", Help: "clear the marks", Keys: []string{"X"}}, run: do(func(m *model) { m.marked = map[string]bool{} })},
+	{Def: keymap.Def{Action: "tags", Help: "edit tags", Keys: []string{"t"}}, run: do((*model).editTags)},
+	{Def: keymap.Def{Action: "organise", Help: "organise files by tags", Keys: []string{"O"}}, run: do((*model).planOrganise)},
+	{Def: keymap.Def{Action: "edit", Help: "edit a smart playlist", Keys: []string{"e"}}, run: do(func(m *model) {
+		if m.focus == 0 && m.leftView == viewSmart {
+			m.editSelectedSmart()
+		}
+	})},
+
+	{Def: keymap.Def{Action: "download", Help: "download an episode", Keys: []string{"d"}}, run: doCmd((*model).downloadSelected)},
+	{Def: keymap.Def{Action: "played", Help: "toggle episode played", Keys: []string{"m"}}, run: do((*model).togglePlayed)},
+	{Def: keymap.Def{Action: "refresh_feed", Help: "refresh the feed", Keys: []string{"u"}}, run: doCmd((*model).refreshFeed)},
+	{Def: keymap.Def{Action: "favourite", Help: "toggle favourite station", Keys: []string{"f"}}, run: do((*model).toggleFav)},
+	{Def: keymap.Def{Action: "station_history", Help: "station track history", Keys: []string{"h"}}, run: do((*model).showStationHistory)},
+
+	{Def: keymap.Def{Action: "quit", Help: "save and quit", Keys: []string{"q", "ctrl+c"}}, run: doCmd(func(m *model) tea.Cmd {
+		m.endPlay()
+		m.save()
+		m.player.Stop()
+		return tea.Quit
+	})},
+	{Def: keymap.Def{Action: "quit_resume", Help: "quit, resume here next time", Keys: []string{"Q"}}, run: doCmd(func(m *model) tea.Cmd {
+		m.endPlay()
+		m.save()
+		m.player.SaveAndStop()
+		return tea.Quit
+	})},
+}
+
+var commandAliases = map[string]string{"vol": "volume", "q": "quit", "h": "help"}
+
+func keyDefs() []keymap.Def {
+	defs := make([]keymap.Def, len(keyActions))
+	for i, a := range keyActions {
+		defs[i] = a.Def
+	}
+	return defs
+}
+
+func lookupCommand(name string) *keyAction {
+	if a, ok := commandAliases[name]; ok {
+		name = a
+	}
+	for i := range keyActions {
+		if keyActions[i].Action == name {
+			return &keyActions[i]
+		}
+	}
+	return nil
+}
+
+// runCommand runs a command on the model; tea.Cmds it starts are left in
+// m.cmds for the caller's loop.
+func (m *model) runCommand(name string, args []string) (interface{}, error) {
+	a := lookupCommand(name)
+	if a == nil {
+		return nil, fmt.Errorf("unknown command %q", name)
+	}
+	return a.run(m, args)
+}
+
+func (m *model) queue(c tea.Cmd) {
+	if c != nil {
+		m.cmds = append(m.cmds, c)
+	}
+}
+
+func (m *model) takeCmds() tea.Cmd {
+	cmds := m.cmds
+	m.cmds = nil
+	return tea.Batch(cmds...)
+}
+
+func keyName(msg tea.KeyMsg) string {
+	if s := msg.String(); s != " " {
+		return s
+	}
+	return "space"
+}
+
+// runKey feeds a key to the keymap and runs the command it completes.
+func (m *model) runKey(msg tea.KeyMsg) tea.Cmd {
+	if name, _ := m.keys.Feed(keyName(msg)); name != "" {
+		if _, err := m.runCommand(name, nil); err != nil {
+			m.setNotice("✗ " + err.Error())
+		}
+	}
+	return m.takeCmds()
+}
+
+func playCommand(m *model, args []string) (interface{}, error) {
+	if len(args) == 0 {
+		if m.state.CurrentIndex < 0 || m.state.CurrentIndex >= len(m.state.Playlist) {
+			if len(m.state.Playlist) == 0 {
+				return nil, errors.New("playlist is empty")
+			}
+			m.state.CurrentIndex = 0
+			m.playTrack(0)
+			m.save()
+		}
+		m.player.setProp("pause", "no")
+		return nil, nil
+	}
+	idx := -1
+	if n, err := strconv.Atoi(args[0]); err == nil {
+		if n < 1 || n > len(m.state.Playlist) {
+			return nil, fmt.Errorf("no track #%d in a playlist of %d", n, len(m.state.Playlist))
+		}
+		idx = n - 1
+	} else {
+		entries := loadPlaylistSource(cmdline.ExpandHome(args[0]))
+		if len(entries) == 0 {
+			return nil, fmt.Errorf("nothing to play in %q", args[0])
+		}
+		idx = len(m.state.Playlist)
+		m.state.Playlist = append(m.state.Playlist, entries...)
+	}
+	m.state.CurrentIndex, m.plCur = idx, idx
+	m.playTrack(idx)
+	m.player.setProp("pause", "no")
+	m.refresh()
+	m.save()
+	return nil, nil
+}
+
+func seekCommand(m *model, args []string) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, errors.New("usage: seek +SEC|-SEC|MM:SS|PCT%")
+	}
+	v, mode, err := parseSeek(args[0])
+	if err != nil {
+		return nil, err
+	}
+	if r := m.player.Command("seek", v, mode); r < 0 {
+		return nil, fmt.Errorf("seek failed (%d)", r)
+	}
+	return nil, nil
+}
+
+func volumeCommand(m *model, args []string) (interface{}, error) {
+	if len(args) != 1 {
+		return m.state.Volume, nil
+	}
+	delta, err := parseVolume(args[0], m.state.Volume)
+	if err != nil {
+		return nil, err
+	}
+	m.changeVolume(delta)
+	return m.state.Volume, nil
+}
+
+func alarmCommand(m *model, args []string) (interface{}, error) {
+	switch {
+	case len(args) == 0:
+		if at, ok := m.alarms.next(); ok {
+			return at.Format("Mon 15:04"), nil
+		}
+		return "no alarm", nil
+	case args[0] == "off":
+		m.alarms.cancel()
+		return nil, nil
+	}
+	a := Alarm{Time: args[0], Once: true}
+	if len(args) > 1 {
+		a.Playlist = cmdline.ExpandHome(strings.Join(args[1:], " "))
+	}
+	if err := m.alarms.add(a); err != nil {
+		return nil, err
+	}
+	at, _ := a.nextAfter(m.now())
+	return at.Format("Mon 15:04"), nil
+}
+
+func sleepCommand(m *model, args []string) (interface{}, error) {
+	if len(args) == 0 {
+		if at, ok := m.alarms.sleeping(); ok {
+			return at.Format("15:04"), nil
+		}
+		return "off", nil
+	}
+	if args[0] == "off" {
+		m.alarms.sleepIn(0)
+		return nil, nil
+	}
+	mins, err := strconv.Atoi(args[0])
+	if err != nil || mins <= 0 {
+		return nil, fmt.Errorf("bad sleep time %q, want minutes", args[0])
+	}
+	m.alarms.sleepIn(time.Duration(mins) * time.Minute)
+	at, _ := m.alarms.sleeping()
+	return at.Format("15:04"), nil
+}
+
+func addCommand(m *model, args []string) (interface{}, error) {
+	if len(args) == 0 {
+		m.add()
+		return nil, nil
+	}
+	n := 0
+	for _, a := range args {
+		entries := loadPlaylistSource(cmdline.ExpandHome(a))
+		if len(entries) == 0 {
+			return n, fmt.Errorf("nothing to add in %q", a)
+		}
+		m.state.Playlist = append(m.state.Playlist, entries...)
+		n += len(entries)
+	}
+	m.refresh()
+	m.save()
+	return n, nil
+}
+
+// themeCommand writes the preset into the shared theme file, so a running
+// cy follows too.
+func themeCommand(m *model, args []string) (interface{}, error) {
+	if len(args) == 0 {
+		return m.theme.Preset, nil
+	}
+	if err := theme.SavePreset(args[0]); err != nil {
+		return nil, err
+	}
+	m.themeWatch.Changed()
+	m.applyTheme()
+	return nil, nil
+}
+
+func cdCommand(m *model, args []string) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, errors.New("usage: cd DIR")
+	}
+	dir := cmdline.ExpandHome(args[0])
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(m.state.Cwd, dir)
+	}
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		return nil, fmt.Errorf("not a folder: %s", args[0])
+	}
+	m.leftView, m.state.Cwd = viewFiles, filepath.Clean(dir)
+	m.focus, m.fmCur, m.fmOff = 0, 0, 0
+	m.refresh()
+	m.save()
+	return nil, nil
+}
+
+// saveCommand writes the playlist as an .m3u next to the browsed folder,
+// stations with their names.
+func saveCommand(m *model, args []string) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, errors.New("usage: save NAME")
+	}
+	path := cmdline.ExpandHome(args[0])
+	if !isPlaylistFile(path) {
+		path += ".m3u"
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(m.state.Cwd, path)
+	}
+	var sb strings.Builder
+	sb.WriteString("#EXTM3U\n")
+	for _, e := range m.state.Playlist {
+		if name, url, ok := strings.Cut(e, m3uSeparator); ok {
+			fmt.Fprintf(&sb, "#EXTINF:-1,%s\n%s\n", name, url)
+		} else {
+			sb.WriteString(e + "\n")
+		}
+	}
+	if err := os.WriteFile(path, []byte(sb.String()), 0644); err != nil {
+		return nil, err
+	}
+	m.refresh()
+	return path, nil
+}
+
+func sortCommand(m *model, args []string) (interface{}, error) {
+	if len(args) == 0 || len(args) > 2 || !hasString(sortFields, args[0]) || len(args) == 2 && args[1] != "desc" {
+		return nil, errors.New("usage: sort " + strings.Join(sortFields, "|") + " [desc]")
+	}
+	key := m.sortKey(args[0])
+	keys := map[string]string{}
+	for _, e := range m.state.Playlist {
+		if _, ok := keys[e]; !ok {
+			keys[e] = key(e)
+		}
+	}
+	desc := len(args) == 2
+	m.sortedBy, m.sortDesc = args[0], desc
+	m.reorderPlaylist(func(pl []string) {
+		sort.SliceStable(pl, func(i, j int) bool {
+			if desc {
+				return keys[pl[i]] > keys[pl[j]]
+			}
+			return keys[pl[i]] < keys[pl[j]]
+		})
+	})
+	return nil, nil
+}
+
+// sortKey makes a comparable string for an entry; numbers are padded so
+// they sort as numbers.
+func (m *model) sortKey(field string) func(e string) string {
+	return func(e string) string {
+		path := entryPath(e)
+		t := m.trackInfo(path)
+		artist := strings.ToLower(t.Artist)
+		switch field {
+		case "artist":
+			return artist + "\x00" + strings.ToLower(t.Album) + "\x00" + strings.ToLower(path)
+		case "album":
+			return strings.ToLower(t.Album) + "\x00" + strings.ToLower(path)
+		case "title":
+			if t.Title == "" {
+				return strings.ToLower(filepath.Base(path))
+			}
+			return strings.ToLower(t.Title)
+		case "year":
+			return fmt.Sprintf("%06d\x00%s", t.Year, artist)
+		case "genre":
+			return strings.ToLower(t.Genre) + "\x00" + artist
+		case "duration":
+			return fmt.Sprintf("%012.3f", t.Length)
+		case "rating":
+			return fmt.Sprintf("%d%d\x00%s", t.Rating, btoi(t.Loved), artist)
+		}
+		return strings.ToLower(path)
+	}
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (m *model) shufflePlaylist() {
+	m.sortedBy = ""
+	m.reorderPlaylist(func(pl []string) {
+		rand.Shuffle(len(pl), func(i, j int) { pl[i], pl[j] = pl[j], pl[i] })
+	})
+}
+
+// reorderPlaylist rearranges the playlist and keeps the current index on
+// the entry that is playing.
+func (m *model) reorderPlaylist(fn func(pl []string)) {
+	cur := ""
+	if m.state.CurrentIndex >= 0 && m.state.CurrentIndex < len(m.state.Playlist) {
+		cur = m.state.Playlist[m.state.CurrentIndex]
+	}
+	pl := append([]string{}, m.state.Playlist...)
+	fn(pl)
+	if cur != "" {
+		for i, e := range pl {
+			if e == cur {
+				m.state.CurrentIndex = i
+				break
+			}
+		}
+	}
+	m.state.Playlist = pl
+	m.refresh()
+	m.save()
+}
+
+func (m *model) toggleHelp() {
+	m.showHelp = !m.showHelp
+	m.focus, m.fmCur, m.fmOff = 0, 0, 0
+	m.refresh()
+}
+
+// helpKey scrolls the help screen; any other key closes it.
+func (m *model) helpKey(msg tea.KeyMsg) {
+	switch msg.String() {
+	case "up":
+		m.fmCur--
+	case "down":
+		m.fmCur++
+	case "pgup":
+		m.fmCur -= m.height
+	case "pgdown":
+		m.fmCur += m.height
+	default:
+		m.toggleHelp()
+	}
+	m.sync()
+}
+
+func (m *model) helpItems() []displayItem {
+	var items []displayItem
+	for _, l := range m.keys.HelpLines() {
+		items = append(items, displayItem{"", l, false})
+	}
+	return items
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const defaultRampSeconds = 60
+
+type Alarm struct {
+	Time        string   `json:"time"`
+	Days        []string `json:"days,omitempty"`
+	Playlist    string   `json:"playlist"`
+	Volume      int      `json:"volume,omitempty"`
+	RampFrom    int      `json:"ramp_from,omitempty"`
+	RampSeconds int      `json:"ramp_seconds,omitempty"`
+	Once        bool     `json:"-"`
+}
+
+func parseClock(s string) (int, int, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(strings.TrimSpace(s), "%d:%d", &h, &m); err != nil {
+		return 0, 0, fmt.Errorf("bad alarm time %q, want HH:MM", s)
+	}
+	if h < 0 || h > 23 || m < 0 || m > 59 {
+		return 0, 0, fmt.Errorf("bad alarm time %q, want HH:MM", s)
+	}
+	return h, m, nil
+}
+
+func (a Alarm) onDay(d time.Weekday) bool {
+	if len(a.Days) == 0 {
+		return true
+	}
+	name := strings.ToLower(d.String()[:3])
+	for _, x := range a.Days {
+		if strings.HasPrefix(strings.ToLower(strings.TrimSpace(x)), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// nextAfter returns the first moment strictly after t at which the alarm rings.
+func (a Alarm) nextAfter(t time.Time) (time.Time, bool) {
+	h, m, err := parseClock(a.Time)
+	if err != nil {
+		return time.Time{}, false
+	}
+	at := time.Date(t.Year(), t.Month(), t.Day(), h, m, 0, 0, t.Location())
+	if !at.After(t) {
+		at = at.AddDate(0, 0, 1)
+	}
+	for i := 0; i < 7; i++ {
+		if a.onDay(at.Weekday()) {
+			return at, true
+		}
+		at = at.AddDate(0, 0, 1)
+	}
+	return time.Time{}, false
+}
+
+func (a Alarm) rampDuration() time.Duration {
+	if a.RampSeconds <= 0 {
+		return defaultRampSeconds * time.Second
+	}
+	return time.Duration(a.RampSeconds) * time.Second
+}
+
+type alarmScheduler struct {
+	alarms  []Alarm
+	now     func() time.Time
+	last    time.Time
+	sleepAt time.Time
+}
+
+func newAlarmScheduler(alarms []Alarm, now func() time.Time) *alarmScheduler {
+	if now == nil {
+		now = time.Now
+	}
+	return &alarmScheduler{alarms: alarms, now: now, last: now()}
+}
+
+// due returns the alarms that rang since the previous call and whether the
+// sleep timer ran out. One-shot alarms are dropped once they fire,
+// recurring ones stay scheduled.
+func (s *alarmScheduler) due() (fired []Alarm, sleep bool) {
+	now := s.now()
+	keep := s.alarms[:0]
+	for _, a := range s.alarms {
+		if at, ok := a.nextAfter(s.last); ok && !at.After(now) {
+			fired = append(fired, a)
+			if a.Once {
+				continue
+			}
+		}
+		keep = append(keep, a)
+	}
+	s.alarms = keep
+	s.last = now
+	if !s.sleepAt.IsZero() && !s.sleepAt.After(now) {
+		s.sleepAt, sleep = time.Time{}, true
+	}
+	return fired, sleep
+}
+
+func (s *alarmScheduler) next() (time.Time, bool) {
+	var best time.Time
+	for _, a := range s.alarms {
+		if at, ok := a.nextAfter(s.last); ok && (best.IsZero() || at.Before(best)) {
+			best = at
+		}
+	}
+	return best, !best.IsZero()
+}
+
+// add schedules one more alarm.
+func (s *alarmScheduler) add(a Alarm) error {
+	if _, _, err := parseClock(a.Time); err != nil {
+		return err
+	}
+	s.alarms = append(s.alarms, a)
+	return nil
+}
+
+// cancel drops every alarm; the sleep timer keeps running.
+func (s *alarmScheduler) cancel() {
+	s.alarms = nil
+}
+
+// sleepIn stops playback d from now; d <= 0 turns the sleep timer off.
+func (s *alarmScheduler) sleepIn(d time.Duration) {
+	s.sleepAt = time.Time{}
+	if d > 0 {
+		s.sleepAt = s.now().Add(d)
+	}
+}
+
+// sleeping returns when the sleep timer runs out.
+func (s *alarmScheduler) sleeping() (time.Time, bool) {
+	return s.sleepAt, !s.sleepAt.IsZero()
+}
+
+type volumeRamp struct {
+	start    time.Time
+	dur      time.Duration
+	from, to int
+}
+
+// level returns the volume the ramp wants at now and whether it has finished.
+func (r *volumeRamp) level(now time.Time) (int, bool) {
+	el := now.Sub(r.start)
+	if r.dur <= 0 || el >= r.dur {
+		return r.to, true
+	}
+	if el < 0 {
+		return r.from, false
+	}
+	return r.from + int(float64(r.to-r.from)*float64(el)/float64(r.dur)), false
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeClock is the injected now of the scheduler.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+// Monday, 19 October 2026, 06:00.
+var alarmStart = time.Date(2026, 10, 19, 6, 0, 0, 0, time.Local)
+
+func TestAlarmScheduler(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		alarms []Alarm
+		setup  func(s *alarmScheduler)
+		steps  []time.Duration
+		fires  []int
+		sleeps []bool
+		next   string
+	}{
+		{
+			name:   "alarm at HH:MM",
+			alarms: []Alarm{{Time: "07:00"}},
+			steps:  []time.Duration{59 * time.Minute, time.Minute, time.Minute},
+			fires:  []int{0, 1, 0},
+			next:   "Tue 07:00",
+		},
+		{
+			name:   "already past today rings tomorrow",
+			alarms: []Alarm{{Time: "05:30"}},
+			steps:  []time.Duration{18 * time.Hour, 5*time.Hour + 29*time.Minute, time.Minute},
+			fires:  []int{0, 0, 1},
+			next:   "Wed 05:30",
+		},
+		{
+			name:   "weekdays skip to the next day that matches",
+			alarms: []Alarm{{Time: "07:00", Days: []string{"wed"}}},
+			steps:  []time.Duration{2 * time.Hour, 24 * time.Hour, 24 * time.Hour},
+			fires:  []int{0, 0, 1},
+			next:   "Wed 07:00",
+		},
+		{
+			name:   "once rings a single time",
+			alarms: []Alarm{{Time: "06:30", Once: true}},
+			steps:  []time.Duration{time.Hour, 24 * time.Hour},
+			fires:  []int{1, 0},
+		},
+		{
+			name:   "cancel drops every alarm",
+			alarms: []Alarm{{Time: "06:30"}, {Time: "07:00", Days: []string{"mon"}}},
+			setup:  func(s *alarmScheduler) { s.cancel() },
+			steps:  []time.Duration{time.Hour, 48 * time.Hour},
+			fires:  []int{0, 0},
+		},
+		{
+			name:   "sleep timer fires once",
+			setup:  func(s *alarmScheduler) { s.sleepIn(30 * time.Minute) },
+			steps:  []time.Duration{29 * time.Minute, time.Minute, time.Hour},
+			fires:  []int{0, 0, 0},
+			sleeps: []bool{false, true, false},
+		},
+		{
+			name:   "sleep timer off",
+			setup:  func(s *alarmScheduler) { s.sleepIn(time.Minute); s.sleepIn(0) },
+			steps:  []time.Duration{time.Hour},
+			fires:  []int{0},
+			sleeps: []bool{false},
+		},
+		{
+			name:   "cancel keeps the sleep timer",
+			alarms: []Alarm{{Time: "06:10"}},
+			setup:  func(s *alarmScheduler) { s.sleepIn(10 * time.Minute); s.cancel() },
+			steps:  []time.Duration{10 * time.Minute},
+			fires:  []int{0},
+			sleeps: []bool{true},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			clock := &fakeClock{alarmStart}
+			s := newAlarmScheduler(append([]Alarm(nil), c.alarms...), clock.now)
+			if c.setup != nil {
+				c.setup(s)
+			}
+			for i, d := range c.steps {
+				clock.advance(d)
+				fired, sleep := s.due()
+				if len(fired) != c.fires[i] {
+					t.Errorf("step %d at %s: %d alarms fired, want %d", i, clock.t.Format("Mon 15:04"), len(fired), c.fires[i])
+				}
+				if want := c.sleeps != nil && c.sleeps[i]; sleep != want {
+					t.Errorf("step %d at %s: sleep %v, want %v", i, clock.t.Format("Mon 15:04"), sleep, want)
+				}
+			}
+			at, ok := s.next()
+			if c.next == "" && ok {
+				t.Errorf("next alarm %s, want none", at.Format("Mon 15:04"))
+			}
+			if c.next != "" && (!ok || at.Format("Mon 15:04") != c.next) {
+				t.Errorf("next alarm %s (%v), want %s", at.Format("Mon 15:04"), ok, c.next)
+			}
+		})
+	}
+}
+
+func TestAlarmAdd(t *testing.T) {
+	clock := &fakeClock{alarmStart}
+	s := newAlarmScheduler(nil, clock.now)
+	if err := s.add(Alarm{Time: "25:00"}); err == nil {
+		t.Error("25:00 was accepted")
+	}
+	if err := s.add(Alarm{Time: "06:05", Once: true}); err != nil {
+		t.Fatal(err)
+	}
+	clock.advance(5 * time.Minute)
+	if fired, _ := s.due(); len(fired) != 1 {
+		t.Errorf("%d alarms fired, want 1", len(fired))
+	}
+}
+
+func TestVolumeRamp(t *testing.T) {
+	r := volumeRamp{start: alarmStart, dur: time.Minute, from: 0, to: 60}
+	for _, c := range []struct {
+		at   time.Duration
+		want int
+		done bool
+	}{
+		{-time.Second, 0, false},
+		{0, 0, false},
+		{30 * time.Second, 30, false},
+		{time.Minute, 60, true},
+		{time.Hour, 60, true},
+	} {
+		if v, done := r.level(alarmStart.Add(c.at)); v != c.want || done != c.done {
+			t.Errorf("level at %s = %d %v, want %d %v", c.at, v, done, c.want, c.done)
+		}
+	}
+}
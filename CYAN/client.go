@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/totiks2012/Cyan_audio_player/internal/stream"
+)
+
+const ctlTimeout = 3 * time.Second
+
+var errDetached = errors.New("not connected to the player")
+
+type remoteStateMsg State
+
+type remoteLostMsg struct{}
+
+// remotePlayer forwards player calls to a cyan daemon and hands state pushes
+// from it to events.
+type remotePlayer struct {
+	conn    net.Conn
+	wmu     sync.Mutex
+	enc     *json.Encoder
+	mu      sync.Mutex
+	nextID  int
+	pending map[int]chan ctlReply
+	events  chan ctlReply
+	done    chan struct{}
+}
+
+func dialControl(path string) (*remotePlayer, error) {
+	c, err := net.DialTimeout("unix", path, time.Second)
+	if err != nil {
+		return nil, err
+	}
+	r := &remotePlayer{
+		conn:    c,
+		enc:     json.NewEncoder(c),
+		pending: map[int]chan ctlReply{},
+		events:  make(chan ctlReply, 16),
+		done:    make(chan struct{}),
+	}
+	go r.read()
+	return r, nil
+}
+
+func (r *remotePlayer) read() {
+	sc := bufio.NewScanner(r.conn)
+	sc.Buffer(make([]byte, 64*1024), 16<<20)
+	for sc.Scan() {
+		var rep ctlReply
+		if json.Unmarshal(sc.Bytes(), &rep) != nil {
+			continue
+		}
+		if rep.Event != "" {
+			select {
+			case r.events <- rep:
+			default:
+			}
+			continue
+		}
+		r.mu.Lock()
+		ch := r.pending[rep.ID]
+		delete(r.pending, rep.ID)
+		r.mu.Unlock()
+		if ch != nil {
+			ch <- rep
+		}
+	}
+	close(r.done)
+	close(r.events)
+}
+
+func (r *remotePlayer) send(req ctlRequest) (ctlReply, error) {
+	ch := make(chan ctlReply, 1)
+	r.mu.Lock()
+	r.nextID++
+	req.ID = r.nextID
+	r.pending[req.ID] = ch
+	r.mu.Unlock()
+
+	r.wmu.Lock()
+	r.conn.SetWriteDeadline(time.Now().Add(ctlTimeout))
+	err := r.enc.Encode(req)
+	r.wmu.Unlock()
+	if err == nil {
+		select {
+		case rep := <-ch:
+			if !rep.OK {
+				return rep, errors.New(rep.Error)
+			}
+			return rep, nil
+		case <-r.done:
+			err = errDetached
+		case <-time.After(ctlTimeout):
+			err = fmt.Errorf("%s: no reply from player", req.Cmd)
+		}
+	}
+	r.mu.Lock()
+	delete(r.pending, req.ID)
+	r.mu.Unlock()
+	return ctlReply{}, err
+}
+
+func (r *remotePlayer) call(cmd string, args ...string) (ctlReply, error) {
+	return r.send(ctlRequest{Cmd: cmd, Args: args})
+}
+
+func (r *remotePlayer) state() (State, error) {
+	var st State
+	rep, err := r.call("state")
+	if err == nil {
+		err = json.Unmarshal(rep.Data, &st)
+	}
+	return st, err
+}
+
+func (r *remotePlayer) pushState(st State) {
+	_, _ = r.send(ctlRequest{Cmd: "set_state", State: &st})
+}
+
+func (r *remotePlayer) status() (playerStatus, error) {
+	var st playerStatus
+	rep, err := r.call("status")
+	if err == nil {
+		err = json.Unmarshal(rep.Data, &st)
+	}
+	return st, err
+}
+
+func (r *remotePlayer) Start(path string, vol int) {
+	_, _ = r.call("start", path, strconv.Itoa(vol))
+}
+
+func (r *remotePlayer) Command(args ...string) int {
+	if _, err := r.call("command", args...); err != nil {
+		return -1
+	}
+	return 0
+}
+
+func (r *remotePlayer) setProp(name, val string) int {
+	if _, err := r.call("set_prop", name, val); err != nil {
+		return -1
+	}
+	return 0
+}
+
+func (r *remotePlayer) getProp(name string) string {
+	rep, err := r.call("get_prop", name)
+	if err != nil {
+		return ""
+	}
+	var s string
+	_ = json.Unmarshal(rep.Data, &s)
+	return s
+}
+
+func (r *remotePlayer) GetPosition() (float64, float64) {
+	st, err := r.status()
+	if err != nil {
+		return 0, 0
+	}
+	return st.Position, st.Duration
+}
+
+func (r *remotePlayer) PollEvents() (bool, []stream.EndEvent) {
+	return false, nil
+}
+
+// Stop only detaches; the daemon keeps playing.
+func (r *remotePlayer) Stop() {
+	r.conn.Close()
+}
+
+func (r *remotePlayer) SaveAndStop() {
+	_, _ = r.call("shutdown", "save")
+	r.conn.Close()
+}
+
+func (m *model) pullStatus() {
+	st, err := m.remote.status()
+	if err != nil {
+		return
+	}
+	m.curPos, m.curDur = st.Position, st.Duration
+	m.icyTitle, m.streamMsg = st.StreamTitle, st.StreamMsg
+	m.buffering, m.bufPct, m.cacheSec = st.Buffering, st.BufferPct, st.CacheSec
+}
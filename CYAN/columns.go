@@ -0,0 +1,427 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/totiks2012/Cyan_audio_player/internal/fuzzy"
+	"github.com/totiks2012/Cyan_audio_player/internal/libindex"
+)
+
+// Column is one column of the PLAYLIST pane. Width 0 shares out the room
+// the fixed columns leave; Align is "left", "right" or "center".
+type Column struct {
+	Field string `json:"field"`
+	Width int    `json:"width,omitempty"`
+	Align string `json:"align,omitempty"`
+}
+
+var columnFields = []string{"#", "name", "title", "artist", "album", "genre", "year", "duration", "rating", "path"}
+
+var defaultColumns = []Column{{Field: "#"}, {Field: "name"}, {Field: "duration"}, {Field: "rating"}}
+
+const (
+	minFlexWidth = 4
+	probeBatch   = 8
+)
+
+var columnTitles = map[string]string{"#": "#", "duration": "TIME", "rating": "RATING"}
+
+func entryPath(e string) string {
+	if _, url, ok := strings.Cut(e, m3uSeparator); ok {
+		return url
+	}
+	return e
+}
+
+// trackInfo is the library entry for a path; the tags of files outside
+// the library are read when first shown and kept for the session.
+func (m *model) trackInfo(path string) libindex.Track {
+	if t, ok := m.library.Tracks[path]; ok {
+		return t
+	}
+	t := libindex.Track{Path: path, Length: m.lengths[path]}
+	if isURL(path) {
+		return t
+	}
+	if m.plMeta == nil {
+		m.plMeta = map[string]libindex.Meta{}
+	}
+	md, ok := m.plMeta[path]
+	if !ok {
+		md = libindex.ReadMeta(path)
+		m.plMeta[path] = md
+	}
+	t.Meta = md
+	return t
+}
+
+// trackLength is the length in seconds, 0 while it is not known.
+func (m *model) trackLength(path string) float64 {
+	if t, ok := m.library.Tracks[path]; ok && t.Length > 0 {
+		return t.Length
+	}
+	return m.lengths[path]
+}
+
+// queueLength is the running time of the whole playlist; unknown is how
+// many entries have no length yet.
+func (m *model) queueLength() (total float64, unknown int) {
+	for _, e := range m.state.Playlist {
+		if l := m.trackLength(entryPath(e)); l > 0 {
+			total += l
+		} else {
+			unknown++
+		}
+	}
+	return total, unknown
+}
+
+func longClock(sec float64) string {
+	s := int(sec)
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+type lengthMsg map[string]float64
+
+// lengthCmd probes, a few at a time, the files in the playlist whose length
+// is not known yet.
+func (m *model) lengthCmd() tea.Cmd {
+	if m.probeBusy || m.remote != nil {
+		return nil
+	}
+	var paths []string
+	for _, e := range m.state.Playlist {
+		p := entryPath(e)
+		if m.trackLength(p) == 0 && !isURL(p) && !m.probeFail[p] && !hasString(paths, p) {
+			paths = append(paths, p)
+			if len(paths) == probeBatch {
+				break
+			}
+		}
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+	m.probeBusy = true
+	return func() tea.Msg {
+		out := lengthMsg{}
+		for _, p := range paths {
+			l, err := probeDuration(p)
+			if err != nil {
+				l = 0
+			}
+			out[p] = l
+		}
+		return out
+	}
+}
+
+// onLength keeps the lengths in the library index, or for the session
+// when the file is not in it.
+func (m *model) onLength(msg lengthMsg) {
+	m.probeBusy = false
+	saved := false
+	for p, l := range msg {
+		if l <= 0 {
+			if m.probeFail == nil {
+				m.probeFail = map[string]bool{}
+			}
+			m.probeFail[p] = true
+		} else if t, ok := m.library.Tracks[p]; ok {
+			t.Length = l
+			m.library.Tracks[p] = t
+			saved = true
+		} else {
+			if m.lengths == nil {
+				m.lengths = map[string]float64{}
+			}
+			m.lengths[p] = l
+		}
+	}
+	if saved {
+		m.saveLibrary()
+	}
+}
+
+// columns resolves the configured columns to widths that fill the pane.
+// Columns that do not fit are dropped from the right.
+func (m *model) columns() []Column {
+	conf := m.config.Columns
+	if len(conf) == 0 {
+		conf = defaultColumns
+	}
+	var cols []Column
+	for _, c := range conf {
+		c.Field = strings.ToLower(c.Field)
+		if !hasString(columnFields, c.Field) {
+			continue
+		}
+		if c.Width <= 0 {
+			c.Width = m.naturalWidth(c.Field)
+		}
+		if c.Align == "" {
+			c.Align = "left"
+			if c.Field == "#" || c.Field == "year" || c.Field == "duration" {
+				c.Align = "right"
+			}
+		}
+		cols = append(cols, c)
+	}
+	if len(cols) == 0 {
+		cols = []Column{{Field: "name", Align: "left"}}
+	}
+	w := m.textWidth()
+	for {
+		fixed, flex := len(cols)-1, 0
+		for _, c := range cols {
+			if c.Width == 0 {
+				flex++
+			}
+			fixed += c.Width
+		}
+		if fixed+flex*minFlexWidth <= w || len(cols) == 1 {
+			if flex == 0 {
+				break
+			}
+			share, extra := (w-fixed)/flex, (w-fixed)%flex
+			for i := range cols {
+				if cols[i].Width == 0 {
+					cols[i].Width = share
+					if extra > 0 {
+						cols[i].Width++
+						extra--
+					}
+				}
+			}
+			break
+		}
+		cols = cols[:len(cols)-1]
+	}
+	if len(cols) == 1 {
+		cols[0].Width = w
+	}
+	return cols
+}
+
+// naturalWidth is the width of the fields that have one; the rest share.
+func (m *model) naturalWidth(field string) int {
+	switch field {
+	case "#":
+		if n := len(fmt.Sprint(len(m.state.Playlist))) + 1; n > 3 {
+			return n
+		}
+		return 3
+	case "year":
+		return 4
+	case "duration":
+		w := 4
+		for _, e := range m.state.Playlist {
+			if n := len(longClock(m.trackLength(entryPath(e)))); n > w {
+				w = n
+			}
+		}
+		return w
+	case "rating":
+		return utf8.RuneCountInString(stars(0, true))
+	}
+	return 0
+}
+
+func (m *model) cell(field string, i int, it displayItem) string {
+	switch field {
+	case "#":
+		return fmt.Sprintf("%d.", m.plEntry(i)+1)
+	case "name":
+		if m.marked[it.path] {
+			return glyphs.Mark + it.name
+		}
+		return it.name
+	case "path":
+		return it.path
+	case "duration":
+		if l := m.trackLength(it.path); l > 0 {
+			return longClock(l)
+		}
+		return ""
+	case "rating":
+		if t := m.library.Tracks[it.path]; t.Rating > 0 || t.Loved {
+			return stars(t.Rating, t.Loved)
+		}
+		return ""
+	}
+	t := m.trackInfo(it.path)
+	switch field {
+	case "title":
+		if t.Title == "" {
+			return it.name
+		}
+		return t.Title
+	case "artist":
+		return t.Artist
+	case "album":
+		return t.Album
+	case "genre":
+		return t.Genre
+	case "year":
+		if t.Year > 0 {
+			return fmt.Sprint(t.Year)
+		}
+	}
+	return ""
+}
+
+// fitCell pads or trims s to exactly w runes.
+func fitCell(s string, w int, align string) string {
+	r := []rune(s)
+	if len(r) > w {
+		if w > 3 {
+			return string(r[:w-1]) + "…"
+		}
+		return string(r[:w])
+	}
+	pad := w - len(r)
+	switch align {
+	case "right":
+		return strings.Repeat(" ", pad) + s
+	case "center":
+		return strings.Repeat(" ", pad/2) + s + strings.Repeat(" ", pad-pad/2)
+	}
+	return s + strings.Repeat(" ", pad)
+}
+
+// playlistRow lays out row i; pos is what the search matched, moved to
+// where the name landed.
+func (m *model) playlistRow(cols []Column, i int, it displayItem) (string, []int) {
+	cells := make([]string, len(cols))
+	var pos []int
+	x := 0
+	for c, col := range cols {
+		s := m.cell(col.Field, i, it)
+		cells[c] = fitCell(s, col.Width, col.Align)
+		if col.Field == "name" {
+			lead, n := 0, len([]rune(s))
+			if n > col.Width {
+				n = col.Width
+				if n > 3 {
+					n--
+				}
+			} else if col.Align == "right" {
+				lead = col.Width - n
+			} else if col.Align == "center" {
+				lead = (col.Width - n) / 2
+			}
+			limit := x + lead + n
+			if m.marked[it.path] {
+				lead += len([]rune(glyphs.Mark))
+			}
+			pos = fuzzy.ShiftPos(m.matchPos(1, i), x+lead, limit)
+		}
+		x += col.Width + 1
+	}
+	return TrimText(strings.Join(cells, " "), m.textWidth()), pos
+}
+
+// columnHeader titles the columns and marks the one the playlist was last
+// sorted by.
+func (m *model) columnHeader(cols []Column) string {
+	cells := make([]string, len(cols))
+	for c, col := range cols {
+		title, ok := columnTitles[col.Field]
+		if !ok {
+			title = strings.ToUpper(col.Field)
+		}
+		if f := sortField(col.Field); f != "" && f == m.sortedBy {
+			if m.sortDesc {
+				title += "▼"
+			} else {
+				title += "▲"
+			}
+		}
+		cells[c] = fitCell(title, col.Width, col.Align)
+	}
+	return TrimText(strings.Join(cells, " "), m.textWidth())
+}
+
+// columnAt is the sortable field under column x of the pane text.
+func (m *model) columnAt(x int) string {
+	for _, c := range m.columns() {
+		if x < c.Width {
+			return sortField(c.Field)
+		}
+		if x -= c.Width + 1; x < 0 {
+			return ""
+		}
+	}
+	return ""
+}
+
+// sortField is the sort a column stands for; "#" has none.
+func sortField(field string) string {
+	if field == "name" {
+		return "title"
+	}
+	if hasString(sortFields, field) {
+		return field
+	}
+	return ""
+}
+
+func (m *model) sortBy(field string, desc bool) {
+	args := []string{field}
+	if desc {
+		args = append(args, "desc")
+	}
+	if _, err := sortCommand(m, args); err == nil {
+		m.setNotice("sorted by " + strings.Join(args, " "))
+	}
+}
+
+// sortNextColumn steps through the sortable columns on screen, each first
+// ascending and then descending.
+func (m *model) sortNextColumn() {
+	var fields []string
+	for _, c := range m.columns() {
+		if f := sortField(c.Field); f != "" && !hasString(fields, f) {
+			fields = append(fields, f)
+		}
+	}
+	if len(fields) == 0 {
+		return
+	}
+	i := -1
+	for j, f := range fields {
+		if f == m.sortedBy {
+			i = j
+		}
+	}
+	switch {
+	case i < 0:
+		m.sortBy(fields[0], false)
+	case !m.sortDesc:
+		m.sortBy(fields[i], true)
+	default:
+		m.sortBy(fields[(i+1)%len(fields)], false)
+	}
+}
+
+func (m *model) playlistTitle() string {
+	n := len(m.state.Playlist)
+	if n == 0 {
+		return ""
+	}
+	total, unknown := m.queueLength()
+	s := fmt.Sprintf(" %d tracks", n)
+	if total > 0 {
+		s += " · " + longClock(total)
+		if unknown > 0 {
+			s += "+"
+		}
+	}
+	return s
+}
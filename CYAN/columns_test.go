@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/totiks2012/Cyan_audio_player/internal/libindex"
+)
+
+func widths(cols []Column) (fields []string, total int) {
+	for _, c := range cols {
+		fields = append(fields, c.Field)
+		total += c.Width
+	}
+	return fields, total + len(cols) - 1
+}
+
+func TestColumns(t *testing.T) {
+	m := &model{library: &libindex.Index{Tracks: map[string]libindex.Track{}}}
+	for i := 0; i < 12; i++ {
+		m.state.Playlist = append(m.state.Playlist, fmt.Sprintf("http://radio.example/%d", i))
+	}
+	ratingW := utf8.RuneCountInString(stars(0, true))
+
+	cols := m.columns()
+	fields, total := widths(cols)
+	if !reflect.DeepEqual(fields, []string{"#", "name", "duration", "rating"}) || total != m.textWidth() {
+		t.Fatalf("default columns %v fill %d of %d", fields, total, m.textWidth())
+	}
+	if cols[0].Width != 3 || cols[0].Align != "right" || cols[2].Width != 4 || cols[2].Align != "right" || cols[3].Width != ratingW {
+		t.Errorf("fixed columns %+v", cols)
+	}
+	if want := m.textWidth() - 3 - 4 - ratingW - 3; cols[1].Width != want || cols[1].Align != "left" {
+		t.Errorf("name column %+v, want width %d", cols[1], want)
+	}
+
+	// two flexible columns share the room, the odd column going left
+	m.config.Columns = []Column{{Field: "Artist"}, {Field: "bogus"}, {Field: "title", Align: "center"}, {Field: "year", Width: 6}}
+	cols = m.columns()
+	fields, total = widths(cols)
+	if !reflect.DeepEqual(fields, []string{"artist", "title", "year"}) || total != m.textWidth() {
+		t.Errorf("configured columns %v fill %d of %d", fields, total, m.textWidth())
+	}
+	if d := cols[0].Width - cols[1].Width; d < 0 || d > 1 || cols[1].Align != "center" || cols[2].Width != 6 || cols[2].Align != "right" {
+		t.Errorf("configured columns %+v", cols)
+	}
+
+	// what does not fit is dropped from the right
+	m.termWidth = 20
+	m.config.Columns = []Column{{Field: "name"}, {Field: "path", Width: 30}, {Field: "#"}}
+	cols = m.columns()
+	if fields, _ = widths(cols); !reflect.DeepEqual(fields, []string{"name"}) || cols[0].Width != m.textWidth() {
+		t.Errorf("narrow columns %+v in %d", cols, m.textWidth())
+	}
+}
+
+func TestFitCell(t *testing.T) {
+	for _, c := range []struct {
+		s     string
+		w     int
+		align string
+		want  string
+	}{
+		{"Кино", 6, "left", "Кино  "},
+		{"Кино", 6, "right", "  Кино"},
+		{"Кино", 7, "center", " Кино  "},
+		{"Группа крови", 8, "left", "Группа …"},
+		{"Группа", 3, "left", "Гру"},
+		{"", 2, "", "  "},
+	} {
+		if got := fitCell(c.s, c.w, c.align); got != c.want {
+			t.Errorf("fitCell(%q, %d, %s) = %q, want %q", c.s, c.w, c.align, got, c.want)
+		}
+	}
+}
+
+func TestColumnAt(t *testing.T) {
+	m := &model{library: &libindex.Index{Tracks: map[string]libindex.Track{}}}
+	m.config.Columns = []Column{{Field: "#", Width: 3}, {Field: "name", Width: 10}, {Field: "year", Width: 4}}
+	for x, want := range map[int]string{0: "", 2: "", 3: "", 4: "title", 13: "title", 14: "", 15: "year", 18: "year"} {
+		if got := m.columnAt(x); got != want {
+			t.Errorf("columnAt(%d) = %q, want %q", x, got, want)
+		}
+	}
+}
+
+func sortModel(t *testing.T) *model {
+	t.Chdir(t.TempDir())
+	m := &model{library: &libindex.Index{Tracks: map[string]libindex.Track{}}, leftView: viewFind, now: time.Now}
+	for _, tr := range []libindex.Track{
+		{Path: "/m/b.mp3", Meta: libindex.Meta{Artist: "Кино", Album: "Группа крови", Title: "Спокойная ночь", Year: 1988}, Length: 367, Rating: 4},
+		{Path: "/m/a.mp3", Meta: libindex.Meta{Artist: "Аквариум", Title: "Город золотой", Year: 1986}, Length: 190, Rating: 4, Loved: true},
+		{Path: "/m/c.mp3", Meta: libindex.Meta{Artist: "Кино", Album: "Звезда по имени Солнце", Title: "Кукушка", Year: 1990}, Length: 400},
+	} {
+		m.library.Tracks[tr.Path] = tr
+		m.state.Playlist = append(m.state.Playlist, tr.Path)
+	}
+	m.state.CurrentIndex = 0
+	return m
+}
+
+func TestSortCommand(t *testing.T) {
+	m := sortModel(t)
+	for _, c := range []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"artist"}, []string{"/m/a.mp3", "/m/b.mp3", "/m/c.mp3"}},
+		{[]string{"year", "desc"}, []string{"/m/c.mp3", "/m/b.mp3", "/m/a.mp3"}},
+		{[]string{"title"}, []string{"/m/a.mp3", "/m/c.mp3", "/m/b.mp3"}},
+		{[]string{"duration"}, []string{"/m/a.mp3", "/m/b.mp3", "/m/c.mp3"}},
+		{[]string{"rating", "desc"}, []string{"/m/a.mp3", "/m/b.mp3", "/m/c.mp3"}},
+		{[]string{"album"}, []string{"/m/a.mp3", "/m/b.mp3", "/m/c.mp3"}},
+	} {
+		if _, err := sortCommand(m, c.args); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(m.state.Playlist, c.want) {
+			t.Errorf("sort %v = %v, want %v", c.args, m.state.Playlist, c.want)
+		}
+		if cur := m.state.Playlist[m.state.CurrentIndex]; cur != "/m/b.mp3" {
+			t.Errorf("sort %v moved the current track to %s", c.args, cur)
+		}
+		if m.sortedBy != c.args[0] || m.sortDesc != (len(c.args) == 2) {
+			t.Errorf("sort %v marked %s desc=%v", c.args, m.sortedBy, m.sortDesc)
+		}
+	}
+	for _, args := range [][]string{nil, {"bpm"}, {"year", "up"}, {"year", "desc", "x"}} {
+		if _, err := sortCommand(m, args); err == nil {
+			t.Errorf("sort %v accepted", args)
+		}
+	}
+}
+
+func TestSortNextColumn(t *testing.T) {
+	m := sortModel(t)
+	var got []string
+	for i := 0; i < 7; i++ {
+		m.sortNextColumn()
+		s := m.sortedBy
+		if m.sortDesc {
+			s += " desc"
+		}
+		got = append(got, s)
+	}
+	want := []string{"title", "title desc", "duration", "duration desc", "rating", "rating desc", "title"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	m.sortedBy = ""
+	if h := m.columnHeader(m.columns()); !utf8.ValidString(h) || len([]rune(h)) != m.textWidth() {
+		t.Errorf("header %q", h)
+	}
+	m.sortBy("year", true)
+	m.config.Columns = []Column{{Field: "year", Width: 6}, {Field: "name"}}
+	if h := m.columnHeader(m.columns()); !strings.HasPrefix(h, " YEAR▼ NAME") {
+		t.Errorf("header %q does not mark the year sort", h)
+	}
+}
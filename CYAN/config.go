@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// loadConfig reads config.json; a missing file is an empty config. When
+// the file can't be read the error is kept in the config too, so
+// saveConfig won't write over what the user has there.
+func loadConfig(file string) (Config, error) {
+	var cfg Config
+	d, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err == nil {
+		err = json.Unmarshal(d, &cfg)
+	}
+	if err != nil {
+		cfg = Config{loadErr: fmt.Errorf("%s: %w", file, err)}
+		return cfg, cfg.loadErr
+	}
+	return cfg, nil
+}
+
+// saveConfig writes cfg over the keys cyan knows and keeps every other key
+// of the file as it is.
+func saveConfig(cfg Config) error {
+	return writeConfig(configFile, cfg)
+}
+
+func (m *model) saveConfig() {
+	if err := saveConfig(m.config); err != nil {
+		m.setNotice(err.Error())
+	}
+}
+
+func writeConfig(file string, cfg Config) error {
+	if cfg.loadErr != nil {
+		return fmt.Errorf("not saving, config.json did not load: %w", cfg.loadErr)
+	}
+	d, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	if old, err := os.ReadFile(file); err == nil && len(strings.TrimSpace(string(old))) > 0 {
+		if d, err = mergeJSON(old, d, reflect.TypeOf(cfg)); err != nil {
+			return fmt.Errorf("not saving, %s: %w", file, err)
+		}
+	} else if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var out bytes.Buffer
+	_ = json.Indent(&out, d, "", "  ")
+	out.WriteByte('\n')
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, out.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// mergeJSON lays cur over old: the fields of t come from cur (or go away
+// when cur leaves them out), anything t doesn't know stays as old has it.
+func mergeJSON(old, cur []byte, t reflect.Type) ([]byte, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return cur, nil
+	}
+	var o, c map[string]json.RawMessage
+	if err := json.Unmarshal(old, &o); err != nil {
+		return nil, err
+	}
+	if json.Unmarshal(cur, &c) != nil || o == nil {
+		return cur, nil
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if !f.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		v, ok := c[name]
+		if !ok {
+			delete(o, name)
+			continue
+		}
+		if prev, ok := o[name]; ok {
+			if m, err := mergeJSON(prev, v, f.Type); err == nil {
+				v = m
+			}
+		}
+		o[name] = v
+	}
+	return json.Marshal(o)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWriteConfigKeepsUnknownKeys(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	old := `{
+  "theme": "nord",
+  "favourites": [{"name": "Radio", "url": "http://radio.example/live"}],
+  "scrobble": {"lastfm": {"api_key": "k", "secret": "s", "session_key": "old", "user": "ann"}, "mystery": 1},
+  "my_own_key": {"keep": true}
+}`
+	if err := os.WriteFile(file, []byte(old), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := loadConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Favourites = nil
+	cfg.Scrobble.LastFM.SessionKey = "new"
+	if err := writeConfig(file, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	var got map[string]interface{}
+	d, _ := os.ReadFile(file)
+	if err := json.Unmarshal(d, &got); err != nil {
+		t.Fatalf("written config: %v\n%s", err, d)
+	}
+	if _, ok := got["favourites"]; ok {
+		t.Error("a removed favourite is still there")
+	}
+	if !reflect.DeepEqual(got["my_own_key"], map[string]interface{}{"keep": true}) {
+		t.Errorf("my_own_key = %v", got["my_own_key"])
+	}
+	sc := got["scrobble"].(map[string]interface{})
+	if sc["mystery"] != 1.0 {
+		t.Errorf("scrobble.mystery = %v", sc["mystery"])
+	}
+	if lf := sc["lastfm"].(map[string]interface{}); lf["session_key"] != "new" || lf["user"] != "ann" {
+		t.Errorf("scrobble.lastfm = %v", lf)
+	}
+	if got["theme"] != "nord" {
+		t.Errorf("theme = %v", got["theme"])
+	}
+}
+
+func TestWriteConfigRefusesBrokenFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	broken := `{"theme": "nord", "favourites": [`
+	if err := os.WriteFile(file, []byte(broken), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := loadConfig(file)
+	if err == nil {
+		t.Fatal("a broken config loaded")
+	}
+	cfg.Theme = "dracula"
+	if err := writeConfig(file, cfg); err == nil {
+		t.Error("saved over a config that did not load")
+	}
+	if err := writeConfig(file, Config{Theme: "dracula"}); err == nil {
+		t.Error("saved over a file that is not JSON")
+	}
+	if d, _ := os.ReadFile(file); string(d) != broken {
+		t.Errorf("the file changed to %s", d)
+	}
+}
+
+func TestLoadConfigMissing(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	cfg, err := loadConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Theme = "nord"
+	if err := writeConfig(file, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg, err = loadConfig(file); err != nil || cfg.Theme != "nord" {
+		t.Errorf("round trip: %+v %v", cfg, err)
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/totiks2012/Cyan_audio_player/internal/mpris"
+)
+
+// The control socket speaks newline-delimited JSON. A request is
+// {"id":1,"cmd":"status","args":[...]}, every request gets a reply with the
+// same id, and the server may push {"event":"state","data":{...}} at any time.
+
+type ctlRequest struct {
+	ID    int      `json:"id,omitempty"`
+	Cmd   string   `json:"cmd"`
+	Args  []string `json:"args,omitempty"`
+	State *State   `json:"state,omitempty"`
+}
+
+type ctlResponse struct {
+	ID    int         `json:"id,omitempty"`
+	OK    bool        `json:"ok"`
+	Error string      `json:"error,omitempty"`
+	Event string      `json:"event,omitempty"`
+	Data  interface{} `json:"data,omitempty"`
+}
+
+type ctlReply struct {
+	ID    int             `json:"id"`
+	OK    bool            `json:"ok"`
+	Error string          `json:"error"`
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data"`
+}
+
+type playerStatus struct {
+	Title       string  `json:"title"`
+	Path        string  `json:"path"`
+	Index       int     `json:"index"`
+	Count       int     `json:"count"`
+	Position    float64 `json:"position"`
+	Duration    float64 `json:"duration"`
+	Paused      bool    `json:"paused"`
+	Volume      int     `json:"volume"`
+	StreamTitle string  `json:"stream_title,omitempty"`
+	StreamMsg   string  `json:"stream_msg,omitempty"`
+	Buffering   bool    `json:"buffering,omitempty"`
+	BufferPct   int     `json:"buffer_pct,omitempty"`
+	CacheSec    float64 `json:"cache_seconds,omitempty"`
+}
+
+func (st playerStatus) mpris() mpris.Status {
+	return mpris.Status{Title: st.Title, Path: st.Path, Index: st.Index, Position: st.Position, Duration: st.Duration,
+		Paused: st.Paused, Volume: st.Volume, StreamTitle: st.StreamTitle}
+}
+
+func runtimeSocket(name string) string {
+	if d := os.Getenv("XDG_RUNTIME_DIR"); d != "" {
+		return filepath.Join(d, name+".sock")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("%s-%d.sock", name, os.Getuid()))
+}
+
+func socketPath() string {
+	return runtimeSocket("cyan")
+}
+
+// parseSeek accepts "+10"/"-10" (relative seconds), "1:23" or "83"
+// (absolute) and "50%" (absolute percent) and returns mpv seek arguments.
+func parseSeek(s string) (string, string, error) {
+	s = strings.TrimSpace(s)
+	mode := "absolute"
+	if strings.HasSuffix(s, "%") {
+		s, mode = strings.TrimSuffix(s, "%"), "absolute-percent"
+	} else if strings.HasPrefix(s, "+") || strings.HasPrefix(s, "-") {
+		mode = "relative"
+	}
+	sign := 1.0
+	if strings.HasPrefix(s, "-") {
+		sign = -1
+	}
+	var secs float64
+	for _, part := range strings.Split(strings.TrimLeft(s, "+-"), ":") {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil || v < 0 {
+			return "", "", fmt.Errorf("bad position %q", s)
+		}
+		secs = secs*60 + v
+	}
+	return strconv.FormatFloat(sign*secs, 'f', -1, 64), mode, nil
+}
+
+func parseVolume(s string, cur int) (int, error) {
+	v, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("bad volume %q", s)
+	}
+	if strings.HasPrefix(s, "+") || strings.HasPrefix(s, "-") {
+		return v, nil
+	}
+	return v - cur, nil
+}
+
+func (m *model) status() playerStatus {
+	st := playerStatus{
+		Index:       m.state.CurrentIndex,
+		Count:       len(m.state.Playlist),
+		Position:    m.curPos,
+		Duration:    m.curDur,
+		Volume:      m.state.Volume,
+		StreamTitle: m.icyTitle,
+		StreamMsg:   m.streamMsg,
+		Buffering:   m.buffering,
+		BufferPct:   m.bufPct,
+		CacheSec:    m.cacheSec,
+	}
+	if m.state.CurrentIndex >= 0 && m.state.CurrentIndex < len(m.state.Playlist) {
+		raw := m.state.Playlist[m.state.CurrentIndex]
+		st.Title, st.Path = filepath.Base(raw), raw
+		if strings.Contains(raw, m3uSeparator) {
+			parts := strings.SplitN(raw, m3uSeparator, 2)
+			st.Title, st.Path = parts[0], parts[1]
+		}
+	}
+	if m.player != nil {
+		st.Paused = m.player.getProp("pause") == "yes"
+	}
+	return st
+}
+
+type ctlHandler func(s *ctlServer, c *ctlConn, m *model, req ctlRequest) (interface{}, error)
+
+var ctlHandlers = map[string]ctlHandler{
+	"state": func(s *ctlServer, c *ctlConn, m *model, req ctlRequest) (interface{}, error) {
+		return m.state, nil
+	},
+	"set_state": func(s *ctlServer, c *ctlConn, m *model, req ctlRequest) (interface{}, error) {
+		if req.State == nil {
+			return nil, errors.New("set_state needs a state")
+		}
+		m.state = *req.State
+		m.podState = loadPodcastState()
+		m.refresh()
+		s.origin = c
+		m.save()
+		s.origin = nil
+		return nil, nil
+	},
+	"start": func(s *ctlServer, c *ctlConn, m *model, req ctlRequest) (interface{}, error) {
+		if len(req.Args) != 2 {
+			return nil, errors.New("usage: start PATH VOLUME")
+		}
+		vol, err := strconv.Atoi(req.Args[1])
+		if err != nil {
+			return nil, err
+		}
+		m.player.Start(req.Args[0], vol)
+		return nil, nil
+	},
+	"command": func(s *ctlServer, c *ctlConn, m *model, req ctlRequest) (interface{}, error) {
+		if r := m.player.Command(req.Args...); r < 0 {
+			return nil, fmt.Errorf("mpv command failed (%d)", r)
+		}
+		return nil, nil
+	},
+	"set_prop": func(s *ctlServer, c *ctlConn, m *model, req ctlRequest) (interface{}, error) {
+		if len(req.Args) != 2 {
+			return nil, errors.New("usage: set_prop NAME VALUE")
+		}
+		if r := m.player.setProp(req.Args[0], req.Args[1]); r < 0 {
+			return nil, fmt.Errorf("cannot set %s (%d)", req.Args[0], r)
+		}
+		return nil, nil
+	},
+	"get_prop": func(s *ctlServer, c *ctlConn, m *model, req ctlRequest) (interface{}, error) {
+		if len(req.Args) != 1 {
+			return nil, errors.New("usage: get_prop NAME")
+		}
+		return m.player.getProp(req.Args[0]), nil
+	},
+	"shutdown": func(s *ctlServer, c *ctlConn, m *model, req ctlRequest) (interface{}, error) {
+		select {
+		case s.shutdown <- len(req.Args) > 0 && req.Args[0] == "save":
+		default:
+		}
+		return nil, nil
+	},
+}
+
+// lookupHandler finds the protocol handler for cmd, or else the player
+// command of that name. Quitting a player over the socket shuts it down.
+func lookupHandler(cmd string) (ctlHandler, bool) {
+	if h, ok := ctlHandlers[cmd]; ok {
+		return h, true
+	}
+	a := lookupCommand(cmd)
+	switch {
+	case a == nil:
+		return nil, false
+	case a.Action == "quit":
+		return func(s *ctlServer, c *ctlConn, m *model, req ctlRequest) (interface{}, error) {
+			return ctlHandlers["shutdown"](s, c, m, ctlRequest{})
+		}, true
+	case a.Action == "quit_resume":
+		return func(s *ctlServer, c *ctlConn, m *model, req ctlRequest) (interface{}, error) {
+			return ctlHandlers["shutdown"](s, c, m, ctlRequest{Args: []string{"save"}})
+		}, true
+	}
+	return func(s *ctlServer, c *ctlConn, m *model, req ctlRequest) (interface{}, error) {
+		return m.runCommand(req.Cmd, req.Args)
+	}, true
+}
+
+type ctlConn struct {
+	c   net.Conn
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func (c *ctlConn) send(v interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.c.SetWriteDeadline(time.Now().Add(2 * time.Second))
+	_ = c.enc.Encode(v)
+}
+
+type ctlServer struct {
+	ln       net.Listener
+	path     string
+	exec     func(func(m *model))
+	mu       sync.Mutex
+	clients  map[*ctlConn]bool
+	origin   *ctlConn
+	shutdown chan bool
+}
+
+// listenControl serves the control socket. exec must run fn on the goroutine
+// (or under the lock) that owns the model.
+func listenControl(path string, exec func(func(m *model))) (*ctlServer, error) {
+	if c, err := net.Dial("unix", path); err == nil {
+		c.Close()
+		return nil, fmt.Errorf("%s is already served by another player", path)
+	}
+	_ = os.Remove(path)
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	_ = os.Chmod(path, 0600)
+	s := &ctlServer{ln: ln, path: path, exec: exec, clients: map[*ctlConn]bool{}, shutdown: make(chan bool, 1)}
+	go s.serve()
+	return s, nil
+}
+
+func (s *ctlServer) serve() {
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(&ctlConn{c: c, enc: json.NewEncoder(c)})
+	}
+}
+
+func (s *ctlServer) handle(c *ctlConn) {
+	s.mu.Lock()
+	s.clients[c] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, c)
+		s.mu.Unlock()
+		c.c.Close()
+	}()
+
+	sc := bufio.NewScanner(c.c)
+	sc.Buffer(make([]byte, 64*1024), 16<<20)
+	for sc.Scan() {
+		var req ctlRequest
+		if err := json.Unmarshal(sc.Bytes(), &req); err != nil {
+			c.send(ctlResponse{Error: "bad request: " + err.Error()})
+			continue
+		}
+		h, ok := lookupHandler(req.Cmd)
+		if !ok {
+			c.send(ctlResponse{ID: req.ID, Error: "unknown command " + strconv.Quote(req.Cmd)})
+			continue
+		}
+		var data interface{}
+		var err error
+		s.exec(func(m *model) { data, err = h(s, c, m, req) })
+		resp := ctlResponse{ID: req.ID, OK: err == nil, Data: data}
+		if err != nil {
+			resp.Error = err.Error()
+		}
+		c.send(resp)
+	}
+}
+
+func (s *ctlServer) broadcast(event string, data interface{}) {
+	s.mu.Lock()
+	var targets []*ctlConn
+	for c := range s.clients {
+		if c != s.origin {
+			targets = append(targets, c)
+		}
+	}
+	s.mu.Unlock()
+	for _, c := range targets {
+		c.send(ctlResponse{OK: true, Event: event, Data: data})
+	}
+}
+
+func (s *ctlServer) Close() {
+	s.ln.Close()
+	s.mu.Lock()
+	for c := range s.clients {
+		c.c.Close()
+	}
+	s.mu.Unlock()
+	_ = os.Remove(s.path)
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	_ "image/jpeg"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/dhowden/tag"
+	"github.com/totiks2012/Cyan_audio_player/internal/libindex"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	coverOff    = "off"
+	coverBlocks = "blocks"
+	coverKitty  = "kitty"
+	coverSixel  = "sixel"
+)
+
+// kittyImageID is the id cyan's cover uses with the kitty graphics protocol,
+// so a new cover replaces the old one instead of piling up.
+const kittyImageID = 7373
+
+var coverNames = []string{"cover.jpg", "folder.jpg", "cover.png", "folder.png", "front.jpg", "front.png", "albumart.jpg"}
+
+// detectCoverMode resolves the cover_art setting; "auto" (or nothing) picks
+// the best protocol the terminal is known to speak.
+func detectCoverMode(setting string) string {
+	switch setting {
+	case coverOff, coverBlocks, coverKitty, coverSixel:
+		return setting
+	}
+	term, prog := os.Getenv("TERM"), os.Getenv("TERM_PROGRAM")
+	switch {
+	case os.Getenv("TMUX") != "" || strings.HasPrefix(term, "screen"):
+		return coverBlocks
+	case os.Getenv("KITTY_WINDOW_ID") != "" || term == "xterm-kitty" || term == "xterm-ghostty" || prog == "ghostty" || prog == "WezTerm":
+		return coverKitty
+	case strings.HasPrefix(term, "foot") || strings.HasPrefix(term, "mlterm") || strings.Contains(term, "sixel") || prog == "iTerm.app":
+		return coverSixel
+	}
+	return coverBlocks
+}
+
+type coverArt struct {
+	path   string
+	meta   libindex.Meta
+	img    image.Image
+	blocks string
+	cols   int
+}
+
+type coverMsg struct {
+	path string
+	meta libindex.Meta
+	img  image.Image
+}
+
+// loadCover returns the embedded picture of path, or the folder's cover
+// image when the file has none.
+func loadCover(path string) image.Image {
+	if f, err := os.Open(path); err == nil {
+		t, err := tag.ReadFrom(f)
+		f.Close()
+		if err == nil && t.Picture() != nil {
+			if img, _, err := image.Decode(bytes.NewReader(t.Picture().Data)); err == nil {
+				return img
+			}
+		}
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return nil
+	}
+	for _, name := range coverNames {
+		for _, e := range entries {
+			if !strings.EqualFold(e.Name(), name) {
+				continue
+			}
+			if f, err := os.Open(filepath.Join(filepath.Dir(path), e.Name())); err == nil {
+				img, _, err := image.Decode(f)
+				f.Close()
+				if err == nil {
+					return img
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// scaleImage box-filters img down (or stretches it up) to w×h.
+func scaleImage(img image.Image, w, h int) *image.RGBA {
+	src := image.NewRGBA(img.Bounds())
+	draw.Draw(src, src.Bounds(), img, img.Bounds().Min, draw.Src)
+	sb := src.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := sb.Dy()*y/h, sb.Dy()*(y+1)/h
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < w; x++ {
+			x0, x1 := sb.Dx()*x/w, sb.Dx()*(x+1)/w
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var r, g, b, n int
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					i := src.PixOffset(sb.Min.X+sx, sb.Min.Y+sy)
+					r, g, b, n = r+int(src.Pix[i]), g+int(src.Pix[i+1]), b+int(src.Pix[i+2]), n+1
+				}
+			}
+			out.SetRGBA(x, y, color.RGBA{uint8(r / n), uint8(g / n), uint8(b / n), 255})
+		}
+	}
+	return out
+}
+
+// halfBlocks draws two pixels per cell: "▀" in the top colour over the
+// bottom colour as background.
+func halfBlocks(img *image.RGBA) string {
+	var sb strings.Builder
+	b := img.Bounds()
+	for y := b.Min.Y; y+1 < b.Max.Y; y += 2 {
+		if y > b.Min.Y {
+			sb.WriteByte('\n')
+		}
+		for x := b.Min.X; x < b.Max.X; x++ {
+			t, u := img.RGBAAt(x, y), img.RGBAAt(x, y+1)
+			fmt.Fprintf(&sb, "\x1b[38;2;%d;%d;%dm\x1b[48;2;%d;%d;%dm▀", t.R, t.G, t.B, u.R, u.G, u.B)
+		}
+		sb.WriteString("\x1b[0m")
+	}
+	return sb.String()
+}
+
+// kittyImage sends img as PNG, scaled by the terminal into cols×rows cells.
+func kittyImage(img image.Image, cols, rows int) string {
+	var buf bytes.Buffer
+	if png.Encode(&buf, img) != nil {
+		return ""
+	}
+	data := base64.StdEncoding.EncodeToString(buf.Bytes())
+	var sb strings.Builder
+	for first := true; len(data) > 0; first = false {
+		chunk := data
+		if len(chunk) > 4096 {
+			chunk = chunk[:4096]
+		}
+		data = data[len(chunk):]
+		more := 0
+		if len(data) > 0 {
+			more = 1
+		}
+		if first {
+			fmt.Fprintf(&sb, "\x1b_Ga=T,f=100,i=%d,c=%d,r=%d,C=1,q=2,m=%d;%s\x1b\\", kittyImageID, cols, rows, more, chunk)
+		} else {
+			fmt.Fprintf(&sb, "\x1b_Gm=%d;%s\x1b\\", more, chunk)
+		}
+	}
+	return sb.String()
+}
+
+func kittyDelete() string {
+	return fmt.Sprintf("\x1b_Ga=d,d=I,i=%d,q=2\x1b\\", kittyImageID)
+}
+
+// sixelImage encodes img with a 216-colour cube palette.
+func sixelImage(img *image.RGBA) string {
+	b := img.Bounds()
+	idx := func(c color.RGBA) int {
+		return int(c.R)*6/256*36 + int(c.G)*6/256*6 + int(c.B)*6/256
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "\x1bP0;1;0q\"1;1;%d;%d", b.Dx(), b.Dy())
+	for i := 0; i < 216; i++ {
+		fmt.Fprintf(&sb, "#%d;2;%d;%d;%d", i, i/36*20, i/6%6*20, i%6*20)
+	}
+	row := make([]byte, b.Dx())
+	for y := b.Min.Y; y < b.Max.Y; y += 6 {
+		used := map[int]bool{}
+		for dy := 0; dy < 6 && y+dy < b.Max.Y; dy++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				used[idx(img.RGBAAt(x, y+dy))] = true
+			}
+		}
+		for c := range used {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				var bits byte
+				for dy := 0; dy < 6 && y+dy < b.Max.Y; dy++ {
+					if idx(img.RGBAAt(x, y+dy)) == c {
+						bits |= 1 << dy
+					}
+				}
+				row[x-b.Min.X] = '?' + bits
+			}
+			fmt.Fprintf(&sb, "#%d", c)
+			for i := 0; i < len(row); {
+				j := i
+				for j < len(row) && row[j] == row[i] {
+					j++
+				}
+				if j-i > 3 {
+					fmt.Fprintf(&sb, "!%d%c", j-i, row[i])
+				} else {
+					sb.Write(row[i:j])
+				}
+				i = j
+			}
+			sb.WriteByte('$')
+		}
+		sb.WriteByte('-')
+	}
+	sb.WriteString("\x1b\\")
+	return sb.String()
+}
+
+// cellSize is the terminal's cell size in pixels, guessed when the terminal
+// does not report it.
+func cellSize() (int, int) {
+	if ws, err := unix.IoctlGetWinsize(int(os.Stdout.Fd()), unix.TIOCGWINSZ); err == nil && ws.Col > 0 && ws.Row > 0 && ws.Xpixel > 0 {
+		return int(ws.Xpixel / ws.Col), int(ws.Ypixel / ws.Row)
+	}
+	return 10, 20
+}
+
+// coverRowsFor sizes the now-playing panel: none on short terminals,
+// growing with the height up to a dozen rows.
+func coverRowsFor(termHeight int) int {
+	if termHeight < 28 {
+		return 0
+	}
+	rows := termHeight / 4
+	if rows > 12 {
+		rows = 12
+	}
+	return rows
+}
+
+// coverCmd starts loading the cover when the track changes.
+func (m *model) coverCmd() tea.Cmd {
+	if m.coverMode == "" || m.coverMode == coverOff {
+		return nil
+	}
+	p := m.currentPath()
+	if isURL(p) {
+		p = ""
+	}
+	if m.cover != nil && m.cover.path == p {
+		return nil
+	}
+	m.cover = &coverArt{path: p}
+	if p == "" {
+		return m.drawCover()
+	}
+	return func() tea.Msg {
+		return coverMsg{p, libindex.ReadMeta(p), loadCover(p)}
+	}
+}
+
+func (m *model) onCover(msg coverMsg) tea.Cmd {
+	if m.cover == nil || m.cover.path != msg.path {
+		return nil
+	}
+	m.cover.meta, m.cover.img = msg.meta, msg.img
+	return m.drawCover()
+}
+
+// termOut is the terminal Bubble Tea draws to. Each frame is a single
+// Write, and the kitty and sixel covers go through the same lock, so an
+// image never lands in the middle of a frame.
+type termOut struct {
+	*os.File
+	mu sync.Mutex
+}
+
+func (t *termOut) Write(b []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.File.Write(b)
+}
+
+// coverDrawMsg is a kitty or sixel cover encoded off the UI goroutine; an
+// empty image clears the panel.
+type coverDrawMsg struct {
+	mode       string
+	image      string
+	rows, cols int
+}
+
+// drawCover encodes kitty and sixel images for onCoverDraw, giving Bubble
+// Tea time to draw the blank panel they go into; half blocks are plain text
+// and come with the view.
+func (m *model) drawCover() tea.Cmd {
+	if m.coverMode != coverKitty && m.coverMode != coverSixel {
+		return nil
+	}
+	rows, cols := m.coverRows, m.coverRows*2
+	var img image.Image
+	if m.cover != nil && rows > 0 {
+		img = m.cover.img
+	}
+	mode := m.coverMode
+	return func() tea.Msg {
+		time.Sleep(50 * time.Millisecond)
+		msg := coverDrawMsg{mode: mode, rows: rows, cols: cols}
+		switch {
+		case img == nil:
+		case mode == coverKitty:
+			msg.image = kittyImage(img, cols, rows)
+		default:
+			cw, ch := cellSize()
+			msg.image = sixelImage(scaleImage(img, cols*cw, rows*ch))
+		}
+		return msg
+	}
+}
+
+// onCoverDraw paints the cover where RenderUI last put the panel.
+func (m *model) onCoverDraw(msg coverDrawMsg) {
+	if m.term == nil {
+		return
+	}
+	top, left := m.geo.cover.y+1, m.geo.cover.x+1
+	var sb strings.Builder
+	sb.WriteString("\x1b7")
+	if msg.mode == coverKitty {
+		sb.WriteString(kittyDelete())
+	} else {
+		for r := 0; r < msg.rows; r++ {
+			fmt.Fprintf(&sb, "\x1b[%d;%dH%s", top+r, left, strings.Repeat(" ", msg.cols))
+		}
+	}
+	if msg.image != "" && m.geo.cover.h > 0 {
+		fmt.Fprintf(&sb, "\x1b[%d;%dH", top, left)
+		sb.WriteString(msg.image)
+	}
+	sb.WriteString("\x1b8")
+	_, _ = m.term.Write([]byte(sb.String()))
+}
+
+// renderCoverPanel is the art with the track's tags beside it, shown under
+// the two boxes when the terminal is tall enough.
+func (m *model) renderCoverPanel() string {
+	rows, cols := m.coverRows, m.coverRows*2
+	if rows == 0 || m.cover == nil {
+		return ""
+	}
+	c := m.cover
+	var art []string
+	switch {
+	case c.img != nil && m.coverMode == coverBlocks:
+		if c.cols != cols {
+			c.blocks, c.cols = halfBlocks(scaleImage(c.img, cols, rows*2)), cols
+		}
+		art = strings.Split(c.blocks, "\n")
+	default:
+		for i := 0; i < rows; i++ {
+			art = append(art, strings.Repeat(" ", cols))
+		}
+		if c.img == nil {
+			mid := "♪"
+			if c.path != "" {
+				mid = "no cover"
+			}
+			pad := (cols - lipgloss.Width(mid)) / 2
+			art[rows/2] = m.styles.Help.Render(strings.Repeat(" ", pad) + mid + strings.Repeat(" ", cols-pad-lipgloss.Width(mid)))
+		}
+	}
+
+	w := m.lineWidth() - 2 - cols
+	var text []string
+	if c.path != "" {
+		title := c.meta.Title
+		if title == "" {
+			title = strings.TrimSuffix(filepath.Base(c.path), filepath.Ext(c.path))
+		}
+		text = append(text, m.styles.Neon.Render(TrimText(title, w)), TrimText(c.meta.Artist, w))
+		album := c.meta.Album
+		if c.meta.Year > 0 {
+			album = strings.TrimSpace(fmt.Sprintf("%s (%d)", album, c.meta.Year))
+		}
+		text = append(text, m.styles.Help.Render(TrimText(album, w)), m.styles.Help.Render(TrimText(c.meta.Genre, w)))
+		if r := strings.TrimSpace(m.ratingColumn(c.path)); r != "" {
+			text = append(text, r)
+		}
+	}
+	lines := make([]string, rows)
+	for i := range lines {
+		lines[i] = " " + art[i]
+		if i < len(text) {
+			lines[i] += "  " + text[i]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
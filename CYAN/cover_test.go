@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/totiks2012/Cyan_audio_player/internal/keymap"
+	"github.com/totiks2012/Cyan_audio_player/internal/libindex"
+)
+
+func TestCoverDrawnOverPanel(t *testing.T) {
+	t.Chdir(t.TempDir())
+	out, err := os.Create(filepath.Join(t.TempDir(), "tty"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	m := &model{library: &libindex.Index{Tracks: map[string]libindex.Track{}}, now: time.Now, leftView: viewFind,
+		coverMode: coverSixel, term: &termOut{File: out}}
+	m.keys, _ = keymap.New(keyDefs(), nil, "")
+	m.Update(tea.WindowSizeMsg{Width: 120, Height: 40})
+	m.cover = &coverArt{path: "/m/a.mp3", img: image.NewRGBA(image.Rect(0, 0, 4, 4))}
+
+	view := strings.Split(RenderUI(m), "\n")
+	rows := m.coverRows
+	if rows == 0 || m.geo.cover.h != rows || m.geo.cover.w != 2*rows {
+		t.Fatalf("cover panel %+v for %d rows", m.geo.cover, rows)
+	}
+	if line := view[m.geo.cover.y]; !strings.HasPrefix(line, " "+strings.Repeat(" ", 2*rows)+"  ") {
+		t.Errorf("panel row %q is not blank art", line)
+	}
+
+	msg := m.drawCover()()
+	m.onCoverDraw(msg.(coverDrawMsg))
+	d, _ := os.ReadFile(out.Name())
+	at := fmt.Sprintf("\x1b[%d;2H\x1bP", m.geo.cover.y+1)
+	if !strings.HasPrefix(string(d), "\x1b7") || !strings.Contains(string(d), at) || !strings.HasSuffix(string(d), "\x1b8") {
+		t.Errorf("sixel not drawn at row %d: %q", m.geo.cover.y+1, d)
+	}
+}
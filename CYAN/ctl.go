@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ctlUsage lists every player command; the ones bound to keys are scriptable
+// too.
+func ctlUsage() string {
+	var sb strings.Builder
+	sb.WriteString("usage: cyan ctl COMMAND [ARGS]\n\n")
+	for _, a := range keyActions {
+		name := a.Action
+		if a.Usage != "" {
+			name += " " + a.Usage
+		}
+		if a.Action == "status" {
+			name += " [--json]"
+		}
+		fmt.Fprintf(&sb, "  %-22s %s\n", name, a.Help)
+	}
+	return sb.String()
+}
+
+// runCtl talks to a running cyan (daemon or TUI) and, unless a socket was
+// given explicitly, falls back to a running cy.
+func runCtl(sock string, explicit bool, args []string) int {
+	if len(args) == 0 || lookupCommand(args[0]) == nil {
+		fmt.Fprint(os.Stderr, ctlUsage())
+		return 2
+	}
+	cmd := lookupCommand(args[0]).Action
+	asJSON := false
+	var rest []string
+	for _, a := range args[1:] {
+		if a == "--json" {
+			asJSON = true
+			continue
+		}
+		if cmd == "add" || cmd == "play" || cmd == "cd" {
+			if abs, err := absIfLocal(a); err == nil {
+				a = abs
+			}
+		}
+		rest = append(rest, a)
+	}
+
+	r, err := dialControl(sock)
+	if err != nil && !explicit {
+		r, err = dialControl(runtimeSocket("cy"))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "cyan ctl: no running cyan or cy player:", err)
+		return 1
+	}
+	defer r.Stop()
+
+	rep, err := r.call(cmd, rest...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "cyan ctl:", err)
+		return 1
+	}
+	switch {
+	case asJSON || (cmd != "status" && len(rep.Data) > 0 && string(rep.Data) != "null"):
+		fmt.Println(string(rep.Data))
+	case cmd == "status":
+		var st playerStatus
+		if err := json.Unmarshal(rep.Data, &st); err != nil {
+			fmt.Fprintln(os.Stderr, "cyan ctl:", err)
+			return 1
+		}
+		fmt.Println(formatStatus(st))
+	}
+	return 0
+}
+
+func absIfLocal(p string) (string, error) {
+	if isURL(p) {
+		return p, nil
+	}
+	if _, err := os.Stat(p); err != nil {
+		return p, err
+	}
+	return filepath.Abs(p)
+}
+
+func formatStatus(st playerStatus) string {
+	if st.Path == "" {
+		return fmt.Sprintf("■ stopped  vol %d%%", st.Volume)
+	}
+	icon := "▶"
+	if st.Paused {
+		icon = "⏸"
+	}
+	title := st.Title
+	if st.StreamTitle != "" {
+		title += " — " + st.StreamTitle
+	}
+	line := fmt.Sprintf("%s %s  %s/%s  vol %d%%", icon, title, clock(st.Position), clock(st.Duration), st.Volume)
+	if st.Count > 0 {
+		line += fmt.Sprintf("  [%d/%d]", st.Index+1, st.Count)
+	}
+	if st.StreamMsg != "" {
+		line += "  " + strings.TrimSpace(st.StreamMsg)
+	}
+	return line
+}
+
+func clock(sec float64) string {
+	return fmt.Sprintf("%d:%02d", int(sec)/60, int(sec)%60)
+}
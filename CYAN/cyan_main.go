@@ -10,7 +10,10 @@ import "C"
 import (
 	"bufio"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
+	"net"
 	"os"
 	"path/filepath"
 	"sort"
@@ -21,6 +24,12 @@ import (
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/totiks2012/Cyan_audio_player/internal/fuzzy"
+	"github.com/totiks2012/Cyan_audio_player/internal/keymap"
+	"github.com/totiks2012/Cyan_audio_player/internal/libindex"
+	"github.com/totiks2012/Cyan_audio_player/internal/mpris"
+	"github.com/totiks2012/Cyan_audio_player/internal/stream"
+	"github.com/totiks2012/Cyan_audio_player/internal/theme"
 )
 
 const (
@@ -30,10 +39,40 @@ const (
 	m3uSeparator  = "|#|"
 )
 
+const (
+	viewFiles = iota
+	viewStations
+	viewPodcasts
+	viewStats
+	viewSmart
+	viewLyrics
+	viewFind
+)
+
 type Config struct {
-	ThemeColor  string `json:"theme_color"`
-	BgCursor    string `json:"bg_cursor"`
-	BorderStyle string `json:"border_style"`
+	Theme            string              `json:"theme,omitempty"`
+	ThemeColor       string              `json:"theme_color,omitempty"`
+	BgCursor         string              `json:"bg_cursor,omitempty"`
+	BorderStyle      string              `json:"border_style,omitempty"`
+	Schedule         []Alarm             `json:"schedule,omitempty"`
+	StationLists     []string            `json:"station_lists,omitempty"`
+	Favourites       []Station           `json:"favourites,omitempty"`
+	Podcasts         []Podcast           `json:"podcasts,omitempty"`
+	PodcastDir       string              `json:"podcast_dir,omitempty"`
+	HTTP             string              `json:"http,omitempty"`
+	HTTPToken        string              `json:"http_token,omitempty"`
+	Scrobble         *ScrobbleConfig     `json:"scrobble,omitempty"`
+	Library          []string            `json:"library,omitempty"`
+	WriteRatingTags  bool                `json:"write_rating_tags,omitempty"`
+	OrganiseTemplate string              `json:"organise_template,omitempty"`
+	CoverArt         string              `json:"cover_art,omitempty"`
+	Visualiser       string              `json:"visualiser,omitempty"`
+	VisFPS           int                 `json:"vis_fps,omitempty"`
+	Keys             map[string][]string `json:"keys,omitempty"`
+	Leader           string              `json:"leader,omitempty"`
+	Columns          []Column            `json:"columns,omitempty"`
+
+	loadErr error
 }
 
 type State struct {
@@ -49,30 +88,103 @@ type displayItem struct {
 }
 
 type UIStyles struct {
-	Box    lipgloss.Style
-	Active lipgloss.Style
-	Head   lipgloss.Style
-	Cursor lipgloss.Style
-	Help   lipgloss.Style
-	Neon   lipgloss.Style
+	Box      lipgloss.Style
+	Active   lipgloss.Style
+	Head     lipgloss.Style
+	Cursor   lipgloss.Style
+	Help     lipgloss.Style
+	Neon     lipgloss.Style
+	Text     lipgloss.Style
+	Faint    lipgloss.Style
+	BarEmpty lipgloss.Style
+	Warm     lipgloss.Style
+	Hot      lipgloss.Style
 }
 
-func InitStyles(cfg Config) UIStyles {
-	theme := lipgloss.Color(cfg.ThemeColor)
+// glyphs are the symbols of the current theme.
+var glyphs theme.Glyphs
+
+var cyanGlyphs = theme.Glyphs{Dir: "◆ ", Mark: "✓ ", Bar: "━", Star: "★", StarEmpty: "☆", Loved: "♥"}
+
+func InitStyles(t theme.Theme) UIStyles {
+	c := func(s string) lipgloss.Color { return lipgloss.Color(theme.NormalizeColor(s)) }
+	accent, bg := c(t.Accent), c(t.Background)
 	border := lipgloss.RoundedBorder()
-	if cfg.BorderStyle == "double" {
+	switch t.BorderStyle {
+	case "double":
 		border = lipgloss.DoubleBorder()
+	case "normal":
+		border = lipgloss.NormalBorder()
+	case "thick":
+		border = lipgloss.ThickBorder()
+	case "hidden":
+		border = lipgloss.HiddenBorder()
+	}
+	box := lipgloss.NewStyle().Border(border)
+	text := lipgloss.NewStyle().Foreground(c(t.Text))
+	if bg != "" {
+		box = box.Background(bg).BorderBackground(bg)
+		text = text.Background(bg)
 	}
 	return UIStyles{
-		Box:    lipgloss.NewStyle().Border(border).BorderForeground(lipgloss.Color("#333333")).Width(50),
-		Active: lipgloss.NewStyle().Border(border).BorderForeground(theme).Width(50),
-		Head:   lipgloss.NewStyle().Background(theme).Foreground(lipgloss.Color("#000000")).Bold(true).Padding(0, 1),
-		Cursor: lipgloss.NewStyle().Background(lipgloss.Color(cfg.BgCursor)).Foreground(lipgloss.Color("#FFFFFF")),
-		Help:   lipgloss.NewStyle().Foreground(lipgloss.Color("#666666")),
-		Neon:   lipgloss.NewStyle().Foreground(theme),
+		Box:      box.BorderForeground(c(t.Border)),
+		Active:   box.BorderForeground(accent),
+		Head:     lipgloss.NewStyle().Background(accent).Foreground(c(t.AccentText)).Bold(true).Padding(0, 1),
+		Cursor:   lipgloss.NewStyle().Background(c(t.CursorBg)).Foreground(c(t.CursorText)),
+		Help:     lipgloss.NewStyle().Foreground(c(t.Dim)),
+		Neon:     lipgloss.NewStyle().Foreground(accent),
+		Text:     text,
+		Faint:    lipgloss.NewStyle().Foreground(c(t.Border)),
+		BarEmpty: lipgloss.NewStyle().Foreground(c(t.BarEmpty)),
+		Warm:     lipgloss.NewStyle().Foreground(c(t.Warm)),
+		Hot:      lipgloss.NewStyle().Foreground(c(t.Hot)),
+	}
+}
+
+// baseTheme is cyan's own look with the preset and the old colour keys of
+// config.json on top; the theme file goes over it.
+func (cfg Config) baseTheme() (theme.Theme, error) {
+	t := theme.Theme{Glyphs: cyanGlyphs}
+	t, _ = t.WithPreset("cyan")
+	t, err := t.WithPreset(cfg.Theme)
+	if cfg.ThemeColor != "" {
+		t.Accent = cfg.ThemeColor
+	}
+	if cfg.BgCursor != "" {
+		t.CursorBg = cfg.BgCursor
+	}
+	if cfg.BorderStyle != "" {
+		t.BorderStyle = cfg.BorderStyle
+	}
+	return t, err
+}
+
+// applyTheme (re)builds the styles from config.json and the theme file.
+func (m *model) applyTheme() {
+	t, err := m.config.baseTheme()
+	t, ferr := theme.Load(t)
+	if ferr != nil {
+		err = ferr
+	}
+	m.theme = t
+	glyphs = t.Glyphs
+	m.styles = InitStyles(t)
+	if err != nil {
+		m.setNotice("theme: " + err.Error())
 	}
 }
 
+type playerBackend interface {
+	Start(path string, vol int)
+	Command(args ...string) int
+	setProp(name, val string) int
+	getProp(name string) string
+	GetPosition() (float64, float64)
+	PollEvents() (bool, []stream.EndEvent)
+	Stop()
+	SaveAndStop()
+}
+
 type MPVPlayer struct {
 	ctx     *C.mpv_handle
 	running bool
@@ -129,6 +241,11 @@ func (p *MPVPlayer) setProp(name, val string) int {
 }
 
 func (p *MPVPlayer) getProp(name string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.ctx == nil {
+		return ""
+	}
 	cn := C.CString(name)
 	defer C.free(unsafe.Pointer(cn))
 	res := C.mpv_get_property_string(p.ctx, cn)
@@ -182,6 +299,30 @@ func (p *MPVPlayer) GetPosition() (float64, float64) {
 	return pos, dur
 }
 
+func (p *MPVPlayer) PollEvents() (loaded bool, ended []stream.EndEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.running || p.ctx == nil {
+		return false, nil
+	}
+	for {
+		ev := C.mpv_wait_event(p.ctx, 0)
+		switch ev.event_id {
+		case C.MPV_EVENT_NONE, C.MPV_EVENT_SHUTDOWN:
+			return loaded, ended
+		case C.MPV_EVENT_FILE_LOADED:
+			loaded = true
+		case C.MPV_EVENT_END_FILE:
+			ef := (*C.mpv_event_end_file)(ev.data)
+			e := stream.EndEvent{Reason: stream.EndReason(ef.reason)}
+			if e.Reason == stream.EndError {
+				e.Err = C.GoString(C.mpv_error_string(ef.error))
+			}
+			ended = append(ended, e)
+		}
+	}
+}
+
 func (p *MPVPlayer) Stop() {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -202,8 +343,54 @@ func (p *MPVPlayer) SaveAndStop() {
 type model struct {
 	state          State
 	config         Config
-	player         *MPVPlayer
+	player         playerBackend
+	remote         *remotePlayer
+	onSave         func()
+	plays          playTracker
+	playLog        []playEntry
+	playLogSize    int64
+	statsPeriod    int
+	statsSummary   string
+	library        *libindex.Index
+	form           *smartForm
+	tags           *tagForm
+	organise       *organisePlan
+	cover          *coverArt
+	coverMode      string
+	coverRows      int
+	term           *termOut
+	marked         map[string]bool
+	lyrics         *lyrics
+	lyricOffsets   *positionStore
+	lyricCur       int
+	lyricsHold     time.Time
+	vis            *vuMeter
+	wave, waveData string
+	waveBusy       bool
+	waves          *waveCache
+	waveFail       map[string]bool
+	plMeta         map[string]libindex.Meta
+	lengths        map[string]float64
+	probeBusy      bool
+	probeFail      map[string]bool
+	sortedBy       string
+	sortDesc       bool
+	geo            geometry
+	seekDrag       bool
+	jumpMode       bool
+	jumpInput      string
+	keys           *keymap.Map
+	cmds           []tea.Cmd
+	palette        *palette
+	paletteHist    []string
+	showHelp       bool
+	smartMsg       string
+	notice         string
+	noticeAt       time.Time
+	scrobbles      *scrobbleQueue
 	styles         UIStyles
+	theme          theme.Theme
+	themeWatch     theme.Watcher
 	fmItems        []displayItem
 	plItems        []displayItem
 	fmCur, plCur   int
@@ -214,35 +401,133 @@ type model struct {
 	curPos, curDur float64
 	searchMode     bool
 	searchInput    string
+	filter         *search
+	findMode       bool
+	findInput      string
+	findMsg        string
+	findPos        [][]int
+	findHits       int
 	lastClick      time.Time
 	lastItem       int
 	lastFocus      int
+	now            func() time.Time
+	libraryDirty   time.Time
+	alarms         *alarmScheduler
+	ramp           *volumeRamp
+	noResume       bool
+	leftView       int
+	stations       []Station
+	stationGroup   string
+	stationHist    string
+	icyURL         string
+	icyTitle       string
+	icyHistory     stationHistory
+	reconnect      stream.Watch
+	streamMsg      string
+	buffering      bool
+	bufPct         int
+	cacheSec       float64
+	podcastSel     int
+	feeds          map[string]*Feed
+	podState       podcastState
+	podMsg         string
+	positions      *positionStore
+	posSaved       time.Time
 }
 
 func (m *model) Init() tea.Cmd {
-	if m.state.CurrentIndex >= 0 && m.state.CurrentIndex < len(m.state.Playlist) {
+	if !m.noResume && m.state.CurrentIndex >= 0 && m.state.CurrentIndex < len(m.state.Playlist) {
 		m.playTrack(m.state.CurrentIndex)
 	}
-	return tea.Tick(time.Second/2, func(t time.Time) tea.Msg { return time.Time(t) })
+	return tea.Batch(m.visTick(), tea.Tick(time.Second/2, func(t time.Time) tea.Msg { return time.Time(t) }))
 }
 
 func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
 	switch msg := msg.(type) {
+	case feedMsg:
+		m.onFeed(msg)
+
+	case lyricsMsg:
+		m.onLyrics(msg)
+
+	case lengthMsg:
+		m.onLength(msg)
+
+	case waveMsg:
+		m.onWave(msg)
+
+	case visMsg:
+		m.vis.update(msg)
+		return m, m.visTick()
+
+	case downloadMsg:
+		m.onDownload(msg)
+
+	case remoteStateMsg:
+		m.state = State(msg)
+		m.refresh()
+
+	case remoteLostMsg:
+		return m, tea.Quit
+
+	case ctlExecMsg:
+		if !msg.taken.CompareAndSwap(false, true) {
+			break
+		}
+		msg.fn(m)
+		close(msg.done)
+		m.sync()
+		cmd = m.takeCmds()
+
+	case ctlQuitMsg:
+		m.endPlay()
+		m.save()
+		if msg {
+			m.player.SaveAndStop()
+		} else {
+			m.player.Stop()
+		}
+		return m, tea.Quit
+
 	case time.Time:
-		if m.player != nil {
+		if m.remote != nil {
+			m.pullStatus()
+			m.checkAlarms()
+		} else if m.player != nil {
 			m.curPos, m.curDur = m.player.GetPosition()
+			m.trackEpisode()
+			m.trackPlay()
 			if m.curDur > 0 && m.curPos >= m.curDur-1.0 {
+				m.finishEpisode()
 				m.nextTrack()
 			}
+			if m.pollStream() {
+				m.resumeEpisode()
+			}
+			m.checkAlarms()
+			m.pollICY()
 		}
-		return m, tea.Tick(time.Second/2, func(t time.Time) tea.Msg { return time.Time(t) })
+		m.followLyrics()
+		m.flushLibrary(false)
+		if m.themeWatch.Changed() {
+			m.applyTheme()
+		}
+		return m, tea.Batch(m.coverCmd(), m.lyricsCmd(), m.waveCmd(), m.lengthCmd(), tea.Tick(time.Second/2, func(t time.Time) tea.Msg { return time.Time(t) }))
 
 	case tea.MouseMsg:
 		switch msg.Type {
 		case tea.MouseLeft:
-			if msg.Action == tea.MouseActionPress {
-				m.handleMouse(msg.X, msg.Y)
+			if pct, ok := m.seekBarAt(msg.X, msg.Y); ok && msg.Action == tea.MouseActionPress {
+				m.seekDrag = true
+				m.seekPercent(pct * 100)
+			} else if m.seekDrag && msg.Action == tea.MouseActionMotion {
+				m.seekPercent(m.barShare(msg.X) * 100)
+			} else if msg.Action == tea.MouseActionPress {
+				cmd = m.handleMouse(msg.X, msg.Y)
 			}
+		case tea.MouseRelease:
+			m.seekDrag = false
 		case tea.MouseWheelUp:
 			if m.focus == 0 {
 				m.fmCur--
@@ -258,125 +543,119 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 		m.sync()
 
+	case libraryMsg:
+		cmd = m.onLibrary(msg)
+
+	case tagWriteMsg:
+		m.onTagWrite(msg)
+
+	case tagsSavedMsg:
+		m.onTagsSaved(msg)
+
+	case organiseMsg:
+		m.onOrganise(msg)
+
+	case coverMsg:
+		cmd = m.onCover(msg)
+
+	case coverDrawMsg:
+		m.onCoverDraw(msg)
+
 	case tea.KeyMsg:
-		if m.searchMode {
-			switch msg.String() {
-			case "enter", "esc":
-				m.searchMode = false
-			case "backspace":
-				r := []rune(m.searchInput)
-				if len(r) > 0 {
-					m.searchInput = string(r[:len(r)-1])
-					m.doSearch()
-				}
-			default:
-				if msg.Type == tea.KeyRunes || msg.String() == " " {
-					m.searchInput += string(msg.Runes)
-					m.doSearch()
-				}
-			}
+		if m.tags != nil {
+			return m, m.tagKey(msg)
+		}
+		if m.organise != nil {
+			return m, m.organiseKey(msg)
+		}
+		if m.form != nil {
+			m.formKey(msg)
 			return m, nil
 		}
-		switch msg.String() {
-		case "q", "ctrl+c":
-			m.save()
-			m.player.Stop()
-			return m, tea.Quit
-		case "Q":
-			m.save()
-			m.player.SaveAndStop()
-			return m, tea.Quit
-		case "/":
-			m.searchMode = true
-			m.searchInput = ""
-		case "f2":
-			m.add()
-		case "f3":
-			m.remove()
-		case "f5":
-			m.clearPlaylist()
-		case "tab":
-			m.focus = (m.focus + 1) % 2
-		case "up":
-			if m.focus == 0 {
-				m.fmCur--
-			} else {
-				m.plCur--
-			}
-		case "down":
-			if m.focus == 0 {
-				m.fmCur++
-			} else {
-				m.plCur++
-			}
-		case "n":
-			m.nextTrack()
-		case "-", "_":
-			m.changeVolume(-5)
-		case "=", "+":
-			m.changeVolume(5)
-		case "enter", "right":
-			m.action()
-		case "left":
-			m.goUp()
-		case " ":
-			m.player.Command("cycle", "pause")
-		case ",":
-			m.player.Command("seek", "-5")
-		case ".":
-			m.player.Command("seek", "5")
+		if m.showHelp {
+			m.helpKey(msg)
+			return m, nil
+		}
+		if m.jumpMode {
+			m.jumpKey(msg)
+			return m, nil
+		}
+		if m.palette != nil {
+			return m, m.paletteKey(msg)
 		}
+		if m.findMode {
+			m.findKey(msg)
+			return m, nil
+		}
+		if m.searchMode {
+			m.searchKey(msg)
+			return m, nil
+		}
+		cmd = m.runKey(msg)
 		m.sync()
 
 	case tea.WindowSizeMsg:
 		m.termWidth, m.termHeight = msg.Width, msg.Height
-		m.height = msg.Height - 10
-		if m.height < 5 {
-			m.height = 5
-		}
+		m.resize()
+		m.sync()
+		cmd = m.drawCover()
 	}
-	return m, nil
+	return m, cmd
 }
 
-func (m *model) handleMouse(x, y int) {
-	fmHeaderHeight := lipgloss.Height(RenderFMHeader(m))
-	plHeaderHeight := lipgloss.Height(RenderPLHeader(m))
+func (m *model) switchView(v int) {
+	if m.leftView == v {
+		v = viewFiles
+	}
+	m.leftView = v
+	m.focus, m.fmCur, m.fmOff = 0, 0, 0
+	m.refresh()
+}
 
-	newFocus := -1
-	if x >= 0 && x <= 50 {
-		newFocus = 0
+func (m *model) handleMouse(x, y int) tea.Cmd {
+	var cmd tea.Cmd
+	pane, row := m.paneAt(x, y)
+	if pane < 0 {
+		return nil
 	}
-	if x >= 51 && x <= 101 {
-		newFocus = 1
+	m.focus = pane
+	cur, off, items := &m.fmCur, m.fmOff, m.fmItems
+	if pane == 1 {
+		cur, off, items = &m.plCur, m.plOff, m.plItems
 	}
-
-	if newFocus != -1 {
-		m.focus = newFocus
-		if newFocus == 0 {
-			idx := y - fmHeaderHeight - 1
-			if idx >= 0 && idx < len(m.fmItems) {
-				m.fmCur = idx + m.fmOff
-				if time.Since(m.lastClick) < time.Duration(doubleClickMs)*time.Millisecond && m.lastItem == m.fmCur && m.lastFocus == 0 {
-					m.action()
-				}
-				m.lastItem, m.lastFocus = m.fmCur, 0
-			}
-		} else {
-			idxPl := y - plHeaderHeight - 1
-			if idxPl >= 0 && idxPl < len(m.plItems) {
-				m.plCur = idxPl + m.plOff
-				if time.Since(m.lastClick) < time.Duration(doubleClickMs)*time.Millisecond && m.lastItem == m.plCur && m.lastFocus == 1 {
-					m.action()
-				}
-				m.lastItem, m.lastFocus = m.plCur, 1
-			}
+	if pane == 1 && row < 0 && y == m.geo.listTop[1]-1 {
+		if f := m.columnAt(x - m.geo.panes[1].x - 1); f != "" {
+			m.sortBy(f, f == m.sortedBy && !m.sortDesc)
 		}
-		m.lastClick = time.Now()
-		m.sync()
 	}
+	if row >= 0 && off+row < len(items) {
+		*cur = off + row
+		if time.Since(m.lastClick) < time.Duration(doubleClickMs)*time.Millisecond && m.lastItem == *cur && m.lastFocus == pane {
+			cmd = m.action()
+		}
+		m.lastItem, m.lastFocus = *cur, pane
+	}
+	m.lastClick = time.Now()
+	m.sync()
+	return cmd
 }
 
 func (m *model) goUp() {
+	if m.leftView == viewStations {
+		m.stationUp()
+		return
+	}
+	if m.leftView == viewPodcasts {
+		m.podcastUp()
+		return
+	}
+	if m.leftView == viewFind {
+		m.closeFind()
+		return
+	}
+	if m.leftView == viewStats || m.leftView == viewSmart || m.leftView == viewLyrics {
+		return
+	}
 	m.state.Cwd = filepath.Dir(m.state.Cwd)
 	m.refresh()
 	m.fmCur = 0
@@ -394,6 +673,55 @@ func (m *model) playTrack(idx int) {
 	m.player.Start(path, m.state.Volume)
 }
 
+func (m *model) checkAlarms() {
+	if m.alarms != nil {
+		fired, sleep := m.alarms.due()
+		for _, a := range fired {
+			m.fireAlarm(a)
+		}
+		if sleep && len(fired) == 0 {
+			m.ramp = nil
+			m.player.setProp("pause", "yes")
+			m.setNotice("sleep timer: paused")
+		}
+	}
+	if m.ramp != nil {
+		v, done := m.ramp.level(m.now())
+		if v != m.state.Volume {
+			m.changeVolume(v - m.state.Volume)
+		}
+		if done {
+			m.ramp = nil
+		}
+	}
+}
+
+func (m *model) fireAlarm(a Alarm) {
+	if entries := loadPlaylistSource(a.Playlist); len(entries) > 0 {
+		m.state.Playlist = entries
+		m.state.CurrentIndex = 0
+		m.plCur, m.plOff = 0, 0
+		m.refresh()
+	}
+	if len(m.state.Playlist) == 0 {
+		return
+	}
+	if m.state.CurrentIndex < 0 || m.state.CurrentIndex >= len(m.state.Playlist) {
+		m.state.CurrentIndex = 0
+	}
+	target := a.Volume
+	if target <= 0 {
+		target = m.state.Volume
+	}
+	m.changeVolume(a.RampFrom - m.state.Volume)
+	m.playTrack(m.state.CurrentIndex)
+	m.player.setProp("pause", "no")
+	m.ramp = &volumeRamp{start: m.now(), dur: a.rampDuration(), from: m.state.Volume, to: target}
+	m.plCur = m.state.CurrentIndex
+	m.sync()
+	m.save()
+}
+
 func (m *model) nextTrack() {
 	if len(m.state.Playlist) > 0 {
 		m.state.CurrentIndex = (m.state.CurrentIndex + 1) % len(m.state.Playlist)
@@ -404,11 +732,49 @@ func (m *model) nextTrack() {
 	}
 }
 
-func (m *model) action() {
+func (m *model) prevTrack() {
+	if len(m.state.Playlist) > 0 {
+		m.state.CurrentIndex--
+		if m.state.CurrentIndex < 0 {
+			m.state.CurrentIndex = len(m.state.Playlist) - 1
+		}
+		m.playTrack(m.state.CurrentIndex)
+		m.plCur = m.state.CurrentIndex
+		m.sync()
+		m.save()
+	}
+}
+
+func (m *model) action() tea.Cmd {
+	if m.tags != nil {
+		m.tags.pick(m.fmCur)
+		m.refresh()
+		return nil
+	}
+	if m.organise != nil {
+		return nil
+	}
+	if m.form != nil {
+		m.form.cur = m.fmCur
+		m.refresh()
+		return nil
+	}
 	if m.focus == 0 {
 		if len(m.fmItems) > 0 && m.fmCur < len(m.fmItems) {
 			it := m.fmItems[m.fmCur]
-			if it.name == ".." {
+			if m.leftView == viewPodcasts {
+				return m.podcastAction(it)
+			} else if m.leftView == viewStats {
+				m.statsAction(it)
+			} else if m.leftView == viewSmart {
+				return m.smartAction(it)
+			} else if m.leftView == viewStations {
+				m.stationAction(it)
+			} else if m.leftView == viewLyrics {
+				m.lyricsAction()
+			} else if m.leftView == viewFind {
+				_, _ = playCommand(m, []string{it.path})
+			} else if it.name == ".." {
 				m.goUp()
 			} else if it.isDir {
 				m.state.Cwd = it.path
@@ -420,10 +786,11 @@ func (m *model) action() {
 		}
 	} else {
 		if len(m.plItems) > 0 && m.plCur < len(m.plItems) {
-			m.state.CurrentIndex = m.plCur
-			m.playTrack(m.plCur)
+			m.state.CurrentIndex = m.plEntry(m.plCur)
+			m.playTrack(m.state.CurrentIndex)
 		}
 	}
+	return nil
 }
 
 func isAudio(f string) bool {
@@ -439,35 +806,39 @@ func (m *model) add() {
 	if it.name == ".." {
 		return
 	}
+	if m.leftView == viewPodcasts {
+		if !it.isDir {
+			m.enqueueEpisode(it)
+		}
+		return
+	}
+	if m.leftView == viewSmart || m.leftView == viewLyrics {
+		return
+	}
+	if m.leftView == viewFind {
+		m.enqueue(it)
+		return
+	}
+	if m.leftView == viewStats {
+		if it.path != "" {
+			m.statsAction(it)
+		}
+		return
+	}
+	if m.leftView == viewStations {
+		if !it.isDir && isURL(it.path) {
+			s := m.stationByURL(it.path, it.name)
+			m.state.Playlist = append(m.state.Playlist, s.Name+m3uSeparator+s.URL)
+			m.refresh()
+			m.save()
+		}
+		return
+	}
 
-	if !it.isDir && strings.ToLower(filepath.Ext(it.name)) == ".m3u" {
-		m.state.Playlist = []string{}
+	if !it.isDir && isPlaylistFile(it.name) {
+		m.state.Playlist = readM3U(it.path)
 		m.state.CurrentIndex = -1
 		m.plCur, m.plOff = 0, 0
-		if file, err := os.Open(it.path); err == nil {
-			scanner := bufio.NewScanner(file)
-			var curName string
-			for scanner.Scan() {
-				line := strings.TrimSpace(scanner.Text())
-				if line == "" {
-					continue
-				}
-				if strings.HasPrefix(line, "#EXTINF:-1,") {
-					n := strings.TrimPrefix(line, "#EXTINF:-1,")
-					if !strings.HasPrefix(n, "http") {
-						curName = n
-					}
-				} else if !strings.HasPrefix(line, "#") {
-					if curName != "" {
-						m.state.Playlist = append(m.state.Playlist, curName+m3uSeparator+line)
-						curName = ""
-					} else {
-						m.state.Playlist = append(m.state.Playlist, line)
-					}
-				}
-			}
-			file.Close()
-		}
 	} else if it.isDir {
 		files, _ := os.ReadDir(it.path)
 		for _, f := range files {
@@ -482,10 +853,83 @@ func (m *model) add() {
 	m.save()
 }
 
+func isPlaylistFile(f string) bool {
+	e := strings.ToLower(filepath.Ext(f))
+	return e == ".m3u" || e == ".m3u8"
+}
+
+func readM3U(path string) []string {
+	list := []string{}
+	file, err := os.Open(path)
+	if err != nil {
+		return list
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	var curName string
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#EXTINF:-1,") {
+			n := strings.TrimPrefix(line, "#EXTINF:-1,")
+			if !strings.HasPrefix(n, "http") {
+				curName = n
+			}
+		} else if !strings.HasPrefix(line, "#") {
+			if curName != "" {
+				list = append(list, curName+m3uSeparator+line)
+				curName = ""
+			} else {
+				list = append(list, line)
+			}
+		}
+	}
+	return list
+}
+
+func isURL(s string) bool {
+	return strings.Contains(s, "://")
+}
+
+// loadPlaylistSource turns an alarm/CLI source (an .m3u file, a directory,
+// an audio file or a stream URL) into playlist entries.
+func loadPlaylistSource(src string) []string {
+	if src == "" {
+		return nil
+	}
+	if isURL(src) {
+		return []string{src}
+	}
+	abs, err := filepath.Abs(src)
+	if err != nil {
+		return nil
+	}
+	fi, err := os.Stat(abs)
+	if err != nil {
+		return nil
+	}
+	if fi.IsDir() {
+		var list []string
+		files, _ := os.ReadDir(abs)
+		for _, f := range files {
+			if !f.IsDir() && isAudio(f.Name()) {
+				list = append(list, filepath.Join(abs, f.Name()))
+			}
+		}
+		return list
+	}
+	if isPlaylistFile(abs) {
+		return readM3U(abs)
+	}
+	return []string{abs}
+}
+
 func (m *model) remove() {
-	if len(m.state.Playlist) > 0 && m.plCur < len(m.state.Playlist) {
-		m.state.Playlist = append(m.state.Playlist[:m.plCur], m.state.Playlist[m.plCur+1:]...)
-		if m.state.CurrentIndex == m.plCur {
+	if i := m.plEntry(m.plCur); len(m.state.Playlist) > 0 && i < len(m.state.Playlist) {
+		m.state.Playlist = append(m.state.Playlist[:i], m.state.Playlist[i+1:]...)
+		if m.state.CurrentIndex == i {
 			m.state.CurrentIndex = -1
 		}
 		m.refresh()
@@ -503,23 +947,47 @@ func (m *model) clearPlaylist() {
 
 func (m *model) refresh() {
 	m.fmItems = nil
-	m.fmItems = append(m.fmItems, displayItem{filepath.Dir(m.state.Cwd), "..", true})
-
-	e, _ := os.ReadDir(m.state.Cwd)
-	var d, f []displayItem
-	for _, x := range e {
-		abs, _ := filepath.Abs(filepath.Join(m.state.Cwd, x.Name()))
-		it := displayItem{abs, x.Name(), x.IsDir()}
-		if x.IsDir() {
-			d = append(d, it)
+	if m.showHelp {
+		m.fmItems = m.helpItems()
+	} else if m.tags != nil {
+		m.fmItems = m.tags.items()
+	} else if m.organise != nil {
+		m.fmItems = m.organise.items()
+	} else if m.leftView == viewStations {
+		m.fmItems = m.stationItems()
+	} else if m.leftView == viewPodcasts {
+		m.fmItems = m.podcastItems()
+	} else if m.leftView == viewStats {
+		m.fmItems = m.statsItems()
+	} else if m.leftView == viewLyrics {
+		m.fmItems = m.lyricsItems()
+	} else if m.leftView == viewFind {
+		m.fmItems = m.findItems()
+	} else if m.leftView == viewSmart {
+		if m.form != nil {
+			m.fmItems = m.form.items()
 		} else {
-			f = append(f, it)
+			m.fmItems = m.smartItems()
 		}
+	} else {
+		m.fmItems = append(m.fmItems, displayItem{filepath.Dir(m.state.Cwd), "..", true})
+
+		e, _ := os.ReadDir(m.state.Cwd)
+		var d, f []displayItem
+		for _, x := range e {
+			abs, _ := filepath.Abs(filepath.Join(m.state.Cwd, x.Name()))
+			it := displayItem{abs, x.Name(), x.IsDir()}
+			if x.IsDir() {
+				d = append(d, it)
+			} else {
+				f = append(f, it)
+			}
+		}
+		sort.Slice(d, func(i, j int) bool { return strings.ToLower(d[i].name) < strings.ToLower(d[j].name) })
+		sort.Slice(f, func(i, j int) bool { return strings.ToLower(f[i].name) < strings.ToLower(f[j].name) })
+		m.fmItems = append(m.fmItems, d...)
+		m.fmItems = append(m.fmItems, f...)
 	}
-	sort.Slice(d, func(i, j int) bool { return strings.ToLower(d[i].name) < strings.ToLower(d[j].name) })
-	sort.Slice(f, func(i, j int) bool { return strings.ToLower(f[i].name) < strings.ToLower(f[j].name) })
-	m.fmItems = append(m.fmItems, d...)
-	m.fmItems = append(m.fmItems, f...)
 
 	m.plItems = nil
 	for _, raw := range m.state.Playlist {
@@ -530,6 +998,7 @@ func (m *model) refresh() {
 		}
 		m.plItems = append(m.plItems, displayItem{p, n, false})
 	}
+	m.onRefresh()
 	m.sync()
 }
 
@@ -550,28 +1019,6 @@ func (m *model) View() string {
 	return RenderUI(m)
 }
 
-func (m *model) doSearch() {
-	m.refresh()
-	items := m.fmItems
-	if m.focus == 1 {
-		items = m.plItems
-	}
-	var res []displayItem
-	s := strings.ToLower(m.searchInput)
-	for _, it := range items {
-		if strings.Contains(strings.ToLower(it.name), s) {
-			res = append(res, it)
-		}
-	}
-	if m.focus == 0 {
-		m.fmItems = res
-	} else {
-		m.plItems = res
-	}
-	m.fmCur, m.plCur = 0, 0
-	m.sync()
-}
-
 func (m *model) sync() {
 	if m.fmCur < 0 {
 		m.fmCur = 0
@@ -596,19 +1043,101 @@ func (m *model) sync() {
 }
 
 func (m *model) save() {
+	m.flushLibrary(true)
+	if m.remote != nil {
+		m.remote.pushState(m.state)
+		return
+	}
 	d, _ := json.Marshal(m.state)
 	_ = os.WriteFile(stateFile, d, 0644)
+	if m.positions != nil {
+		m.positions.save()
+	}
+	if m.onSave != nil {
+		m.onSave()
+	}
 }
 
 func RenderFMHeader(m *model) string {
+	if m.showHelp {
+		h := m.styles.Head.Render(" KEYS ") + "\n"
+		h += m.styles.Help.Render(TrimText(` ◆ remap in config.json "keys" | any key: close`, m.textWidth()))
+		return h
+	}
+	if m.tags != nil {
+		h := m.styles.Head.Render(" TAG EDITOR ") + "\n"
+		sub := " ✎ ENTER: save | ESC: cancel | from name: %n - %t"
+		if m.tags.err != "" {
+			sub = " ✗ " + m.tags.err
+		}
+		h += m.styles.Help.Render(TrimText(sub, m.textWidth()))
+		return h
+	}
+	if m.organise != nil {
+		h := m.styles.Head.Render(" ORGANISE ") + "\n"
+		h += m.styles.Help.Render(TrimText(" ◆ "+m.organise.summary(), m.textWidth()))
+		return h
+	}
+	if m.leftView == viewSmart {
+		h := m.styles.Head.Render(" SMART PLAYLISTS ") + "\n"
+		sub := " ◆ " + m.smartMsg
+		if m.form != nil {
+			sub = " ✎ ENTER: save | ESC: cancel | field op value"
+			if m.form.err != "" {
+				sub = " ✗ " + m.form.err
+			}
+		}
+		h += m.styles.Help.Render(TrimText(sub, m.textWidth()))
+		return h
+	}
+	if m.leftView == viewFind {
+		h := m.styles.Head.Render(" FIND ") + "\n"
+		h += m.styles.Help.Render(TrimText(m.findSummary(), m.textWidth()))
+		return h
+	}
+	if m.leftView == viewLyrics {
+		h := m.styles.Head.Render(" LYRICS ") + "\n"
+		h += m.styles.Help.Render(TrimText(" ♪ "+m.lyricsSummary(), m.textWidth()))
+		return h
+	}
+	if m.leftView == viewStats {
+		h := m.styles.Head.Render(" STATS ") + "\n"
+		h += m.styles.Help.Render(TrimText(" ◆ "+m.statsSummary, m.textWidth()))
+		return h
+	}
+	if m.leftView == viewPodcasts {
+		sub := " ◆ "
+		if pc, ok := m.currentPodcast(); ok {
+			sub += pc.Title
+		}
+		if m.podMsg != "" {
+			sub = " ⟳ " + m.podMsg
+		}
+		h := m.styles.Head.Render(" PODCASTS ") + "\n"
+		h += m.styles.Help.Render(TrimText(sub, m.textWidth()))
+		return h
+	}
+	if m.leftView == viewStations {
+		sub := " ◆ " + m.stationGroup
+		if m.stationHist != "" {
+			sub = " ♪ " + m.stationByURL(m.stationHist, m.stationHist).Name
+		}
+		h := m.styles.Head.Render(" STATIONS ") + "\n"
+		h += m.styles.Help.Render(TrimText(sub, m.textWidth()))
+		return h
+	}
 	h := m.styles.Head.Render(" FILES ") + "\n"
-	h += m.styles.Help.Render(TrimText(" ◆ "+m.state.Cwd, 48))
+	h += m.styles.Help.Render(TrimText(" ◆ "+m.state.Cwd, m.textWidth()))
 	return h
 }
 
-func RenderPLHeader(m *model) string {
-	h := m.styles.Head.Render(" PLAYLIST ") + "\n"
-	h += strings.Repeat(" ", 48)
+func RenderPLHeader(m *model, cols []Column) string {
+	h := m.styles.Head.Render(" PLAYLIST ")
+	if w := m.textWidth() - lipgloss.Width(h); w > 0 {
+		h += m.styles.Help.Render(fitCell(m.playlistTitle(), w, "left"))
+	}
+	h += "\n"
+	h += m.styles.Help.Render(m.columnHeader(cols))
 	return h
 }
 
@@ -620,55 +1149,120 @@ func RenderUI(m *model) string {
 		rS = m.styles.Active
 	}
 
-	fmContent := RenderFMHeader(m) + "\n"
+	cols := m.columns()
+	fmHead, plHead := RenderFMHeader(m), RenderPLHeader(m, cols)
+	fmContent := fmHead + "\n"
 	for i := m.fmOff; i < m.fmOff+m.height && i < len(m.fmItems); i++ {
 		it := m.fmItems[i]
 		prefix := "  "
 		if it.isDir {
-			prefix = "◆ "
+			prefix = glyphs.Dir
+		} else if m.marked[it.path] {
+			prefix = glyphs.Mark
 		}
-		line := TrimText(prefix+it.name, 48)
+		line := TrimText(prefix+it.name, m.textWidth())
+		shown := m.textWidth()
+		if n := len([]rune(prefix + it.name)); n > shown {
+			shown -= 3
+		}
+		pos := fuzzy.ShiftPos(m.matchPos(0, i), len([]rune(prefix)), shown)
 		if i == m.fmCur && m.focus == 0 {
-			fmContent += m.styles.Cursor.Render(line) + "\n"
+			fmContent += renderMatch(line, pos, m.styles.Cursor) + "\n"
+		} else if m.leftView == viewLyrics && i == m.lyricCur {
+			fmContent += m.styles.Neon.Render(line) + "\n"
 		} else {
-			fmContent += line + "\n"
+			fmContent += renderMatch(line, pos, m.styles.Text) + "\n"
 		}
 	}
 
-	plContent := RenderPLHeader(m) + "\n"
+	plContent := plHead + "\n"
 	for i := m.plOff; i < m.plOff+m.height && i < len(m.plItems); i++ {
 		it := m.plItems[i]
-		line := TrimText(fmt.Sprintf("%2d. %s", i+1, it.name), 48)
-		style := lipgloss.NewStyle()
-		isPlaying := (i == m.state.CurrentIndex)
+		line, pos := m.playlistRow(cols, i, it)
+		style := m.styles.Text
+		isPlaying := (m.plEntry(i) == m.state.CurrentIndex)
 		if isPlaying && i == m.plCur && m.focus == 1 {
 			style = style.Underline(true)
 		}
 		if i == m.plCur && m.focus == 1 {
-			plContent += m.styles.Cursor.Render(line) + "\n"
+			plContent += renderMatch(line, pos, m.styles.Cursor) + "\n"
 		} else {
 			if isPlaying {
 				style = m.styles.Neon.Underline(true)
 			}
-			plContent += style.Render(line) + "\n"
+			plContent += renderMatch(line, pos, style) + "\n"
 		}
 	}
 
-	helpText := "TAB: focus | ARROWS: nav | ENTER: action | . , : seek"
+	helpText := fmt.Sprintf("%s: focus | ARROWS: nav | %s: action | %s %s: seek | %s: keys",
+		strings.ToUpper(m.keys.First("focus")), strings.ToUpper(m.keys.First("open")), m.keys.First("seek_back"), m.keys.First("seek_forward"), m.keys.First("help"))
 	help := m.styles.Help.Render(helpText)
+	if t := m.keys.Typed(); t != "" {
+		help = m.styles.Neon.Render(t + " …")
+	}
 	if m.searchMode {
 		help = m.styles.Neon.Render("SEARCH: " + m.searchInput)
 	}
+	if m.findMode {
+		help = m.styles.Neon.Render("FIND: " + m.findInput + " | ENTER: results | ESC: close")
+	}
+	if m.jumpMode {
+		help = m.styles.Neon.Render("GO TO: " + m.jumpInput + "%")
+	}
+	if m.palette != nil {
+		help = m.styles.Neon.Render(m.paletteLine())
+	}
 
-	bar := RenderProgressBar(50, m.curPos, m.curDur, lipgloss.Color(m.config.ThemeColor))
+	bar := RenderProgressBar(m.barWidth(), m.curPos, m.curDur, m.styles)
+	if m.wave != "" && m.wave == m.currentPath() {
+		bar = renderWaveBar(m.barWidth(), m.waveData, m.curPos, m.curDur, m.styles)
+	}
 	timer := fmt.Sprintf(" %02d:%02d/%02d:%02d", int(m.curPos)/60, int(m.curPos)%60, int(m.curDur)/60, int(m.curDur)%60)
 	vol := m.styles.Neon.Render(fmt.Sprintf(" VOL: %d%%", m.state.Volume))
+	if st := m.streamStatus(); st != "" {
+		vol += m.styles.Help.Render(st)
+	}
+	if m.alarms != nil {
+		if at, ok := m.alarms.next(); ok {
+			vol += m.styles.Help.Render(" ⏰ " + at.Format("Mon 15:04"))
+		}
+		if at, ok := m.alarms.sleeping(); ok {
+			vol += m.styles.Help.Render(" ☾ " + at.Format("15:04"))
+		}
+	}
+
+	nowPlaying := ""
+	if m.icyTitle != "" {
+		nowPlaying = " " + m.styles.Neon.Render("♪ "+m.icyTitle)
+	}
+	if m.streamMsg != "" {
+		nowPlaying += " " + m.styles.Help.Render("⟳ "+m.streamMsg)
+	}
+	if n := m.currentNotice(); n != "" {
+		nowPlaying += " " + m.styles.Help.Render(n)
+	}
 
+	w := m.boxWidth()
+	boxes := m.joinPanes(
+		lS.Width(w).Height(m.height+2).Render(strings.TrimSuffix(fmContent, "\n")),
+		rS.Width(w).Height(m.height+2).Render(strings.TrimSuffix(plContent, "\n")),
+		fmHead, plHead)
+	m.geo.cover = rect{}
+	if panel := m.renderCoverPanel(); panel != "" {
+		m.geo.cover = rect{1, lipgloss.Height(boxes), m.coverRows * 2, m.coverRows}
+		boxes = lipgloss.JoinVertical(lipgloss.Left, boxes, panel)
+	}
+	if vis := m.renderVis(); vis != "" {
+		boxes = lipgloss.JoinVertical(lipgloss.Left, boxes, vis)
+	}
+	line := lipgloss.NewStyle().MaxWidth(m.lineWidth())
+	nowPlaying = line.Render(nowPlaying)
+	m.geo.bar = rect{1, lipgloss.Height(boxes) + lipgloss.Height(nowPlaying), m.barWidth(), 1}
 	return lipgloss.JoinVertical(lipgloss.Left,
-		lipgloss.JoinHorizontal(lipgloss.Top, lS.Height(m.height+2).Render(fmContent), rS.Height(m.height+2).Render(plContent)),
-		"",
-		" "+bar+timer+vol,
-		" "+help)
+		boxes,
+		nowPlaying,
+		line.Render(" "+bar+timer+vol),
+		line.Render(" "+help))
 }
 
 func TrimText(s string, w int) string {
@@ -679,9 +1273,9 @@ func TrimText(s string, w int) string {
 	return string(r[:w-3]) + "..."
 }
 
-func RenderProgressBar(width int, cur, total float64, color lipgloss.Color) string {
+func RenderProgressBar(width int, cur, total float64, st UIStyles) string {
 	if total <= 0 {
-		return lipgloss.NewStyle().Foreground(lipgloss.Color("#333333")).Render(strings.Repeat("━", width))
+		return st.Faint.Render(strings.Repeat(glyphs.Bar, width))
 	}
 	filledWidth := int((cur / total) * float64(width))
 	if filledWidth > width {
@@ -690,8 +1284,8 @@ func RenderProgressBar(width int, cur, total float64, color lipgloss.Color) stri
 	if filledWidth < 0 {
 		filledWidth = 0
 	}
-	filled := lipgloss.NewStyle().Foreground(color).Render(strings.Repeat("━", filledWidth))
-	empty := lipgloss.NewStyle().Foreground(lipgloss.Color("#444444")).Render(strings.Repeat("━", width-filledWidth))
+	filled := st.Neon.Render(strings.Repeat(glyphs.Bar, filledWidth))
+	empty := st.BarEmpty.Render(strings.Repeat(glyphs.Bar, width-filledWidth))
 	return filled + empty
 }
 
@@ -704,12 +1298,159 @@ func parseFloat(s string) (float64, error) {
 	return f, err
 }
 
+// options are the flags that start the player. The plain `cyan` takes them
+// before any subcommand; `cyan daemon` and the others take theirs after it,
+// defaulting to what came before.
+type options struct {
+	sock       string
+	alarmAt    string
+	alarmSrc   string
+	alarmVol   int
+	alarmRamp  int
+	httpAddr   string
+	foreground bool
+}
+
+func (o *options) socketFlag(fs *flag.FlagSet) {
+	fs.StringVar(&o.sock, "socket", o.sock, "control socket of the cyan daemon")
+}
+
+func (o *options) alarmFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.alarmAt, "alarm", o.alarmAt, "start playback at HH:MM")
+	fs.StringVar(&o.alarmSrc, "playlist", o.alarmSrc, "playlist, folder, file or stream URL for --alarm")
+	fs.IntVar(&o.alarmVol, "alarm-volume", o.alarmVol, "target volume of the --alarm ramp (default: saved volume)")
+	fs.IntVar(&o.alarmRamp, "ramp", o.alarmRamp, "seconds to ramp the --alarm volume up from 0")
+}
+
+func (o *options) httpFlag(fs *flag.FlagSet) {
+	fs.StringVar(&o.httpAddr, "http", o.httpAddr, "serve the JSON API and web remote on ADDR (\":8765\" = localhost only)")
+}
+
+// flagSet reports whether a flag was given on the command line.
+func flagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) { set = set || f.Name == name })
+	return set
+}
+
+const cyanUsage = "usage: cyan [flags] [daemon [--foreground] [flags]|attach|ctl COMMAND|organise [-n] [PATH...]]\n"
+
+// commandLine is what main was started with.
+type commandLine struct {
+	options
+	mode        string
+	args        []string
+	socketSet   bool
+	subscribeTo string
+	lastfmUser  string
+	export      string
+}
+
+// parseCommandLine reads the flags of the plain player, then the mode and
+// the flags of that mode, which can repeat the ones before it. Errors and
+// -help go to out.
+func parseCommandLine(argv []string, out io.Writer) (*commandLine, error) {
+	c := &commandLine{options: options{sock: socketPath(), alarmRamp: defaultRampSeconds}}
+	top := flag.NewFlagSet("cyan", flag.ContinueOnError)
+	top.SetOutput(out)
+	top.Usage = func() {
+		fmt.Fprint(out, cyanUsage)
+		top.PrintDefaults()
+	}
+	c.socketFlag(top)
+	c.alarmFlags(top)
+	c.httpFlag(top)
+	top.StringVar(&c.subscribeTo, "subscribe", "", "add a podcast feed (URL or file) to config.json and exit")
+	top.StringVar(&c.lastfmUser, "lastfm-login", "", "log in to Last.fm as USER, store the session key in config.json and exit")
+	top.StringVar(&c.export, "export-plays", "", "write the play log to stdout as csv or json and exit")
+	if err := top.Parse(argv); err != nil {
+		return nil, err
+	}
+	c.mode, c.args = top.Arg(0), top.Args()
+	if len(c.args) > 0 {
+		c.args = c.args[1:]
+	}
+	sub := flag.NewFlagSet("cyan "+c.mode, flag.ContinueOnError)
+	sub.SetOutput(out)
+	c.socketFlag(sub)
+	switch c.mode {
+	case "":
+	case "daemon":
+		c.alarmFlags(sub)
+		c.httpFlag(sub)
+		sub.BoolVar(&c.foreground, "foreground", false, "run without detaching from the terminal")
+	case "attach", "ctl":
+	case "organise":
+		// runOrganise has flags of its own.
+		return c, nil
+	default:
+		top.Usage()
+		return nil, fmt.Errorf("unknown mode %q", c.mode)
+	}
+	if err := sub.Parse(c.args); err != nil {
+		return nil, err
+	}
+	c.args = sub.Args()
+	c.socketSet = flagSet(top, "socket") || flagSet(sub, "socket")
+	if (c.mode == "daemon" || c.mode == "attach") && len(c.args) > 0 {
+		sub.Usage()
+		return nil, fmt.Errorf("cyan %s takes no arguments", c.mode)
+	}
+	return c, nil
+}
+
 func main() {
+	o, err := parseCommandLine(os.Args[1:], os.Stderr)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		os.Exit(2)
+	}
+	mode, args := o.mode, o.args
+	if mode == "ctl" {
+		os.Exit(runCtl(o.sock, o.socketSet, args))
+	}
+
 	os.Setenv("PIPEWIRE_DEBUG", "0")
-	cfg := Config{ThemeColor: "#00FFFF", BgCursor: "#005555", BorderStyle: "rounded"}
-	if d, err := os.ReadFile(configFile); err == nil {
-		_ = json.Unmarshal(d, &cfg)
+	cfg, cfgErr := loadConfig(configFile)
+	if o.subscribeTo != "" {
+		if err := subscribe(&cfg, o.subscribeTo); err != nil {
+			fmt.Fprintln(os.Stderr, "FATAL:", err)
+			os.Exit(1)
+		}
+		if err := saveConfig(cfg); err != nil {
+			fmt.Fprintln(os.Stderr, "FATAL:", err)
+			os.Exit(1)
+		}
+		fmt.Println("subscribed:", cfg.Podcasts[len(cfg.Podcasts)-1].Title)
+		return
+	}
+
+	if mode == "organise" {
+		os.Exit(runOrganise(cfg, o.sock, args))
+	}
+
+	if o.export != "" {
+		if err := exportPlayLog(os.Stdout, o.export, readPlayLog(playLogFile)); err != nil {
+			fmt.Fprintln(os.Stderr, "FATAL:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if o.lastfmUser != "" {
+		if err := lastfmLogin(&cfg, o.lastfmUser); err != nil {
+			fmt.Fprintln(os.Stderr, "FATAL:", err)
+			os.Exit(1)
+		}
+		if err := saveConfig(cfg); err != nil {
+			fmt.Fprintln(os.Stderr, "FATAL:", err)
+			os.Exit(1)
+		}
+		fmt.Println("logged in to Last.fm as", o.lastfmUser)
+		return
 	}
+
 	st := State{Volume: 50, CurrentIndex: -1}
 	if d, err := os.ReadFile(stateFile); err == nil {
 		_ = json.Unmarshal(d, &st)
@@ -718,15 +1459,141 @@ func main() {
 		st.Cwd, _ = os.Getwd()
 	}
 
+	schedule := cfg.Schedule
+	if o.alarmAt != "" {
+		if _, _, err := parseClock(o.alarmAt); err != nil {
+			fmt.Fprintln(os.Stderr, "FATAL:", err)
+			os.Exit(1)
+		}
+		if o.alarmSrc != "" && len(loadPlaylistSource(o.alarmSrc)) == 0 {
+			fmt.Fprintf(os.Stderr, "FATAL: nothing to play in %q\n", o.alarmSrc)
+			os.Exit(1)
+		}
+		schedule = append(schedule, Alarm{Time: o.alarmAt, Playlist: o.alarmSrc, Volume: o.alarmVol, RampSeconds: o.alarmRamp, Once: true})
+	}
+
+	if o.httpAddr == "" {
+		o.httpAddr = cfg.HTTP
+	}
+	if o.httpAddr != "" {
+		if err := checkHTTP(o.httpAddr, cfg.HTTPToken); err != nil {
+			fmt.Fprintln(os.Stderr, "FATAL:", err)
+			os.Exit(1)
+		}
+	}
+
+	if mode == "daemon" && !o.foreground && os.Getenv(daemonChildEnv) == "" {
+		if err := spawnDaemon(o.sock); err != nil {
+			fmt.Fprintln(os.Stderr, "FATAL:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	m := &model{state: st, config: cfg, height: 20, now: time.Now, marked: map[string]bool{}, icyHistory: loadStationHistory(),
+		podcastSel: -1, feeds: map[string]*Feed{}, podState: loadPodcastState(), positions: loadPositions(positionsFile),
+		lyricOffsets: loadPositions(lyricsOffsetsFile),
+		library:      loadLibrary(), waves: loadWaveCache(waveCacheFile)}
+	// An attached interface only gets a sleep timer; the daemon rings the
+	// alarms.
+	m.alarms = newAlarmScheduler(nil, m.now)
+	if cfgErr != nil {
+		m.setNotice(cfgErr.Error())
+	}
+	m.themeWatch.Changed()
+	m.applyTheme()
+	m.loadStations()
+	keys, warn := keymap.New(keyDefs(), cfg.Keys, cfg.Leader)
+	m.keys = keys
+	if len(warn) > 0 {
+		m.setNotice("keys: " + strings.Join(warn, "; "))
+	}
+	if mode != "daemon" {
+		m.vis = newVUMeter(cfg)
+		m.coverMode = detectCoverMode(cfg.CoverArt)
+		if m.coverMode == coverKitty {
+			defer os.Stdout.WriteString(kittyDelete())
+		}
+	}
+
+	if mode != "daemon" {
+		remote, err := dialControl(o.sock)
+		if err == nil {
+			if o.alarmAt != "" {
+				fmt.Fprintln(os.Stderr, "FATAL: a daemon is running; pass --alarm to `cyan daemon` instead")
+				os.Exit(1)
+			}
+			if m.state, err = remote.state(); err != nil {
+				fmt.Fprintln(os.Stderr, "FATAL:", err)
+				os.Exit(1)
+			}
+			m.player, m.remote, m.noResume = remote, remote, true
+			m.refresh()
+			if err := runAttached(m); err != nil {
+				os.Exit(1)
+			}
+			return
+		}
+		if mode == "attach" {
+			fmt.Fprintln(os.Stderr, "FATAL: no cyan daemon at", o.sock)
+			os.Exit(1)
+		}
+	}
+
 	player := NewPlayer()
 	if player == nil {
 		fmt.Fprintln(os.Stderr, "FATAL: failed to create mpv player via libmpv/CGO")
 		os.Exit(1)
 	}
-
-	m := &model{state: st, config: cfg, player: player, styles: InitStyles(cfg), height: 20}
+	m.player = player
+	if cfg.Visualiser == "vu" {
+		enableVis(player)
+	}
+	m.alarms = newAlarmScheduler(schedule, m.now)
+	m.noResume = o.alarmAt != ""
 	m.refresh()
-	p := tea.NewProgram(m, tea.WithAltScreen(), tea.WithMouseCellMotion())
+	if bs := scrobblers(cfg.Scrobble); len(bs) > 0 {
+		m.scrobbles = newScrobbleQueue(scrobbleQueueFile, bs)
+		go m.scrobbles.run()
+		defer m.scrobbles.Close()
+	}
+
+	var httpLn net.Listener
+	if o.httpAddr != "" {
+		ln, err := listenHTTP(o.httpAddr)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "FATAL:", err)
+			os.Exit(1)
+		}
+		httpLn = ln
+	}
+	services := func(b modelBackend) func() {
+		stopMPRIS := mpris.Serve("cyan", mprisBackend{b})
+		stopHTTP := func() {}
+		if httpLn != nil {
+			stopHTTP = serveHTTP(httpLn, cfg.HTTPToken, b)
+		}
+		return func() { stopHTTP(); stopMPRIS() }
+	}
+	if mode == "daemon" {
+		if err := runDaemon(m, o.sock, services); err != nil {
+			fmt.Fprintln(os.Stderr, "FATAL:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	m.term = &termOut{File: os.Stdout}
+	p := tea.NewProgram(m, tea.WithAltScreen(), tea.WithMouseCellMotion(), tea.WithOutput(m.term))
+	defer services(modelBackend(tuiExec(p.Send, ctlTimeout)))()
+	if srv, err := listenControl(o.sock, tuiExec(p.Send, ctlTimeout)); err == nil {
+		defer srv.Close()
+		m.onSave = func() { srv.broadcast("state", m.state) }
+		go func() {
+			if withPos, ok := <-srv.shutdown; ok {
+				p.Send(ctlQuitMsg(withPos))
+			}
+		}()
+	}
 	if _, err := p.Run(); err != nil {
 		os.Exit(1)
 	}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestParseCommandLine(t *testing.T) {
+	def := options{sock: socketPath(), alarmRamp: defaultRampSeconds}
+	with := func(f func(o *options)) options {
+		o := def
+		f(&o)
+		return o
+	}
+	for _, c := range []struct {
+		argv []string
+		mode string
+		opts options
+		args []string
+		set  bool
+	}{
+		{nil, "", def, nil, false},
+		{[]string{"--alarm", "07:00", "--playlist", "m.m3u8"}, "", with(func(o *options) { o.alarmAt, o.alarmSrc = "07:00", "m.m3u8" }), nil, false},
+		{[]string{"daemon", "--foreground"}, "daemon", with(func(o *options) { o.foreground = true }), nil, false},
+		{[]string{"daemon", "--foreground", "--alarm", "06:30", "--ramp", "120"}, "daemon",
+			with(func(o *options) { o.foreground, o.alarmAt, o.alarmRamp = true, "06:30", 120 }), nil, false},
+		{[]string{"--socket", "/tmp/a.sock", "daemon", "--alarm", "06:30"}, "daemon",
+			with(func(o *options) { o.sock, o.alarmAt = "/tmp/a.sock", "06:30" }), nil, true},
+		{[]string{"attach", "--socket", "/tmp/b.sock"}, "attach", with(func(o *options) { o.sock = "/tmp/b.sock" }), nil, true},
+		{[]string{"ctl", "seek", "-5"}, "ctl", def, []string{"seek", "-5"}, false},
+		{[]string{"ctl", "--socket", "/tmp/c.sock", "status", "--json"}, "ctl", with(func(o *options) { o.sock = "/tmp/c.sock" }), []string{"status", "--json"}, true},
+		{[]string{"organise", "-n", "~/Music"}, "organise", def, []string{"-n", "~/Music"}, false},
+	} {
+		got, err := parseCommandLine(c.argv, io.Discard)
+		if err != nil {
+			t.Errorf("%q: %v", c.argv, err)
+			continue
+		}
+		if got.mode != c.mode || !reflect.DeepEqual(got.options, c.opts) || len(got.args)+len(c.args) > 0 && !reflect.DeepEqual(got.args, c.args) || got.socketSet != c.set {
+			t.Errorf("%q = %q %+v %q socket set %v, want %q %+v %q %v", c.argv, got.mode, got.options, got.args, got.socketSet, c.mode, c.opts, c.args, c.set)
+		}
+	}
+}
+
+func TestParseCommandLineErrors(t *testing.T) {
+	for _, argv := range [][]string{
+		{"play"},
+		{"attach", "--foreground"},
+		{"daemon", "extra"},
+		{"--nope"},
+	} {
+		if _, err := parseCommandLine(argv, io.Discard); err == nil {
+			t.Errorf("%q parsed", argv)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/totiks2012/Cyan_audio_player/internal/mpris"
+)
+
+const daemonChildEnv = "CYAN_DAEMON_CHILD"
+
+// spawnDaemon re-executes cyan in its own session so playback survives the
+// terminal, then waits until the control socket answers.
+func spawnDaemon(sock string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), daemonChildEnv+"=1")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	pid := cmd.Process.Pid
+	_ = cmd.Process.Release()
+	for i := 0; i < 30; i++ {
+		if r, err := dialControl(sock); err == nil {
+			r.Stop()
+			fmt.Printf("cyan daemon running (pid %d, %s)\n", pid, sock)
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("daemon (pid %d) did not open %s", pid, sock)
+}
+
+// runDaemon plays headless until a signal or a shutdown request; services
+// starts the optional frontends (MPRIS, HTTP) and returns their shutdown.
+func runDaemon(m *model, sock string, services func(modelBackend) func()) error {
+	var mu sync.Mutex
+	var exec func(fn func(m *model))
+	exec = func(fn func(m *model)) {
+		mu.Lock()
+		fn(m)
+		cmds := m.cmds
+		m.cmds = nil
+		mu.Unlock()
+		runCmds(exec, cmds)
+	}
+	srv, err := listenControl(sock, exec)
+	if err != nil {
+		return err
+	}
+	defer srv.Close()
+	defer services(exec)()
+	m.onSave = func() { srv.broadcast("state", m.state) }
+
+	mu.Lock()
+	m.Init()
+	mu.Unlock()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	signal.Ignore(syscall.SIGHUP)
+	ticker := time.NewTicker(time.Second / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case t := <-ticker.C:
+			mu.Lock()
+			m.Update(t)
+			mu.Unlock()
+		case <-sig:
+			mu.Lock()
+			m.endPlay()
+			m.save()
+			m.player.Stop()
+			mu.Unlock()
+			return nil
+		case withPos := <-srv.shutdown:
+			mu.Lock()
+			m.endPlay()
+			m.save()
+			if withPos {
+				m.player.SaveAndStop()
+			} else {
+				m.player.Stop()
+			}
+			mu.Unlock()
+			return nil
+		}
+	}
+}
+
+// runCmds does for the headless player what Bubble Tea does for the TUI:
+// each tea.Cmd runs on its own goroutine and its message goes back through
+// Update.
+func runCmds(exec func(func(m *model)), cmds []tea.Cmd) {
+	for _, c := range cmds {
+		if c == nil {
+			continue
+		}
+		go func(c tea.Cmd) {
+			switch msg := c().(type) {
+			case nil, tea.QuitMsg:
+			case tea.BatchMsg:
+				runCmds(exec, msg)
+			default:
+				exec(func(m *model) {
+					_, cmd := m.Update(msg)
+					m.queue(cmd)
+				})
+			}
+		}(c)
+	}
+}
+
+func runAttached(m *model) error {
+	m.term = &termOut{File: os.Stdout}
+	p := tea.NewProgram(m, tea.WithAltScreen(), tea.WithMouseCellMotion(), tea.WithOutput(m.term))
+	go func() {
+		for ev := range m.remote.events {
+			if ev.Event != "state" {
+				continue
+			}
+			var st State
+			if json.Unmarshal(ev.Data, &st) == nil {
+				p.Send(remoteStateMsg(st))
+			}
+		}
+		p.Send(remoteLostMsg{})
+	}()
+	_, err := p.Run()
+	return err
+}
+
+// ctlExecMsg carries a control request into Update. Update and the caller
+// waiting for it race for taken: a request the caller gave up on is never
+// run, since fn writes to the caller's variables.
+type ctlExecMsg struct {
+	fn    func(m *model)
+	done  chan struct{}
+	taken *atomic.Bool
+}
+
+type ctlQuitMsg bool
+
+// tuiExec runs control requests inside the Bubble Tea update loop so they
+// never race with key handling; send is the program's Send.
+func tuiExec(send func(tea.Msg), timeout time.Duration) func(func(m *model)) {
+	return func(fn func(m *model)) {
+		msg := ctlExecMsg{fn, make(chan struct{}), new(atomic.Bool)}
+		send(msg)
+		select {
+		case <-msg.done:
+		case <-time.After(timeout):
+			if !msg.taken.CompareAndSwap(false, true) {
+				// Update is running it already
+				<-msg.done
+			}
+		}
+	}
+}
+
+// modelBackend exposes the local model to MPRIS through the same handlers
+// the control socket uses.
+type modelBackend func(func(m *model))
+
+func (exec modelBackend) status() playerStatus {
+	var st playerStatus
+	exec(func(m *model) { st = m.status() })
+	return st
+}
+
+func (exec modelBackend) call(cmd string, args ...string) (interface{}, error) {
+	var data interface{}
+	var err error
+	h, ok := lookupHandler(cmd)
+	if !ok {
+		return nil, fmt.Errorf("unknown command %q", cmd)
+	}
+	exec(func(m *model) { data, err = h(nil, nil, m, ctlRequest{Cmd: cmd, Args: args}) })
+	return data, err
+}
+
+func (exec modelBackend) do(cmd string, args ...string) error {
+	_, err := exec.call(cmd, args...)
+	return err
+}
+
+// mprisBackend is modelBackend as the mpris package wants it.
+type mprisBackend struct{ modelBackend }
+
+func (b mprisBackend) Status() mpris.Status { return b.status().mpris() }
+
+func (b mprisBackend) Do(cmd string, args ...string) error { return b.do(cmd, args...) }
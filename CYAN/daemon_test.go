@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/totiks2012/Cyan_audio_player/internal/libindex"
+)
+
+func TestTuiExec(t *testing.T) {
+	t.Chdir(t.TempDir())
+	m := &model{library: &libindex.Index{Tracks: map[string]libindex.Track{}}, now: time.Now, leftView: viewFind}
+	msgs := make(chan tea.Msg, 1)
+	send := func(msg tea.Msg) { msgs <- msg }
+
+	// the request reaches Update only after the caller gave up on it
+	ran := false
+	tuiExec(send, time.Millisecond)(func(m *model) { ran = true })
+	m.Update(<-msgs)
+	if ran {
+		t.Error("a request the caller gave up on was run")
+	}
+
+	go func() { m.Update(<-msgs) }()
+	var st playerStatus
+	tuiExec(send, time.Minute)(func(m *model) { st.Volume = 42 })
+	if st.Volume != 42 {
+		t.Errorf("request in time not run: %+v", st)
+	}
+}
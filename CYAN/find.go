@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/totiks2012/Cyan_audio_player/internal/fuzzy"
+	"github.com/totiks2012/Cyan_audio_player/internal/libindex"
+)
+
+// FIND searches the whole library index from the left pane as the query is
+// typed: ENTER plays a result, add queues it and reveal opens its folder.
+// Opening it again from the results goes back to editing the query.
+
+const (
+	findLimit = 200
+	findStale = time.Hour
+)
+
+func (m *model) openFind(query string) tea.Cmd {
+	if query == "" && m.leftView == viewFind {
+		m.findMode, m.focus = true, 0
+		return nil
+	}
+	m.leftView, m.focus, m.fmCur, m.fmOff = viewFind, 0, 0, 0
+	m.findInput, m.findMode = query, query == ""
+	m.library.Sync(libraryFile)
+	m.refresh()
+	if !m.library.Scanned.IsZero() && m.now().Sub(m.library.Scanned) < findStale {
+		return nil
+	}
+	m.findMsg = "scanning library…"
+	return m.rescanLibrary(func(m *model) tea.Cmd {
+		m.findMsg = ""
+		if m.leftView == viewFind {
+			m.refresh()
+		}
+		return nil
+	})
+}
+
+func (m *model) closeFind() {
+	m.findMode = false
+	m.switchView(viewFiles)
+}
+
+func (m *model) findKey(msg tea.KeyMsg) {
+	switch msg.String() {
+	case "enter":
+		m.findMode = false
+		return
+	case "esc":
+		m.closeFind()
+		return
+	case "backspace":
+		r := []rune(m.findInput)
+		if len(r) == 0 {
+			return
+		}
+		m.findInput = string(r[:len(r)-1])
+	case "ctrl+u":
+		m.findInput = ""
+	default:
+		if msg.Type != tea.KeyRunes && msg.String() != " " {
+			return
+		}
+		m.findInput += string(msg.Runes)
+	}
+	m.fmCur, m.fmOff = 0, 0
+	m.refresh()
+}
+
+func findLabel(t libindex.Track) string {
+	s := strings.TrimSuffix(filepath.Base(t.Path), filepath.Ext(t.Path))
+	if t.Title != "" {
+		s = t.Title
+		if t.Artist != "" {
+			s = t.Artist + " - " + s
+		}
+	}
+	if t.Album != "" {
+		s += " · " + t.Album
+	}
+	return s
+}
+
+// findItems ranks the library by the query; rating terms work as in /.
+func (m *model) findItems() []displayItem {
+	m.findPos, m.findHits = nil, 0
+	q, keep := m.ratingFilter(m.findInput)
+	if strings.TrimSpace(q) == "" && keep == nil {
+		return nil
+	}
+	var tracks []libindex.Track
+	for p, t := range m.library.Tracks {
+		if keep == nil || keep(p) {
+			t.Path = p
+			tracks = append(tracks, t)
+		}
+	}
+	sort.Slice(tracks, func(i, j int) bool { return tracks[i].Path < tracks[j].Path })
+	res := fuzzy.Rank(len(tracks), q, func(i int) []string {
+		return []string{findLabel(tracks[i]), tracks[i].Path}
+	})
+	m.findHits = len(res)
+	if len(res) > findLimit {
+		res = res[:findLimit]
+	}
+	items := make([]displayItem, 0, len(res))
+	for _, r := range res {
+		t := tracks[r.Index]
+		items = append(items, displayItem{t.Path, findLabel(t), false})
+		m.findPos = append(m.findPos, r.Pos)
+	}
+	return items
+}
+
+func (m *model) findSummary() string {
+	if m.findMsg != "" {
+		return " ⟳ " + m.findMsg
+	}
+	s := " ⌕ " + m.findInput
+	if m.findMode {
+		s += "▌"
+	}
+	if strings.TrimSpace(m.findInput) == "" {
+		return s + fmt.Sprintf("  %d tracks", len(m.library.Tracks))
+	}
+	return s + fmt.Sprintf("  %d/%d", len(m.fmItems), m.findHits)
+}
+
+// enqueue adds a result to the end of the playlist.
+func (m *model) enqueue(it displayItem) {
+	m.state.Playlist = append(m.state.Playlist, it.path)
+	m.refresh()
+	m.save()
+	m.setNotice("queued " + it.name)
+}
+
+// reveal opens the folder of the selected track in FILES, with the track
+// under the cursor.
+func (m *model) reveal() {
+	it, ok := m.selectedItem()
+	if !ok || it.isDir || isURL(it.path) || it.path == "" {
+		return
+	}
+	m.findMode = false
+	m.leftView, m.focus = viewFiles, 0
+	m.state.Cwd = filepath.Dir(it.path)
+	m.fmCur, m.fmOff = 0, 0
+	m.refresh()
+	for i, f := range m.fmItems {
+		if f.path == it.path {
+			m.fmCur = i
+		}
+	}
+	m.sync()
+}
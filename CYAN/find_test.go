@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/totiks2012/Cyan_audio_player/internal/libindex"
+)
+
+func findModel(tracks ...libindex.Track) *model {
+	l := &libindex.Index{Tracks: map[string]libindex.Track{}}
+	for _, t := range tracks {
+		l.Tracks[t.Path] = t
+	}
+	return &model{library: l}
+}
+
+func TestFindItems(t *testing.T) {
+	m := findModel(
+		libindex.Track{Path: "/music/kino/01.mp3", Meta: libindex.Meta{Artist: "Кино", Title: "Группа крови", Album: "Группа крови"}, Rating: 5},
+		libindex.Track{Path: "/music/kino/02.mp3", Meta: libindex.Meta{Artist: "Кино", Title: "Звезда по имени Солнце"}, Rating: 3, Loved: true},
+		libindex.Track{Path: "/music/aquarium/Город золотой.flac"},
+		libindex.Track{Path: "/podcasts/radio-t/rt-900.mp3", Meta: libindex.Meta{Title: "Радио-Т 900"}},
+	)
+	for _, c := range []struct {
+		query  string
+		labels []string
+	}{
+		{"", nil},
+		{"кино", []string{"Кино - Группа крови · Группа крови", "Кино - Звезда по имени Солнце"}},
+		{"звезда солнце", []string{"Кино - Звезда по имени Солнце"}},
+		{"aquarium", []string{"Город золотой"}},
+		{"кино r>=4", []string{"Кино - Группа крови · Группа крови"}},
+		{"loved", []string{"Кино - Звезда по имени Солнце"}},
+		{"r=1", []string{}},
+		{"битлз", []string{}},
+	} {
+		m.findInput = c.query
+		items := m.findItems()
+		var labels []string
+		if items != nil {
+			labels = []string{}
+		}
+		for _, it := range items {
+			labels = append(labels, it.name)
+		}
+		if !reflect.DeepEqual(labels, c.labels) {
+			t.Errorf("find %q = %q, want %q", c.query, labels, c.labels)
+		}
+		if len(m.findPos) != len(items) || m.findHits != len(items) {
+			t.Errorf("find %q: %d positions, %d hits for %d items", c.query, len(m.findPos), m.findHits, len(items))
+		}
+	}
+}
+
+func TestFindLimit(t *testing.T) {
+	var tracks []libindex.Track
+	for i := 0; i < findLimit+50; i++ {
+		tracks = append(tracks, libindex.Track{Path: fmt.Sprintf("/music/track %03d.mp3", i)})
+	}
+	m := findModel(tracks...)
+	m.findInput = "track"
+	items := m.findItems()
+	if len(items) != findLimit || m.findHits != findLimit+50 {
+		t.Errorf("%d items of %d hits, want %d of %d", len(items), m.findHits, findLimit, findLimit+50)
+	}
+	if items[0].path != "/music/track 000.mp3" {
+		t.Errorf("first hit %s, want the first path on a tie", items[0].path)
+	}
+}
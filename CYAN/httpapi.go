@@ -0,0 +1,264 @@
+package main
+
+import (
+	"crypto/subtle"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed web
+var webFiles embed.FS
+
+// Commands reachable over HTTP; state/set_state/shutdown stay socket-only.
+var httpCommands = map[string]bool{
+	"play": true, "pause": true, "toggle": true, "next": true, "prev": true,
+	"seek": true, "volume": true, "add": true,
+}
+
+type httpTrack struct {
+	Index int    `json:"index"`
+	Title string `json:"title"`
+	Path  string `json:"path"`
+}
+
+type httpPlaylist struct {
+	Current int         `json:"current"`
+	Tracks  []httpTrack `json:"tracks"`
+}
+
+func (m *model) playlistView() httpPlaylist {
+	pl := httpPlaylist{Current: m.state.CurrentIndex, Tracks: []httpTrack{}}
+	for i, raw := range m.state.Playlist {
+		t := httpTrack{Index: i, Title: filepath.Base(raw), Path: raw}
+		if strings.Contains(raw, m3uSeparator) {
+			parts := strings.SplitN(raw, m3uSeparator, 2)
+			t.Title, t.Path = parts[0], parts[1]
+		}
+		pl.Tracks = append(pl.Tracks, t)
+	}
+	return pl
+}
+
+func (pl httpPlaylist) version() uint64 {
+	h := fnv.New64a()
+	fmt.Fprint(h, pl.Current)
+	for _, t := range pl.Tracks {
+		io.WriteString(h, "\x00"+t.Title+"\x00"+t.Path)
+	}
+	return h.Sum64()
+}
+
+// listenHTTP binds addr; a bare ":port" stays on localhost so the remote is
+// only reachable from the LAN when asked for explicitly.
+func listenHTTP(addr string) (net.Listener, error) {
+	if strings.HasPrefix(addr, ":") {
+		addr = "127.0.0.1" + addr
+	}
+	return net.Listen("tcp", addr)
+}
+
+// loopbackAddr reports whether a listener is only reachable from this
+// machine.
+func loopbackAddr(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	return ok && tcp.IP.IsLoopback()
+}
+
+// checkHTTP refuses to open the remote to the network without a token;
+// a bare ":port" is loopback, see listenHTTP.
+func checkHTTP(addr, token string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("--http %s: %v", addr, err)
+	}
+	ip := net.ParseIP(host)
+	if token == "" && host != "" && host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("--http %s is reachable from the network; set \"http_token\" in config.json", addr)
+	}
+	return nil
+}
+
+type httpAPI struct {
+	b        modelBackend
+	token    string
+	loopback bool
+}
+
+func serveHTTP(ln net.Listener, token string, b modelBackend) func() {
+	api := &httpAPI{b: b, token: token, loopback: loopbackAddr(ln.Addr())}
+	static, _ := fs.Sub(webFiles, "web")
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServer(http.FS(static)))
+	mux.HandleFunc("/api/status", api.auth(api.status))
+	mux.HandleFunc("/api/playlist", api.auth(api.playlist))
+	mux.HandleFunc("/api/events", api.auth(api.events))
+	mux.HandleFunc("/api/", api.auth(api.command))
+	srv := &http.Server{Handler: api.guard(mux), ReadHeaderTimeout: 5 * time.Second}
+	go srv.Serve(ln)
+	return func() { srv.Close() }
+}
+
+// loopbackHost reports whether a Host header names this machine.
+func loopbackHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && ip.IsLoopback()
+}
+
+// guard keeps other web sites out: a page elsewhere can't post to the API
+// (its Origin is not ours), and on loopback a name that a DNS rebinding
+// pointed at 127.0.0.1 is turned away by its Host.
+func (a *httpAPI) guard(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.loopback && !loopbackHost(r.Host) {
+			writeJSON(w, http.StatusForbidden, ctlResponse{Error: "bad host " + strconv.Quote(r.Host)})
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" {
+			u, err := url.Parse(origin)
+			if err != nil || u.Scheme != "http" || !strings.EqualFold(u.Host, r.Host) {
+				writeJSON(w, http.StatusForbidden, ctlResponse{Error: "cross-origin request from " + strconv.Quote(origin)})
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (a *httpAPI) auth(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.token != "" {
+			got := r.URL.Query().Get("token")
+			if bearer := r.Header.Get("Authorization"); strings.HasPrefix(bearer, "Bearer ") {
+				got = strings.TrimPrefix(bearer, "Bearer ")
+			}
+			if subtle.ConstantTimeCompare([]byte(got), []byte(a.token)) != 1 {
+				writeJSON(w, http.StatusUnauthorized, ctlResponse{Error: "bad or missing token"})
+				return
+			}
+		}
+		h(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func (a *httpAPI) status(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, ctlResponse{OK: true, Data: a.b.status()})
+}
+
+func (a *httpAPI) playlist(w http.ResponseWriter, r *http.Request) {
+	var pl httpPlaylist
+	a.b(func(m *model) { pl = m.playlistView() })
+	writeJSON(w, http.StatusOK, ctlResponse{OK: true, Data: pl})
+}
+
+// command runs POST /api/<cmd> with {"args": [...]} (or ?arg=... repeated),
+// the same commands and arguments as `cyan ctl`.
+func (a *httpAPI) command(w http.ResponseWriter, r *http.Request) {
+	cmd := strings.TrimPrefix(r.URL.Path, "/api/")
+	if !httpCommands[cmd] {
+		writeJSON(w, http.StatusNotFound, ctlResponse{Error: "unknown command " + strconv.Quote(cmd)})
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, ctlResponse{Error: cmd + " needs POST"})
+		return
+	}
+	var body struct {
+		Args []string `json:"args"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&body); err != nil && err != io.EOF {
+			writeJSON(w, http.StatusBadRequest, ctlResponse{Error: "bad request: " + err.Error()})
+			return
+		}
+	}
+	args := append(body.Args, r.URL.Query()["arg"]...)
+	data, err := a.b.call(cmd, args...)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ctlResponse{Error: err.Error(), Data: data})
+		return
+	}
+	writeJSON(w, http.StatusOK, ctlResponse{OK: true, Data: data})
+}
+
+// events streams Server-Sent Events: "status" whenever the now-playing
+// snapshot changes and "playlist" whenever the queue or current track does.
+func (a *httpAPI) events(w http.ResponseWriter, r *http.Request) {
+	fl, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError, ctlResponse{Error: "streaming unsupported"})
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	send := func(event string, v interface{}) bool {
+		d, _ := json.Marshal(v)
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, d); err != nil {
+			return false
+		}
+		fl.Flush()
+		return true
+	}
+	var lastSt playerStatus
+	var lastPl uint64
+	first := true
+	tick := time.NewTicker(500 * time.Millisecond)
+	defer tick.Stop()
+	idle := 0
+	for {
+		var st playerStatus
+		var pl httpPlaylist
+		a.b(func(m *model) { st, pl = m.status(), m.playlistView() })
+		if v := pl.version(); first || v != lastPl {
+			if !send("playlist", pl) {
+				return
+			}
+			lastPl = v
+		}
+		if first || st != lastSt {
+			if !send("status", st) {
+				return
+			}
+			lastSt, idle = st, 0
+		} else if idle++; idle >= 30 {
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return
+			}
+			fl.Flush()
+			idle = 0
+		}
+		first = false
+		select {
+		case <-r.Context().Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
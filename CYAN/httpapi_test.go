@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPGuard(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) { writeJSON(w, http.StatusOK, ctlResponse{OK: true}) }
+	for _, c := range []struct {
+		name     string
+		loopback bool
+		token    string
+		host     string
+		origin   string
+		query    string
+		want     int
+	}{
+		{"loopback", true, "", "127.0.0.1:8765", "", "", 200},
+		{"localhost name", true, "", "localhost:8765", "", "", 200},
+		{"same origin", true, "", "127.0.0.1:8765", "http://127.0.0.1:8765", "", 200},
+		{"rebound name", true, "", "evil.example:8765", "", "", 403},
+		{"other site posts", true, "", "127.0.0.1:8765", "http://evil.example", "", 403},
+		{"other port", true, "", "127.0.0.1:8765", "http://127.0.0.1:9999", "", 403},
+		{"opaque origin", true, "", "127.0.0.1:8765", "null", "", 403},
+		{"lan with token", false, "s3cret", "192.168.1.5:8765", "", "?token=s3cret", 200},
+		{"lan same origin", false, "s3cret", "192.168.1.5:8765", "http://192.168.1.5:8765", "?token=s3cret", 200},
+		{"lan bad token", false, "s3cret", "192.168.1.5:8765", "", "?token=nope", 401},
+		{"lan other site", false, "s3cret", "192.168.1.5:8765", "http://evil.example", "?token=s3cret", 403},
+	} {
+		api := &httpAPI{token: c.token, loopback: c.loopback}
+		h := api.guard(api.auth(ok))
+		r := httptest.NewRequest(http.MethodPost, "http://"+c.host+"/api/toggle"+c.query, nil)
+		if c.origin != "" {
+			r.Header.Set("Origin", c.origin)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != c.want {
+			t.Errorf("%s: %d, want %d (%s)", c.name, w.Code, c.want, w.Body)
+		}
+	}
+}
+
+func TestCheckHTTP(t *testing.T) {
+	for _, c := range []struct {
+		addr, token string
+		ok          bool
+	}{
+		{":8765", "", true},
+		{"127.0.0.1:8765", "", true},
+		{"localhost:8765", "", true},
+		{"[::1]:8765", "", true},
+		{"0.0.0.0:8765", "", false},
+		{"192.168.1.5:8765", "", false},
+		{"myhost:8765", "", false},
+		{"0.0.0.0:8765", "s3cret", true},
+		{"8765", "", false},
+	} {
+		if err := checkHTTP(c.addr, c.token); (err == nil) != c.ok {
+			t.Errorf("checkHTTP(%q, %q) = %v", c.addr, c.token, err)
+		}
+	}
+}
+
+func TestServeHTTPOnLoopback(t *testing.T) {
+	ln, err := listenHTTP(":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := serveHTTP(ln, "", nil)
+	defer stop()
+	base := "http://" + ln.Addr().String()
+
+	resp, err := http.Get(base + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET / = %s", resp.Status)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, base+"/", nil)
+	req.Host = "rebind.evil.example"
+	if resp, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("a rebound Host got %s", resp.Status)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"github.com/charmbracelet/lipgloss"
+)
+
+const (
+	stackBelow = 80 // narrower terminals get the panes one above the other
+	minRows    = 3
+)
+
+type rect struct{ x, y, w, h int }
+
+func (r rect) has(x, y int) bool {
+	return x >= r.x && x < r.x+r.w && y >= r.y && y < r.y+r.h
+}
+
+// geometry is where RenderUI put the panes, the cover and the seek bar, so
+// the mouse hits what is on screen and images are drawn over their panel.
+type geometry struct {
+	panes   [2]rect
+	listTop [2]int
+	cover   rect
+	bar     rect
+}
+
+func (m *model) stacked() bool {
+	return m.termWidth > 0 && m.termWidth < stackBelow
+}
+
+// boxWidth is the inside of a pane's border; until the first resize it is
+// the old fixed 50.
+func (m *model) boxWidth() int {
+	if m.termWidth <= 0 {
+		return 50
+	}
+	w := m.termWidth
+	if !m.stacked() {
+		w /= 2
+	}
+	if w -= 2; w < 20 {
+		w = 20
+	}
+	return w
+}
+
+// textWidth is what a pane line is trimmed to.
+func (m *model) textWidth() int {
+	return m.boxWidth() - 2
+}
+
+// barWidth leaves room for the timer, volume and stream status after the
+// seek bar.
+func (m *model) barWidth() int {
+	if m.termWidth <= 0 {
+		return 50
+	}
+	w := m.termWidth - 46
+	if w < 10 {
+		w = 10
+	}
+	return w
+}
+
+func (m *model) lineWidth() int {
+	if m.termWidth <= 0 {
+		return 102
+	}
+	return m.termWidth
+}
+
+// resize fits the list rows to the terminal. A box is its rows plus the
+// two header lines and the border; below the boxes go the cover, the VU
+// meter and three status lines.
+func (m *model) resize() {
+	m.coverRows = 0
+	if m.coverMode != "" && m.coverMode != coverOff && !m.stacked() {
+		m.coverRows = coverRowsFor(m.termHeight)
+	}
+	free := m.termHeight - 3 - m.coverRows - m.visRows() - 1
+	if m.stacked() {
+		free = free/2 - 4
+	} else {
+		free -= 4
+	}
+	if free < minRows {
+		free = minRows
+	}
+	m.height = free
+}
+
+// joinPanes puts the two boxes side by side or one above the other and
+// records where they landed.
+func (m *model) joinPanes(left, right, leftHead, rightHead string) string {
+	lw, lh := lipgloss.Size(left)
+	rw, rh := lipgloss.Size(right)
+	m.geo.panes[0] = rect{0, 0, lw, lh}
+	var boxes string
+	if m.stacked() {
+		m.geo.panes[1] = rect{0, lh, rw, rh}
+		boxes = lipgloss.JoinVertical(lipgloss.Left, left, right)
+	} else {
+		m.geo.panes[1] = rect{lw, 0, rw, rh}
+		boxes = lipgloss.JoinHorizontal(lipgloss.Top, left, right)
+	}
+	m.geo.listTop[0] = m.geo.panes[0].y + 1 + lipgloss.Height(leftHead)
+	m.geo.listTop[1] = m.geo.panes[1].y + 1 + lipgloss.Height(rightHead)
+	return boxes
+}
+
+// paneAt maps a click to a pane and a visible row; row is -1 on the
+// header or border.
+func (m *model) paneAt(x, y int) (pane, row int) {
+	for i, r := range m.geo.panes {
+		if !r.has(x, y) {
+			continue
+		}
+		row = y - m.geo.listTop[i]
+		if row < 0 || row >= m.height {
+			row = -1
+		}
+		return i, row
+	}
+	return -1, -1
+}
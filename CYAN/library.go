@@ -0,0 +1,127 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/totiks2012/Cyan_audio_player/internal/cmdline"
+	"github.com/totiks2012/Cyan_audio_player/internal/libindex"
+)
+
+// libraryFile is the index cyan shares with cy. legacyLibraryFile is where
+// cyan kept its own before; its tracks are taken in once and the file is
+// renamed.
+var libraryFile = libindex.File()
+
+const legacyLibraryFile = ".cyan_library.json"
+
+func loadLibrary() *libindex.Index {
+	l := libindex.Load(libraryFile)
+	old := libindex.Load(legacyLibraryFile)
+	if len(old.Tracks) == 0 {
+		return l
+	}
+	for p, t := range old.Tracks {
+		if cur, ok := l.Tracks[p]; ok && (cur.Rating > 0 || cur.Loved) {
+			t.Rating, t.Loved, t.Rated = cur.Rating, cur.Loved, cur.Rated
+		}
+		l.Tracks[p] = t
+	}
+	if l.Save(libraryFile) == nil {
+		_ = os.Rename(legacyLibraryFile, legacyLibraryFile+".old")
+	}
+	return l
+}
+
+func libraryDirs(cfg Config, cwd string) []string {
+	var dirs []string
+	for _, d := range cfg.Library {
+		dirs = append(dirs, cmdline.ExpandHome(d))
+	}
+	if len(dirs) == 0 {
+		if home, err := os.UserHomeDir(); err == nil {
+			if fi, err := os.Stat(filepath.Join(home, "Music")); err == nil && fi.IsDir() {
+				return []string{filepath.Join(home, "Music")}
+			}
+		}
+		dirs = []string{cwd}
+	}
+	return dirs
+}
+
+// librarySaveDelay is how long a change to the index waits before it is
+// written, so a run of ratings costs one save.
+const librarySaveDelay = 2 * time.Second
+
+// saveLibrary marks the index changed; the tick or the next state save
+// writes it.
+func (m *model) saveLibrary() {
+	if m.libraryDirty.IsZero() {
+		m.libraryDirty = m.now()
+	}
+}
+
+// flushLibrary writes a changed index once it has waited librarySaveDelay,
+// or at once with force.
+func (m *model) flushLibrary(force bool) {
+	if m.libraryDirty.IsZero() || !force && m.now().Sub(m.libraryDirty) < librarySaveDelay {
+		return
+	}
+	m.libraryDirty = time.Time{}
+	_ = m.library.Save(libraryFile)
+}
+
+type libraryMsg struct {
+	dirs   []string
+	tracks map[string]libindex.Track
+	then   func(m *model) tea.Cmd
+}
+
+// rescanLibrary refreshes the index off the UI goroutine and runs then on
+// the model once it is in place.
+func (m *model) rescanLibrary(then func(m *model) tea.Cmd) tea.Cmd {
+	dirs, old := libraryDirs(m.config, m.state.Cwd), m.library.Snapshot()
+	return func() tea.Msg {
+		return libraryMsg{dirs, libindex.Scan(dirs, old, isAudio), then}
+	}
+}
+
+func (m *model) onLibrary(msg libraryMsg) tea.Cmd {
+	m.library.Apply(msg.dirs, msg.tracks, m.now())
+	_ = m.library.Save(libraryFile)
+	if msg.then != nil {
+		return msg.then(m)
+	}
+	return nil
+}
+
+type playCount struct {
+	Plays int
+	Last  time.Time
+}
+
+func (m *model) loadPlayLog() []playEntry {
+	if fi, err := os.Stat(playLogFile); err == nil && fi.Size() != m.playLogSize {
+		m.playLog, m.playLogSize = readPlayLog(playLogFile), fi.Size()
+	}
+	return m.playLog
+}
+
+// playCounts counts every logged play that was not an early skip.
+func playCounts(entries []playEntry) map[string]playCount {
+	out := map[string]playCount{}
+	for _, e := range entries {
+		if e.Outcome == outcomeSkipped && e.Listened < 30 {
+			continue
+		}
+		c := out[e.Path]
+		c.Plays++
+		if e.Start.After(c.Last) {
+			c.Last = e.Start
+		}
+		out[e.Path] = c
+	}
+	return out
+}
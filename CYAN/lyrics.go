@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/dhowden/tag"
+	"github.com/totiks2012/Cyan_audio_player/internal/tags"
+)
+
+const lyricsOffsetsFile = ".cyan_lyrics_offsets.json"
+
+type lyricLine struct {
+	At   float64
+	Text string
+}
+
+// lyrics of one track; without timestamps Synced is false and the lines are
+// just shown as text.
+type lyrics struct {
+	path   string
+	Lines  []lyricLine
+	Synced bool
+	Source string
+}
+
+type lyricsMsg lyrics
+
+var (
+	lrcStamp = regexp.MustCompile(`^\[(\d+):(\d+(?:[.:]\d+)?)\]`)
+	lrcTag   = regexp.MustCompile(`^\[([a-zA-Z]+):\s*(.*)\]$`)
+	lrcWord  = regexp.MustCompile(`<\d+:\d+(?:[.:]\d+)?>`)
+)
+
+// parseLRC reads "[mm:ss.xx]text" lines, with any number of stamps per line
+// and the [offset:ms] tag. Text without stamps comes back unsynced.
+func parseLRC(text string) ([]lyricLine, bool) {
+	var lines, plain []lyricLine
+	offset := 0.0
+	for _, raw := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		l := strings.TrimSpace(raw)
+		var stamps []float64
+		for {
+			sm := lrcStamp.FindStringSubmatch(l)
+			if sm == nil {
+				break
+			}
+			min, _ := strconv.Atoi(sm[1])
+			sec, _ := strconv.ParseFloat(strings.Replace(sm[2], ":", ".", 1), 64)
+			stamps = append(stamps, float64(min)*60+sec)
+			l = strings.TrimSpace(l[len(sm[0]):])
+		}
+		if len(stamps) == 0 {
+			if sm := lrcTag.FindStringSubmatch(l); sm != nil {
+				if strings.EqualFold(sm[1], "offset") {
+					ms, _ := strconv.Atoi(strings.TrimPrefix(sm[2], "+"))
+					offset = float64(ms) / 1000
+				}
+				continue
+			}
+			plain = append(plain, lyricLine{Text: l})
+			continue
+		}
+		l = strings.TrimSpace(lrcWord.ReplaceAllString(l, ""))
+		for _, at := range stamps {
+			lines = append(lines, lyricLine{At: at, Text: l})
+		}
+	}
+	if len(lines) == 0 {
+		for len(plain) > 0 && plain[0].Text == "" {
+			plain = plain[1:]
+		}
+		for len(plain) > 0 && plain[len(plain)-1].Text == "" {
+			plain = plain[:len(plain)-1]
+		}
+		return plain, false
+	}
+	// a positive [offset:] makes the lines come sooner
+	for i := range lines {
+		lines[i].At -= offset
+	}
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].At < lines[j].At })
+	return lines, true
+}
+
+// parseSYLT reads an ID3 synchronised lyrics frame with millisecond stamps.
+func parseSYLT(data []byte) []lyricLine {
+	if len(data) < 6 || data[4] != 2 {
+		return nil
+	}
+	enc, b := data[0], data[6:]
+	wide := enc == 1 || enc == 2
+	next := func() (string, bool) {
+		if wide {
+			for i := 0; i+1 < len(b); i += 2 {
+				if b[i] == 0 && b[i+1] == 0 {
+					s := tags.DecodeText(enc, b[:i])
+					b = b[i+2:]
+					return s, true
+				}
+			}
+			return "", false
+		}
+		for i, c := range b {
+			if c == 0 {
+				s := tags.DecodeText(enc, b[:i])
+				b = b[i+1:]
+				return s, true
+			}
+		}
+		return "", false
+	}
+	if _, ok := next(); !ok {
+		return nil
+	}
+	var lines []lyricLine
+	for len(b) > 0 {
+		s, ok := next()
+		if !ok || len(b) < 4 {
+			break
+		}
+		at := float64(binary.BigEndian.Uint32(b)) / 1000
+		b = b[4:]
+		lines = append(lines, lyricLine{At: at, Text: strings.TrimSpace(strings.TrimLeft(s, "\n\r"))})
+	}
+	return lines
+}
+
+// loadLyrics prefers a .lrc next to the file, then the lyrics tags.
+func loadLyrics(path string) lyrics {
+	l := lyrics{path: path}
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	for _, ext := range []string{".lrc", ".LRC"} {
+		if d, err := os.ReadFile(base + ext); err == nil {
+			l.Lines, l.Synced = parseLRC(string(d))
+			l.Source = filepath.Base(base + ext)
+			return l
+		}
+	}
+	var text string
+	if t, err := tags.Open(path); err == nil {
+		if t.IsID3() {
+			for _, d := range t.Frames("SYLT") {
+				if lines := parseSYLT(d); len(lines) > 0 {
+					l.Lines, l.Synced, l.Source = lines, true, "SYLT"
+					return l
+				}
+			}
+			for _, d := range t.Frames("USLT") {
+				if len(d) > 4 {
+					_, text = tags.SplitText(append([]byte{d[0]}, d[4:]...))
+					l.Source = "USLT"
+					break
+				}
+			}
+		} else {
+			for _, k := range []string{"LYRICS", "UNSYNCEDLYRICS"} {
+				if text = t.Comment(k); text != "" {
+					l.Source = k
+					break
+				}
+			}
+		}
+	} else if f, err := os.Open(path); err == nil {
+		if md, err := tag.ReadFrom(f); err == nil {
+			text, l.Source = md.Lyrics(), "tags"
+		}
+		f.Close()
+	}
+	if strings.TrimSpace(text) == "" {
+		l.Source = ""
+		return l
+	}
+	l.Lines, l.Synced = parseLRC(text)
+	return l
+}
+
+// index is the line being sung at pos, -1 before the first one.
+func (l *lyrics) index(pos float64) int {
+	return sort.Search(len(l.Lines), func(i int) bool { return l.Lines[i].At > pos }) - 1
+}
+
+// lyricsCmd loads the current track's lyrics while the view is open.
+func (m *model) lyricsCmd() tea.Cmd {
+	if m.leftView != viewLyrics {
+		return nil
+	}
+	p := m.currentPath()
+	if isURL(p) {
+		p = ""
+	}
+	if m.lyrics != nil && m.lyrics.path == p {
+		return nil
+	}
+	m.lyrics = &lyrics{path: p, Source: "…"}
+	m.refresh()
+	if p == "" {
+		return nil
+	}
+	return func() tea.Msg { return lyricsMsg(loadLyrics(p)) }
+}
+
+func (m *model) onLyrics(msg lyricsMsg) {
+	if m.lyrics == nil || m.lyrics.path != msg.path {
+		return
+	}
+	l := lyrics(msg)
+	m.lyrics = &l
+	m.fmCur, m.fmOff = 0, 0
+	m.refresh()
+	m.followLyrics()
+}
+
+func (m *model) lyricOffset() float64 {
+	if m.lyrics == nil {
+		return 0
+	}
+	return m.lyricOffsets.get(m.lyrics.path)
+}
+
+// shiftLyrics moves the lines later (positive) or sooner for this track.
+func (m *model) shiftLyrics(d float64) {
+	if m.lyrics == nil || !m.lyrics.Synced {
+		return
+	}
+	off := float64(int((m.lyricOffset()+d)*100+0.5*sign(m.lyricOffset()+d))) / 100
+	if off == 0 {
+		m.lyricOffsets.forget(m.lyrics.path)
+	} else {
+		m.lyricOffsets.set(m.lyrics.path, off)
+	}
+	m.lyricOffsets.save()
+	m.followLyrics()
+}
+
+func sign(f float64) float64 {
+	if f < 0 {
+		return -1
+	}
+	return 1
+}
+
+// holdLyrics stops the pane following the song for a moment so a line can
+// be picked with the cursor.
+func (m *model) holdLyrics() {
+	if m.leftView == viewLyrics {
+		m.lyricsHold = m.now()
+	}
+}
+
+// followLyrics marks the line being sung and, unless the cursor was just
+// moved by hand, puts the cursor on it and keeps it centred.
+func (m *model) followLyrics() {
+	m.lyricCur = -1
+	if m.leftView != viewLyrics || m.lyrics == nil || !m.lyrics.Synced || m.tags != nil || m.organise != nil {
+		return
+	}
+	i := m.lyrics.index(m.curPos - m.lyricOffset())
+	m.lyricCur = i
+	if m.now().Sub(m.lyricsHold) < 5*time.Second {
+		return
+	}
+	if i < 0 {
+		i = 0
+	}
+	m.fmCur = i
+	m.fmOff = i - m.height/2
+	if max := len(m.fmItems) - m.height; m.fmOff > max {
+		m.fmOff = max
+	}
+	if m.fmOff < 0 {
+		m.fmOff = 0
+	}
+}
+
+func (m *model) lyricsItems() []displayItem {
+	if m.lyrics == nil {
+		return nil
+	}
+	var items []displayItem
+	for _, l := range m.lyrics.Lines {
+		t := l.Text
+		if t == "" {
+			t = "♪"
+		}
+		items = append(items, displayItem{"", t, false})
+	}
+	return items
+}
+
+func (m *model) lyricsSummary() string {
+	switch {
+	case m.lyrics == nil || m.lyrics.path == "":
+		return "nothing playing"
+	case m.lyrics.Source == "…":
+		return "loading…"
+	case m.lyrics.Source == "":
+		return "no lyrics found"
+	case !m.lyrics.Synced:
+		return m.lyrics.Source + " · plain text"
+	}
+	return fmt.Sprintf("%s · offset %+.2fs (< >)", m.lyrics.Source, m.lyricOffset())
+}
+
+// lyricsAction seeks to the selected line.
+func (m *model) lyricsAction() {
+	if m.lyrics == nil || !m.lyrics.Synced || m.fmCur >= len(m.lyrics.Lines) {
+		return
+	}
+	at := m.lyrics.Lines[m.fmCur].At + m.lyricOffset()
+	if at < 0 {
+		at = 0
+	}
+	m.player.Command("seek", strconv.FormatFloat(at, 'f', 2, 64), "absolute")
+	m.lyricsHold = time.Time{}
+}
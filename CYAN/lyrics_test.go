@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"unicode/utf16"
+)
+
+func TestParseLRC(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		text   string
+		lines  []lyricLine
+		synced bool
+	}{
+		{
+			name: "stamps and tags",
+			text: "[ar:Кино]\n[ti:Группа крови]\n[00:12.50]Тёплое место,\n[00:15.20]но улицы ждут\n",
+			lines: []lyricLine{
+				{12.5, "Тёплое место,"},
+				{15.2, "но улицы ждут"},
+			},
+			synced: true,
+		},
+		{
+			name: "several stamps on a line are sorted",
+			text: "[00:30.00][00:10.00]Припев\n[00:20.00]Куплет",
+			lines: []lyricLine{
+				{10, "Припев"},
+				{20, "Куплет"},
+				{30, "Припев"},
+			},
+			synced: true,
+		},
+		{
+			name:   "positive offset makes lines sooner",
+			text:   "[offset:+500]\r\n[01:02.50]Line\r\n",
+			lines:  []lyricLine{{62, "Line"}},
+			synced: true,
+		},
+		{
+			name:   "word stamps and a colon before hundredths",
+			text:   "[00:05:25]<00:05.25>Звезда <00:06.00>по имени Солнце",
+			lines:  []lyricLine{{5.25, "Звезда по имени Солнце"}},
+			synced: true,
+		},
+		{
+			name:  "plain text keeps inner blank lines",
+			text:  "\n\nПеремен!\n\nТребуют наши сердца\n\n",
+			lines: []lyricLine{{0, "Перемен!"}, {0, ""}, {0, "Требуют наши сердца"}},
+		},
+		{
+			name: "empty",
+			text: "[ar:Кино]\n",
+		},
+	} {
+		lines, synced := parseLRC(c.text)
+		if synced != c.synced || len(lines)+len(c.lines) > 0 && !reflect.DeepEqual(lines, c.lines) {
+			t.Errorf("%s: %v %+v, want %v %+v", c.name, synced, lines, c.synced, c.lines)
+		}
+	}
+}
+
+// sylt builds a SYLT frame body: encoding, language, timestamp format,
+// content type, descriptor, then text and a millisecond stamp per line.
+func sylt(enc, format byte, text func(string) []byte, lines ...lyricLine) []byte {
+	var b bytes.Buffer
+	b.WriteByte(enc)
+	b.WriteString("rus")
+	b.WriteByte(format)
+	b.WriteByte(1)
+	b.Write(text(""))
+	for _, l := range lines {
+		b.Write(text(l.Text))
+		_ = binary.Write(&b, binary.BigEndian, uint32(l.At*1000))
+	}
+	return b.Bytes()
+}
+
+func utf8Text(s string) []byte { return append([]byte(s), 0) }
+
+func utf16Text(s string) []byte {
+	b := []byte{0xff, 0xfe}
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(u), byte(u>>8))
+	}
+	return append(b, 0, 0)
+}
+
+func TestParseSYLT(t *testing.T) {
+	lines := []lyricLine{{1.5, "Кукушка"}, {4.25, "Песен ещё ненаписанных"}}
+	for _, c := range []struct {
+		name string
+		data []byte
+		want []lyricLine
+	}{
+		{"UTF-8", sylt(3, 2, utf8Text, lines...), lines},
+		{"UTF-16", sylt(1, 2, utf16Text, lines...), lines},
+		{"leading newline", sylt(3, 2, utf8Text, lyricLine{2, "\nКукушка "}), []lyricLine{{2, "Кукушка"}}},
+		{"MPEG frame stamps", sylt(3, 1, utf8Text, lines...), nil},
+		{"cut short", sylt(3, 2, utf8Text, lines...)[:30], lines[:1]},
+		{"no descriptor end", []byte{3, 'r', 'u', 's', 2, 1, 'x'}, nil},
+		{"too short", []byte{3, 'r', 'u'}, nil},
+	} {
+		if got := parseSYLT(c.data); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: %+v, want %+v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestLyricsIndex(t *testing.T) {
+	l := lyrics{Lines: []lyricLine{{10, "a"}, {20, "b"}, {30, "c"}}}
+	for pos, want := range map[float64]int{0: -1, 10: 0, 19.9: 0, 20: 1, 99: 2} {
+		if got := l.index(pos); got != want {
+			t.Errorf("index(%v) = %d, want %d", pos, got, want)
+		}
+	}
+}
@@ -0,0 +1,682 @@
+package main
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/dhowden/tag"
+	"github.com/totiks2012/Cyan_audio_player/internal/cmdline"
+	"github.com/totiks2012/Cyan_audio_player/internal/libindex"
+	"github.com/totiks2012/Cyan_audio_player/internal/tags"
+)
+
+const defaultOrganiseTemplate = "{albumartist}/{year} - {album}/{track:02} {title}.{ext}"
+
+var organiseFieldNames = []string{"albumartist", "artist", "album", "title", "track", "disc", "year", "genre", "ext"}
+
+// organiseFields reads the values a template can use. Missing names fall
+// back the way most taggers do; missing numbers stay empty.
+func organiseFields(path string) map[string]string {
+	f := map[string]string{"ext": strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))}
+	if fh, err := os.Open(path); err == nil {
+		if t, err := tag.ReadFrom(fh); err == nil {
+			f["albumartist"], f["artist"], f["album"] = t.AlbumArtist(), t.Artist(), t.Album()
+			f["title"], f["genre"] = t.Title(), t.Genre()
+			n, _ := t.Track()
+			d, _ := t.Disc()
+			for k, v := range map[string]int{"track": n, "disc": d, "year": t.Year()} {
+				if v > 0 {
+					f[k] = strconv.Itoa(v)
+				}
+			}
+		}
+		fh.Close()
+	}
+	for k, v := range f {
+		f[k] = strings.TrimSpace(v)
+	}
+	// only tags count here: a name guessed from the file name would change
+	// once the file is renamed, and a second run would move it again
+	if f["albumartist"] == "" {
+		f["albumartist"] = f["artist"]
+	}
+	for k, v := range map[string]string{"albumartist": "Unknown Artist", "artist": "Unknown Artist", "album": "Unknown Album",
+		"title": strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))} {
+		if f[k] == "" {
+			f[k] = v
+		}
+	}
+	return f
+}
+
+// renderTemplate fills "{field}" and "{field:0N}" placeholders. Values
+// cannot add folders, and separators left dangling by an empty value are
+// trimmed from each path element.
+func renderTemplate(tmpl string, f map[string]string) (string, error) {
+	var sb strings.Builder
+	for {
+		i := strings.IndexByte(tmpl, '{')
+		if i < 0 {
+			sb.WriteString(tmpl)
+			break
+		}
+		j := strings.IndexByte(tmpl[i:], '}')
+		if j < 0 {
+			return "", fmt.Errorf("unclosed { in template")
+		}
+		sb.WriteString(tmpl[:i])
+		name, spec, formatted := strings.Cut(tmpl[i+1:i+j], ":")
+		if !hasString(organiseFieldNames, name) {
+			return "", fmt.Errorf("unknown template field {%s}", name)
+		}
+		v := f[name]
+		if formatted {
+			w, err := strconv.Atoi(spec)
+			if err != nil || !strings.HasPrefix(spec, "0") {
+				return "", fmt.Errorf("bad format {%s:%s}, use a width such as :02", name, spec)
+			}
+			if n, err := strconv.Atoi(v); err == nil {
+				v = fmt.Sprintf("%0*d", w, n)
+			}
+		}
+		sb.WriteString(strings.Map(func(r rune) rune {
+			if strings.ContainsRune(`/\:*?"<>|`, r) || r < 32 {
+				return '_'
+			}
+			return r
+		}, v))
+		tmpl = tmpl[i+j+1:]
+	}
+	var parts []string
+	for _, p := range strings.Split(sb.String(), "/") {
+		if p = strings.Trim(p, " -_."); p != "" {
+			parts = append(parts, p)
+		}
+	}
+	if len(parts) == 0 {
+		return "", errors.New("template gives an empty path")
+	}
+	return filepath.Join(parts...), nil
+}
+
+type organiseMove struct {
+	From, To string
+	Conflict string
+}
+
+// organiseSources expands files and folders into the audio files below them.
+func organiseSources(paths []string) []string {
+	var out []string
+	for _, p := range paths {
+		p, _ = filepath.Abs(cmdline.ExpandHome(p))
+		_ = filepath.WalkDir(p, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if d.IsDir() {
+				if path != p && strings.HasPrefix(d.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if isAudio(path) && !hasString(out, path) {
+				out = append(out, path)
+			}
+			return nil
+		})
+	}
+	sort.Strings(out)
+	return out
+}
+
+// planOrganise works out where each file goes under root. Files already in
+// place are left out; a move whose target exists or is claimed by another
+// file is kept with its Conflict set and never carried out.
+func planOrganise(files []string, root, tmpl string) ([]organiseMove, error) {
+	var moves []organiseMove
+	claimed := map[string]string{}
+	for _, f := range files {
+		rel, err := renderTemplate(tmpl, organiseFields(f))
+		if err != nil {
+			return nil, err
+		}
+		to := filepath.Join(root, rel)
+		if to == f {
+			continue
+		}
+		mv := organiseMove{From: f, To: to}
+		key := strings.ToLower(to)
+		if other, ok := claimed[key]; ok {
+			mv.Conflict = "same target as " + filepath.Base(other)
+		} else if fi, err := os.Stat(to); err == nil {
+			if src, err := os.Stat(f); err != nil || !os.SameFile(fi, src) {
+				mv.Conflict = "target exists"
+			}
+		}
+		claimed[key] = f
+		moves = append(moves, mv)
+	}
+	return moves, nil
+}
+
+// moveFile renames, falling back to copy and delete across file systems.
+func moveFile(from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+	if _, err := os.Lstat(to); err == nil && !strings.EqualFold(from, to) {
+		return fmt.Errorf("%s already exists", to)
+	}
+	err := os.Rename(from, to)
+	var le *os.LinkError
+	if !errors.As(err, &le) || le.Err != syscall.EXDEV {
+		return err
+	}
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	fi, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	tmp := to + ".part"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_EXCL|os.O_WRONLY, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := dst.Sync(); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	_ = os.Chtimes(tmp, fi.ModTime(), fi.ModTime())
+	if err := os.Rename(tmp, to); err != nil {
+		return err
+	}
+	return os.Remove(from)
+}
+
+// applyOrganise carries out the moves without a conflict and returns the
+// ones that happened, old path to new.
+func applyOrganise(moves []organiseMove, root string) (map[string]string, []error) {
+	moved := map[string]string{}
+	var errs []error
+	dirs := map[string]bool{}
+	for _, mv := range moves {
+		if mv.Conflict != "" {
+			continue
+		}
+		if err := moveFile(mv.From, mv.To); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		moved[mv.From] = mv.To
+		dirs[filepath.Dir(mv.From)] = true
+	}
+	moveCyState(moved)
+	for d := range dirs {
+		pruneEmptyDirs(d, root)
+	}
+	return moved, errs
+}
+
+// pruneEmptyDirs removes dir if it was left empty, and its parents too as
+// long as they are empty and below root.
+func pruneEmptyDirs(dir, root string) {
+	for dir != root && os.Remove(dir) == nil {
+		dir = filepath.Dir(dir)
+		if !strings.HasPrefix(dir, root+string(filepath.Separator)) {
+			return
+		}
+	}
+}
+
+// ---- references to moved files ----------------------------------------------
+
+func relocatePath(p string, moved map[string]string) (string, bool) {
+	to, ok := moved[p]
+	if !ok {
+		return p, false
+	}
+	return to, true
+}
+
+// relocateEntries rewrites playlist entries, "name|#|path" ones included.
+func relocateEntries(entries []string, moved map[string]string) bool {
+	changed := false
+	for i, e := range entries {
+		name, p := "", e
+		if strings.Contains(e, m3uSeparator) {
+			parts := strings.SplitN(e, m3uSeparator, 2)
+			name, p = parts[0]+m3uSeparator, parts[1]
+		}
+		if to, ok := relocatePath(p, moved); ok {
+			entries[i], changed = name+to, true
+		}
+	}
+	return changed
+}
+
+// relocateM3U rewrites the lines of an .m3u that point at moved files,
+// keeping relative entries relative.
+func relocateM3U(path string, moved map[string]string) (bool, error) {
+	d, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	dir := filepath.Dir(path)
+	lines := strings.Split(string(d), "\n")
+	changed := false
+	for i, l := range lines {
+		entry := strings.TrimRight(l, "\r")
+		if entry == "" || strings.HasPrefix(entry, "#") || isURL(entry) {
+			continue
+		}
+		abs := entry
+		if !filepath.IsAbs(abs) {
+			abs = filepath.Join(dir, abs)
+		}
+		to, ok := relocatePath(filepath.Clean(abs), moved)
+		if !ok {
+			continue
+		}
+		if !filepath.IsAbs(entry) {
+			if rel, err := filepath.Rel(dir, to); err == nil {
+				to = rel
+			}
+		}
+		lines[i], changed = to+l[len(entry):], true
+	}
+	if !changed {
+		return false, nil
+	}
+	return true, tags.ReplaceHead(path, int64(len(d)), []byte(strings.Join(lines, "\n")))
+}
+
+// findM3U lists the playlists under dirs, skipping hidden folders.
+func findM3U(dirs []string) []string {
+	var out []string
+	for _, dir := range dirs {
+		_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if d.IsDir() && path != dir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			if !d.IsDir() && isPlaylistFile(path) && !hasString(out, path) {
+				out = append(out, path)
+			}
+			return nil
+		})
+	}
+	return out
+}
+
+func relocatePlayLog(file string, moved map[string]string) error {
+	entries := readPlayLog(file)
+	changed := false
+	for i, e := range entries {
+		if to, ok := relocatePath(e.Path, moved); ok {
+			entries[i].Path, changed = to, true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	var out []byte
+	for _, e := range entries {
+		d, _ := json.Marshal(e)
+		out = append(append(out, d...), '\n')
+	}
+	return tags.ReplaceHead(file, fi.Size(), out)
+}
+
+// relocateWatchLater renames mpv's resume files, which are named after the
+// MD5 of the played path.
+func relocateWatchLater(dir string, moved map[string]string) {
+	name := func(p string) string { return fmt.Sprintf("%X", md5.Sum([]byte(p))) }
+	for from, to := range moved {
+		_ = os.Rename(filepath.Join(dir, name(from)), filepath.Join(dir, name(to)))
+	}
+}
+
+// moveCyState carries cy's per-folder resume file over to the files' new
+// places.
+func moveCyState(moved map[string]string) {
+	for from, to := range moved {
+		sf := filepath.Join(filepath.Dir(from), ".cyan_player_state")
+		d, err := os.ReadFile(sf)
+		if err != nil {
+			continue
+		}
+		track, pos, _ := strings.Cut(string(d), "\n")
+		dst := filepath.Join(filepath.Dir(to), ".cyan_player_state")
+		if track != from {
+			continue
+		}
+		if _, err := os.Stat(dst); err == nil {
+			continue
+		}
+		if os.WriteFile(dst, []byte(to+"\n"+pos), 0644) == nil {
+			os.Remove(sf)
+		}
+	}
+}
+
+// relocateFiles updates everything cyan keeps on disk about the moved files
+// except the model's own state, which the caller saves.
+func relocateFiles(cfg Config, cwd string, moved map[string]string) []error {
+	var errs []error
+	if err := relocatePlayLog(playLogFile, moved); err != nil && !os.IsNotExist(err) {
+		errs = append(errs, err)
+	}
+	relocateWatchLater(".cyan_history", moved)
+	for _, p := range findM3U(append(libraryDirs(cfg, cwd), cwd)) {
+		if _, err := relocateM3U(p, moved); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+func relocateLibrary(l *libindex.Index, moved map[string]string) {
+	for from, to := range moved {
+		if t, ok := l.Tracks[from]; ok {
+			delete(l.Tracks, from)
+			t.Path = to
+			if fi, err := os.Stat(to); err == nil {
+				t.Size, t.ModTime = fi.Size(), fi.ModTime().Unix()
+			}
+			l.Tracks[to] = t
+		}
+	}
+}
+
+func relocatePositions(s *positionStore, moved map[string]string) {
+	for from, to := range moved {
+		if p, ok := s.pos[from]; ok {
+			s.forget(from)
+			s.set(to, p)
+		}
+	}
+}
+
+func relocatePodcasts(st podcastState, moved map[string]string) bool {
+	changed := false
+	for _, e := range st {
+		if to, ok := relocatePath(e.File, moved); ok {
+			e.File, changed = to, true
+		}
+	}
+	return changed
+}
+
+// relocateState points the saved session at the new paths; a browsed
+// folder that was emptied and removed falls back to its nearest parent.
+func relocateState(st *State, moved map[string]string) {
+	relocateEntries(st.Playlist, moved)
+	for st.Cwd != filepath.Dir(st.Cwd) {
+		if fi, err := os.Stat(st.Cwd); err == nil && fi.IsDir() {
+			break
+		}
+		st.Cwd = filepath.Dir(st.Cwd)
+	}
+}
+
+// ---- TUI ----------------------------------------------------------------------
+
+// organisePlan is the preview shown before anything moves.
+type organisePlan struct {
+	moves []organiseMove
+	root  string
+	err   string
+}
+
+type organiseMsg struct {
+	moved map[string]string
+	errs  []error
+}
+
+func (m *model) organiseTemplate() string {
+	if m.config.OrganiseTemplate != "" {
+		return m.config.OrganiseTemplate
+	}
+	return defaultOrganiseTemplate
+}
+
+// organiseSelection is the marked files, or the selected file or folder.
+func (m *model) organiseSelection() []string {
+	if len(m.marked) > 0 {
+		return m.tagSelection()
+	}
+	it, ok := m.selectedItem()
+	if !ok || it.name == ".." || isURL(it.path) {
+		return nil
+	}
+	return []string{it.path}
+}
+
+func (m *model) planOrganise() {
+	paths := m.organiseSelection()
+	if len(paths) == 0 {
+		return
+	}
+	root := libraryDirs(m.config, m.state.Cwd)[0]
+	p := &organisePlan{root: root}
+	moves, err := planOrganise(organiseSources(paths), root, m.organiseTemplate())
+	if err != nil {
+		p.err = err.Error()
+	}
+	p.moves = moves
+	m.organise, m.focus, m.fmCur, m.fmOff = p, 0, 0, 0
+	m.refresh()
+}
+
+func (p *organisePlan) items() []displayItem {
+	if len(p.moves) == 0 && p.err == "" {
+		return []displayItem{{"", "everything is already in place", false}}
+	}
+	var items []displayItem
+	for _, mv := range p.moves {
+		to := mv.To
+		if rel, err := filepath.Rel(p.root, to); err == nil {
+			to = rel
+		}
+		if mv.Conflict != "" {
+			items = append(items, displayItem{mv.From, "! " + filepath.Base(mv.From) + " (" + mv.Conflict + ")", false})
+		} else {
+			items = append(items, displayItem{mv.From, "- " + filepath.Base(mv.From), false})
+		}
+		items = append(items, displayItem{mv.From, "+ " + to, false})
+	}
+	return items
+}
+
+func (p *organisePlan) summary() string {
+	if p.err != "" {
+		return "✗ " + p.err
+	}
+	n, c := 0, 0
+	for _, mv := range p.moves {
+		if mv.Conflict != "" {
+			c++
+		} else {
+			n++
+		}
+	}
+	return fmt.Sprintf("%d to move, %d conflicts · ENTER: move | ESC: cancel", n, c)
+}
+
+func (m *model) organiseKey(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "esc":
+		m.organise = nil
+	case "up":
+		m.fmCur--
+	case "down":
+		m.fmCur++
+	case "enter":
+		if m.organise.err != "" {
+			break
+		}
+		moves, root := m.organise.moves, m.organise.root
+		m.organise = nil
+		m.setNotice("organising…")
+		m.refresh()
+		return func() tea.Msg {
+			moved, errs := applyOrganise(moves, root)
+			return organiseMsg{moved, errs}
+		}
+	}
+	m.refresh()
+	return nil
+}
+
+func (m *model) onOrganise(msg organiseMsg) {
+	errs := msg.errs
+	if len(msg.moved) > 0 {
+		relocateState(&m.state, msg.moved)
+		relocateLibrary(m.library, msg.moved)
+		_ = m.library.Save(libraryFile)
+		relocatePositions(m.positions, msg.moved)
+		if relocatePodcasts(m.podState, msg.moved) {
+			m.podState.save()
+		}
+		for from, to := range msg.moved {
+			if m.marked[from] {
+				delete(m.marked, from)
+				m.marked[to] = true
+			}
+		}
+		errs = append(errs, relocateFiles(m.config, m.state.Cwd, msg.moved)...)
+		// the same track under its new name must not count as a new play
+		if to, ok := relocatePath(m.plays.cur.Path, msg.moved); ok {
+			m.plays.cur.Path = to
+		}
+		m.save()
+	}
+	m.refresh()
+	if len(errs) > 0 {
+		m.setNotice(fmt.Sprintf("moved %d files, %d failed: %v", len(msg.moved), len(errs), errs[0]))
+		return
+	}
+	m.setNotice(fmt.Sprintf("moved %d files", len(msg.moved)))
+}
+
+// ---- CLI ----------------------------------------------------------------------
+
+// runOrganise is `cyan organise [-n] [-template T] [-to DIR] [PATH...]`.
+func runOrganise(cfg Config, sock string, args []string) int {
+	fl := flag.NewFlagSet("organise", flag.ContinueOnError)
+	dry := fl.Bool("n", false, "dry run: print the moves without making them")
+	fl.BoolVar(dry, "dry-run", false, "same as -n")
+	tmpl := fl.String("template", cfg.OrganiseTemplate, "layout, default "+strconv.Quote(defaultOrganiseTemplate))
+	root := fl.String("to", "", "folder to organise into (default: the first library folder)")
+	if err := fl.Parse(args); err != nil {
+		return 2
+	}
+	if *tmpl == "" {
+		*tmpl = defaultOrganiseTemplate
+	}
+	cwd, _ := os.Getwd()
+	dirs := libraryDirs(cfg, cwd)
+	if *root == "" {
+		*root = dirs[0]
+	}
+	*root, _ = filepath.Abs(cmdline.ExpandHome(*root))
+	src := fl.Args()
+	if len(src) == 0 {
+		src = dirs
+	}
+	if !*dry {
+		if r, err := dialControl(sock); err == nil {
+			r.Stop()
+			fmt.Fprintln(os.Stderr, "cyan organise: cyan is running; organise from its TUI (O) or quit it first")
+			return 1
+		}
+	}
+
+	moves, err := planOrganise(organiseSources(src), *root, *tmpl)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "cyan organise:", err)
+		return 2
+	}
+	w := bufio.NewWriter(os.Stdout)
+	conflicts := 0
+	for _, mv := range moves {
+		if mv.Conflict != "" {
+			conflicts++
+			fmt.Fprintf(w, "! %s\n  %s: %s\n", mv.From, mv.To, mv.Conflict)
+			continue
+		}
+		fmt.Fprintf(w, "- %s\n+ %s\n", mv.From, mv.To)
+	}
+	fmt.Fprintf(w, "%d to move, %d conflicts\n", len(moves)-conflicts, conflicts)
+	w.Flush()
+	if *dry || len(moves) == conflicts {
+		return 0
+	}
+
+	moved, errs := applyOrganise(moves, *root)
+	if len(moved) > 0 {
+		st := State{Volume: 50, CurrentIndex: -1}
+		if d, err := os.ReadFile(stateFile); err == nil {
+			_ = json.Unmarshal(d, &st)
+			relocateState(&st, moved)
+			d, _ := json.Marshal(st)
+			_ = os.WriteFile(stateFile, d, 0644)
+		}
+		l := loadLibrary()
+		relocateLibrary(l, moved)
+		_ = l.Save(libraryFile)
+		pos := loadPositions(positionsFile)
+		relocatePositions(pos, moved)
+		pos.save()
+		if ps := loadPodcastState(); relocatePodcasts(ps, moved) {
+			ps.save()
+		}
+		errs = append(errs, relocateFiles(cfg, cwd, moved)...)
+	}
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, "cyan organise:", err)
+	}
+	fmt.Printf("moved %d files\n", len(moved))
+	if len(errs) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	f := map[string]string{"albumartist": "Кино", "artist": "Кино", "album": "Группа крови", "title": "Кукушка", "track": "3", "year": "1988", "ext": "mp3"}
+	with := func(k, v string) map[string]string {
+		g := map[string]string{}
+		for k, v := range f {
+			g[k] = v
+		}
+		g[k] = v
+		return g
+	}
+	for _, c := range []struct {
+		tmpl   string
+		fields map[string]string
+		want   string
+	}{
+		{defaultOrganiseTemplate, f, "Кино/1988 - Группа крови/03 Кукушка.mp3"},
+		{"{track:03}-{title}", f, "003-Кукушка"},
+		// a value that is not a number is left as it is
+		{"{track:02} {title}", with("track", "A"), "A Кукушка"},
+		// separators around an empty value are trimmed
+		{defaultOrganiseTemplate, with("year", ""), "Кино/Группа крови/03 Кукушка.mp3"},
+		{defaultOrganiseTemplate, with("track", ""), "Кино/1988 - Группа крови/Кукушка.mp3"},
+		{"{genre}/{title}", f, "Кукушка"},
+		// values cannot add folders or climb out of root
+		{"{albumartist}/{title}.{ext}", with("albumartist", "AC/DC"), "AC_DC/Кукушка.mp3"},
+		{"{album}/{title}", with("album", ".."), "Кукушка"},
+		{"{title}", with("title", `a:b*c?`), "a_b_c"},
+	} {
+		got, err := renderTemplate(c.tmpl, c.fields)
+		if err != nil || got != filepath.FromSlash(c.want) {
+			t.Errorf("%s with %v = %q, %v; want %q", c.tmpl, c.fields, got, err, c.want)
+		}
+	}
+
+	for _, tmpl := range []string{"{bpm}/{title}", "{track:2}", "{track:x}", "{track:}", "{title", "{genre}", " - "} {
+		if got, err := renderTemplate(tmpl, f); err == nil {
+			t.Errorf("%q accepted as %q", tmpl, got)
+		}
+	}
+}
+
+func writeFiles(t *testing.T, paths ...string) {
+	t.Helper()
+	for _, p := range paths {
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("not audio"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func exists(p string) bool {
+	_, err := os.Stat(p)
+	return err == nil
+}
+
+func TestPlanOrganise(t *testing.T) {
+	root := t.TempDir()
+	in := func(p string) string { return filepath.Join(root, filepath.FromSlash(p)) }
+	writeFiles(t, in("new/a.mp3"), in("a.mp3"), in("x/b.mp3"), in("y/B.MP3"), in("c.mp3"))
+
+	moves, err := planOrganise([]string{in("new/a.mp3"), in("x/b.mp3"), in("y/B.MP3"), in("c.mp3")}, root, "{title}.{ext}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []organiseMove{
+		{in("new/a.mp3"), in("a.mp3"), "target exists"},
+		{in("x/b.mp3"), in("b.mp3"), ""},
+		// names differing only in case would clash on many file systems
+		{in("y/B.MP3"), in("B.mp3"), "same target as b.mp3"},
+	}
+	if len(moves) != len(want) {
+		t.Fatalf("planned %+v", moves)
+	}
+	for i := range want {
+		if moves[i] != want[i] {
+			t.Errorf("move %d = %+v, want %+v", i, moves[i], want[i])
+		}
+	}
+
+	if _, err := planOrganise([]string{in("c.mp3")}, root, "{bpm}"); err == nil {
+		t.Error("bad template accepted")
+	}
+}
+
+func TestApplyOrganise(t *testing.T) {
+	t.Chdir(t.TempDir())
+	root := filepath.Join(t.TempDir(), "Music")
+	in := func(p string) string { return filepath.Join(root, filepath.FromSlash(p)) }
+	writeFiles(t, in("Kino/1988/a.mp3"), in("Misc/b.mp3"), in("Misc/cover.jpg"), in("c.mp3"), in("d.mp3"), in("Sorted/d.mp3"))
+
+	moved, errs := applyOrganise([]organiseMove{
+		{From: in("Kino/1988/a.mp3"), To: in("Sorted/Кино/a.mp3")},
+		{From: in("Misc/b.mp3"), To: in("Sorted/b.mp3")},
+		{From: in("c.mp3"), To: in("Sorted/c.mp3")},
+		{From: in("d.mp3"), To: in("Sorted/d.mp3"), Conflict: "target exists"},
+	}, root)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if len(moved) != 3 || moved[in("Kino/1988/a.mp3")] != in("Sorted/Кино/a.mp3") {
+		t.Errorf("moved %v", moved)
+	}
+	for _, p := range []string{"Sorted/Кино/a.mp3", "Sorted/b.mp3", "Sorted/c.mp3", "d.mp3", "Misc/cover.jpg"} {
+		if !exists(in(p)) {
+			t.Errorf("%s missing", p)
+		}
+	}
+	if exists(in("Kino")) {
+		t.Error("emptied folders left behind")
+	}
+
+	// pruning stops at root even when root is left empty
+	writeFiles(t, in("Only/e.mp3"))
+	for _, p := range []string{"Sorted", "Misc", "d.mp3"} {
+		_ = os.RemoveAll(in(p))
+	}
+	if _, errs := applyOrganise([]organiseMove{{From: in("Only/e.mp3"), To: filepath.Join(filepath.Dir(root), "e.mp3")}}, root); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if exists(in("Only")) || !exists(root) {
+		t.Errorf("pruned Only %v, root %v", !exists(in("Only")), !exists(root))
+	}
+}
+
+func TestRelocateM3U(t *testing.T) {
+	root := t.TempDir()
+	in := func(p string) string { return filepath.Join(root, filepath.FromSlash(p)) }
+	list := in("lists/mix.m3u")
+	writeFiles(t, list)
+	body := "#EXTM3U\r\n#EXTINF:215,Кино - Кукушка\r\n../Kino/a.mp3\r\n" + in("b.mp3") + "\r\nhttp://radio.example/jazz\r\n../other.mp3\r\n"
+	if err := os.WriteFile(list, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	moved := map[string]string{in("Kino/a.mp3"): in("Sorted/Кино/a.mp3"), in("b.mp3"): in("Sorted/b.mp3")}
+
+	changed, err := relocateM3U(list, moved)
+	if err != nil || !changed {
+		t.Fatalf("changed %v, %v", changed, err)
+	}
+	d, _ := os.ReadFile(list)
+	want := "#EXTM3U\r\n#EXTINF:215,Кино - Кукушка\r\n" + filepath.FromSlash("../Sorted/Кино/a.mp3") + "\r\n" + in("Sorted/b.mp3") + "\r\nhttp://radio.example/jazz\r\n../other.mp3\r\n"
+	if string(d) != want {
+		t.Errorf("got\n%s\nwant\n%s", d, want)
+	}
+
+	if changed, err := relocateM3U(list, map[string]string{in("zzz.mp3"): in("y.mp3")}); changed || err != nil {
+		t.Errorf("untouched playlist: %v, %v", changed, err)
+	}
+	if d2, _ := os.ReadFile(list); string(d2) != want {
+		t.Error("untouched playlist rewritten")
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/totiks2012/Cyan_audio_player/internal/cmdline"
+)
+
+// palette is the ":" command line: TAB completes the command or its
+// argument, up/down walk the history.
+type palette struct {
+	input   string
+	matches []string
+	pick    int
+	base    string
+	hist    int
+}
+
+func (m *model) openPalette() {
+	m.palette = &palette{hist: len(m.paletteHist)}
+}
+
+func completePaths(m *model, arg string) []string {
+	return cmdline.CompleteFiles(m.state.Cwd, arg, false)
+}
+func completeDirs(m *model, arg string) []string {
+	return cmdline.CompleteFiles(m.state.Cwd, arg, true)
+}
+
+// paletteCandidates works out the candidates for the last word, the one
+// being typed, and the line before it.
+func (m *model) paletteCandidates(line string) (string, []string) {
+	words, err := cmdline.Split(line)
+	if err != nil {
+		return "", nil
+	}
+	if len(words) == 0 || len(words) == 1 && !strings.HasSuffix(line, " ") {
+		prefix := ""
+		if len(words) == 1 {
+			prefix = words[0]
+		}
+		var names []string
+		for _, a := range keyActions {
+			names = append(names, a.Action)
+		}
+		return "", cmdline.CompleteWords(names, prefix)
+	}
+	a := lookupCommand(words[0])
+	if a == nil || a.complete == nil {
+		return "", nil
+	}
+	arg := ""
+	if !strings.HasSuffix(line, " ") {
+		arg, words = words[len(words)-1], words[:len(words)-1]
+	}
+	var head []string
+	for _, w := range words {
+		head = append(head, cmdline.Quote(w))
+	}
+	return strings.Join(head, " ") + " ", a.complete(m, arg)
+}
+
+// tab completes as far as the candidates agree and then cycles through
+// them on repeated presses.
+func (p *palette) tab(m *model) {
+	if len(p.matches) > 1 {
+		p.pick = (p.pick + 1) % len(p.matches)
+		p.input = p.base + cmdline.Quote(p.matches[p.pick])
+		return
+	}
+	base, matches := m.paletteCandidates(p.input)
+	switch len(matches) {
+	case 0:
+		return
+	case 1:
+		p.input = base + cmdline.Quote(matches[0])
+		if !strings.HasSuffix(matches[0], "/") {
+			p.input += " "
+		}
+		p.matches = nil
+	default:
+		if c := cmdline.CommonPrefix(matches); len(base+cmdline.Quote(c)) > len(p.input) {
+			p.input = base + cmdline.Quote(c)
+			return
+		}
+		p.base, p.matches, p.pick = base, matches, 0
+		p.input = base + cmdline.Quote(matches[0])
+	}
+}
+
+func (m *model) paletteKey(msg tea.KeyMsg) tea.Cmd {
+	p := m.palette
+	if msg.String() != "tab" {
+		p.matches = nil
+	}
+	switch msg.String() {
+	case "esc", "ctrl+c":
+		m.palette = nil
+	case "tab":
+		p.tab(m)
+	case "up":
+		if p.hist > 0 {
+			p.hist--
+			p.input = m.paletteHist[p.hist]
+		}
+	case "down":
+		if p.hist < len(m.paletteHist) {
+			p.hist++
+			p.input = ""
+			if p.hist < len(m.paletteHist) {
+				p.input = m.paletteHist[p.hist]
+			}
+		}
+	case "backspace":
+		if r := []rune(p.input); len(r) > 0 {
+			p.input = string(r[:len(r)-1])
+		} else {
+			m.palette = nil
+		}
+	case "ctrl+u":
+		p.input = ""
+	case "enter":
+		m.palette = nil
+		line := strings.TrimSpace(p.input)
+		if line == "" {
+			break
+		}
+		if n := len(m.paletteHist); n == 0 || m.paletteHist[n-1] != line {
+			m.paletteHist = append(m.paletteHist, line)
+		}
+		m.execLine(line)
+	default:
+		if msg.Type == tea.KeyRunes || msg.String() == " " {
+			p.input += string(msg.Runes)
+		}
+	}
+	m.sync()
+	return m.takeCmds()
+}
+
+// execLine runs a typed command and shows what came back.
+func (m *model) execLine(line string) {
+	words, err := cmdline.Split(line)
+	if err == nil && len(words) > 0 {
+		var data interface{}
+		data, err = m.runCommand(words[0], words[1:])
+		if err == nil && data != nil {
+			m.setNotice(formatResult(data))
+		}
+	}
+	if err != nil {
+		m.setNotice("✗ " + err.Error())
+	}
+}
+
+func formatResult(v interface{}) string {
+	switch v := v.(type) {
+	case playerStatus:
+		return formatStatus(v)
+	case string:
+		return v
+	case int:
+		return fmt.Sprint(v)
+	}
+	d, _ := json.Marshal(v)
+	return string(d)
+}
+
+func (m *model) paletteLine() string {
+	p := m.palette
+	s := ":" + p.input + "▌"
+	if len(p.matches) > 1 {
+		var names []string
+		for i, c := range p.matches {
+			if strings.HasSuffix(c, "/") {
+				c = filepath.Base(c) + "/"
+			} else {
+				c = filepath.Base(c)
+			}
+			if i == p.pick {
+				c = "[" + c + "]"
+			}
+			names = append(names, c)
+		}
+		s += "  " + strings.Join(names, " ")
+	}
+	return s
+}
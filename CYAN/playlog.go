@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/totiks2012/Cyan_audio_player/internal/libindex"
+)
+
+const playLogFile = ".cyan_plays.log"
+
+const (
+	outcomeCompleted = "completed"
+	outcomeSkipped   = "skipped"
+)
+
+// playEntry is one line of the append-only play log.
+type playEntry struct {
+	Path     string    `json:"path"`
+	Artist   string    `json:"artist,omitempty"`
+	Title    string    `json:"title,omitempty"`
+	Album    string    `json:"album,omitempty"`
+	Start    time.Time `json:"start"`
+	Listened float64   `json:"listened"`
+	Duration float64   `json:"duration,omitempty"`
+	Outcome  string    `json:"outcome"`
+}
+
+// playTracker follows the position stream of the current track. Listening
+// time only grows while the position moves forward at playback speed, so
+// seeking neither counts as listening nor earns a scrobble.
+type playTracker struct {
+	cur       playEntry
+	last      float64
+	scrobbled bool
+}
+
+// observe returns the finished session when the track changes or restarts.
+func (t *playTracker) observe(path, name string, pos, dur float64, now time.Time) (playEntry, bool) {
+	if path != t.cur.Path || (pos < t.last-2 && pos < 2) {
+		prev, ok := t.finish()
+		*t = playTracker{cur: playEntry{Path: path, Title: name, Start: now, Duration: dur}, last: pos}
+		return prev, ok
+	}
+	if d := pos - t.last; d > 0 && d <= 2 {
+		t.cur.Listened += d
+	}
+	t.last = pos
+	if dur > 0 {
+		t.cur.Duration = dur
+	}
+	return playEntry{}, false
+}
+
+func (t *playTracker) finish() (playEntry, bool) {
+	e := t.cur
+	if e.Path == "" || e.Listened < 1 {
+		return playEntry{}, false
+	}
+	e.Outcome = outcomeCompleted
+	if e.Duration > 0 && e.Listened < e.Duration*0.9 && t.last < e.Duration-3 {
+		e.Outcome = outcomeSkipped
+	}
+	return e, true
+}
+
+func appendPlayLog(file string, e playEntry) {
+	if !isURL(e.Path) {
+		md := libindex.ReadMeta(e.Path)
+		e.Artist, e.Album = md.Artist, md.Album
+		if md.Title != "" {
+			e.Title = md.Title
+		}
+	}
+	e.Listened = float64(int(e.Listened*10)) / 10
+	d, _ := json.Marshal(e)
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	_, _ = f.Write(append(d, '\n'))
+	f.Close()
+}
+
+func readPlayLog(file string) []playEntry {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var out []playEntry
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	for sc.Scan() {
+		var e playEntry
+		if json.Unmarshal(sc.Bytes(), &e) == nil && e.Path != "" {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func (m *model) currentName() string {
+	if m.state.CurrentIndex < 0 || m.state.CurrentIndex >= len(m.state.Playlist) {
+		return ""
+	}
+	raw := m.state.Playlist[m.state.CurrentIndex]
+	if strings.Contains(raw, m3uSeparator) {
+		return strings.SplitN(raw, m3uSeparator, 2)[0]
+	}
+	return strings.TrimSuffix(filepath.Base(raw), filepath.Ext(raw))
+}
+
+func (m *model) trackPlay() {
+	if e, ok := m.plays.observe(m.currentPath(), m.currentName(), m.curPos, m.curDur, m.now()); ok {
+		appendPlayLog(playLogFile, e)
+	}
+	if m.scrobbles != nil {
+		if l, ok := m.plays.scrobbleDue(); ok {
+			m.scrobbles.add(l)
+		}
+	}
+}
+
+// endPlay logs the session in progress; called when the player shuts down.
+func (m *model) endPlay() {
+	if e, ok := m.plays.finish(); ok {
+		appendPlayLog(playLogFile, e)
+	}
+	m.plays = playTracker{}
+}
+
+type statRow struct {
+	Name    string
+	Path    string
+	Plays   int
+	Seconds float64
+}
+
+type playStats struct {
+	Plays   int
+	Seconds float64
+	Skipped int
+	Rated   int
+	Artists []statRow
+	Tracks  []statRow
+}
+
+var statPeriods = []struct {
+	name string
+	days int
+}{{"week", 7}, {"month", 30}, {"all time", 0}}
+
+func computeStats(entries []playEntry, since time.Time, top int) playStats {
+	var st playStats
+	artists, tracks := map[string]*statRow{}, map[string]*statRow{}
+	for _, e := range entries {
+		if e.Start.Before(since) {
+			continue
+		}
+		st.Seconds += e.Listened
+		if e.Duration > 0 {
+			st.Rated++
+			if e.Outcome == outcomeSkipped {
+				st.Skipped++
+			}
+		}
+		if e.Outcome == outcomeSkipped && e.Listened < 30 {
+			continue
+		}
+		st.Plays++
+		name := e.Title
+		if e.Artist != "" {
+			name = e.Artist + " - " + e.Title
+			a := artists[e.Artist]
+			if a == nil {
+				a = &statRow{Name: e.Artist}
+				artists[e.Artist] = a
+			}
+			a.Plays++
+			a.Seconds += e.Listened
+		}
+		t := tracks[e.Path]
+		if t == nil {
+			t = &statRow{Name: name, Path: e.Path}
+			tracks[e.Path] = t
+		}
+		t.Plays++
+		t.Seconds += e.Listened
+	}
+	st.Artists, st.Tracks = topRows(artists, top), topRows(tracks, top)
+	return st
+}
+
+func topRows(rows map[string]*statRow, n int) []statRow {
+	out := make([]statRow, 0, len(rows))
+	for _, r := range rows {
+		out = append(out, *r)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Plays != out[j].Plays {
+			return out[i].Plays > out[j].Plays
+		}
+		if out[i].Seconds != out[j].Seconds {
+			return out[i].Seconds > out[j].Seconds
+		}
+		return out[i].Name < out[j].Name
+	})
+	if len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+func (st playStats) skipRate() float64 {
+	if st.Rated == 0 {
+		return 0
+	}
+	return float64(st.Skipped) / float64(st.Rated)
+}
+
+func (m *model) stats() playStats {
+	m.loadPlayLog()
+	var since time.Time
+	if d := statPeriods[m.statsPeriod].days; d > 0 {
+		since = m.now().AddDate(0, 0, -d)
+	}
+	return computeStats(m.playLog, since, 15)
+}
+
+func (m *model) statsItems() []displayItem {
+	st := m.stats()
+	m.statsSummary = fmt.Sprintf("%s · %d plays · %.1f h · skip %.0f%%", statPeriods[m.statsPeriod].name, st.Plays, st.Seconds/3600, st.skipRate()*100)
+	items := []displayItem{{"", "TOP ARTISTS", true}}
+	for _, r := range st.Artists {
+		items = append(items, displayItem{"", fmt.Sprintf("%4d  %s", r.Plays, r.Name), false})
+	}
+	items = append(items, displayItem{"", "", false}, displayItem{"", "TOP TRACKS", true})
+	for _, r := range st.Tracks {
+		items = append(items, displayItem{r.Path, fmt.Sprintf("%4d  %s", r.Plays, r.Name), false})
+	}
+	return items
+}
+
+// statsAction queues a top track; anywhere else it steps through the periods.
+func (m *model) statsAction(it displayItem) {
+	if it.path != "" {
+		m.state.Playlist = append(m.state.Playlist, it.path)
+		m.refresh()
+		m.save()
+		return
+	}
+	m.statsPeriod = (m.statsPeriod + 1) % len(statPeriods)
+	m.refresh()
+}
+
+func exportPlayLog(w io.Writer, format string, entries []playEntry) error {
+	switch format {
+	case "json":
+		if entries == nil {
+			entries = []playEntry{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	case "csv":
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"start", "artist", "title", "album", "path", "listened", "duration", "outcome"})
+		for _, e := range entries {
+			_ = cw.Write([]string{e.Start.Format(time.RFC3339), e.Artist, e.Title, e.Album, e.Path,
+				strconv.FormatFloat(e.Listened, 'f', 1, 64), strconv.FormatFloat(e.Duration, 'f', 1, 64), e.Outcome})
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return fmt.Errorf("unknown export format %q (csv or json)", format)
+}
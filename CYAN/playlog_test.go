@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var playStart = time.Date(2026, 10, 19, 20, 0, 0, 0, time.UTC)
+
+// playTo feeds the tracker positions from..to every half second.
+func playTo(t *playTracker, path string, from, to, dur float64) {
+	for pos := from; pos <= to; pos += 0.5 {
+		if e, ok := t.observe(path, "x", pos, dur, playStart); ok {
+			panic("session ended inside playTo: " + e.Path)
+		}
+	}
+}
+
+func TestPlayTracker(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		play     func(t *playTracker)
+		listened float64
+		outcome  string
+	}{
+		{"played through", func(t *playTracker) { playTo(t, "/m/a.mp3", 0, 200, 200) }, 200, outcomeCompleted},
+		{"skipped early", func(t *playTracker) { playTo(t, "/m/a.mp3", 0, 40, 200) }, 40, outcomeSkipped},
+		{"seek forward is not listening", func(t *playTracker) {
+			playTo(t, "/m/a.mp3", 0, 10, 200)
+			playTo(t, "/m/a.mp3", 100, 110, 200)
+		}, 20, outcomeSkipped},
+		{"seek back is not listening", func(t *playTracker) {
+			playTo(t, "/m/a.mp3", 0, 50, 200)
+			playTo(t, "/m/a.mp3", 20, 30, 200)
+		}, 60, outcomeSkipped},
+		// jumping to the end still ends the track where it should end
+		{"seek to the end", func(t *playTracker) {
+			playTo(t, "/m/a.mp3", 0, 10, 200)
+			playTo(t, "/m/a.mp3", 198, 200, 200)
+		}, 12, outcomeCompleted},
+		{"ninety percent is enough", func(t *playTracker) { playTo(t, "/m/a.mp3", 0, 180, 200) }, 180, outcomeCompleted},
+		{"a stream has no length", func(t *playTracker) { playTo(t, "http://radio.example/jazz", 0, 30, 0) }, 30, outcomeCompleted},
+	} {
+		var tr playTracker
+		c.play(&tr)
+		e, ok := tr.observe("/m/b.mp3", "b", 0, 100, playStart)
+		if !ok || e.Listened != c.listened || e.Outcome != c.outcome {
+			t.Errorf("%s: %v %.1f %s, want %.1f %s", c.name, ok, e.Listened, e.Outcome, c.listened, c.outcome)
+		}
+	}
+
+	// under a second of listening is no play
+	var tr playTracker
+	playTo(&tr, "/m/a.mp3", 0, 0.5, 200)
+	if e, ok := tr.finish(); ok {
+		t.Errorf("half a second logged: %+v", e)
+	}
+
+	// going back to the start plays the track again
+	tr = playTracker{}
+	playTo(&tr, "/m/a.mp3", 0, 50, 200)
+	e, ok := tr.observe("/m/a.mp3", "a", 0.5, 200, playStart)
+	if !ok || e.Listened != 50 || e.Outcome != outcomeSkipped {
+		t.Errorf("restart ended %v %+v", ok, e)
+	}
+	if tr.cur.Path != "/m/a.mp3" || tr.cur.Listened != 0 {
+		t.Errorf("restart did not start a new session: %+v", tr.cur)
+	}
+}
+
+func TestComputeStats(t *testing.T) {
+	day := func(n int) time.Time { return playStart.AddDate(0, 0, -n) }
+	entries := []playEntry{
+		{Path: "/m/a.mp3", Artist: "Кино", Title: "Кукушка", Start: day(1), Listened: 400, Duration: 400, Outcome: outcomeCompleted},
+		{Path: "/m/a.mp3", Artist: "Кино", Title: "Кукушка", Start: day(3), Listened: 20, Duration: 400, Outcome: outcomeSkipped},
+		{Path: "/m/b.mp3", Artist: "Аквариум", Title: "Город золотой", Start: day(5), Listened: 100, Duration: 190, Outcome: outcomeSkipped},
+		{Path: "http://radio.example/jazz", Title: "Jazz", Start: day(20), Listened: 3600, Outcome: outcomeCompleted},
+		{Path: "/m/c.mp3", Artist: "Кино", Title: "Группа крови", Start: day(200), Listened: 280, Duration: 280, Outcome: outcomeCompleted},
+	}
+	for _, c := range []struct {
+		period  string
+		since   time.Time
+		plays   int
+		seconds float64
+		skip    float64
+		artists []statRow
+		tracks  []string
+	}{
+		// a skip under half a minute counts as time but not as a play
+		{"week", day(7), 2, 520, 2.0 / 3, []statRow{{Name: "Кино", Plays: 1, Seconds: 400}, {Name: "Аквариум", Plays: 1, Seconds: 100}},
+			[]string{"Кино - Кукушка", "Аквариум - Город золотой"}},
+		{"month", day(30), 3, 4120, 2.0 / 3, []statRow{{Name: "Кино", Plays: 1, Seconds: 400}, {Name: "Аквариум", Plays: 1, Seconds: 100}},
+			[]string{"Jazz", "Кино - Кукушка"}},
+		{"all time", time.Time{}, 4, 4400, 2.0 / 4, []statRow{{Name: "Кино", Plays: 2, Seconds: 680}, {Name: "Аквариум", Plays: 1, Seconds: 100}},
+			[]string{"Jazz", "Кино - Кукушка"}},
+	} {
+		st := computeStats(entries, c.since, 2)
+		if st.Plays != c.plays || st.Seconds != c.seconds || st.skipRate() != c.skip {
+			t.Errorf("%s: %d plays %.0f s skip %.2f, want %d %.0f %.2f", c.period, st.Plays, st.Seconds, st.skipRate(), c.plays, c.seconds, c.skip)
+		}
+		// artists come by plays, then by time listened
+		if !reflect.DeepEqual(st.Artists, c.artists) {
+			t.Errorf("%s: artists %+v, want %+v", c.period, st.Artists, c.artists)
+		}
+		var tracks []string
+		for _, r := range st.Tracks {
+			tracks = append(tracks, r.Name)
+		}
+		if !reflect.DeepEqual(tracks, c.tracks) {
+			t.Errorf("%s: tracks %q, want %q", c.period, tracks, c.tracks)
+		}
+	}
+	if r := computeStats(nil, time.Time{}, 5).skipRate(); r != 0 {
+		t.Errorf("skip rate of nothing %v", r)
+	}
+}
+
+func TestExportPlayLog(t *testing.T) {
+	entries := []playEntry{
+		{Path: "/m/a, b.mp3", Artist: `Группа "Кино"`, Title: "Кукушка,\nlive", Start: playStart, Listened: 12.34, Duration: 400, Outcome: outcomeSkipped},
+		{Path: "http://radio.example/jazz", Title: "Jazz", Start: playStart.Add(time.Hour), Listened: 3600, Outcome: outcomeCompleted},
+	}
+
+	var b bytes.Buffer
+	if err := exportPlayLog(&b, "csv", entries); err != nil {
+		t.Fatal(err)
+	}
+	want := "start,artist,title,album,path,listened,duration,outcome\n" +
+		"2026-10-19T20:00:00Z,\"Группа \"\"Кино\"\"\",\"Кукушка,\nlive\",,\"/m/a, b.mp3\",12.3,400.0,skipped\n" +
+		"2026-10-19T21:00:00Z,,Jazz,,http://radio.example/jazz,3600.0,0.0,completed\n"
+	if b.String() != want {
+		t.Errorf("csv\n%s\nwant\n%s", b.String(), want)
+	}
+
+	b.Reset()
+	if err := exportPlayLog(&b, "json", entries); err != nil {
+		t.Fatal(err)
+	}
+	var back []playEntry
+	if err := json.Unmarshal(b.Bytes(), &back); err != nil || !reflect.DeepEqual(back, entries) {
+		t.Errorf("json %s read back as %+v, %v", b.String(), back, err)
+	}
+	if !strings.Contains(b.String(), "\n  {") {
+		t.Errorf("json not indented: %s", b.String())
+	}
+
+	b.Reset()
+	if err := exportPlayLog(&b, "json", nil); err != nil || strings.TrimSpace(b.String()) != "[]" {
+		t.Errorf("empty log exported as %q, %v", b.String(), err)
+	}
+	if err := exportPlayLog(&b, "xml", entries); err == nil {
+		t.Error("xml accepted")
+	}
+}
@@ -0,0 +1,605 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/totiks2012/Cyan_audio_player/internal/cmdline"
+)
+
+const (
+	podcastStateFile  = ".cyan_podcasts.json"
+	defaultPodcastDir = "~/Podcasts"
+	resumeMinSeconds  = 5
+)
+
+type Podcast struct {
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+type Episode struct {
+	Title     string
+	GUID      string
+	URL       string
+	Published time.Time
+	Duration  string
+}
+
+type Feed struct {
+	Title    string
+	Episodes []Episode
+}
+
+type episodeState struct {
+	Played bool   `json:"played,omitempty"`
+	File   string `json:"file,omitempty"`
+}
+
+// podcastState is keyed by the enclosure URL of an episode.
+type podcastState map[string]*episodeState
+
+type rssLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssDoc struct {
+	Channel struct {
+		Title string `xml:"title"`
+		Items []struct {
+			Title     string `xml:"title"`
+			GUID      string `xml:"guid"`
+			PubDate   string `xml:"pubDate"`
+			Duration  string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd duration"`
+			Enclosure struct {
+				URL  string `xml:"url,attr"`
+				Type string `xml:"type,attr"`
+			} `xml:"enclosure"`
+		} `xml:"item"`
+	} `xml:"channel"`
+}
+
+type atomDoc struct {
+	Title   string `xml:"title"`
+	Entries []struct {
+		Title     string    `xml:"title"`
+		ID        string    `xml:"id"`
+		Published string    `xml:"published"`
+		Updated   string    `xml:"updated"`
+		Links     []rssLink `xml:"link"`
+	} `xml:"entry"`
+}
+
+var feedDateLayouts = []string{
+	time.RFC1123Z, time.RFC1123, time.RFC822Z, time.RFC822, time.RFC3339,
+	"Mon, 2 Jan 2006 15:04:05 -0700", "Mon, 2 Jan 2006 15:04:05 MST", "2 Jan 2006 15:04:05 -0700", "2006-01-02",
+}
+
+func parseFeedDate(s string) time.Time {
+	s = strings.TrimSpace(s)
+	for _, l := range feedDateLayouts {
+		if t, err := time.Parse(l, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func parseFeed(data []byte) (*Feed, error) {
+	var root xml.Name
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("not a feed: %v", err)
+		}
+		if se, ok := tok.(xml.StartElement); ok {
+			root = se.Name
+			break
+		}
+	}
+
+	feed := &Feed{}
+	switch root.Local {
+	case "rss":
+		var doc rssDoc
+		if err := xml.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+		feed.Title = strings.TrimSpace(doc.Channel.Title)
+		for _, it := range doc.Channel.Items {
+			if it.Enclosure.URL == "" {
+				continue
+			}
+			ep := Episode{
+				Title:     strings.TrimSpace(it.Title),
+				GUID:      strings.TrimSpace(it.GUID),
+				URL:       strings.TrimSpace(it.Enclosure.URL),
+				Published: parseFeedDate(it.PubDate),
+				Duration:  strings.TrimSpace(it.Duration),
+			}
+			feed.Episodes = append(feed.Episodes, ep)
+		}
+	case "feed":
+		var doc atomDoc
+		if err := xml.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+		feed.Title = strings.TrimSpace(doc.Title)
+		for _, e := range doc.Entries {
+			ep := Episode{Title: strings.TrimSpace(e.Title), GUID: strings.TrimSpace(e.ID)}
+			for _, l := range e.Links {
+				if l.Rel == "enclosure" {
+					ep.URL = strings.TrimSpace(l.Href)
+					break
+				}
+			}
+			if ep.URL == "" {
+				continue
+			}
+			ep.Published = parseFeedDate(e.Published)
+			if ep.Published.IsZero() {
+				ep.Published = parseFeedDate(e.Updated)
+			}
+			feed.Episodes = append(feed.Episodes, ep)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported feed format <%s>", root.Local)
+	}
+	for i := range feed.Episodes {
+		if feed.Episodes[i].GUID == "" {
+			feed.Episodes[i].GUID = feed.Episodes[i].URL
+		}
+		if feed.Episodes[i].Title == "" {
+			feed.Episodes[i].Title = path.Base(feed.Episodes[i].URL)
+		}
+	}
+	sort.SliceStable(feed.Episodes, func(i, j int) bool { return feed.Episodes[i].Published.After(feed.Episodes[j].Published) })
+	return feed, nil
+}
+
+var feedClient = &http.Client{Timeout: 30 * time.Second}
+
+func openFeed(src string) (io.ReadCloser, error) {
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		resp, err := feedClient.Get(src)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%s: %s", src, resp.Status)
+		}
+		return resp.Body, nil
+	}
+	return os.Open(cmdline.ExpandHome(strings.TrimPrefix(src, "file://")))
+}
+
+func fetchFeed(src string) (*Feed, error) {
+	r, err := openFeed(src)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(io.LimitReader(r, 32<<20))
+	if err != nil {
+		return nil, err
+	}
+	return parseFeed(data)
+}
+
+func safeFileName(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < 32 {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(s))
+	if r := []rune(s); len(r) > 120 {
+		s = string(r[:120])
+	}
+	if s == "" || s == "." || s == ".." {
+		s = "episode"
+	}
+	return s
+}
+
+// downloadClient gives up on a server that doesn't answer, but leaves a
+// long episode on a slow line the time it needs.
+var downloadClient = &http.Client{
+	Timeout: time.Hour,
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   30 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+	},
+}
+
+// episodeFileName is the episode's title with its date in front and a bit
+// of its GUID behind, so episodes that share a title don't overwrite each
+// other.
+func episodeFileName(ep Episode) string {
+	name := safeFileName(ep.Title)
+	if !ep.Published.IsZero() {
+		name = ep.Published.Format("2006-01-02") + " " + name
+	}
+	sum := sha1.Sum([]byte(ep.GUID))
+	return name + " " + hex.EncodeToString(sum[:4])
+}
+
+// downloadEpisode stores the enclosure under dir, writing to a .part file
+// first so an interrupted download never looks complete.
+func downloadEpisode(ep Episode, dir string) (string, error) {
+	ext := path.Ext(strings.SplitN(ep.URL, "?", 2)[0])
+	if ext == "" || len(ext) > 5 {
+		ext = ".mp3"
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	dst := filepath.Join(dir, episodeFileName(ep)+ext)
+	resp, err := downloadClient.Get(ep.URL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", ep.URL, resp.Status)
+	}
+	tmp := dst + ".part"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return dst, os.Rename(tmp, dst)
+}
+
+func loadPodcastState() podcastState {
+	st := podcastState{}
+	if d, err := os.ReadFile(podcastStateFile); err == nil {
+		_ = json.Unmarshal(d, &st)
+	}
+	return st
+}
+
+func (st podcastState) save() {
+	d, _ := json.Marshal(st)
+	_ = os.WriteFile(podcastStateFile, d, 0644)
+}
+
+func (st podcastState) get(url string) *episodeState {
+	if st[url] == nil {
+		st[url] = &episodeState{}
+	}
+	return st[url]
+}
+
+// keyFor maps a playlist path (enclosure URL or downloaded file) back to the
+// episode it belongs to.
+func (st podcastState) keyFor(p string) (string, bool) {
+	if _, ok := st[p]; ok {
+		return p, true
+	}
+	for url, e := range st {
+		if e.File != "" && e.File == p {
+			return url, true
+		}
+	}
+	return "", false
+}
+
+type feedMsg struct {
+	url  string
+	feed *Feed
+	err  error
+}
+
+type downloadMsg struct {
+	url, file string
+	err       error
+}
+
+func fetchFeedCmd(url string) tea.Cmd {
+	return func() tea.Msg {
+		f, err := fetchFeed(url)
+		return feedMsg{url, f, err}
+	}
+}
+
+func (m *model) podcastDir() string {
+	if m.config.PodcastDir != "" {
+		return cmdline.ExpandHome(m.config.PodcastDir)
+	}
+	return cmdline.ExpandHome(defaultPodcastDir)
+}
+
+func (m *model) currentPodcast() (Podcast, bool) {
+	if m.podcastSel < 0 || m.podcastSel >= len(m.config.Podcasts) {
+		return Podcast{}, false
+	}
+	return m.config.Podcasts[m.podcastSel], true
+}
+
+func (m *model) podcastItems() []displayItem {
+	items := []displayItem{{"", "..", true}}
+	pc, ok := m.currentPodcast()
+	if !ok {
+		for _, p := range m.config.Podcasts {
+			name := p.Title
+			if name == "" {
+				name = p.URL
+			}
+			items = append(items, displayItem{p.URL, name, true})
+		}
+		return items
+	}
+	feed := m.feeds[pc.URL]
+	if feed == nil {
+		return items
+	}
+	for _, ep := range feed.Episodes {
+		mark := "● "
+		st := m.podState[ep.URL]
+		if st != nil && st.Played {
+			mark = "○ "
+		}
+		name := mark + ep.Title
+		if !ep.Published.IsZero() {
+			name += "  " + ep.Published.Format("02.01.06")
+		}
+		if st != nil && st.File != "" {
+			name += " ⬇"
+		}
+		items = append(items, displayItem{ep.URL, name, false})
+	}
+	return items
+}
+
+func (m *model) episodeAt(url string) (Episode, bool) {
+	pc, ok := m.currentPodcast()
+	if !ok || m.feeds[pc.URL] == nil {
+		return Episode{}, false
+	}
+	for _, ep := range m.feeds[pc.URL].Episodes {
+		if ep.URL == url {
+			return ep, true
+		}
+	}
+	return Episode{}, false
+}
+
+func (m *model) episodeEntry(ep Episode) string {
+	src := ep.URL
+	if st := m.podState[ep.URL]; st != nil && st.File != "" {
+		if _, err := os.Stat(st.File); err == nil {
+			src = st.File
+		}
+	}
+	m.podState.get(ep.URL) // registers the entry so resume can find it
+	return ep.Title + m3uSeparator + src
+}
+
+func (m *model) podcastUp() {
+	if m.podcastSel >= 0 {
+		m.podcastSel = -1
+	} else {
+		m.leftView = viewFiles
+	}
+	m.fmCur, m.fmOff = 0, 0
+	m.refresh()
+}
+
+func (m *model) podcastAction(it displayItem) tea.Cmd {
+	switch {
+	case it.name == "..":
+		m.podcastUp()
+	case it.isDir:
+		for i, p := range m.config.Podcasts {
+			if p.URL == it.path {
+				m.podcastSel = i
+			}
+		}
+		m.fmCur, m.fmOff = 0, 0
+		m.refresh()
+		if m.feeds[it.path] == nil {
+			m.podMsg = "loading " + it.name + "..."
+			return fetchFeedCmd(it.path)
+		}
+	default:
+		ep, ok := m.episodeAt(it.path)
+		if !ok {
+			return nil
+		}
+		entry := m.episodeEntry(ep)
+		idx := -1
+		for i, raw := range m.state.Playlist {
+			if raw == entry {
+				idx = i
+			}
+		}
+		if idx < 0 {
+			m.state.Playlist = append(m.state.Playlist, entry)
+			idx = len(m.state.Playlist) - 1
+		}
+		m.podState.save()
+		m.state.CurrentIndex = idx
+		m.plCur = idx
+		m.playTrack(idx)
+		m.refresh()
+		m.save()
+	}
+	return nil
+}
+
+func (m *model) enqueueEpisode(it displayItem) {
+	if ep, ok := m.episodeAt(it.path); ok {
+		m.state.Playlist = append(m.state.Playlist, m.episodeEntry(ep))
+		m.podState.save()
+		m.refresh()
+		m.save()
+	}
+}
+
+func (m *model) downloadSelected() tea.Cmd {
+	if m.leftView != viewPodcasts || m.fmCur >= len(m.fmItems) {
+		return nil
+	}
+	ep, ok := m.episodeAt(m.fmItems[m.fmCur].path)
+	if !ok {
+		return nil
+	}
+	pc, _ := m.currentPodcast()
+	dir := filepath.Join(m.podcastDir(), safeFileName(pc.Title))
+	m.podMsg = "downloading " + ep.Title + "..."
+	return func() tea.Msg {
+		file, err := downloadEpisode(ep, dir)
+		return downloadMsg{ep.URL, file, err}
+	}
+}
+
+func (m *model) togglePlayed() {
+	if m.leftView != viewPodcasts || m.fmCur >= len(m.fmItems) {
+		return
+	}
+	if ep, ok := m.episodeAt(m.fmItems[m.fmCur].path); ok {
+		st := m.podState.get(ep.URL)
+		st.Played = !st.Played
+		if st.Played {
+			m.positions.forget(ep.URL)
+		}
+		m.podState.save()
+		m.refresh()
+	}
+}
+
+func (m *model) unsubscribe() {
+	if m.leftView != viewPodcasts || m.podcastSel >= 0 || m.fmCur >= len(m.fmItems) {
+		return
+	}
+	it := m.fmItems[m.fmCur]
+	for i, p := range m.config.Podcasts {
+		if p.URL == it.path {
+			m.config.Podcasts = append(m.config.Podcasts[:i], m.config.Podcasts[i+1:]...)
+			delete(m.feeds, p.URL)
+			m.saveConfig()
+			m.refresh()
+			return
+		}
+	}
+}
+
+func (m *model) refreshFeed() tea.Cmd {
+	if pc, ok := m.currentPodcast(); ok {
+		m.podMsg = "updating " + pc.Title + "..."
+		return fetchFeedCmd(pc.URL)
+	}
+	return nil
+}
+
+func (m *model) onFeed(msg feedMsg) {
+	if msg.err != nil {
+		m.podMsg = "feed error: " + msg.err.Error()
+		return
+	}
+	m.podMsg = ""
+	m.feeds[msg.url] = msg.feed
+	for i, p := range m.config.Podcasts {
+		if p.URL == msg.url && p.Title == "" && msg.feed.Title != "" {
+			m.config.Podcasts[i].Title = msg.feed.Title
+			m.saveConfig()
+		}
+	}
+	if m.leftView == viewPodcasts {
+		m.refresh()
+	}
+}
+
+func (m *model) onDownload(msg downloadMsg) {
+	if msg.err != nil {
+		m.podMsg = "download error: " + msg.err.Error()
+		return
+	}
+	m.podMsg = "saved " + filepath.Base(msg.file)
+	m.podState.get(msg.url).File = msg.file
+	m.podState.save()
+	if m.leftView == viewPodcasts {
+		m.refresh()
+	}
+}
+
+func (m *model) currentEpisode() (string, bool) {
+	p := m.currentPath()
+	if p == "" {
+		return "", false
+	}
+	return m.podState.keyFor(p)
+}
+
+func (m *model) resumeEpisode() {
+	if key, ok := m.currentEpisode(); ok {
+		if pos := m.positions.get(key); pos > resumeMinSeconds {
+			m.player.Command("seek", fmt.Sprintf("%.2f", pos), "absolute")
+		}
+	}
+}
+
+func (m *model) trackEpisode() {
+	key, ok := m.currentEpisode()
+	if !ok || m.curPos <= resumeMinSeconds {
+		return
+	}
+	m.positions.set(key, m.curPos)
+	if m.now().Sub(m.posSaved) > 10*time.Second {
+		m.positions.save()
+		m.posSaved = m.now()
+	}
+}
+
+func (m *model) finishEpisode() {
+	if key, ok := m.currentEpisode(); ok {
+		m.podState.get(key).Played = true
+		m.podState.save()
+		m.positions.forget(key)
+		m.positions.save()
+	}
+}
+
+func subscribe(cfg *Config, src string) error {
+	feed, err := fetchFeed(src)
+	if err != nil {
+		return err
+	}
+	for _, p := range cfg.Podcasts {
+		if p.URL == src {
+			return fmt.Errorf("already subscribed to %s", src)
+		}
+	}
+	cfg.Podcasts = append(cfg.Podcasts, Podcast{Title: feed.Title, URL: src})
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type feedWant struct {
+	title    string
+	episodes []Episode
+}
+
+var feedFixtures = map[string]feedWant{
+	"feed.rss": {"Радио Т", []Episode{
+		{Title: "Выпуск 2", GUID: "rt-2", URL: "https://cdn.example/rt/2.mp3?src=rss", Published: time.Date(2026, 10, 10, 15, 0, 0, 0, time.UTC)},
+		{Title: "bonus.ogg", GUID: "https://cdn.example/rt/bonus.ogg", URL: "https://cdn.example/rt/bonus.ogg", Published: time.Date(2026, 10, 5, 9, 0, 0, 0, time.UTC)},
+		{Title: "Выпуск 1", GUID: "rt-1", URL: "https://cdn.example/rt/1.mp3", Published: time.Date(2026, 10, 3, 15, 0, 0, 0, time.UTC), Duration: "01:02:03"},
+	}},
+	"feed.atom": {"Atom Cast", []Episode{
+		{Title: "New one", GUID: "urn:uuid:new", URL: "https://atom.example/new.mp3", Published: time.Date(2026, 10, 1, 10, 0, 0, 0, time.UTC)},
+		{Title: "Old one", GUID: "urn:uuid:old", URL: "https://atom.example/old.m4a", Published: time.Date(2026, 9, 1, 10, 0, 0, 0, time.UTC)},
+	}},
+}
+
+func checkFeed(t *testing.T, src string, feed *Feed, err error, want feedWant) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %v", src, err)
+	}
+	if feed.Title != want.title {
+		t.Errorf("%s: title %q, want %q", src, feed.Title, want.title)
+	}
+	if len(feed.Episodes) != len(want.episodes) {
+		t.Fatalf("%s: %d episodes, want %d: %+v", src, len(feed.Episodes), len(want.episodes), feed.Episodes)
+	}
+	for i, ep := range feed.Episodes {
+		w := want.episodes[i]
+		if !ep.Published.Equal(w.Published) {
+			t.Errorf("%s: episode %d published %s, want %s", src, i, ep.Published, w.Published)
+		}
+		ep.Published, w.Published = time.Time{}, time.Time{}
+		if !reflect.DeepEqual(ep, w) {
+			t.Errorf("%s: episode %d = %+v, want %+v", src, i, ep, w)
+		}
+	}
+}
+
+func TestParseFeedFromFile(t *testing.T) {
+	for name, want := range feedFixtures {
+		src := filepath.Join("testdata", name)
+		feed, err := fetchFeed(src)
+		checkFeed(t, src, feed, err, want)
+	}
+}
+
+func TestParseFeedFromServer(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+	for name, want := range feedFixtures {
+		src := srv.URL + "/" + name
+		feed, err := fetchFeed(src)
+		checkFeed(t, src, feed, err, want)
+	}
+	if _, err := fetchFeed(srv.URL + "/missing.rss"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("missing feed: %v", err)
+	}
+}
+
+func TestParseFeedRejects(t *testing.T) {
+	for _, data := range []string{"", "not xml", `<html><body>hi</body></html>`} {
+		if _, err := parseFeed([]byte(data)); err == nil {
+			t.Errorf("parseFeed(%q) took it", data)
+		}
+	}
+}
+
+func TestDownloadEpisodeNames(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("audio of " + r.URL.Path))
+	}))
+	defer srv.Close()
+	dir := t.TempDir()
+	day := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	eps := []Episode{
+		{Title: "Новости: утро", GUID: "a", URL: srv.URL + "/a.mp3", Published: day},
+		{Title: "Новости: утро", GUID: "b", URL: srv.URL + "/b.mp3", Published: day},
+		{Title: "Новости: утро", GUID: "c", URL: srv.URL + "/c.mp3?x=1", Published: day.AddDate(0, 0, 1)},
+	}
+	seen := map[string]bool{}
+	for _, ep := range eps {
+		file, err := downloadEpisode(ep, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[file] {
+			t.Fatalf("%s downloaded twice to %s", ep.GUID, file)
+		}
+		seen[file] = true
+		if !strings.HasPrefix(filepath.Base(file), ep.Published.Format("2006-01-02")+" Новости_ утро ") || filepath.Ext(file) != ".mp3" {
+			t.Errorf("file name %s", filepath.Base(file))
+		}
+		if d, _ := os.ReadFile(file); string(d) != "audio of /"+ep.GUID+".mp3" {
+			t.Errorf("%s holds %q", file, d)
+		}
+	}
+	if parts, _ := filepath.Glob(filepath.Join(dir, "*.part")); len(parts) > 0 {
+		t.Errorf("left %v behind", parts)
+	}
+}
+
+func TestDownloadEpisodeTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+	saved := downloadClient
+	defer func() { downloadClient = saved }()
+	downloadClient = &http.Client{Transport: &http.Transport{ResponseHeaderTimeout: 100 * time.Millisecond}}
+
+	dir := t.TempDir()
+	start := time.Now()
+	_, err := downloadEpisode(Episode{Title: "stuck", GUID: "s", URL: srv.URL + "/s.mp3"}, dir)
+	if err == nil {
+		t.Fatal("a server that never answers did not time out")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("timed out after %s", time.Since(start))
+	}
+	if files, _ := os.ReadDir(dir); len(files) > 0 {
+		t.Errorf("left %d files behind", len(files))
+	}
+}
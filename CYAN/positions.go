@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+)
+
+const positionsFile = ".cyan_positions.json"
+
+type positionStore struct {
+	file  string
+	pos   map[string]float64
+	dirty bool
+}
+
+func loadPositions(file string) *positionStore {
+	s := &positionStore{file: file, pos: map[string]float64{}}
+	if d, err := os.ReadFile(file); err == nil {
+		_ = json.Unmarshal(d, &s.pos)
+	}
+	return s
+}
+
+func (s *positionStore) get(key string) float64 {
+	return s.pos[key]
+}
+
+func (s *positionStore) set(key string, pos float64) {
+	if s.pos[key] != pos {
+		s.pos[key] = pos
+		s.dirty = true
+	}
+}
+
+func (s *positionStore) forget(key string) {
+	if _, ok := s.pos[key]; ok {
+		delete(s.pos, key)
+		s.dirty = true
+	}
+}
+
+func (s *positionStore) save() {
+	if !s.dirty {
+		return
+	}
+	d, _ := json.Marshal(s.pos)
+	if os.WriteFile(s.file, d, 0644) == nil {
+		s.dirty = false
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/totiks2012/Cyan_audio_player/internal/libindex"
+	"github.com/totiks2012/Cyan_audio_player/internal/tags"
+)
+
+type tagWriteMsg struct {
+	path string
+	err  error
+}
+
+// ratingTarget is the selected playlist entry when the playlist has focus,
+// otherwise the track that is playing.
+func (m *model) ratingTarget() string {
+	if m.focus == 1 && m.plCur >= 0 && m.plCur < len(m.plItems) {
+		return m.plItems[m.plCur].path
+	}
+	return m.currentPath()
+}
+
+func (m *model) rate(rating int) tea.Cmd {
+	path := m.ratingTarget()
+	if path == "" || isURL(path) {
+		return nil
+	}
+	t := m.library.Update(path, func(t *libindex.Track) { t.Rating = rating })
+	m.saveLibrary()
+	m.setNotice(strings.TrimSpace(stars(rating, false)) + " " + trackLabel(t))
+	if !m.config.WriteRatingTags {
+		return nil
+	}
+	return func() tea.Msg {
+		return tagWriteMsg{path, tags.WriteRating(path, rating)}
+	}
+}
+
+func (m *model) toggleLoved() {
+	path := m.ratingTarget()
+	if path == "" || isURL(path) {
+		return
+	}
+	t := m.library.Update(path, func(t *libindex.Track) { t.Loved = !t.Loved })
+	m.saveLibrary()
+	if t.Loved {
+		m.setNotice("♥ " + trackLabel(t))
+	} else {
+		m.setNotice("♡ " + trackLabel(t))
+	}
+}
+
+func (m *model) onTagWrite(msg tagWriteMsg) {
+	if msg.err != nil {
+		m.setNotice("tags not written: " + msg.err.Error())
+		return
+	}
+	// the rewrite changed size and mtime; keep the index from re-reading it
+	if fi, err := os.Stat(msg.path); err == nil {
+		if t, ok := m.library.Tracks[msg.path]; ok {
+			t.Size, t.ModTime = fi.Size(), fi.ModTime().Unix()
+			m.library.Tracks[msg.path] = t
+			m.saveLibrary()
+		}
+	}
+}
+
+func trackLabel(t libindex.Track) string {
+	if t.Title == "" {
+		return filepath.Base(t.Path)
+	}
+	return t.Title
+}
+
+func (m *model) setNotice(s string) {
+	m.notice, m.noticeAt = s, m.now()
+}
+
+func (m *model) currentNotice() string {
+	if m.notice == "" || m.now().Sub(m.noticeAt) > 5*time.Second {
+		return ""
+	}
+	return m.notice
+}
+
+func stars(rating int, loved bool) string {
+	s := "     "
+	if rating > 0 {
+		s = strings.Repeat(glyphs.Star, rating) + strings.Repeat(glyphs.StarEmpty, 5-rating)
+	}
+	if loved {
+		return s + " " + glyphs.Loved
+	}
+	return s + strings.Repeat(" ", 1+utf8.RuneCountInString(glyphs.Loved))
+}
+
+// ratingColumn is the rating as fixed-width stars, blank when unrated.
+func (m *model) ratingColumn(path string) string {
+	t, ok := m.library.Tracks[path]
+	if !ok || (t.Rating == 0 && !t.Loved) {
+		return strings.Repeat(" ", 7)
+	}
+	return stars(t.Rating, t.Loved)
+}
+
+// ratingFilter takes the rating terms out of a search query: "r>=4",
+// "r=5", "r<3" and "loved". It returns the remaining text and a predicate
+// for the paths that pass, or nil when the query has no such terms.
+func (m *model) ratingFilter(q string) (string, func(path string) bool) {
+	var rest []string
+	var tests []func(libindex.Track) bool
+	for _, w := range strings.Fields(q) {
+		lw := strings.ToLower(w)
+		if lw == "loved" || lw == "♥" {
+			tests = append(tests, func(t libindex.Track) bool { return t.Loved })
+			continue
+		}
+		if o, n, ok := parseRatingTerm(lw); ok {
+			tests = append(tests, func(t libindex.Track) bool {
+				switch o {
+				case ">=":
+					return t.Rating >= n
+				case "<=":
+					return t.Rating <= n
+				case ">":
+					return t.Rating > n
+				case "<":
+					return t.Rating < n
+				}
+				return t.Rating == n
+			})
+			continue
+		}
+		rest = append(rest, w)
+	}
+	if len(tests) == 0 {
+		return q, nil
+	}
+	return strings.Join(rest, " "), func(path string) bool {
+		t := m.library.Tracks[path]
+		for _, test := range tests {
+			if !test(t) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func parseRatingTerm(w string) (string, int, bool) {
+	if !strings.HasPrefix(w, "r") {
+		return "", 0, false
+	}
+	for _, o := range []string{">=", "<=", "=", ">", "<"} {
+		if strings.HasPrefix(w[1:], o) {
+			n, err := strconv.Atoi(w[1+len(o):])
+			return o, n, err == nil
+		}
+	}
+	return "", 0, false
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/totiks2012/Cyan_audio_player/internal/libindex"
+)
+
+func TestRatingSaveDebounced(t *testing.T) {
+	t.Chdir(t.TempDir())
+	old := libraryFile
+	libraryFile = filepath.Join(t.TempDir(), "library.json")
+	t.Cleanup(func() { libraryFile = old })
+
+	clock := &fakeClock{alarmStart}
+	m := &model{library: &libindex.Index{Tracks: map[string]libindex.Track{}}, now: clock.now, leftView: viewFind}
+	m.state.Playlist = []string{"/m/a.mp3"}
+	saved := func() int { return libindex.Load(libraryFile).Tracks["/m/a.mp3"].Rating }
+
+	m.rate(3)
+	clock.advance(time.Second)
+	m.rate(4)
+	m.flushLibrary(false)
+	if got := saved(); got != 0 {
+		t.Fatalf("rating %d written before the delay", got)
+	}
+	clock.advance(librarySaveDelay)
+	m.flushLibrary(false)
+	if got := saved(); got != 4 {
+		t.Fatalf("saved rating %d, want 4", got)
+	}
+
+	// a state save, as on quit, writes what is pending at once
+	m.toggleLoved()
+	m.save()
+	if !libindex.Load(libraryFile).Tracks["/m/a.mp3"].Loved {
+		t.Error("love not written by save")
+	}
+}
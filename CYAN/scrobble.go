@@ -0,0 +1,485 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/totiks2012/Cyan_audio_player/internal/libindex"
+	"golang.org/x/term"
+)
+
+const scrobbleQueueFile = ".cyan_scrobbles.json"
+
+const (
+	scrobbleMinTrack = 30.0
+	scrobbleMaxWait  = 240.0
+	scrobbleRetryMin = 30 * time.Second
+	scrobbleRetryMax = time.Hour
+)
+
+type ScrobbleConfig struct {
+	ListenBrainz *ListenBrainzConfig `json:"listenbrainz,omitempty"`
+	LastFM       *LastFMConfig       `json:"lastfm,omitempty"`
+}
+
+type ListenBrainzConfig struct {
+	Token string `json:"token"`
+	URL   string `json:"url,omitempty"`
+}
+
+type LastFMConfig struct {
+	APIKey     string `json:"api_key"`
+	Secret     string `json:"secret"`
+	SessionKey string `json:"session_key,omitempty"`
+	URL        string `json:"url,omitempty"`
+}
+
+type listen struct {
+	libindex.Meta
+	Duration   int   `json:"duration"`
+	ListenedAt int64 `json:"listened_at"`
+}
+
+func (l listen) key() string {
+	return strconv.FormatInt(l.ListenedAt, 10) + "\x00" + l.Artist + "\x00" + l.Title
+}
+
+// scrobbleDue reports, once per session, that the standard rule (half the
+// track or four minutes of actual listening) has been met.
+func (t *playTracker) scrobbleDue() (listen, bool) {
+	c := t.cur
+	if t.scrobbled || c.Path == "" || isURL(c.Path) || c.Duration < scrobbleMinTrack {
+		return listen{}, false
+	}
+	if c.Listened < c.Duration/2 && c.Listened < scrobbleMaxWait {
+		return listen{}, false
+	}
+	t.scrobbled = true
+	md := libindex.ReadMeta(c.Path)
+	if md.Artist == "" || md.Title == "" {
+		return listen{}, false
+	}
+	return listen{Meta: md, Duration: int(c.Duration), ListenedAt: c.Start.Unix()}, true
+}
+
+type scrobbler interface {
+	name() string
+	batch() int
+	submit(ls []listen) error
+}
+
+// errRejected marks listens the service will never accept; they are dropped
+// instead of retried.
+var errRejected = errors.New("rejected")
+
+type listenBrainz struct {
+	cfg    ListenBrainzConfig
+	client *http.Client
+}
+
+func (s *listenBrainz) name() string { return "listenbrainz" }
+func (s *listenBrainz) batch() int   { return 100 }
+
+func (s *listenBrainz) submit(ls []listen) error {
+	type info struct {
+		DurationMs int `json:"duration_ms,omitempty"`
+	}
+	type meta struct {
+		Artist  string `json:"artist_name"`
+		Track   string `json:"track_name"`
+		Release string `json:"release_name,omitempty"`
+		Info    info   `json:"additional_info"`
+	}
+	type item struct {
+		ListenedAt int64 `json:"listened_at"`
+		Meta       meta  `json:"track_metadata"`
+	}
+	body := struct {
+		Type    string `json:"listen_type"`
+		Payload []item `json:"payload"`
+	}{Type: "import"}
+	if len(ls) == 1 {
+		body.Type = "single"
+	}
+	for _, l := range ls {
+		body.Payload = append(body.Payload, item{l.ListenedAt, meta{l.Artist, l.Title, l.Album, info{l.Duration * 1000}}})
+	}
+	d, _ := json.Marshal(body)
+	base := s.cfg.URL
+	if base == "" {
+		base = "https://api.listenbrainz.org"
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(base, "/")+"/1/submit-listens", bytes.NewReader(d))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token "+s.cfg.Token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return nil
+	case resp.StatusCode == http.StatusBadRequest:
+		return fmt.Errorf("%w: %s", errRejected, strings.TrimSpace(string(msg)))
+	}
+	return fmt.Errorf("listenbrainz: %s", resp.Status)
+}
+
+type lastFM struct {
+	cfg    LastFMConfig
+	client *http.Client
+}
+
+func (s *lastFM) name() string { return "lastfm" }
+func (s *lastFM) batch() int   { return 50 }
+
+// sign adds api_sig: md5 of the sorted key/value pairs followed by the secret.
+func (s *lastFM) sign(v url.Values) {
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		if k != "format" && k != "callback" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k + v.Get(k))
+	}
+	b.WriteString(s.cfg.Secret)
+	sum := md5.Sum([]byte(b.String()))
+	v.Set("api_sig", hex.EncodeToString(sum[:]))
+}
+
+func (s *lastFM) call(v url.Values) (json.RawMessage, error) {
+	v.Set("api_key", s.cfg.APIKey)
+	s.sign(v)
+	v.Set("format", "json")
+	base := s.cfg.URL
+	if base == "" {
+		base = "https://ws.audioscrobbler.com/2.0/"
+	}
+	resp, err := s.client.PostForm(base, v)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	d, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	var e struct {
+		Error   int    `json:"error"`
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(d, &e) == nil && e.Error != 0 {
+		// 6: invalid parameters, 7: invalid resource; the rest (auth,
+		// offline, rate limit) may clear up later.
+		if e.Error == 6 || e.Error == 7 {
+			return nil, fmt.Errorf("%w: %s", errRejected, e.Message)
+		}
+		return nil, fmt.Errorf("lastfm: %s (%d)", e.Message, e.Error)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("lastfm: %s", resp.Status)
+	}
+	return d, nil
+}
+
+func (s *lastFM) submit(ls []listen) error {
+	if s.cfg.SessionKey == "" {
+		return errors.New("lastfm: no session key, run cyan --lastfm-login USER")
+	}
+	v := url.Values{"method": {"track.scrobble"}, "sk": {s.cfg.SessionKey}}
+	for i, l := range ls {
+		n := "[" + strconv.Itoa(i) + "]"
+		v.Set("artist"+n, l.Artist)
+		v.Set("track"+n, l.Title)
+		v.Set("timestamp"+n, strconv.FormatInt(l.ListenedAt, 10))
+		v.Set("duration"+n, strconv.Itoa(l.Duration))
+		if l.Album != "" {
+			v.Set("album"+n, l.Album)
+		}
+	}
+	_, err := s.call(v)
+	return err
+}
+
+func (s *lastFM) login(user, password string) (string, error) {
+	d, err := s.call(url.Values{"method": {"auth.getMobileSession"}, "username": {user}, "password": {password}})
+	if err != nil {
+		return "", err
+	}
+	var r struct {
+		Session struct {
+			Key string `json:"key"`
+		} `json:"session"`
+	}
+	if err := json.Unmarshal(d, &r); err != nil || r.Session.Key == "" {
+		return "", errors.New("lastfm: no session in reply")
+	}
+	return r.Session.Key, nil
+}
+
+func scrobblers(cfg *ScrobbleConfig) []scrobbler {
+	if cfg == nil {
+		return nil
+	}
+	client := &http.Client{Timeout: 20 * time.Second}
+	var out []scrobbler
+	if c := cfg.ListenBrainz; c != nil && c.Token != "" {
+		out = append(out, &listenBrainz{*c, client})
+	}
+	if c := cfg.LastFM; c != nil && c.APIKey != "" {
+		out = append(out, &lastFM{*c, client})
+	}
+	return out
+}
+
+type queuedListen struct {
+	listen
+	Pending []string `json:"pending"`
+}
+
+// scrobbleQueue keeps every listen on disk until each configured service
+// has taken it, and retries failed services with exponential backoff.
+type scrobbleQueue struct {
+	file     string
+	backends []scrobbler
+	mu       sync.Mutex
+	items    []queuedListen
+	retry    map[string]time.Time
+	backoff  map[string]time.Duration
+	lastErr  map[string]string
+	wake     chan struct{}
+	stop     chan struct{}
+}
+
+func newScrobbleQueue(file string, backends []scrobbler) *scrobbleQueue {
+	q := &scrobbleQueue{file: file, backends: backends, retry: map[string]time.Time{}, backoff: map[string]time.Duration{},
+		lastErr: map[string]string{}, wake: make(chan struct{}, 1), stop: make(chan struct{})}
+	if d, err := os.ReadFile(file); err == nil {
+		_ = json.Unmarshal(d, &q.items)
+	}
+	return q
+}
+
+func (q *scrobbleQueue) saveLocked() {
+	d, _ := json.MarshalIndent(q.items, "", "  ")
+	tmp := q.file + ".tmp"
+	if os.WriteFile(tmp, d, 0644) == nil {
+		_ = os.Rename(tmp, q.file)
+	}
+}
+
+func (q *scrobbleQueue) add(l listen) {
+	it := queuedListen{listen: l}
+	for _, b := range q.backends {
+		it.Pending = append(it.Pending, b.name())
+	}
+	if len(it.Pending) == 0 {
+		return
+	}
+	q.mu.Lock()
+	q.items = append(q.items, it)
+	q.saveLocked()
+	q.mu.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *scrobbleQueue) pending() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+func (q *scrobbleQueue) run() {
+	t := time.NewTicker(time.Minute)
+	defer t.Stop()
+	for {
+		q.flush(time.Now())
+		select {
+		case <-q.stop:
+			return
+		case <-q.wake:
+		case <-t.C:
+		}
+	}
+}
+
+func (q *scrobbleQueue) Close() {
+	close(q.stop)
+}
+
+// flush submits what is due for each service, one batch per call per
+// service so a long offline backlog drains without blocking the others.
+func (q *scrobbleQueue) flush(now time.Time) {
+	for _, b := range q.backends {
+		name := b.name()
+		q.mu.Lock()
+		if now.Before(q.retry[name]) {
+			q.mu.Unlock()
+			continue
+		}
+		var batch []listen
+		for _, it := range q.items {
+			if hasString(it.Pending, name) {
+				batch = append(batch, it.listen)
+				if len(batch) == b.batch() {
+					break
+				}
+			}
+		}
+		q.mu.Unlock()
+		if len(batch) == 0 {
+			continue
+		}
+
+		done, err := submitBatch(b, batch)
+
+		q.mu.Lock()
+		if err != nil && !errors.Is(err, errRejected) {
+			d := q.backoff[name] * 2
+			if d < scrobbleRetryMin {
+				d = scrobbleRetryMin
+			} else if d > scrobbleRetryMax {
+				d = scrobbleRetryMax
+			}
+			q.backoff[name], q.retry[name], q.lastErr[name] = d, now.Add(d), err.Error()
+		} else {
+			delete(q.backoff, name)
+			delete(q.retry, name)
+			delete(q.lastErr, name)
+			if err != nil {
+				q.lastErr[name] = err.Error()
+			}
+		}
+		if len(done) > 0 {
+			q.removeLocked(name, done)
+			q.saveLocked()
+		}
+		q.mu.Unlock()
+		if len(done) == b.batch() {
+			select {
+			case q.wake <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// submitBatch sends a batch and returns the listens the service is done
+// with, taken or rejected. One bad listen makes the service turn down the
+// whole request, so a rejected batch is sent again a listen at a time and
+// only the ones rejected on their own are dropped; a failure that may clear
+// up stops there and leaves the rest queued.
+func submitBatch(b scrobbler, batch []listen) ([]listen, error) {
+	err := b.submit(batch)
+	switch {
+	case err == nil:
+		return batch, nil
+	case !errors.Is(err, errRejected):
+		return nil, err
+	case len(batch) == 1:
+		return batch, err
+	}
+	var rejected error
+	for i, l := range batch {
+		err := b.submit([]listen{l})
+		if err != nil && !errors.Is(err, errRejected) {
+			return batch[:i], err
+		}
+		if err != nil {
+			rejected = err
+		}
+	}
+	return batch, rejected
+}
+
+// removeLocked marks listens as taken by the service name and drops those
+// no service is waiting for.
+func (q *scrobbleQueue) removeLocked(name string, done []listen) {
+	sent := map[string]bool{}
+	for _, l := range done {
+		sent[l.key()] = true
+	}
+	kept := q.items[:0]
+	for _, it := range q.items {
+		if sent[it.key()] {
+			it.Pending = removeString(it.Pending, name)
+		}
+		if len(it.Pending) > 0 {
+			kept = append(kept, it)
+		}
+	}
+	q.items = kept
+}
+
+func hasString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func removeString(list []string, s string) []string {
+	out := list[:0]
+	for _, v := range list {
+		if v != s {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// lastfmLogin trades a username and password (read from stdin) for a
+// session key and stores it in config.json.
+func lastfmLogin(cfg *Config, user string) error {
+	if cfg.Scrobble == nil || cfg.Scrobble.LastFM == nil || cfg.Scrobble.LastFM.APIKey == "" {
+		return errors.New(`set "scrobble": {"lastfm": {"api_key": ..., "secret": ...}} in config.json first`)
+	}
+	fmt.Fprintf(os.Stderr, "Last.fm password for %s: ", user)
+	var pw string
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		b, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return err
+		}
+		pw = string(b)
+	} else {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return err
+		}
+		pw = strings.TrimRight(line, "\r\n")
+	}
+	s := &lastFM{*cfg.Scrobble.LastFM, &http.Client{Timeout: 20 * time.Second}}
+	key, err := s.login(user, pw)
+	if err != nil {
+		return err
+	}
+	cfg.Scrobble.LastFM.SessionKey = key
+	return nil
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/totiks2012/Cyan_audio_player/internal/libindex"
+)
+
+// scrobbleMock is a scrobbling service: it turns down a whole request when
+// any track in it is called "BAD" and fails with 503 while down > 0.
+type scrobbleMock struct {
+	mu       sync.Mutex
+	down     int
+	requests int
+	taken    []string
+}
+
+// serve handles one request; tracks are the titles in it.
+func (s *scrobbleMock) serve(tracks []string) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	if s.down > 0 {
+		s.down--
+		return http.StatusServiceUnavailable, false
+	}
+	for _, t := range tracks {
+		if t == "BAD" {
+			return http.StatusBadRequest, false
+		}
+	}
+	s.taken = append(s.taken, tracks...)
+	return http.StatusOK, true
+}
+
+func (s *scrobbleMock) got() (int, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests, append([]string(nil), s.taken...)
+}
+
+func listenBrainzMock(t *testing.T, s *scrobbleMock) *listenBrainz {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/1/submit-listens" || r.Header.Get("Authorization") != "Token tok" {
+			t.Errorf("listenbrainz got %s %s", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		var body struct {
+			Type    string `json:"listen_type"`
+			Payload []struct {
+				Meta struct {
+					Track string `json:"track_name"`
+				} `json:"track_metadata"`
+			} `json:"payload"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		if (body.Type == "single") != (len(body.Payload) == 1) {
+			t.Errorf("listen_type %s for %d listens", body.Type, len(body.Payload))
+		}
+		var tracks []string
+		for _, p := range body.Payload {
+			tracks = append(tracks, p.Meta.Track)
+		}
+		code, _ := s.serve(tracks)
+		w.WriteHeader(code)
+	}))
+	t.Cleanup(srv.Close)
+	return &listenBrainz{ListenBrainzConfig{Token: "tok", URL: srv.URL}, srv.Client()}
+}
+
+func lastFMMock(t *testing.T, s *scrobbleMock) *lastFM {
+	cfg := LastFMConfig{APIKey: "key", Secret: "secret", SessionKey: "sk"}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		v := r.PostForm
+		sig := v.Get("api_sig")
+		v.Del("api_sig")
+		check := &lastFM{cfg: cfg}
+		check.sign(v)
+		if v.Get("api_sig") != sig || v.Get("method") != "track.scrobble" || v.Get("sk") != "sk" {
+			t.Errorf("lastfm got %v", r.PostForm)
+		}
+		var tracks []string
+		for i := 0; v.Get("track["+strconv.Itoa(i)+"]") != ""; i++ {
+			tracks = append(tracks, v.Get("track["+strconv.Itoa(i)+"]"))
+		}
+		switch code, _ := s.serve(tracks); code {
+		case http.StatusOK:
+			_, _ = w.Write([]byte(`{"scrobbles":{"@attr":{"accepted":1}}}`))
+		case http.StatusBadRequest:
+			_, _ = w.Write([]byte(`{"error":6,"message":"Invalid parameters"}`))
+		default:
+			w.WriteHeader(code)
+			_, _ = w.Write([]byte(`{"error":16,"message":"The service is temporarily unavailable"}`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	cfg.URL = srv.URL
+	return &lastFM{cfg, srv.Client()}
+}
+
+func testListens(titles ...string) []listen {
+	var out []listen
+	for i, title := range titles {
+		out = append(out, listen{Meta: libindex.Meta{Artist: "Кино", Title: title}, Duration: 200, ListenedAt: 1760000000 + int64(i)*300})
+	}
+	return out
+}
+
+func TestLastFMSign(t *testing.T) {
+	s := &lastFM{cfg: LastFMConfig{Secret: "sec"}}
+	v := map[string][]string{"method": {"auth.getMobileSession"}, "api_key": {"k"}, "format": {"json"}}
+	s.sign(v)
+	sum := md5.Sum([]byte("api_keykmethodauth.getMobileSessionsec"))
+	if got := v["api_sig"][0]; got != hex.EncodeToString(sum[:]) {
+		t.Errorf("api_sig %s", got)
+	}
+}
+
+func TestScrobbleSubmit(t *testing.T) {
+	var lbs, lfs scrobbleMock
+	file := filepath.Join(t.TempDir(), "scrobbles.json")
+	q := newScrobbleQueue(file, []scrobbler{listenBrainzMock(t, &lbs), lastFMMock(t, &lfs)})
+	for _, l := range testListens("Кукушка", "Группа крови", "Звезда") {
+		q.add(l)
+	}
+	q.flush(time.Now())
+	want := []string{"Кукушка", "Группа крови", "Звезда"}
+	for name, s := range map[string]*scrobbleMock{"listenbrainz": &lbs, "lastfm": &lfs} {
+		if n, got := s.got(); n != 1 || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: %d requests, took %q", name, n, got)
+		}
+	}
+	if n := q.pending(); n != 0 {
+		t.Errorf("%d listens left", n)
+	}
+}
+
+func TestScrobbleRetry(t *testing.T) {
+	lbs := scrobbleMock{down: 3}
+	q := newScrobbleQueue(filepath.Join(t.TempDir(), "scrobbles.json"), []scrobbler{listenBrainzMock(t, &lbs)})
+	q.add(testListens("Кукушка")[0])
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		after    time.Duration
+		requests int
+		backoff  time.Duration
+	}{
+		{0, 1, 30 * time.Second},
+		{10 * time.Second, 1, 30 * time.Second},
+		{20 * time.Second, 2, time.Minute},
+		{59 * time.Second, 2, time.Minute},
+		{time.Second, 3, 2 * time.Minute},
+		{2 * time.Minute, 4, 0},
+	} {
+		now = now.Add(c.after)
+		q.flush(now)
+		n, _ := lbs.got()
+		q.mu.Lock()
+		backoff := q.backoff["listenbrainz"]
+		q.mu.Unlock()
+		if n != c.requests || backoff != c.backoff {
+			t.Fatalf("at %s: %d requests, backoff %s; want %d, %s", now.Format("15:04:05"), n, backoff, c.requests, c.backoff)
+		}
+	}
+	if q.pending() != 0 {
+		t.Error("the listen is still queued after the service came back")
+	}
+}
+
+func TestScrobbleRejectedListen(t *testing.T) {
+	var lbs, lfs scrobbleMock
+	q := newScrobbleQueue(filepath.Join(t.TempDir(), "scrobbles.json"), []scrobbler{listenBrainzMock(t, &lbs), lastFMMock(t, &lfs)})
+	for _, l := range testListens("Кукушка", "BAD", "Звезда") {
+		q.add(l)
+	}
+	q.flush(time.Now())
+	for name, s := range map[string]*scrobbleMock{"listenbrainz": &lbs, "lastfm": &lfs} {
+		if n, got := s.got(); n != 4 || !reflect.DeepEqual(got, []string{"Кукушка", "Звезда"}) {
+			t.Errorf("%s: %d requests, took %q", name, n, got)
+		}
+		if q.lastErr[name] == "" {
+			t.Errorf("%s: the rejection is not reported", name)
+		}
+	}
+	if n := q.pending(); n != 0 {
+		t.Errorf("%d listens left", n)
+	}
+}
+
+func TestScrobbleRejectedThenDown(t *testing.T) {
+	var lbs scrobbleMock
+	// The batch and "BAD" on its own are turned down, then the service goes
+	// away: "Кукушка" and "Звезда" have to stay queued.
+	b := &downAfter{listenBrainzMock(t, &lbs), 2}
+	done, err := submitBatch(b, testListens("BAD", "Кукушка", "Звезда"))
+	if err == nil || errors.Is(err, errRejected) || len(done) != 1 || done[0].Title != "BAD" {
+		t.Errorf("submitBatch = %v, %v", done, err)
+	}
+}
+
+// downAfter lets n requests through and fails the rest.
+type downAfter struct {
+	scrobbler
+	n int
+}
+
+func (d *downAfter) submit(ls []listen) error {
+	if d.n == 0 {
+		return errors.New("service down")
+	}
+	d.n--
+	return d.scrobbler.submit(ls)
+}
+
+func TestScrobbleQueuePersists(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "scrobbles.json")
+	down := scrobbleMock{down: 1}
+	q := newScrobbleQueue(file, []scrobbler{listenBrainzMock(t, &down)})
+	for _, l := range testListens("Кукушка", "Звезда") {
+		q.add(l)
+	}
+	q.flush(time.Now())
+	q.Close()
+
+	var up scrobbleMock
+	q = newScrobbleQueue(file, []scrobbler{listenBrainzMock(t, &up)})
+	if n := q.pending(); n != 2 {
+		t.Fatalf("%d listens after a restart, want 2", n)
+	}
+	if got := q.items[0].Pending; !reflect.DeepEqual(got, []string{"listenbrainz"}) {
+		t.Errorf("pending %q", got)
+	}
+	q.flush(time.Now())
+	if _, got := up.got(); !reflect.DeepEqual(got, []string{"Кукушка", "Звезда"}) {
+		t.Errorf("took %q after the restart", got)
+	}
+	if n := newScrobbleQueue(file, nil).pending(); n != 0 {
+		t.Errorf("%d listens on disk after the flush", n)
+	}
+}
+
+func TestScrobbleDue(t *testing.T) {
+	const path = "/music/Кино - Кукушка.mp3"
+	for _, c := range []struct {
+		name     string
+		path     string
+		duration float64
+		listened float64
+		want     bool
+	}{
+		{"under half", path, 400, 199, false},
+		{"half", path, 400, 200, true},
+		{"four minutes of a long track", path, 1200, 240, true},
+		{"not yet four minutes", path, 1200, 239, false},
+		{"too short a track", path, 29, 29, false},
+		{"a stream", "http://radio.example/live", 600, 600, false},
+		{"no artist", "/music/untitled.mp3", 600, 600, false},
+	} {
+		tr := playTracker{cur: playEntry{Path: c.path, Duration: c.duration, Listened: c.listened, Start: time.Unix(1760000000, 0)}}
+		l, ok := tr.scrobbleDue()
+		if ok != c.want {
+			t.Errorf("%s: due %v, want %v", c.name, ok, c.want)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if l.Artist != "Кино" || l.Title != "Кукушка" || l.ListenedAt != 1760000000 || l.Duration != int(c.duration) {
+			t.Errorf("%s: listen %+v", c.name, l)
+		}
+		if _, again := tr.scrobbleDue(); again {
+			t.Errorf("%s: scrobbled twice", c.name)
+		}
+	}
+}
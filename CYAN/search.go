@@ -0,0 +1,182 @@
+package main
+
+import (
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/totiks2012/Cyan_audio_player/internal/fuzzy"
+)
+
+// search is the / filter over one pane: base is the listing it started
+// from, and idx and pos say which base row each shown row is and what in
+// its name matched.
+type search struct {
+	pane int
+	base []displayItem
+	idx  []int
+	pos  [][]int
+}
+
+func (m *model) paneItems(pane int) (*[]displayItem, *int) {
+	if pane == 1 {
+		return &m.plItems, &m.plCur
+	}
+	return &m.fmItems, &m.fmCur
+}
+
+func (m *model) startSearch() {
+	m.clearSearch()
+	items, _ := m.paneItems(m.focus)
+	m.searchMode, m.searchInput = true, ""
+	m.filter = &search{pane: m.focus, base: *items}
+}
+
+func (m *model) searchKey(msg tea.KeyMsg) {
+	switch msg.String() {
+	case "enter":
+		m.searchMode = false
+	case "esc":
+		m.searchMode = false
+		m.clearSearch()
+	case "backspace":
+		if r := []rune(m.searchInput); len(r) > 0 {
+			m.searchInput = string(r[:len(r)-1])
+			m.doSearch()
+		}
+	default:
+		if msg.Type == tea.KeyRunes || msg.String() == " " {
+			m.searchInput += string(msg.Runes)
+			m.doSearch()
+		}
+	}
+}
+
+// searchTexts is what an entry can be found by: its name, then the tags
+// the library or the playlist already knows. Nothing is read from disk
+// while typing.
+func (m *model) searchTexts(it displayItem) []string {
+	texts := []string{it.name}
+	t, ok := m.library.Tracks[it.path]
+	if !ok {
+		t.Meta, ok = m.plMeta[it.path]
+	}
+	if ok {
+		texts = append(texts, t.Title, t.Artist, t.Album)
+	}
+	return texts
+}
+
+// doSearch shows the base rows that match, best first, and puts the cursor
+// on the best one. With an empty query the whole listing comes back with
+// the cursor left on what was selected.
+func (m *model) doSearch() {
+	f := m.filter
+	if f == nil {
+		return
+	}
+	items, cur := m.paneItems(f.pane)
+	q, keep := m.ratingFilter(m.searchInput)
+	if strings.TrimSpace(q) == "" && keep == nil {
+		m.showAll()
+		m.sync()
+		return
+	}
+	var cand []int
+	for i, it := range f.base {
+		if keep == nil || keep(it.path) {
+			cand = append(cand, i)
+		}
+	}
+	var res []displayItem
+	f.idx, f.pos = nil, nil
+	for _, r := range fuzzy.Rank(len(cand), q, func(i int) []string { return m.searchTexts(f.base[cand[i]]) }) {
+		res = append(res, f.base[cand[r.Index]])
+		f.idx = append(f.idx, cand[r.Index])
+		f.pos = append(f.pos, r.Pos)
+	}
+	*items, *cur = res, 0
+	m.sync()
+}
+
+// showAll puts the base listing back and keeps the cursor on the selected
+// row.
+func (m *model) showAll() {
+	f := m.filter
+	items, cur := m.paneItems(f.pane)
+	sel := -1
+	if f.idx != nil && *cur >= 0 && *cur < len(f.idx) {
+		sel = f.idx[*cur]
+	} else if f.idx == nil {
+		sel = *cur
+	}
+	*items, f.idx, f.pos = f.base, nil, nil
+	if sel >= 0 {
+		*cur = sel
+	}
+}
+
+// clearSearch drops the filter, if there is one.
+func (m *model) clearSearch() {
+	if m.filter == nil {
+		return
+	}
+	m.showAll()
+	m.filter = nil
+	m.sync()
+}
+
+// onRefresh follows a rebuilt listing: a search still being typed runs
+// again over it, a finished one is dropped with the old rows.
+func (m *model) onRefresh() {
+	f := m.filter
+	if f == nil {
+		return
+	}
+	if !m.searchMode {
+		m.filter = nil
+		return
+	}
+	items, _ := m.paneItems(f.pane)
+	f.base, f.idx, f.pos = *items, nil, nil
+	m.doSearch()
+}
+
+// plEntry is the playlist index of row i of the PLAYLIST pane.
+func (m *model) plEntry(i int) int {
+	if f := m.filter; f != nil && f.pane == 1 && f.idx != nil && i >= 0 && i < len(f.idx) {
+		return f.idx[i]
+	}
+	return i
+}
+
+// matchPos is what to highlight in the name of row i of a pane.
+func (m *model) matchPos(pane, i int) []int {
+	if f := m.filter; f != nil && f.pane == pane {
+		if i >= 0 && i < len(f.pos) {
+			return f.pos[i]
+		}
+		return nil
+	}
+	if pane == 0 && m.leftView == viewFind && i >= 0 && i < len(m.findPos) {
+		return m.findPos[i]
+	}
+	return nil
+}
+
+// renderMatch draws line in st with the matched runes picked out.
+func renderMatch(line string, pos []int, st lipgloss.Style) string {
+	if len(pos) == 0 {
+		return st.Render(line)
+	}
+	hl := st.Bold(true).Underline(true)
+	var sb strings.Builder
+	for _, sp := range fuzzy.Spans(line, pos) {
+		if sp.Hit {
+			sb.WriteString(hl.Render(sp.Text))
+		} else {
+			sb.WriteString(st.Render(sp.Text))
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,474 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/totiks2012/Cyan_audio_player/internal/libindex"
+)
+
+const smartDir = "smart"
+
+// smartPlaylist is a saved query over the library index, stored as
+// smart/<name>.json next to config.json:
+//
+//	{"name": "Rock", "match": "all", "limit": 50, "order": "random",
+//	 "rules": [{"field": "genre", "op": "=", "value": "Rock"},
+//	           {"field": "rating", "op": ">=", "value": "4"},
+//	           {"field": "last_played", "op": "not_in_last", "value": "30d"}]}
+type smartPlaylist struct {
+	Name  string      `json:"name"`
+	Match string      `json:"match,omitempty"`
+	Rules []smartRule `json:"rules"`
+	Limit int         `json:"limit,omitempty"`
+	Order string      `json:"order,omitempty"`
+}
+
+type smartRule struct {
+	Field string `json:"field"`
+	Op    string `json:"op"`
+	Value string `json:"value"`
+}
+
+const (
+	kindText = iota
+	kindNumber
+	kindDate
+	kindBool
+)
+
+var smartFields = map[string]int{
+	"artist": kindText, "title": kindText, "album": kindText, "genre": kindText, "path": kindText,
+	"year": kindNumber, "rating": kindNumber, "plays": kindNumber,
+	"last_played": kindDate, "added": kindDate,
+	"loved": kindBool,
+}
+
+var smartOps = map[int][]string{
+	kindText:   {"=", "!=", "contains", "!contains"},
+	kindNumber: {"=", "!=", "<", "<=", ">", ">="},
+	kindDate:   {"in_last", "not_in_last"},
+	kindBool:   {"=", "!="},
+}
+
+var smartOrders = []string{"random", "artist", "title", "album", "year", "rating", "plays", "last_played", "added"}
+
+// smartTrack is what rules are evaluated against: the index entry plus
+// play counts from the play log.
+type smartTrack struct {
+	libindex.Track
+	playCount
+}
+
+func (r smartRule) String() string {
+	return r.Field + " " + r.Op + " " + r.Value
+}
+
+// parseRule reads the "field op value" form used by the editor.
+func parseRule(s string) (smartRule, error) {
+	parts := strings.Fields(s)
+	if len(parts) < 2 {
+		return smartRule{}, fmt.Errorf("rule %q: want FIELD OP VALUE", s)
+	}
+	r := smartRule{Field: strings.ToLower(parts[0]), Op: strings.ToLower(parts[1])}
+	if len(parts) > 2 {
+		r.Value = strings.Join(parts[2:], " ")
+	}
+	return r, r.validate()
+}
+
+func (r smartRule) validate() error {
+	kind, ok := smartFields[r.Field]
+	if !ok {
+		return fmt.Errorf("unknown field %q", r.Field)
+	}
+	if !hasString(smartOps[kind], r.Op) {
+		return fmt.Errorf("%s: operator must be one of %s", r.Field, strings.Join(smartOps[kind], " "))
+	}
+	switch kind {
+	case kindNumber:
+		if _, err := strconv.ParseFloat(r.Value, 64); err != nil {
+			return fmt.Errorf("%s: %q is not a number", r.Field, r.Value)
+		}
+	case kindDate:
+		if _, err := parseAge(r.Value); err != nil {
+			return err
+		}
+	case kindBool:
+		if _, err := strconv.ParseBool(r.Value); err != nil {
+			return fmt.Errorf("%s: want true or false", r.Field)
+		}
+	}
+	return nil
+}
+
+// parseAge reads "30d", "2w", "6m" or "1y".
+func parseAge(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if len(s) < 2 {
+		return 0, fmt.Errorf("bad age %q (30d, 2w, 6m, 1y)", s)
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("bad age %q (30d, 2w, 6m, 1y)", s)
+	}
+	day := 24 * time.Hour
+	switch s[len(s)-1] {
+	case 'd':
+		return time.Duration(n) * day, nil
+	case 'w':
+		return time.Duration(n) * 7 * day, nil
+	case 'm':
+		return time.Duration(n) * 30 * day, nil
+	case 'y':
+		return time.Duration(n) * 365 * day, nil
+	}
+	return 0, fmt.Errorf("bad age %q (30d, 2w, 6m, 1y)", s)
+}
+
+func (t smartTrack) text(field string) string {
+	switch field {
+	case "artist":
+		return t.Artist
+	case "title":
+		return t.Title
+	case "album":
+		return t.Album
+	case "genre":
+		return t.Genre
+	}
+	return t.Path
+}
+
+func (t smartTrack) number(field string) float64 {
+	switch field {
+	case "year":
+		return float64(t.Year)
+	case "rating":
+		return float64(t.Rating)
+	}
+	return float64(t.Plays)
+}
+
+func (t smartTrack) date(field string) time.Time {
+	if field == "added" {
+		return time.Unix(t.ModTime, 0)
+	}
+	return t.Last
+}
+
+func (r smartRule) match(t smartTrack, now time.Time) bool {
+	switch smartFields[r.Field] {
+	case kindText:
+		v, want := strings.ToLower(t.text(r.Field)), strings.ToLower(r.Value)
+		switch r.Op {
+		case "=":
+			return v == want
+		case "!=":
+			return v != want
+		case "contains":
+			return strings.Contains(v, want)
+		case "!contains":
+			return !strings.Contains(v, want)
+		}
+	case kindNumber:
+		v := t.number(r.Field)
+		want, _ := strconv.ParseFloat(r.Value, 64)
+		switch r.Op {
+		case "=":
+			return v == want
+		case "!=":
+			return v != want
+		case "<":
+			return v < want
+		case "<=":
+			return v <= want
+		case ">":
+			return v > want
+		case ">=":
+			return v >= want
+		}
+	case kindDate:
+		age, _ := parseAge(r.Value)
+		d := t.date(r.Field)
+		recent := !d.IsZero() && now.Sub(d) <= age
+		return recent == (r.Op == "in_last")
+	case kindBool:
+		want, _ := strconv.ParseBool(r.Value)
+		return (t.Loved == want) == (r.Op == "=")
+	}
+	return false
+}
+
+func (sp smartPlaylist) validate() error {
+	if strings.TrimSpace(sp.Name) == "" {
+		return errors.New("name is empty")
+	}
+	if sp.Match != "" && sp.Match != "all" && sp.Match != "any" {
+		return errors.New(`match must be "all" or "any"`)
+	}
+	if sp.Limit < 0 {
+		return errors.New("limit must not be negative")
+	}
+	if o := strings.TrimSuffix(sp.Order, " desc"); o != "" && !hasString(smartOrders, o) {
+		return fmt.Errorf("order must be one of %s (add \" desc\" to reverse)", strings.Join(smartOrders, " "))
+	}
+	for _, r := range sp.Rules {
+		if err := r.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// evaluate returns the paths of the matching tracks, ordered and limited.
+func (sp smartPlaylist) evaluate(tracks map[string]libindex.Track, plays map[string]playCount, now time.Time, rnd *rand.Rand) []string {
+	var hits []smartTrack
+	for _, lt := range tracks {
+		t := smartTrack{lt, plays[lt.Path]}
+		ok := sp.Match != "any" || len(sp.Rules) == 0
+		for _, r := range sp.Rules {
+			if r.match(t, now) != (sp.Match != "any") {
+				ok = !ok
+				break
+			}
+		}
+		if ok {
+			hits = append(hits, t)
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool { return hits[i].Path < hits[j].Path })
+	order := strings.TrimSuffix(sp.Order, " desc")
+	desc := strings.HasSuffix(sp.Order, " desc")
+	if order == "random" {
+		rnd.Shuffle(len(hits), func(i, j int) { hits[i], hits[j] = hits[j], hits[i] })
+	} else if order != "" {
+		sort.SliceStable(hits, func(i, j int) bool {
+			a, b := hits[i], hits[j]
+			if desc {
+				a, b = b, a
+			}
+			switch smartFields[order] {
+			case kindNumber:
+				return a.number(order) < b.number(order)
+			case kindDate:
+				return a.date(order).Before(b.date(order))
+			}
+			return strings.ToLower(a.text(order)) < strings.ToLower(b.text(order))
+		})
+	}
+	if sp.Limit > 0 && len(hits) > sp.Limit {
+		hits = hits[:sp.Limit]
+	}
+	out := make([]string, len(hits))
+	for i, t := range hits {
+		out[i] = t.Path
+	}
+	return out
+}
+
+func smartFile(name string) string {
+	return filepath.Join(smartDir, safeFileName(name)+".json")
+}
+
+func loadSmartPlaylists() []smartPlaylist {
+	files, _ := filepath.Glob(filepath.Join(smartDir, "*.json"))
+	var out []smartPlaylist
+	for _, f := range files {
+		var sp smartPlaylist
+		if d, err := os.ReadFile(f); err == nil && json.Unmarshal(d, &sp) == nil && sp.Name != "" {
+			out = append(out, sp)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return strings.ToLower(out[i].Name) < strings.ToLower(out[j].Name) })
+	return out
+}
+
+func saveSmartPlaylist(sp smartPlaylist) error {
+	if err := os.MkdirAll(smartDir, 0755); err != nil {
+		return err
+	}
+	d, _ := json.MarshalIndent(sp, "", "  ")
+	return os.WriteFile(smartFile(sp.Name), append(d, '\n'), 0644)
+}
+
+const newSmartItem = "+ new smart playlist"
+
+func (m *model) smartItems() []displayItem {
+	items := []displayItem{{"", newSmartItem, false}}
+	for _, sp := range loadSmartPlaylists() {
+		items = append(items, displayItem{sp.Name, sp.Name, true})
+	}
+	return items
+}
+
+func findSmart(name string) (smartPlaylist, bool) {
+	for _, sp := range loadSmartPlaylists() {
+		if sp.Name == name {
+			return sp, true
+		}
+	}
+	return smartPlaylist{}, false
+}
+
+// loadSmart rescans the library and replaces the playlist with the result.
+func (m *model) loadSmart(name string) tea.Cmd {
+	sp, ok := findSmart(name)
+	if !ok {
+		return nil
+	}
+	m.smartMsg = "scanning library…"
+	return m.rescanLibrary(func(m *model) tea.Cmd {
+		paths := sp.evaluate(m.library.Tracks, playCounts(m.loadPlayLog()), m.now(), rand.New(rand.NewSource(m.now().UnixNano())))
+		m.smartMsg = fmt.Sprintf("%s: %d tracks", sp.Name, len(paths))
+		m.state.Playlist = paths
+		m.state.CurrentIndex = -1
+		m.plCur, m.plOff = 0, 0
+		m.refresh()
+		m.save()
+		return nil
+	})
+}
+
+func (m *model) smartAction(it displayItem) tea.Cmd {
+	if it.name == newSmartItem {
+		m.editSmart(smartPlaylist{Match: "all", Order: "random", Limit: 50}, "")
+		return nil
+	}
+	return m.loadSmart(it.path)
+}
+
+func (m *model) deleteSmart() {
+	if m.fmCur >= len(m.fmItems) || m.fmItems[m.fmCur].path == "" {
+		return
+	}
+	_ = os.Remove(smartFile(m.fmItems[m.fmCur].path))
+	m.refresh()
+}
+
+// smartForm edits a smart playlist field by field; the last rule line is
+// always blank so typing into it adds a rule.
+type smartForm struct {
+	orig   string
+	labels []string
+	values []string
+	cur    int
+	err    string
+}
+
+const smartFormFixed = 4
+
+func (m *model) editSmart(sp smartPlaylist, orig string) {
+	f := &smartForm{orig: orig,
+		labels: []string{"name", "match", "limit", "order"},
+		values: []string{sp.Name, sp.Match, strconv.Itoa(sp.Limit), sp.Order}}
+	for _, r := range sp.Rules {
+		f.labels, f.values = append(f.labels, "rule"), append(f.values, r.String())
+	}
+	f.labels, f.values = append(f.labels, "rule"), append(f.values, "")
+	m.form, m.focus, m.fmCur, m.fmOff = f, 0, 0, 0
+	m.refresh()
+}
+
+func (m *model) editSelectedSmart() {
+	if m.fmCur >= len(m.fmItems) || m.fmItems[m.fmCur].path == "" {
+		return
+	}
+	if sp, ok := findSmart(m.fmItems[m.fmCur].path); ok {
+		m.editSmart(sp, sp.Name)
+	}
+}
+
+func (f *smartForm) playlist() (smartPlaylist, error) {
+	sp := smartPlaylist{Name: strings.TrimSpace(f.values[0]), Match: strings.TrimSpace(f.values[1]), Order: strings.TrimSpace(f.values[3])}
+	if v := strings.TrimSpace(f.values[2]); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return sp, fmt.Errorf("limit %q is not a number", v)
+		}
+		sp.Limit = n
+	}
+	for _, v := range f.values[smartFormFixed:] {
+		if strings.TrimSpace(v) == "" {
+			continue
+		}
+		r, err := parseRule(v)
+		if err != nil {
+			return sp, err
+		}
+		sp.Rules = append(sp.Rules, r)
+	}
+	return sp, sp.validate()
+}
+
+func (f *smartForm) items() []displayItem {
+	var items []displayItem
+	for i, l := range f.labels {
+		v := f.values[i]
+		if i == f.cur {
+			v += "▌"
+		}
+		items = append(items, displayItem{"", fmt.Sprintf("%-6s %s", l+":", v), false})
+	}
+	return items
+}
+
+// formKey handles a key while the editor is open; enter saves, esc cancels.
+func (m *model) formKey(msg tea.KeyMsg) {
+	f := m.form
+	switch msg.String() {
+	case "esc":
+		m.form = nil
+	case "up", "shift+tab":
+		if f.cur > 0 {
+			f.cur--
+		}
+	case "down", "tab":
+		if f.cur < len(f.values)-1 {
+			f.cur++
+		}
+	case "backspace":
+		r := []rune(f.values[f.cur])
+		if len(r) > 0 {
+			f.values[f.cur] = string(r[:len(r)-1])
+		}
+	case "ctrl+u":
+		f.values[f.cur] = ""
+	case "enter":
+		sp, err := f.playlist()
+		if err != nil {
+			f.err = err.Error()
+			break
+		}
+		if f.orig != "" && smartFile(f.orig) != smartFile(sp.Name) {
+			_ = os.Remove(smartFile(f.orig))
+		}
+		if err := saveSmartPlaylist(sp); err != nil {
+			f.err = err.Error()
+			break
+		}
+		m.form, m.smartMsg = nil, "saved "+sp.Name
+	default:
+		if msg.Type == tea.KeyRunes || msg.String() == " " {
+			f.values[f.cur] += string(msg.Runes)
+			f.err = ""
+		}
+	}
+	if m.form != nil && f.values[len(f.values)-1] != "" {
+		f.labels, f.values = append(f.labels, "rule"), append(f.values, "")
+	}
+	m.refresh()
+	if m.form != nil {
+		m.fmCur = f.cur
+		m.sync()
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/totiks2012/Cyan_audio_player/internal/libindex"
+)
+
+var smartNow = time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+func smartTracks() (map[string]libindex.Track, map[string]playCount) {
+	tracks := map[string]libindex.Track{}
+	for _, t := range []libindex.Track{
+		{Path: "/m/a.mp3", Meta: libindex.Meta{Artist: "Кино", Title: "Кукушка", Genre: "Rock", Year: 1990}, Rating: 5, Loved: true},
+		{Path: "/m/b.mp3", Meta: libindex.Meta{Artist: "Кино", Title: "Группа крови", Genre: "Rock", Year: 1988}, Rating: 4},
+		{Path: "/m/c.mp3", Meta: libindex.Meta{Artist: "Аквариум", Title: "Город золотой", Genre: "Folk", Year: 1986}, Rating: 3},
+		{Path: "/m/d.mp3", Meta: libindex.Meta{Artist: "ДДТ", Title: "Осень", Genre: "rock", Year: 1991}},
+	} {
+		tracks[t.Path] = t
+	}
+	plays := map[string]playCount{
+		"/m/a.mp3": {Plays: 12, Last: smartNow.AddDate(0, 0, -2)},
+		"/m/b.mp3": {Plays: 3, Last: smartNow.AddDate(0, 0, -60)},
+		"/m/c.mp3": {Plays: 7, Last: smartNow.AddDate(0, 0, -10)},
+	}
+	return tracks, plays
+}
+
+func TestSmartRuleMatch(t *testing.T) {
+	tracks, plays := smartTracks()
+	for _, c := range []struct {
+		rule string
+		want []string
+	}{
+		{"rating >= 4", []string{"/m/a.mp3", "/m/b.mp3"}},
+		{"rating < 4", []string{"/m/c.mp3", "/m/d.mp3"}},
+		{"genre = ROCK", []string{"/m/a.mp3", "/m/b.mp3", "/m/d.mp3"}},
+		{"title contains город", []string{"/m/c.mp3"}},
+		{"artist !contains кино", []string{"/m/c.mp3", "/m/d.mp3"}},
+		{"plays > 5", []string{"/m/a.mp3", "/m/c.mp3"}},
+		{"loved = true", []string{"/m/a.mp3"}},
+		{"loved != true", []string{"/m/b.mp3", "/m/c.mp3", "/m/d.mp3"}},
+		{"last_played in_last 30d", []string{"/m/a.mp3", "/m/c.mp3"}},
+		// a track never played was not played lately either
+		{"last_played not_in_last 30d", []string{"/m/b.mp3", "/m/d.mp3"}},
+		{"last_played not_in_last 1w", []string{"/m/b.mp3", "/m/c.mp3", "/m/d.mp3"}},
+	} {
+		r, err := parseRule(c.rule)
+		if err != nil {
+			t.Fatalf("%s: %v", c.rule, err)
+		}
+		var got []string
+		for p, lt := range tracks {
+			if r.match(smartTrack{lt, plays[p]}, smartNow) {
+				got = append(got, p)
+			}
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s matched %v, want %v", c.rule, got, c.want)
+		}
+	}
+}
+
+func TestSmartPlaylistEvaluate(t *testing.T) {
+	tracks, plays := smartTracks()
+	rules := []smartRule{{"genre", "=", "rock"}, {"rating", ">=", "4"}}
+	for _, c := range []struct {
+		name string
+		sp   smartPlaylist
+		want []string
+	}{
+		{"all", smartPlaylist{Rules: rules, Order: "title"}, []string{"/m/b.mp3", "/m/a.mp3"}},
+		{"any", smartPlaylist{Match: "any", Rules: rules, Order: "year"}, []string{"/m/b.mp3", "/m/a.mp3", "/m/d.mp3"}},
+		{"no rules take everything", smartPlaylist{Match: "any"}, []string{"/m/a.mp3", "/m/b.mp3", "/m/c.mp3", "/m/d.mp3"}},
+		{"descending with a limit", smartPlaylist{Order: "plays desc", Limit: 2}, []string{"/m/a.mp3", "/m/c.mp3"}},
+		// ties keep the path order
+		{"ties", smartPlaylist{Order: "artist"}, []string{"/m/c.mp3", "/m/d.mp3", "/m/a.mp3", "/m/b.mp3"}},
+		{"never played first", smartPlaylist{Order: "last_played"}, []string{"/m/d.mp3", "/m/b.mp3", "/m/c.mp3", "/m/a.mp3"}},
+	} {
+		if got := c.sp.evaluate(tracks, plays, smartNow, nil); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: %v, want %v", c.name, got, c.want)
+		}
+	}
+
+	// random order depends only on the seed, not on map order
+	sp := smartPlaylist{Order: "random", Limit: 3}
+	want := []string{"/m/a.mp3", "/m/b.mp3", "/m/c.mp3", "/m/d.mp3"}
+	rand.New(rand.NewSource(7)).Shuffle(len(want), func(i, j int) { want[i], want[j] = want[j], want[i] })
+	for i := 0; i < 5; i++ {
+		if got := sp.evaluate(tracks, plays, smartNow, rand.New(rand.NewSource(7))); !reflect.DeepEqual(got, want[:3]) {
+			t.Fatalf("seeded shuffle %v, want %v", got, want[:3])
+		}
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	day := 24 * time.Hour
+	for s, want := range map[string]time.Duration{"30d": 30 * day, " 2w ": 14 * day, "6m": 180 * day, "1y": 365 * day, "0d": 0} {
+		if got, err := parseAge(s); err != nil || got != want {
+			t.Errorf("parseAge(%q) = %s, %v; want %s", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "d", "30", "30h", "-1d", "xd", "1.5w"} {
+		if got, err := parseAge(s); err == nil {
+			t.Errorf("parseAge(%q) = %s, want an error", s, got)
+		}
+	}
+}
+
+func TestSmartValidate(t *testing.T) {
+	for _, s := range []string{"rating", "bpm > 100", "rating contains 4", "rating >= four", "genre < rock",
+		"last_played = 30d", "last_played in_last soon", "loved = maybe", "added in_last"} {
+		if r, err := parseRule(s); err == nil {
+			t.Errorf("rule %q accepted as %+v", s, r)
+		}
+	}
+	if r, err := parseRule("Title Contains Город Золотой"); err != nil || r != (smartRule{"title", "contains", "Город Золотой"}) {
+		t.Errorf("parsed %+v, %v", r, err)
+	}
+
+	good := smartPlaylist{Name: "Rock", Match: "all", Limit: 10, Order: "rating desc", Rules: []smartRule{{"genre", "=", "Rock"}}}
+	if err := good.validate(); err != nil {
+		t.Fatal(err)
+	}
+	for name, f := range map[string]func(sp *smartPlaylist){
+		"no name":   func(sp *smartPlaylist) { sp.Name = " " },
+		"match":     func(sp *smartPlaylist) { sp.Match = "some" },
+		"limit":     func(sp *smartPlaylist) { sp.Limit = -1 },
+		"order":     func(sp *smartPlaylist) { sp.Order = "bpm" },
+		"desc only": func(sp *smartPlaylist) { sp.Order = "random asc" },
+		"bad rule":  func(sp *smartPlaylist) { sp.Rules = append(sp.Rules, smartRule{"year", ">", "soon"}) },
+	} {
+		sp := good
+		sp.Rules = append([]smartRule(nil), good.Rules...)
+		f(&sp)
+		if err := sp.validate(); err == nil {
+			t.Errorf("%s: %+v accepted", name, sp)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/totiks2012/Cyan_audio_player/internal/cmdline"
+)
+
+const (
+	icyHistoryFile  = ".cyan_icy_history.json"
+	icyHistoryLimit = 200
+	favouritesGroup = "★ Favourites"
+	ungroupedGroup  = "Other"
+	stationPrefix   = "station:"
+)
+
+type Station struct {
+	Name  string `json:"name"`
+	URL   string `json:"url"`
+	Group string `json:"group,omitempty"`
+}
+
+type icyEntry struct {
+	Title string    `json:"title"`
+	At    time.Time `json:"at"`
+}
+
+type stationHistory map[string][]icyEntry
+
+func extinfAttr(line, key string) string {
+	i := strings.Index(line, key+`="`)
+	if i < 0 {
+		return ""
+	}
+	rest := line[i+len(key)+2:]
+	if j := strings.IndexByte(rest, '"'); j >= 0 {
+		return rest[:j]
+	}
+	return ""
+}
+
+// extinfName is the title after the first comma outside a quoted attribute,
+// so attributes may hold commas and so may the name.
+func extinfName(line string) string {
+	quoted := false
+	for i, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ',' && !quoted:
+			return strings.TrimSpace(line[i+1:])
+		}
+	}
+	return ""
+}
+
+func readStations(path string) []Station {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var list []Station
+	var cur Station
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#EXTINF:") {
+			cur = Station{Group: extinfAttr(line, "group-title")}
+			cur.Name = extinfName(line)
+		} else if strings.HasPrefix(line, "#EXTGRP:") {
+			cur.Group = strings.TrimSpace(strings.TrimPrefix(line, "#EXTGRP:"))
+		} else if !strings.HasPrefix(line, "#") {
+			if isURL(line) {
+				cur.URL = line
+				if cur.Name == "" {
+					cur.Name = line
+				}
+				list = append(list, cur)
+			}
+			cur = Station{}
+		}
+	}
+	return list
+}
+
+func stationGroups(stations []Station, favs []Station) []string {
+	seen := map[string]bool{}
+	var groups []string
+	for _, s := range stations {
+		g := s.Group
+		if g == "" {
+			g = ungroupedGroup
+		}
+		if !seen[g] {
+			seen[g] = true
+			groups = append(groups, g)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return strings.ToLower(groups[i]) < strings.ToLower(groups[j]) })
+	if len(favs) > 0 {
+		groups = append([]string{favouritesGroup}, groups...)
+	}
+	return groups
+}
+
+func stationsIn(group string, stations []Station, favs []Station) []Station {
+	if group == favouritesGroup {
+		return favs
+	}
+	var res []Station
+	for _, s := range stations {
+		g := s.Group
+		if g == "" {
+			g = ungroupedGroup
+		}
+		if g == group {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+func isFavourite(favs []Station, url string) bool {
+	for _, s := range favs {
+		if s.URL == url {
+			return true
+		}
+	}
+	return false
+}
+
+func toggleFavourite(favs []Station, s Station) []Station {
+	for i, f := range favs {
+		if f.URL == s.URL {
+			return append(favs[:i], favs[i+1:]...)
+		}
+	}
+	return append(favs, s)
+}
+
+func loadStationHistory() stationHistory {
+	h := stationHistory{}
+	if d, err := os.ReadFile(icyHistoryFile); err == nil {
+		_ = json.Unmarshal(d, &h)
+	}
+	return h
+}
+
+func (h stationHistory) add(url, title string, at time.Time) bool {
+	list := h[url]
+	if len(list) > 0 && list[len(list)-1].Title == title {
+		return false
+	}
+	list = append(list, icyEntry{Title: title, At: at})
+	if len(list) > icyHistoryLimit {
+		list = list[len(list)-icyHistoryLimit:]
+	}
+	h[url] = list
+	return true
+}
+
+func (h stationHistory) save() {
+	d, _ := json.Marshal(h)
+	_ = os.WriteFile(icyHistoryFile, d, 0644)
+}
+
+func (m *model) loadStations() {
+	m.stations = nil
+	for _, p := range m.config.StationLists {
+		m.stations = append(m.stations, readStations(cmdline.ExpandHome(p))...)
+	}
+}
+
+func (m *model) stationItems() []displayItem {
+	items := []displayItem{{"", "..", true}}
+	if m.stationHist != "" {
+		list := m.icyHistory[m.stationHist]
+		for i := len(list) - 1; i >= 0; i-- {
+			items = append(items, displayItem{"", list[i].At.Format("02.01 15:04  ") + list[i].Title, false})
+		}
+		return items
+	}
+	if m.stationGroup == "" {
+		for _, g := range stationGroups(m.stations, m.config.Favourites) {
+			items = append(items, displayItem{stationPrefix + g, g, true})
+		}
+		return items
+	}
+	for _, s := range stationsIn(m.stationGroup, m.stations, m.config.Favourites) {
+		name := s.Name
+		if isFavourite(m.config.Favourites, s.URL) {
+			name = "★ " + name
+		}
+		items = append(items, displayItem{s.URL, name, false})
+	}
+	return items
+}
+
+func (m *model) stationByURL(url, fallback string) Station {
+	for _, s := range m.config.Favourites {
+		if s.URL == url {
+			return s
+		}
+	}
+	for _, s := range m.stations {
+		if s.URL == url {
+			return s
+		}
+	}
+	return Station{Name: strings.TrimPrefix(fallback, "★ "), URL: url}
+}
+
+func (m *model) stationUp() {
+	if m.stationHist != "" {
+		m.stationHist = ""
+	} else if m.stationGroup != "" {
+		m.stationGroup = ""
+	} else {
+		m.leftView = viewFiles
+	}
+	m.fmCur, m.fmOff = 0, 0
+	m.refresh()
+}
+
+func (m *model) stationAction(it displayItem) {
+	switch {
+	case it.name == "..":
+		m.stationUp()
+	case it.isDir:
+		m.stationGroup = strings.TrimPrefix(it.path, stationPrefix)
+		m.fmCur, m.fmOff = 0, 0
+		m.refresh()
+	case it.path != "":
+		m.state.Playlist = nil
+		m.state.CurrentIndex = 0
+		for i, s := range stationsIn(m.stationGroup, m.stations, m.config.Favourites) {
+			m.state.Playlist = append(m.state.Playlist, s.Name+m3uSeparator+s.URL)
+			if s.URL == it.path {
+				m.state.CurrentIndex = i
+			}
+		}
+		m.playTrack(m.state.CurrentIndex)
+		m.plCur = m.state.CurrentIndex
+		m.refresh()
+		m.save()
+	}
+}
+
+func (m *model) showStationHistory() {
+	if m.leftView != viewStations || m.stationHist != "" || m.fmCur >= len(m.fmItems) {
+		return
+	}
+	if it := m.fmItems[m.fmCur]; !it.isDir && it.path != "" {
+		m.stationHist = it.path
+		m.fmCur, m.fmOff = 0, 0
+		m.refresh()
+	}
+}
+
+func (m *model) toggleFav() {
+	var it displayItem
+	if m.focus == 0 {
+		if m.leftView != viewStations || m.fmCur >= len(m.fmItems) {
+			return
+		}
+		it = m.fmItems[m.fmCur]
+	} else {
+		if m.plCur >= len(m.plItems) {
+			return
+		}
+		it = m.plItems[m.plCur]
+	}
+	if it.isDir || !isURL(it.path) {
+		return
+	}
+	m.config.Favourites = toggleFavourite(m.config.Favourites, m.stationByURL(it.path, it.name))
+	m.saveConfig()
+	m.refresh()
+}
+
+func (m *model) currentPath() string {
+	if m.state.CurrentIndex < 0 || m.state.CurrentIndex >= len(m.state.Playlist) {
+		return ""
+	}
+	raw := m.state.Playlist[m.state.CurrentIndex]
+	if strings.Contains(raw, m3uSeparator) {
+		raw = strings.SplitN(raw, m3uSeparator, 2)[1]
+	}
+	return raw
+}
+
+func (m *model) currentURL() string {
+	if raw := m.currentPath(); isURL(raw) {
+		return raw
+	}
+	return ""
+}
+
+func (m *model) pollICY() {
+	url := m.currentURL()
+	if url != m.icyURL {
+		m.icyURL, m.icyTitle = url, ""
+	}
+	if url == "" {
+		return
+	}
+	t := strings.TrimSpace(m.player.getProp("metadata/by-key/icy-title"))
+	if t == "" || t == m.icyTitle {
+		return
+	}
+	m.icyTitle = t
+	if m.icyHistory.add(url, t, m.now()) {
+		m.icyHistory.save()
+	}
+}
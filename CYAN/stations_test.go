@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadStations(t *testing.T) {
+	list := filepath.Join(t.TempDir(), "radio.m3u")
+	body := `#EXTM3U
+#EXTINF:-1 group-title="Jazz",Radio Swiss Jazz, Basel
+http://stream.srg-ssr.ch/m/rsj/mp3_128
+#EXTINF:-1 tvg-name="Rock, Live" group-title="Rock, Classic",Classic Rock, 24/7
+https://radio.example/rock
+
+#EXTINF:-1,Без группы
+#EXTGRP:Русское
+http://radio.example/ru
+#EXTINF:-1 group-title="News"
+http://radio.example/news
+#EXTINF:-1,not a stream
+/music/a.mp3
+`
+	if err := os.WriteFile(list, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	want := []Station{
+		{"Radio Swiss Jazz, Basel", "http://stream.srg-ssr.ch/m/rsj/mp3_128", "Jazz"},
+		{"Classic Rock, 24/7", "https://radio.example/rock", "Rock, Classic"},
+		{"Без группы", "http://radio.example/ru", "Русское"},
+		// without a title the URL is the name
+		{"http://radio.example/news", "http://radio.example/news", "News"},
+	}
+	if got := readStations(list); !reflect.DeepEqual(got, want) {
+		t.Errorf("got  %+v\nwant %+v", got, want)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/totiks2012/Cyan_audio_player/internal/stream"
+)
+
+func (m *model) pollStream() bool {
+	loaded, ended := m.player.PollEvents()
+	now := m.now()
+	url := m.currentURL()
+	if loaded {
+		m.streamMsg = ""
+		m.reconnect.Loaded(now)
+	}
+	for _, e := range ended {
+		if d, ok := m.reconnect.Ended(url, e, now); ok {
+			m.streamMsg = fmt.Sprintf("stream lost (%s), retry #%d in %ds", e, m.reconnect.Attempt(), int(d.Seconds()))
+		} else if e.Reason == stream.EndError {
+			m.streamMsg = "playback " + e.String()
+		}
+	}
+	pending := m.reconnect.Pending()
+	if m.reconnect.Due(url, now) {
+		m.streamMsg = fmt.Sprintf("reconnecting (#%d)...", m.reconnect.Attempt())
+		m.playTrack(m.state.CurrentIndex)
+	} else if pending && !m.reconnect.Pending() {
+		m.streamMsg = ""
+	}
+
+	m.buffering, m.bufPct, m.cacheSec = false, 0, 0
+	if url != "" {
+		m.buffering = m.player.getProp("paused-for-cache") == "yes"
+		m.bufPct, _ = strconv.Atoi(m.player.getProp("cache-buffering-state"))
+		m.cacheSec, _ = parseFloat(m.player.getProp("demuxer-cache-duration"))
+	}
+	return loaded
+}
+
+func (m *model) streamStatus() string {
+	switch {
+	case m.buffering:
+		return fmt.Sprintf(" BUF %d%%", m.bufPct)
+	case m.cacheSec > 0:
+		return fmt.Sprintf(" CACHE %ds", int(m.cacheSec))
+	}
+	return ""
+}
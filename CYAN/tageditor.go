@@ -0,0 +1,364 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/totiks2012/Cyan_audio_player/internal/libindex"
+	"github.com/totiks2012/Cyan_audio_player/internal/tags"
+)
+
+var tagLabels = map[string]string{"albumartist": "album artist"}
+
+// tagForm edits the common fields of one or more files. A field only gets
+// written when it was touched, so a batch edit leaves the per-file values
+// (titles, track numbers) of untouched fields alone.
+type tagForm struct {
+	files  []*tags.File
+	values []string
+	mixed  []bool
+	dirty  []bool
+	cur    int
+	err    string
+}
+
+// the row after the tag fields holds the file name pattern
+func (f *tagForm) patternRow() int { return len(tags.Fields) }
+
+// pick moves the cursor to the field shown on list row i.
+func (f *tagForm) pick(i int) {
+	if i < len(tags.Fields) {
+		f.cur = i
+	} else if i == len(tags.Fields)+1 {
+		f.cur = f.patternRow()
+	}
+}
+
+type tagsSavedMsg struct {
+	paths []string
+	err   error
+}
+
+// tagSelection is the marked files, or the selected one when nothing is
+// marked.
+func (m *model) tagSelection() []string {
+	var out []string
+	for _, it := range m.plItems {
+		if m.marked[it.path] {
+			out = append(out, it.path)
+		}
+	}
+	for _, it := range m.fmItems {
+		if m.marked[it.path] && !hasString(out, it.path) {
+			out = append(out, it.path)
+		}
+	}
+	for p := range m.marked {
+		if !hasString(out, p) {
+			out = append(out, p)
+		}
+	}
+	if len(out) > 0 {
+		return out
+	}
+	if it, ok := m.selectedItem(); ok && !it.isDir && !isURL(it.path) && isAudio(it.path) {
+		return []string{it.path}
+	}
+	return nil
+}
+
+func (m *model) selectedItem() (displayItem, bool) {
+	if m.focus == 1 {
+		if m.plCur >= 0 && m.plCur < len(m.plItems) {
+			return m.plItems[m.plCur], true
+		}
+	} else if (m.leftView == viewFiles || m.leftView == viewFind) && m.fmCur >= 0 && m.fmCur < len(m.fmItems) {
+		return m.fmItems[m.fmCur], true
+	}
+	return displayItem{}, false
+}
+
+// toggleMark adds the selected audio file to the multi-selection.
+func (m *model) toggleMark() {
+	it, ok := m.selectedItem()
+	if !ok || it.isDir || isURL(it.path) || !isAudio(it.path) {
+		return
+	}
+	if m.marked[it.path] {
+		delete(m.marked, it.path)
+	} else {
+		m.marked[it.path] = true
+	}
+	if m.focus == 1 {
+		m.plCur++
+	} else {
+		m.fmCur++
+	}
+}
+
+func (m *model) editTags() {
+	paths := m.tagSelection()
+	if len(paths) == 0 {
+		return
+	}
+	f := &tagForm{values: make([]string, len(tags.Fields)+1), mixed: make([]bool, len(tags.Fields)), dirty: make([]bool, len(tags.Fields))}
+	for _, p := range paths {
+		t, err := tags.Open(p)
+		if err != nil {
+			m.setNotice(filepath.Base(p) + ": " + err.Error())
+			return
+		}
+		f.files = append(f.files, t)
+	}
+	for i, fd := range tags.Fields {
+		f.values[i] = f.files[0].Get(fd.Name)
+		for _, t := range f.files[1:] {
+			if t.Get(fd.Name) != f.values[i] {
+				f.values[i], f.mixed[i] = "", true
+				break
+			}
+		}
+	}
+	m.tags, m.focus, m.fmCur, m.fmOff = f, 0, 0, 0
+	m.refresh()
+}
+
+func (f *tagForm) items() []displayItem {
+	var items []displayItem
+	for i, fd := range tags.Fields {
+		label := fd.Name
+		if l, ok := tagLabels[label]; ok {
+			label = l
+		}
+		v := f.values[i]
+		if i == f.cur {
+			v += "▌"
+		} else if f.mixed[i] && !f.dirty[i] {
+			v = "‹mixed›"
+		}
+		items = append(items, displayItem{"", fmt.Sprintf("%-13s %s", label+":", v), false})
+	}
+	v := f.values[f.patternRow()]
+	if f.cur == f.patternRow() {
+		v += "▌"
+	}
+	items = append(items, displayItem{"", "", false}, displayItem{"", fmt.Sprintf("%-13s %s", "from name:", v), false})
+	if pat := f.values[f.patternRow()]; pat == "" {
+		if f.cur == f.patternRow() {
+			items = append(items, displayItem{"", "  %t title  %a artist  %b album  %A album artist", false},
+				displayItem{"", "  %n track  %d disc  %y year  %g genre  %* skip", false})
+		}
+	} else if re, fields, err := compileNamePattern(pat); err != nil {
+		items = append(items, displayItem{"", "  ✗ " + err.Error(), false})
+	} else if vals, ok := matchNamePattern(re, fields, f.files[0].Path()); !ok {
+		items = append(items, displayItem{"", "  ✗ no match: " + filepath.Base(f.files[0].Path()), false})
+	} else {
+		var parts []string
+		for _, fd := range tags.Fields {
+			if v, ok := vals[fd.Name]; ok {
+				parts = append(parts, fd.Name+"="+v)
+			}
+		}
+		items = append(items, displayItem{"", "  → " + strings.Join(parts, " "), false})
+	}
+	if len(f.files) > 1 {
+		items = append(items, displayItem{"", "", false}, displayItem{"", fmt.Sprintf("%d files:", len(f.files)), true})
+		for _, t := range f.files {
+			items = append(items, displayItem{t.Path(), "  " + filepath.Base(t.Path()), false})
+		}
+	}
+	return items
+}
+
+// changes works out what each file gets: the values parsed from its name
+// first, then the fields edited in the form on top.
+func (f *tagForm) changes() ([]map[string]string, error) {
+	var re *regexp.Regexp
+	var fields []string
+	if pat := f.values[f.patternRow()]; pat != "" {
+		var err error
+		if re, fields, err = compileNamePattern(pat); err != nil {
+			return nil, err
+		}
+	}
+	out := make([]map[string]string, len(f.files))
+	for i, t := range f.files {
+		ch := map[string]string{}
+		if re != nil {
+			vals, ok := matchNamePattern(re, fields, t.Path())
+			if !ok {
+				return nil, fmt.Errorf("pattern does not match %s", filepath.Base(t.Path()))
+			}
+			for k, v := range vals {
+				ch[k] = v
+			}
+		}
+		for j, fd := range tags.Fields {
+			if f.dirty[j] {
+				ch[fd.Name] = strings.TrimSpace(f.values[j])
+			}
+		}
+		for k, v := range ch {
+			if t.Get(k) == v {
+				delete(ch, k)
+			}
+		}
+		out[i] = ch
+	}
+	return out, nil
+}
+
+func (m *model) tagKey(msg tea.KeyMsg) tea.Cmd {
+	f := m.tags
+	var cmd tea.Cmd
+	edit := func(v string) {
+		f.values[f.cur], f.err = v, ""
+		if f.cur < len(f.dirty) {
+			f.dirty[f.cur] = true
+		}
+	}
+	switch msg.String() {
+	case "esc":
+		m.tags = nil
+	case "up", "shift+tab":
+		if f.cur > 0 {
+			f.cur--
+		}
+	case "down", "tab":
+		if f.cur < f.patternRow() {
+			f.cur++
+		}
+	case "backspace":
+		if r := []rune(f.values[f.cur]); len(r) > 0 {
+			edit(string(r[:len(r)-1]))
+		}
+	case "ctrl+u":
+		edit("")
+	case "enter":
+		changes, err := f.changes()
+		if err != nil {
+			f.err = err.Error()
+			break
+		}
+		files := f.files
+		m.tags = nil
+		m.setNotice(fmt.Sprintf("writing tags to %d file(s)…", len(files)))
+		cmd = func() tea.Msg {
+			var done []string
+			for i, t := range files {
+				if len(changes[i]) == 0 {
+					continue
+				}
+				for k, v := range changes[i] {
+					t.Set(k, v)
+				}
+				if err := t.Save(); err != nil {
+					return tagsSavedMsg{done, fmt.Errorf("%s: %v", filepath.Base(t.Path()), err)}
+				}
+				done = append(done, t.Path())
+			}
+			return tagsSavedMsg{done, nil}
+		}
+	default:
+		if msg.Type == tea.KeyRunes || msg.String() == " " {
+			edit(f.values[f.cur] + string(msg.Runes))
+		}
+	}
+	m.refresh()
+	if m.tags != nil {
+		m.fmCur = f.cur
+		if f.cur == f.patternRow() {
+			m.fmCur++
+		}
+		m.sync()
+	}
+	return cmd
+}
+
+// onTagsSaved refreshes the index entries of the rewritten files.
+func (m *model) onTagsSaved(msg tagsSavedMsg) {
+	for _, p := range msg.paths {
+		delete(m.plMeta, p)
+		if t, ok := m.library.Tracks[p]; ok {
+			t.Meta = libindex.ReadMeta(p)
+			if fi, err := os.Stat(p); err == nil {
+				t.Size, t.ModTime = fi.Size(), fi.ModTime().Unix()
+			}
+			m.library.Tracks[p] = t
+		}
+	}
+	if len(msg.paths) > 0 {
+		m.saveLibrary()
+	}
+	if msg.err != nil {
+		m.setNotice("tags not written: " + msg.err.Error())
+		return
+	}
+	m.setNotice(fmt.Sprintf("tags written to %d file(s)", len(msg.paths)))
+	m.marked = map[string]bool{}
+}
+
+var nameTokens = map[byte]string{'t': "title", 'a': "artist", 'b': "album", 'A': "albumartist",
+	'n': "track", 'd': "disc", 'y': "year", 'g': "genre"}
+
+// compileNamePattern turns a pattern such as "%n - %t" or "%a/%b/%n %t"
+// into a regexp over the end of the path, without the extension.
+func compileNamePattern(pat string) (*regexp.Regexp, []string, error) {
+	var sb strings.Builder
+	var fields []string
+	sb.WriteString(`(?:^|/)`)
+	for i := 0; i < len(pat); i++ {
+		if pat[i] != '%' {
+			sb.WriteString(regexp.QuoteMeta(pat[i : i+1]))
+			continue
+		}
+		if i++; i == len(pat) {
+			return nil, nil, fmt.Errorf("pattern ends in %%")
+		}
+		switch c := pat[i]; {
+		case c == '%':
+			sb.WriteString("%")
+		case c == '*':
+			sb.WriteString(`[^/]*?`)
+		case nameTokens[c] != "":
+			fields = append(fields, nameTokens[c])
+			if c == 'n' || c == 'd' || c == 'y' {
+				sb.WriteString(`(\d+)`)
+			} else {
+				sb.WriteString(`([^/]+?)`)
+			}
+		default:
+			return nil, nil, fmt.Errorf("unknown pattern token %%%c", c)
+		}
+	}
+	if len(fields) == 0 {
+		return nil, nil, fmt.Errorf("pattern has no %%-fields")
+	}
+	sb.WriteString("$")
+	re, err := regexp.Compile(sb.String())
+	return re, fields, err
+}
+
+func matchNamePattern(re *regexp.Regexp, fields []string, path string) (map[string]string, bool) {
+	name := strings.TrimSuffix(filepath.ToSlash(path), filepath.Ext(path))
+	sm := re.FindStringSubmatch(name)
+	if sm == nil {
+		return nil, false
+	}
+	vals := map[string]string{}
+	for i, f := range fields {
+		v := strings.TrimSpace(sm[i+1])
+		if f == "track" || f == "disc" {
+			if n, err := strconv.Atoi(v); err == nil {
+				v = strconv.Itoa(n)
+			}
+		}
+		vals[f] = v
+	}
+	return vals, true
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNamePattern(t *testing.T) {
+	for _, c := range []struct {
+		pat, path string
+		want      map[string]string
+	}{
+		{"%n - %t", "/music/Kino/03 - Группа крови.mp3", map[string]string{"track": "3", "title": "Группа крови"}},
+		{"%n - %t", "/music/Kino/Группа крови.mp3", nil},
+		{"%a/%b/%n %t", "/music/Кино/Группа крови/01 Группа крови.flac", map[string]string{"artist": "Кино", "album": "Группа крови", "track": "1", "title": "Группа крови"}},
+		// the fields stop at folder boundaries
+		{"%a/%b/%n %t", "/music/Кино/1988/Группа крови/07 Кукушка.mp3", map[string]string{"artist": "1988", "album": "Группа крови", "track": "7", "title": "Кукушка"}},
+		{"%a - %t", "Аквариум - Город золотой (live).ogg", map[string]string{"artist": "Аквариум", "title": "Город золотой (live)"}},
+		{"%y %* - %t", "/m/1986 Аквариум - Город золотой.mp3", map[string]string{"year": "1986", "title": "Город золотой"}},
+		{"100%% %t", "/m/100% Кино.mp3", map[string]string{"title": "Кино"}},
+	} {
+		re, fields, err := compileNamePattern(c.pat)
+		if err != nil {
+			t.Fatalf("%q: %v", c.pat, err)
+		}
+		got, ok := matchNamePattern(re, fields, c.path)
+		if ok != (c.want != nil) || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%q on %s = %v %v, want %v", c.pat, c.path, got, ok, c.want)
+		}
+	}
+
+	for _, pat := range []string{"%t - %", "%q", "just text", ""} {
+		if _, _, err := compileNamePattern(pat); err == nil {
+			t.Errorf("pattern %q accepted", pat)
+		}
+	}
+}
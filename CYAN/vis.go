@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// The VU meter reads the levels of the audio frame mpv just played: an
+// astats filter in the af chain tags every frame with its RMS and peak, and
+// mpv exposes those tags as af-metadata/<label>.
+const (
+	visLabel      = "cyanvis"
+	visFilter     = "@" + visLabel + ":lavfi=[astats=metadata=1:reset=1]"
+	visFloorDB    = -60.0
+	visDefaultFPS = 20
+	visPeakHold   = time.Second
+	visFall       = 1.5 // share of the scale a bar may drop per second
+)
+
+type visMsg struct {
+	rms, peak [2]float64
+	idle      bool
+	at        time.Time
+}
+
+type vuMeter struct {
+	every       time.Duration
+	level, peak [2]float64
+	peakAt      [2]time.Time
+	last        time.Time
+}
+
+func newVUMeter(cfg Config) *vuMeter {
+	if cfg.Visualiser != "vu" {
+		return nil
+	}
+	fps := cfg.VisFPS
+	if fps <= 0 {
+		fps = visDefaultFPS
+	}
+	if fps > 60 {
+		fps = 60
+	}
+	return &vuMeter{every: time.Second / time.Duration(fps)}
+}
+
+func enableVis(p playerBackend) {
+	p.setProp("af", visFilter)
+}
+
+// visScale maps dBFS onto 0..1 above visFloorDB.
+func visScale(s string) float64 {
+	db, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || db <= visFloorDB {
+		return 0
+	}
+	if db >= 0 {
+		return 1
+	}
+	return 1 - db/visFloorDB
+}
+
+func readLevels(p playerBackend) visMsg {
+	var v visMsg
+	if p.getProp("core-idle") != "no" {
+		v.idle = true
+		return v
+	}
+	key := "af-metadata/" + visLabel + "/lavfi.astats."
+	for ch := 0; ch < 2; ch++ {
+		n := strconv.Itoa(ch + 1)
+		rms := p.getProp(key + n + ".RMS_level")
+		if rms == "" && ch == 1 {
+			// mono: both bars show the one channel
+			v.rms[1], v.peak[1] = v.rms[0], v.peak[0]
+			break
+		}
+		v.rms[ch] = visScale(rms)
+		v.peak[ch] = visScale(p.getProp(key + n + ".Peak_level"))
+	}
+	return v
+}
+
+// visTick samples the levels off the update loop, once per frame.
+func (m *model) visTick() tea.Cmd {
+	if m.vis == nil || m.player == nil {
+		return nil
+	}
+	p := m.player
+	return tea.Tick(m.vis.every, func(t time.Time) tea.Msg {
+		v := readLevels(p)
+		v.at = t
+		return v
+	})
+}
+
+// update lets bars jump up at once and fall back at visFall, and holds
+// the peak marks for visPeakHold.
+func (v *vuMeter) update(msg visMsg) {
+	dt := msg.at.Sub(v.last).Seconds()
+	if v.last.IsZero() || dt > 1 {
+		dt = 1
+	}
+	v.last = msg.at
+	for ch := range v.level {
+		if fall := v.level[ch] - visFall*dt; msg.rms[ch] < fall {
+			v.level[ch] = fall
+		} else {
+			v.level[ch] = msg.rms[ch]
+		}
+		if v.level[ch] < 0 {
+			v.level[ch] = 0
+		}
+		if msg.peak[ch] >= v.peak[ch] {
+			v.peak[ch], v.peakAt[ch] = msg.peak[ch], msg.at
+		} else if msg.at.Sub(v.peakAt[ch]) > visPeakHold {
+			v.peak[ch] -= visFall * dt
+			if v.peak[ch] < v.level[ch] {
+				v.peak[ch] = v.level[ch]
+			}
+		}
+	}
+}
+
+func (m *model) visRows() int {
+	if m.vis == nil {
+		return 0
+	}
+	return 2
+}
+
+func (m *model) renderVis() string {
+	if m.vis == nil {
+		return ""
+	}
+	width := m.lineWidth() - 4
+	hot, warm, dim := m.styles.Hot, m.styles.Warm, m.styles.Faint
+	var rows []string
+	for ch, name := range []string{"L", "R"} {
+		fill := int(m.vis.level[ch]*float64(width) + 0.5)
+		mark := int(m.vis.peak[ch]*float64(width)+0.5) - 1
+		var sb, run strings.Builder
+		var cur lipgloss.Style
+		for i := 0; i < width; i++ {
+			c, st := "▮", dim
+			if i < fill || i == mark {
+				switch {
+				case i >= width*9/10:
+					st = hot
+				case i >= width*3/4:
+					st = warm
+				default:
+					st = m.styles.Neon
+				}
+			}
+			if i == mark && i >= fill {
+				c = "▏"
+			}
+			if i > 0 && st.GetForeground() != cur.GetForeground() {
+				sb.WriteString(cur.Render(run.String()))
+				run.Reset()
+			}
+			cur = st
+			run.WriteString(c)
+		}
+		sb.WriteString(cur.Render(run.String()))
+		rows = append(rows, fmt.Sprintf(" %s %s", m.styles.Help.Render(name), sb.String()))
+	}
+	return strings.Join(rows, "\n")
+}
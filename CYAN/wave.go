@@ -0,0 +1,397 @@
+package main
+
+/*
+#cgo pkg-config: mpv
+#include <mpv/client.h>
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/totiks2012/Cyan_audio_player/internal/stream"
+)
+
+const (
+	waveBuckets  = 100
+	waveRate     = 4000
+	waveTimeout  = 2 * time.Minute
+	probeTimeout = 10 * time.Second
+	waveLevels   = "▁▂▃▄▅▆▇█"
+
+	waveCacheFile = ".cyan_waves.json"
+	waveCacheMax  = 5000
+)
+
+// A waveform is waveBuckets digits '0'..'7', the loudness of each slice of
+// the track; it is kept in the wave cache, apart from the library index
+// cy shares.
+type waveMsg struct {
+	path, wave string
+	err        error
+}
+
+type cachedWave struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"`
+	Wave    string `json:"wave"`
+}
+
+// waveCache holds the decoded waveforms, valid while the file keeps its
+// size and mtime. limit is the size at which stale entries are next looked
+// for.
+type waveCache struct {
+	file  string
+	waves map[string]cachedWave
+	limit int
+}
+
+func loadWaveCache(file string) *waveCache {
+	c := &waveCache{file: file, waves: map[string]cachedWave{}}
+	if d, err := os.ReadFile(file); err == nil {
+		_ = json.Unmarshal(d, &c.waves)
+	}
+	return c
+}
+
+func (c *waveCache) get(path string) string {
+	w, ok := c.waves[path]
+	if !ok {
+		return ""
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Size() != w.Size || fi.ModTime().Unix() != w.ModTime {
+		return ""
+	}
+	return w.Wave
+}
+
+// put stores the waveform of path; once the cache grows past its limit the
+// entries of files that are gone or changed are dropped, and the limit
+// doubles over what is left so the files are not all checked again on the
+// next put.
+func (c *waveCache) put(path, wave string) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	c.waves[path] = cachedWave{fi.Size(), fi.ModTime().Unix(), wave}
+	if len(c.waves) > max(c.limit, waveCacheMax) {
+		for p := range c.waves {
+			if c.get(p) == "" {
+				delete(c.waves, p)
+			}
+		}
+		c.limit = 2 * len(c.waves)
+	}
+	d, _ := json.Marshal(c.waves)
+	tmp := c.file + ".tmp"
+	if os.WriteFile(tmp, d, 0644) == nil {
+		_ = os.Rename(tmp, c.file)
+	}
+}
+
+// decodeWave runs the file through a second, silent mpv that writes mono
+// 16-bit PCM to a temporary WAV as fast as it can decode.
+func decodeWave(path string) (string, error) {
+	tmp, err := os.CreateTemp("", "cyan-wave-*.wav")
+	if err != nil {
+		return "", err
+	}
+	name := tmp.Name()
+	tmp.Close()
+	defer os.Remove(name)
+	if err := decodePCM(path, name); err != nil {
+		return "", err
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	n, err := wavData(f)
+	if err != nil {
+		return "", err
+	}
+	return wavePeaks(bufio.NewReader(io.LimitReader(f, n)), n/2), nil
+}
+
+// silentMPV starts an mpv that reads no config and shows nothing, with
+// opts on top, and loads path into it.
+func silentMPV(path string, opts [][2]string) (*C.mpv_handle, error) {
+	ctx := C.mpv_create()
+	if ctx == nil {
+		return nil, errors.New("mpv_create failed")
+	}
+	for _, o := range append([][2]string{
+		{"terminal", "no"}, {"config", "no"}, {"video", "no"}, {"load-scripts", "no"},
+		{"resume-playback", "no"}, {"save-position-on-quit", "no"}, {"ytdl", "no"},
+	}, opts...) {
+		cn, cv := C.CString(o[0]), C.CString(o[1])
+		C.mpv_set_option_string(ctx, cn, cv)
+		C.free(unsafe.Pointer(cn))
+		C.free(unsafe.Pointer(cv))
+	}
+	if int(C.mpv_initialize(ctx)) < 0 {
+		C.mpv_terminate_destroy(ctx)
+		return nil, errors.New("mpv_initialize failed")
+	}
+	args := []*C.char{C.CString("loadfile"), C.CString(path), nil}
+	C.mpv_command(ctx, &args[0])
+	C.free(unsafe.Pointer(args[0]))
+	C.free(unsafe.Pointer(args[1]))
+	return ctx, nil
+}
+
+// decodePCM returns once the WAV is complete: mpv only finishes the header
+// when the instance is destroyed.
+func decodePCM(path, out string) error {
+	ctx, err := silentMPV(path, [][2]string{
+		{"ao", "pcm"}, {"ao-pcm-file", out}, {"ao-pcm-waveheader", "yes"}, {"untimed", "yes"},
+		{"audio-channels", "mono"}, {"audio-format", "s16"}, {"audio-samplerate", strconv.Itoa(waveRate)},
+	})
+	if err != nil {
+		return err
+	}
+	defer C.mpv_terminate_destroy(ctx)
+
+	deadline := time.Now().Add(waveTimeout)
+	for {
+		ev := C.mpv_wait_event(ctx, 1)
+		switch ev.event_id {
+		case C.MPV_EVENT_END_FILE:
+			ef := (*C.mpv_event_end_file)(ev.data)
+			if stream.EndReason(ef.reason) == stream.EndError {
+				return errors.New(C.GoString(C.mpv_error_string(ef.error)))
+			}
+			return nil
+		case C.MPV_EVENT_SHUTDOWN:
+			return errors.New("mpv shut down")
+		}
+		if time.Now().After(deadline) {
+			return errors.New("decoding timed out")
+		}
+	}
+}
+
+// probeDuration opens the file paused in a silent mpv just long enough to
+// read its length.
+func probeDuration(path string) (float64, error) {
+	ctx, err := silentMPV(path, [][2]string{{"ao", "null"}, {"pause", "yes"}})
+	if err != nil {
+		return 0, err
+	}
+	defer C.mpv_terminate_destroy(ctx)
+	deadline := time.Now().Add(probeTimeout)
+	for time.Now().Before(deadline) {
+		ev := C.mpv_wait_event(ctx, 1)
+		switch ev.event_id {
+		case C.MPV_EVENT_FILE_LOADED:
+			cn := C.CString("duration")
+			cv := C.mpv_get_property_string(ctx, cn)
+			C.free(unsafe.Pointer(cn))
+			if cv == nil {
+				return 0, errors.New("no duration")
+			}
+			defer C.mpv_free(unsafe.Pointer(cv))
+			return strconv.ParseFloat(C.GoString(cv), 64)
+		case C.MPV_EVENT_END_FILE, C.MPV_EVENT_SHUTDOWN:
+			return 0, errors.New("cannot open " + path)
+		}
+	}
+	return 0, errors.New("probe timed out")
+}
+
+// wavData skips to the data chunk of a RIFF file and returns its length.
+func wavData(f *os.File) (int64, error) {
+	var hdr [12]byte
+	if _, err := io.ReadFull(f, hdr[:]); err != nil || string(hdr[:4]) != "RIFF" || string(hdr[8:]) != "WAVE" {
+		return 0, errors.New("not a WAV file")
+	}
+	for {
+		var ch [8]byte
+		if _, err := io.ReadFull(f, ch[:]); err != nil {
+			return 0, errors.New("no data chunk")
+		}
+		size := int64(binary.LittleEndian.Uint32(ch[4:]))
+		if string(ch[:4]) == "data" {
+			if fi, err := f.Stat(); err == nil {
+				pos, _ := f.Seek(0, io.SeekCurrent)
+				if rest := fi.Size() - pos; size == 0 || size == 0xFFFFFFFF || size > rest {
+					size = rest
+				}
+			}
+			return size, nil
+		}
+		if _, err := f.Seek(size+size&1, io.SeekCurrent); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// wavePeaks reads little-endian s16 samples and quantises the RMS of
+// each bucket against the loudest one.
+func wavePeaks(r io.Reader, samples int64) string {
+	if samples <= 0 {
+		return ""
+	}
+	var sum [waveBuckets]float64
+	var cnt [waveBuckets]int64
+	var b [2]byte
+	for i := int64(0); i < samples; i++ {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			break
+		}
+		s := float64(int16(binary.LittleEndian.Uint16(b[:]))) / 32768
+		k := i * waveBuckets / samples
+		sum[k] += s * s
+		cnt[k]++
+	}
+	var rms [waveBuckets]float64
+	max := 0.0
+	for k := range rms {
+		if cnt[k] > 0 {
+			rms[k] = math.Sqrt(sum[k] / float64(cnt[k]))
+		}
+		if rms[k] > max {
+			max = rms[k]
+		}
+	}
+	top := len([]rune(waveLevels)) - 1
+	var sb strings.Builder
+	for _, v := range rms {
+		l := 0
+		if max > 0 {
+			l = int(v/max*float64(top) + 0.5)
+		}
+		sb.WriteByte(byte('0' + l))
+	}
+	return sb.String()
+}
+
+// waveCmd decodes the waveform of the current track unless the wave cache
+// already has it.
+func (m *model) waveCmd() tea.Cmd {
+	p := m.currentPath()
+	if p == "" || isURL(p) || m.curDur <= 0 || m.wave == p || m.waveBusy {
+		return nil
+	}
+	if w := m.waves.get(p); w != "" {
+		m.wave, m.waveData = p, w
+		return nil
+	}
+	if m.waveFail[p] {
+		return nil
+	}
+	m.waveBusy = true
+	return func() tea.Msg {
+		w, err := decodeWave(p)
+		return waveMsg{p, w, err}
+	}
+}
+
+func (m *model) onWave(msg waveMsg) {
+	m.waveBusy = false
+	if msg.err != nil || msg.wave == "" {
+		if m.waveFail == nil {
+			m.waveFail = map[string]bool{}
+		}
+		m.waveFail[msg.path] = true
+		return
+	}
+	m.waves.put(msg.path, msg.wave)
+	if msg.path == m.currentPath() {
+		m.wave, m.waveData = msg.path, msg.wave
+	}
+}
+
+// renderWaveBar draws the waveform squeezed into width columns, the played
+// part in color.
+func renderWaveBar(width int, wave string, cur, total float64, st UIStyles) string {
+	levels := []rune(waveLevels)
+	played := 0
+	if total > 0 {
+		played = int(cur / total * float64(width))
+	}
+	var a, b strings.Builder
+	for x := 0; x < width; x++ {
+		lo, hi := x*len(wave)/width, (x+1)*len(wave)/width
+		if hi <= lo {
+			hi = lo + 1
+		}
+		l := byte('0')
+		for _, c := range []byte(wave[lo:hi]) {
+			if c > l {
+				l = c
+			}
+		}
+		r := levels[int(l-'0')%len(levels)]
+		if x < played {
+			a.WriteRune(r)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return st.Neon.Render(a.String()) + st.BarEmpty.Render(b.String())
+}
+
+// seekBarAt maps a click on the bar to a share of the track; ok is false
+// when x,y is not on it.
+func (m *model) seekBarAt(x, y int) (float64, bool) {
+	if !m.geo.bar.has(x, y) || m.curDur <= 0 {
+		return 0, false
+	}
+	return m.barShare(x), true
+}
+
+// barShare is the share of the track under column x, also while dragging
+// past the ends.
+func (m *model) barShare(x int) float64 {
+	if m.geo.bar.w < 2 {
+		return 0
+	}
+	return float64(x-m.geo.bar.x) / float64(m.geo.bar.w-1)
+}
+
+func (m *model) seekPercent(p float64) {
+	if p < 0 {
+		p = 0
+	}
+	if p > 100 {
+		p = 100
+	}
+	m.player.Command("seek", strconv.FormatFloat(p, 'f', 2, 64), "absolute-percent")
+	m.curPos = m.curDur * p / 100
+}
+
+// jumpKey edits the "go to %" prompt.
+func (m *model) jumpKey(msg tea.KeyMsg) {
+	switch msg.String() {
+	case "esc":
+		m.jumpMode = false
+	case "enter":
+		m.jumpMode = false
+		if p, err := strconv.ParseFloat(m.jumpInput, 64); err == nil {
+			m.seekPercent(p)
+		}
+	case "backspace":
+		if n := len(m.jumpInput); n > 0 {
+			m.jumpInput = m.jumpInput[:n-1]
+		}
+	default:
+		if s := msg.String(); len(s) == 1 && (s[0] >= '0' && s[0] <= '9' || s == "." && !strings.Contains(m.jumpInput, ".")) && len(m.jumpInput) < 5 {
+			m.jumpInput += s
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func pcm(samples ...int16) []byte {
+	var b bytes.Buffer
+	_ = binary.Write(&b, binary.LittleEndian, samples)
+	return b.Bytes()
+}
+
+func TestWavePeaks(t *testing.T) {
+	if got := wavePeaks(bytes.NewReader(nil), 0); got != "" {
+		t.Errorf("no samples gave %q", got)
+	}
+	if got := wavePeaks(bytes.NewReader(pcm(make([]int16, 400)...)), 400); got != strings.Repeat("0", waveBuckets) {
+		t.Errorf("silence gave %q", got)
+	}
+
+	// a quiet first half and a loud second half, four samples a bucket
+	s := make([]int16, 4*waveBuckets)
+	for i := range s {
+		v := int16(1000)
+		if i >= len(s)/2 {
+			v = 28000
+		}
+		if i%2 == 1 {
+			v = -v
+		}
+		s[i] = v
+	}
+	want := strings.Repeat("0", waveBuckets/2) + strings.Repeat("7", waveBuckets/2)
+	if got := wavePeaks(bytes.NewReader(pcm(s...)), int64(len(s))); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+
+	// a short read leaves the missing buckets empty
+	got := wavePeaks(bytes.NewReader(pcm(s[len(s)/2:]...)), int64(len(s)))
+	if got != strings.Repeat("7", waveBuckets/2)+strings.Repeat("0", waveBuckets/2) {
+		t.Errorf("short read gave %s", got)
+	}
+}
+
+func writeWAV(t *testing.T, chunks ...[]byte) *os.File {
+	t.Helper()
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	_ = binary.Write(&b, binary.LittleEndian, uint32(0))
+	b.WriteString("WAVE")
+	for _, c := range chunks {
+		b.Write(c)
+	}
+	name := filepath.Join(t.TempDir(), "test.wav")
+	if err := os.WriteFile(name, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func chunk(id string, size uint32, body []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString(id)
+	_ = binary.Write(&b, binary.LittleEndian, size)
+	b.Write(body)
+	return b.Bytes()
+}
+
+func TestWavData(t *testing.T) {
+	data := pcm(1, 2, 3, 4, 5)
+	want := wavePeaks(bytes.NewReader(data), 5)
+	for _, c := range []struct {
+		name   string
+		chunks [][]byte
+		want   int64
+	}{
+		{"plain", [][]byte{chunk("fmt ", 16, make([]byte, 16)), chunk("data", 10, data)}, 10},
+		// odd chunks are padded to an even size
+		{"odd chunk before data", [][]byte{chunk("LIST", 3, []byte{1, 2, 3, 0}), chunk("data", 10, data)}, 10},
+		// mpv writes an unknown length while it streams
+		{"streaming length", [][]byte{chunk("data", 0xFFFFFFFF, data)}, 10},
+		{"zero length", [][]byte{chunk("data", 0, data)}, 10},
+		{"truncated", [][]byte{chunk("data", 1000, data)}, 10},
+	} {
+		f := writeWAV(t, c.chunks...)
+		n, err := wavData(f)
+		if err != nil || n != c.want {
+			t.Errorf("%s: %d, %v; want %d", c.name, n, err, c.want)
+			continue
+		}
+		// the file is left at the first sample
+		if got := wavePeaks(f, n/2); got != want {
+			t.Errorf("%s: peaks %s, want %s", c.name, got, want)
+		}
+	}
+
+	if _, err := wavData(writeWAV(t, chunk("fmt ", 16, make([]byte, 16)))); err == nil {
+		t.Error("a file without data chunk was accepted")
+	}
+	name := filepath.Join(t.TempDir(), "song.mp3")
+	if err := os.WriteFile(name, []byte("ID3\x03not a wav file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := wavData(f); err == nil {
+		t.Error("a non-RIFF file was accepted")
+	}
+}
+
+func TestWaveCache(t *testing.T) {
+	dir := t.TempDir()
+	song := filepath.Join(dir, "outside.mp3")
+	if err := os.WriteFile(song, []byte("audio"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "waves.json")
+	c := loadWaveCache(file)
+	if c.get(song) != "" {
+		t.Fatal("empty cache has a wave")
+	}
+	c.put(song, "01234567")
+	if got := loadWaveCache(file).get(song); got != "01234567" {
+		t.Errorf("saved wave %q", got)
+	}
+
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(song, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.get(song); got != "" {
+		t.Errorf("changed file still has wave %q", got)
+	}
+	_ = os.Remove(song)
+	if got := c.get(song); got != "" {
+		t.Errorf("deleted file still has wave %q", got)
+	}
+}
@@ -0,0 +1,132 @@
+// Package cmdline splits, quotes and completes the command lines of the
+// : prompt in both players.
+package cmdline
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Split splits a command line into words; quotes keep spaces and a
+// backslash escapes the next character.
+func Split(s string) ([]string, error) {
+	var out []string
+	var cur strings.Builder
+	in, quote, esc := false, rune(0), false
+	for _, r := range s {
+		switch {
+		case esc:
+			cur.WriteRune(r)
+			esc, in = false, true
+		case r == '\\' && quote != '\'':
+			esc = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote, in = r, true
+		case r == ' ' || r == '\t':
+			if in {
+				out = append(out, cur.String())
+				cur.Reset()
+				in = false
+			}
+		default:
+			cur.WriteRune(r)
+			in = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+	if in || esc {
+		out = append(out, cur.String())
+	}
+	return out, nil
+}
+
+// Quote quotes s so Split gives it back as one word.
+func Quote(s string) string {
+	if s == "" || strings.ContainsAny(s, " \t\"'\\") {
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+	}
+	return s
+}
+
+// ExpandHome expands a leading ~/ to the home folder.
+func ExpandHome(p string) string {
+	if strings.HasPrefix(p, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, p[2:])
+		}
+	}
+	return p
+}
+
+// CompleteWords is the words that start with prefix.
+func CompleteWords(words []string, prefix string) []string {
+	var out []string
+	for _, w := range words {
+		if strings.HasPrefix(w, prefix) {
+			out = append(out, w)
+		}
+	}
+	return out
+}
+
+// CompleteFiles lists the entries of arg's folder (relative to cwd) that
+// start with its last element; folders end in a slash.
+func CompleteFiles(cwd, arg string, dirsOnly bool) []string {
+	dir, prefix := filepath.Split(arg)
+	look := ExpandHome(dir)
+	if look == "" {
+		look = "."
+	}
+	if !filepath.IsAbs(look) {
+		look = filepath.Join(cwd, look)
+	}
+	entries, _ := os.ReadDir(look)
+	var out []string
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, prefix) || strings.HasPrefix(name, ".") && !strings.HasPrefix(prefix, ".") {
+			continue
+		}
+		isDir := e.IsDir()
+		if e.Type()&os.ModeSymlink != 0 {
+			if fi, err := os.Stat(filepath.Join(look, name)); err == nil {
+				isDir = fi.IsDir()
+			}
+		}
+		if isDir {
+			out = append(out, dir+name+"/")
+		} else if !dirsOnly {
+			out = append(out, dir+name)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// CommonPrefix is the longest prefix all of ss share, cut at a rune
+// boundary so it stays valid UTF-8.
+func CommonPrefix(ss []string) string {
+	if len(ss) == 0 {
+		return ""
+	}
+	p := ss[0]
+	for _, s := range ss[1:] {
+		for !strings.HasPrefix(s, p) {
+			_, n := utf8.DecodeLastRuneInString(p)
+			p = p[:len(p)-n]
+		}
+	}
+	return p
+}
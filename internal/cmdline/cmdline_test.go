@@ -0,0 +1,97 @@
+package cmdline
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplit(t *testing.T) {
+	for _, c := range []struct {
+		in   string
+		want []string
+		err  bool
+	}{
+		{"", nil, false},
+		{"  next  ", []string{"next"}, false},
+		{"add ~/Music/a.mp3 b.mp3", []string{"add", "~/Music/a.mp3", "b.mp3"}, false},
+		{`add "My Music/x.mp3"`, []string{"add", "My Music/x.mp3"}, false},
+		{`add 'it''s'`, []string{"add", "its"}, false},
+		{`add My\ Music`, []string{"add", "My Music"}, false},
+		{`add 'a\b'`, []string{"add", `a\b`}, false},
+		{`add "a\"b"`, []string{"add", `a"b`}, false},
+		{`add ""`, []string{"add", ""}, false},
+		{"cd ~/Музыка/Кино\tplay", []string{"cd", "~/Музыка/Кино", "play"}, false},
+		{`add "open`, nil, true},
+	} {
+		got, err := Split(c.in)
+		if (err != nil) != c.err {
+			t.Errorf("Split(%q) error %v", c.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Split(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestQuoteRoundTrip(t *testing.T) {
+	for _, s := range []string{"plain", "", "with space", `back\slash`, `"quoted"`, "it's", "Группа крови"} {
+		got, err := Split("x " + Quote(s))
+		if err != nil || len(got) != 2 || got[1] != s {
+			t.Errorf("Quote(%q) = %s, splits back to %q (%v)", s, Quote(s), got, err)
+		}
+	}
+}
+
+func TestCommonPrefix(t *testing.T) {
+	for _, c := range []struct {
+		in   []string
+		want string
+	}{
+		{nil, ""},
+		{[]string{"abc"}, "abc"},
+		{[]string{"album/", "alarm/"}, "al"},
+		{[]string{"Ария/", "Арбат/"}, "Ар"},
+		{[]string{"Кино", "Ки"}, "Ки"},
+		{[]string{"ё", "е"}, ""},
+		{[]string{"x", "y"}, ""},
+	} {
+		got := CommonPrefix(c.in)
+		if got != c.want || !utf8.ValidString(got) {
+			t.Errorf("CommonPrefix(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestCompleteFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, d := range []string{"Ария", "Арбат", ".hidden"} {
+		if err := os.Mkdir(filepath.Join(dir, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "Ария", "01.mp3"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := CompleteFiles(dir, "Ар", false), []string{"Арбат/", "Ария/"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("CompleteFiles = %q, want %q", got, want)
+	}
+	if got, want := CompleteFiles(dir, "Ария/", false), []string{"Ария/01.mp3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("CompleteFiles = %q, want %q", got, want)
+	}
+	if got := CompleteFiles(dir, "Ария/", true); got != nil {
+		t.Errorf("dirsOnly listed %q", got)
+	}
+	if got, want := CompleteFiles(dir, ".h", true), []string{".hidden/"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("hidden = %q, want %q", got, want)
+	}
+}
+
+func TestCompleteWords(t *testing.T) {
+	if got, want := CompleteWords([]string{"next", "neon", "prev"}, "ne"), []string{"next", "neon"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("CompleteWords = %q, want %q", got, want)
+	}
+}
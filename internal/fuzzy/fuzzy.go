@@ -0,0 +1,194 @@
+// Package fuzzy is the matcher behind search and find in both players.
+//
+// It scores the way fzf does: every rune of a term has to appear
+// in order, runs of adjacent runes and matches at the start of a word count
+// for more, and gaps cost a little. Words of the query are matched
+// separately and all of them have to hit.
+package fuzzy
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	scoreHit       = 16
+	scoreGapStart  = -3
+	scoreGapExtend = -1
+	bonusBoundary  = 8
+	bonusCamel     = 7
+	bonusAdjacent  = 4
+	firstTimes     = 2
+)
+
+// bonus is what a match at r[i] is worth beyond the hit itself.
+func bonus(r []rune, i int) int {
+	if i == 0 {
+		return bonusBoundary
+	}
+	prev, cur := r[i-1], r[i]
+	switch {
+	case unicode.IsSpace(prev) || strings.ContainsRune("-_./\\()[]{},:;&+", prev):
+		return bonusBoundary
+	case unicode.IsLower(prev) && unicode.IsUpper(cur), !unicode.IsDigit(prev) && unicode.IsDigit(cur):
+		return bonusCamel
+	}
+	return 0
+}
+
+func foldRunes(s string) []rune {
+	r := []rune(s)
+	for i, c := range r {
+		r[i] = unicode.ToLower(c)
+	}
+	return r
+}
+
+// matchTerm matches one word against text: the first place it fits, then
+// tightened from its end backwards so "ab" in "a_xab" takes the later "a".
+func matchTerm(text, folded, term []rune) (int, []int, bool) {
+	qi, end := 0, -1
+	for i := 0; i < len(folded) && qi < len(term); i++ {
+		if folded[i] == term[qi] {
+			if qi++; qi == len(term) {
+				end = i
+			}
+		}
+	}
+	if end < 0 {
+		return 0, nil, false
+	}
+	start := end
+	for i, qi := end, len(term)-1; i >= 0 && qi >= 0; i-- {
+		if folded[i] == term[qi] {
+			start, qi = i, qi-1
+		}
+	}
+	score, pos, qi, gap := 0, make([]int, 0, len(term)), 0, 0
+	for i := start; i <= end && qi < len(term); i++ {
+		if folded[i] != term[qi] {
+			if gap++; gap == 1 {
+				score += scoreGapStart
+			} else {
+				score += scoreGapExtend
+			}
+			continue
+		}
+		b := bonus(text, i)
+		if len(pos) > 0 && pos[len(pos)-1] == i-1 && b < bonusAdjacent {
+			b = bonusAdjacent
+		}
+		if qi == 0 {
+			b *= firstTimes
+		}
+		score += scoreHit + b
+		pos, qi, gap = append(pos, i), qi+1, 0
+	}
+	return score, pos, true
+}
+
+// Match scores query against s, case-insensitively; pos are the rune
+// indexes of s to highlight. ok is false when a word of query is missing.
+func Match(s, query string) (score int, pos []int, ok bool) {
+	text, folded := []rune(s), foldRunes(s)
+	for _, w := range strings.Fields(query) {
+		sc, p, hit := matchTerm(text, folded, foldRunes(w))
+		if !hit {
+			return 0, nil, false
+		}
+		score += sc
+		pos = append(pos, p...)
+	}
+	sort.Ints(pos)
+	return score, pos, true
+}
+
+// Result is an item that matched; pos highlight its first text.
+type Result struct {
+	Index int
+	Score int
+	Pos   []int
+}
+
+// Rank matches query against n items and returns the hits, best
+// first and otherwise in their own order. texts(i) gives an item's shown
+// name first and then what else it can be found by, such as its tags:
+// each word has to hit one of them, and only hits in the name are shown.
+func Rank(n int, query string, texts func(i int) []string) []Result {
+	words := strings.Fields(query)
+	var out []Result
+	for i := 0; i < n; i++ {
+		fields := texts(i)
+		if len(fields) == 0 {
+			continue
+		}
+		res := Result{Index: i}
+		ok := true
+		for _, w := range words {
+			best, hit := 0, false
+			for f, s := range fields {
+				sc, pos, m := Match(s, w)
+				if !m || hit && sc <= best {
+					continue
+				}
+				best, hit = sc, true
+				if f == 0 {
+					res.Pos = append(res.Pos, pos...)
+				}
+			}
+			if !hit {
+				ok = false
+				break
+			}
+			res.Score += best
+		}
+		if ok {
+			sort.Ints(res.Pos)
+			out = append(out, res)
+		}
+	}
+	sort.SliceStable(out, func(a, b int) bool { return out[a].Score > out[b].Score })
+	return out
+}
+
+// Span is a run of text that is either all highlighted or not at all.
+type Span struct {
+	Text string
+	Hit  bool
+}
+
+// Spans cuts s at the highlighted rune positions.
+func Spans(s string, pos []int) []Span {
+	hit := make(map[int]bool, len(pos))
+	for _, p := range pos {
+		hit[p] = true
+	}
+	var out []Span
+	var cur []rune
+	on := false
+	for i, r := range []rune(s) {
+		if hit[i] != on && len(cur) > 0 {
+			out = append(out, Span{string(cur), on})
+			cur = cur[:0]
+		}
+		on = hit[i]
+		cur = append(cur, r)
+	}
+	if len(cur) > 0 {
+		out = append(out, Span{string(cur), on})
+	}
+	return out
+}
+
+// ShiftPos moves highlight positions by off runes and drops those that
+// fall outside [0, limit).
+func ShiftPos(pos []int, off, limit int) []int {
+	var out []int
+	for _, p := range pos {
+		if p += off; p >= 0 && p < limit {
+			out = append(out, p)
+		}
+	}
+	return out
+}
@@ -0,0 +1,89 @@
+package fuzzy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	for _, c := range []struct {
+		s, query string
+		pos      []int
+		ok       bool
+	}{
+		{"Кино - Звезда по имени Солнце", "звезда", []int{7, 8, 9, 10, 11, 12}, true},
+		{"Кино - Звезда по имени Солнце", "кн сол", []int{0, 2, 23, 24, 25}, true},
+		{"Кино - Звезда по имени Солнце", "луна", nil, false},
+		{"Ария - Штиль.mp3", "ШТИЛЬ", []int{7, 8, 9, 10, 11}, true},
+		{"a_xab", "ab", []int{3, 4}, true},
+		{"anything", "", nil, true},
+	} {
+		_, pos, ok := Match(c.s, c.query)
+		if ok != c.ok || !reflect.DeepEqual(pos, c.pos) {
+			t.Errorf("Match(%q, %q) = %v %v, want %v %v", c.s, c.query, pos, ok, c.pos, c.ok)
+		}
+	}
+}
+
+func TestMatchScore(t *testing.T) {
+	for _, c := range []struct {
+		query, better, worse string
+	}{
+		{"сол", "Солнце", "Рассольник"},
+		{"сол", "Кино/Солнце", "Посол"},
+		{"зв", "Звезда", "Заявка"},
+		{"db", "dbus", "dumb"},
+		{"ar", "ArtistRock", "bArk"},
+	} {
+		a, _, okA := Match(c.better, c.query)
+		b, _, okB := Match(c.worse, c.query)
+		if !okA || !okB || a <= b {
+			t.Errorf("%q: %q scored %d, %q scored %d", c.query, c.better, a, c.worse, b)
+		}
+	}
+}
+
+func TestRank(t *testing.T) {
+	items := [][]string{
+		{"Посол.mp3", "Кино"},
+		{"Солнце.mp3", "Кино"},
+		{"Дождь.mp3", "ДДТ"},
+		{"Восход солнца.mp3", "Кино"},
+	}
+	res := Rank(len(items), "сол кино", func(i int) []string { return items[i] })
+	var got []int
+	for _, r := range res {
+		got = append(got, r.Index)
+	}
+	if want := []int{1, 3, 0}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Rank order %v, want %v", got, want)
+	}
+	// "кино" hits only the tag, so just "сол" is highlighted.
+	if want := []int{0, 1, 2}; !reflect.DeepEqual(res[0].Pos, want) {
+		t.Errorf("Rank highlight %v, want %v", res[0].Pos, want)
+	}
+	if res := Rank(len(items), "", func(i int) []string { return items[i] }); len(res) != len(items) {
+		t.Errorf("an empty query kept %d of %d", len(res), len(items))
+	}
+}
+
+func TestSpans(t *testing.T) {
+	_, pos, _ := Match("Ария - Штиль", "шт")
+	got := Spans("Ария - Штиль", pos)
+	want := []Span{{"Ария - ", false}, {"Шт", true}, {"иль", false}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Spans = %v, want %v", got, want)
+	}
+	if got := Spans("Дождь", nil); !reflect.DeepEqual(got, []Span{{"Дождь", false}}) {
+		t.Errorf("Spans without hits = %v", got)
+	}
+}
+
+func TestShiftPos(t *testing.T) {
+	if got := ShiftPos([]int{0, 2, 5, 9}, 3, 10); !reflect.DeepEqual(got, []int{3, 5, 8}) {
+		t.Errorf("ShiftPos = %v", got)
+	}
+	if got := ShiftPos([]int{0, 1, 4}, -2, 10); !reflect.DeepEqual(got, []int{2}) {
+		t.Errorf("ShiftPos back = %v", got)
+	}
+}
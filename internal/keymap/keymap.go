@@ -0,0 +1,165 @@
+// Package keymap resolves keys and chords to actions for both players.
+//
+// A key is named the way bubbletea prints it: "q", "Q", "ctrl+p", "alt+l",
+// "f2", "up", "enter", "esc", "tab", "space". A binding is one key or a
+// chord of keys separated by spaces ("ctrl+x s"); "<leader>" in a chord
+// stands for the configured leader key.
+package keymap
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DefaultLeader is the leader key when the config names none.
+const DefaultLeader = `\`
+
+// Def is an action with its help text and default keys.
+type Def struct {
+	Action string
+	Help   string
+	Keys   []string
+}
+
+// Map is a set of bindings and the chord typed so far.
+type Map struct {
+	defs    []Def
+	keys    map[string][]string
+	seqs    map[string]string
+	prefix  map[string]bool
+	pending []string
+}
+
+// New applies overrides (action → keys; an empty list unbinds) on
+// top of the defaults. A key taken by an override is dropped from the
+// action that had it by default.
+func New(defs []Def, overrides map[string][]string, leader string) (*Map, []string) {
+	if leader == "" {
+		leader = DefaultLeader
+	}
+	k := &Map{defs: defs, keys: map[string][]string{}, seqs: map[string]string{}, prefix: map[string]bool{}}
+	var warn []string
+	known := map[string]bool{}
+	for _, d := range defs {
+		known[d.Action] = true
+	}
+	taken := map[string]bool{}
+	var names []string
+	for a := range overrides {
+		names = append(names, a)
+	}
+	sort.Strings(names)
+	for _, a := range names {
+		if !known[a] {
+			warn = append(warn, "unknown action "+a)
+			continue
+		}
+		for _, s := range overrides[a] {
+			taken[normalizeChord(s, leader)] = true
+		}
+	}
+	for _, d := range defs {
+		keys, over := overrides[d.Action]
+		for _, s := range keys {
+			if s = normalizeChord(s, leader); s != "" {
+				k.keys[d.Action] = append(k.keys[d.Action], s)
+			}
+		}
+		if over {
+			continue
+		}
+		for _, s := range d.Keys {
+			if s = normalizeChord(s, leader); s != "" && !taken[s] {
+				k.keys[d.Action] = append(k.keys[d.Action], s)
+			}
+		}
+	}
+	for _, d := range defs {
+		for _, s := range k.keys[d.Action] {
+			if other, ok := k.seqs[s]; ok {
+				warn = append(warn, fmt.Sprintf("%s is bound to both %s and %s", s, other, d.Action))
+				continue
+			}
+			k.seqs[s] = d.Action
+			parts := strings.Fields(s)
+			for i := 1; i < len(parts); i++ {
+				k.prefix[strings.Join(parts[:i], " ")] = true
+			}
+		}
+	}
+	for s, a := range k.seqs {
+		if k.prefix[s] {
+			warn = append(warn, fmt.Sprintf("%s (%s) is hidden by a longer chord", s, a))
+		}
+	}
+	sort.Strings(warn)
+	return k, warn
+}
+
+func normalizeChord(s, leader string) string {
+	// a bare space is what bubbletea's KeyMsg.String gives for the key
+	if s == " " {
+		return "space"
+	}
+	var parts []string
+	for _, p := range strings.Fields(s) {
+		if p == "<leader>" {
+			p = leader
+		}
+		if strings.EqualFold(p, "spc") {
+			p = "space"
+		}
+		parts = append(parts, p)
+	}
+	return strings.Join(parts, " ")
+}
+
+// Feed takes one key and returns the action it completes. wait is true
+// while the keys so far are the start of a chord.
+func (k *Map) Feed(key string) (action string, wait bool) {
+	seq := strings.Join(append(append([]string{}, k.pending...), key), " ")
+	if k.prefix[seq] {
+		k.pending = append(k.pending, key)
+		return "", true
+	}
+	if a, ok := k.seqs[seq]; ok {
+		k.pending = nil
+		return a, false
+	}
+	if len(k.pending) > 0 {
+		k.pending = nil
+		return k.Feed(key)
+	}
+	return "", false
+}
+
+// Typed is the unfinished chord, for the status line.
+func (k *Map) Typed() string {
+	return strings.Join(k.pending, " ")
+}
+
+func (k *Map) Reset() {
+	k.pending = nil
+}
+
+// First is the first key of an action, for hints; "" when unbound.
+func (k *Map) First(action string) string {
+	if keys := k.keys[action]; len(keys) > 0 {
+		return keys[0]
+	}
+	return ""
+}
+
+// HelpLines lists every action with its keys in definition order.
+func (k *Map) HelpLines() []string {
+	var out []string
+	for _, d := range k.defs {
+		keys := strings.Join(k.keys[d.Action], " · ")
+		if keys == "" {
+			keys = "—"
+		}
+		out = append(out, fmt.Sprintf("%-12s %-15s %s", keys, d.Action, d.Help))
+	}
+	return out
+}
@@ -0,0 +1,106 @@
+package keymap
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testDefs = []Def{
+	{Action: "play", Help: "play", Keys: []string{"p", " "}},
+	{Action: "pause", Help: "pause", Keys: []string{"P"}},
+	{Action: "save", Help: "save", Keys: []string{"ctrl+x s"}},
+	{Action: "quit", Help: "quit", Keys: []string{"q", "ctrl+x q"}},
+	{Action: "search", Help: "search", Keys: []string{"<leader> f"}},
+}
+
+func TestNew(t *testing.T) {
+	for _, c := range []struct {
+		name      string
+		overrides map[string][]string
+		leader    string
+		keys      map[string][]string
+		warn      []string
+	}{
+		{
+			name: "defaults",
+			keys: map[string][]string{"play": {"p", "space"}, "pause": {"P"}, "save": {"ctrl+x s"}, "quit": {"q", "ctrl+x q"}, "search": {`\ f`}},
+		},
+		{
+			name:      "an override takes the key from its default action",
+			overrides: map[string][]string{"pause": {"p", "spc"}},
+			keys:      map[string][]string{"pause": {"p", "space"}, "save": {"ctrl+x s"}, "quit": {"q", "ctrl+x q"}, "search": {`\ f`}},
+		},
+		{
+			name:      "an empty override unbinds",
+			overrides: map[string][]string{"quit": {}},
+			keys:      map[string][]string{"play": {"p", "space"}, "pause": {"P"}, "save": {"ctrl+x s"}, "search": {`\ f`}},
+		},
+		{
+			name:      "leader",
+			overrides: map[string][]string{"save": {"<leader> s"}},
+			leader:    ",",
+			keys:      map[string][]string{"play": {"p", "space"}, "pause": {"P"}, "save": {", s"}, "quit": {"q", "ctrl+x q"}, "search": {", f"}},
+		},
+		{
+			name:      "warnings",
+			overrides: map[string][]string{"pause": {"x", "ctrl+x"}, "play": {"x"}, "stop": {"s"}},
+			keys:      map[string][]string{"play": {"x"}, "pause": {"x", "ctrl+x"}, "save": {"ctrl+x s"}, "quit": {"q", "ctrl+x q"}, "search": {`\ f`}},
+			warn:      []string{"ctrl+x (pause) is hidden by a longer chord", "unknown action stop", "x is bound to both play and pause"},
+		},
+	} {
+		k, warn := New(testDefs, c.overrides, c.leader)
+		if !reflect.DeepEqual(k.keys, c.keys) {
+			t.Errorf("%s: keys %v, want %v", c.name, k.keys, c.keys)
+		}
+		if !reflect.DeepEqual(warn, c.warn) {
+			t.Errorf("%s: warnings %q, want %q", c.name, warn, c.warn)
+		}
+	}
+
+	k, _ := New(testDefs, map[string][]string{"quit": {}}, "")
+	if k.First("quit") != "" || k.First("play") != "p" {
+		t.Errorf("First: quit %q, play %q", k.First("quit"), k.First("play"))
+	}
+	if h := k.HelpLines(); len(h) != len(testDefs) || !strings.HasPrefix(h[3], "—") || !strings.HasPrefix(h[0], "p · space") {
+		t.Errorf("help %q", h)
+	}
+}
+
+func TestFeed(t *testing.T) {
+	k, _ := New(testDefs, nil, "")
+	type step struct {
+		key, action string
+		wait        bool
+	}
+	for _, c := range []struct {
+		name  string
+		steps []step
+	}{
+		{"single key", []step{{"p", "play", false}}},
+		{"chord", []step{{"ctrl+x", "", true}, {"s", "save", false}}},
+		{"leader chord", []step{{`\`, "", true}, {"f", "search", false}}},
+		// a key that ends no chord starts over with that key
+		{"dead prefix", []step{{"ctrl+x", "", true}, {"p", "play", false}, {"s", "", false}}},
+		{"dead prefix into a chord", []step{{`\`, "", true}, {"ctrl+x", "", true}, {"q", "quit", false}}},
+		{"unbound", []step{{"z", "", false}, {"q", "quit", false}}},
+	} {
+		k.Reset()
+		for i, s := range c.steps {
+			a, wait := k.Feed(s.key)
+			if a != s.action || wait != s.wait {
+				t.Errorf("%s: key %d %q gave %q %v, want %q %v", c.name, i, s.key, a, wait, s.action, s.wait)
+			}
+		}
+	}
+
+	k.Reset()
+	k.Feed("ctrl+x")
+	if k.Typed() != "ctrl+x" {
+		t.Errorf("typed %q", k.Typed())
+	}
+	k.Reset()
+	if a, _ := k.Feed("s"); a != "" || k.Typed() != "" {
+		t.Errorf("Reset kept the chord: %q %q", a, k.Typed())
+	}
+}
@@ -0,0 +1,300 @@
+// Package libindex is the library index both players share: the tags of
+// every audio file under the library folders and the user's ratings.
+//
+// It lives in ~/.config/cy/library.json next to theme.json. Either player
+// may have it open while the other saves: Save and Sync merge with the
+// file, the newer rating of a track wins and tracks only the other player
+// added are kept.
+package libindex
+
+import (
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dhowden/tag"
+)
+
+// Meta is what the tags say about a track.
+type Meta struct {
+	Artist string `json:"artist,omitempty"`
+	Title  string `json:"title,omitempty"`
+	Album  string `json:"album,omitempty"`
+	Genre  string `json:"genre,omitempty"`
+	Year   int    `json:"year,omitempty"`
+}
+
+// Track is one file of the index. Tags are re-read whenever the file's
+// size or mtime changes; Rating and Loved belong to the user and survive
+// rescans, Rated is when they last changed. Length is the duration a
+// player measured.
+type Track struct {
+	Meta
+	Path    string  `json:"path"`
+	Size    int64   `json:"size"`
+	ModTime int64   `json:"mtime"`
+	Rating  int     `json:"rating,omitempty"`
+	Loved   bool    `json:"loved,omitempty"`
+	Rated   int64   `json:"rated,omitempty"`
+	Length  float64 `json:"length,omitempty"`
+}
+
+type Index struct {
+	Tracks  map[string]Track `json:"tracks"`
+	Scanned time.Time        `json:"scanned"`
+
+	// known are the paths the file had when it was last read or written,
+	// so a merge tells tracks this side dropped from ones the other added.
+	known   map[string]bool
+	modTime time.Time
+}
+
+// File is ~/.config/cy/library.json.
+func File() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "cy", "library.json")
+}
+
+// Load reads file; a missing or broken one gives an empty index.
+func Load(file string) *Index {
+	x := &Index{}
+	if d, err := os.ReadFile(file); err == nil {
+		_ = json.Unmarshal(d, x)
+	}
+	if x.Tracks == nil {
+		x.Tracks = map[string]Track{}
+	}
+	for p, t := range x.Tracks {
+		if t.Path == "" {
+			t.Path = p
+			x.Tracks[p] = t
+		}
+	}
+	x.remember(file)
+	return x
+}
+
+func (x *Index) remember(file string) {
+	x.known = make(map[string]bool, len(x.Tracks))
+	for p := range x.Tracks {
+		x.known[p] = true
+	}
+	if fi, err := os.Stat(file); err == nil {
+		x.modTime = fi.ModTime()
+	}
+}
+
+// merge takes in what the other player changed in disk since this index
+// last read or wrote the file.
+func (x *Index) merge(disk *Index) {
+	for p, d := range disk.Tracks {
+		t, ok := x.Tracks[p]
+		if !ok {
+			if !x.known[p] {
+				x.Tracks[p] = d
+			}
+			continue
+		}
+		if d.Rated > t.Rated {
+			t.Rating, t.Loved, t.Rated = d.Rating, d.Loved, d.Rated
+		}
+		if t.Length == 0 && t.Size == d.Size && t.ModTime == d.ModTime {
+			t.Length = d.Length
+		}
+		x.Tracks[p] = t
+	}
+}
+
+// Sync merges file into the index if it changed since it was last read or
+// written, and reports whether it did.
+func (x *Index) Sync(file string) bool {
+	fi, err := os.Stat(file)
+	if err != nil || fi.ModTime().Equal(x.modTime) {
+		return false
+	}
+	known := x.known
+	x.merge(Load(file))
+	x.known = known
+	x.modTime = fi.ModTime()
+	for p := range x.Tracks {
+		x.known[p] = true
+	}
+	return true
+}
+
+// Save merges file into the index and writes the result back.
+func (x *Index) Save(file string) error {
+	x.merge(Load(file))
+	d, err := json.Marshal(x)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	// cy and cyan share the file, so each write gets its own temp name
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".library-*.json")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(d)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	x.remember(file)
+	return nil
+}
+
+func (x *Index) Snapshot() map[string]Track {
+	out := make(map[string]Track, len(x.Tracks))
+	for k, v := range x.Tracks {
+		out[k] = v
+	}
+	return out
+}
+
+// Entry returns the track for path, reading a new one for files outside
+// the library folders so their ratings are kept too.
+func (x *Index) Entry(path string) Track {
+	if t, ok := x.Tracks[path]; ok {
+		return t
+	}
+	t := Track{Path: path, Meta: ReadMeta(path)}
+	if fi, err := os.Stat(path); err == nil {
+		t.Size, t.ModTime = fi.Size(), fi.ModTime().Unix()
+	}
+	return t
+}
+
+// Update applies fn to the track for path and stores it, stamping Rated
+// when the rating or the loved flag changed.
+func (x *Index) Update(path string, fn func(*Track)) Track {
+	t := x.Entry(path)
+	rating, loved := t.Rating, t.Loved
+	fn(&t)
+	if t.Rating != rating || t.Loved != loved {
+		t.Rated = time.Now().UnixNano()
+	}
+	x.Tracks[path] = t
+	return t
+}
+
+// Apply puts a fresh Scan of dirs in place. Ratings made while it ran are
+// kept, and so are measured lengths of unchanged files. Tracks outside
+// dirs, the other player's folders and rated files anywhere, stay while
+// the files exist.
+func (x *Index) Apply(dirs []string, scanned map[string]Track, now time.Time) {
+	for p, cur := range x.Tracks {
+		if t, ok := scanned[p]; ok {
+			t.Rating, t.Loved, t.Rated = cur.Rating, cur.Loved, cur.Rated
+			if t.Length == 0 && t.Size == cur.Size && t.ModTime == cur.ModTime {
+				t.Length = cur.Length
+			}
+			scanned[p] = t
+		} else if cur.Rating > 0 || cur.Loved || !under(dirs, p) {
+			if _, err := os.Stat(p); err == nil {
+				scanned[p] = cur
+			}
+		}
+	}
+	x.Tracks, x.Scanned = scanned, now
+}
+
+func under(dirs []string, path string) bool {
+	for _, d := range dirs {
+		if d, err := filepath.Abs(d); err == nil && strings.HasPrefix(path, d+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Scan walks dirs for files audio accepts, skipping hidden folders, and
+// returns the new tracks, reusing old entries whose files have not changed.
+func Scan(dirs []string, old map[string]Track, audio func(path string) bool) map[string]Track {
+	out := map[string]Track{}
+	for _, dir := range dirs {
+		_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if d.IsDir() {
+				if path != dir && strings.HasPrefix(d.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !audio(path) {
+				return nil
+			}
+			fi, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			abs, _ := filepath.Abs(path)
+			t, ok := old[abs]
+			if !ok || t.Size != fi.Size() || t.ModTime != fi.ModTime().Unix() {
+				t.Meta = ReadMeta(abs)
+				t.Path, t.Size, t.ModTime = abs, fi.Size(), fi.ModTime().Unix()
+				t.Length = 0
+			}
+			out[abs] = t
+			return nil
+		})
+	}
+	return out
+}
+
+// ReadMeta reads the file's tags, falling back to an "Artist - Title" file
+// name when there are none.
+func ReadMeta(path string) Meta {
+	var md Meta
+	if f, err := os.Open(path); err == nil {
+		if t, err := tag.ReadFrom(f); err == nil {
+			md = Meta{Artist: strings.TrimSpace(t.Artist()), Title: strings.TrimSpace(t.Title()), Album: strings.TrimSpace(t.Album()),
+				Genre: strings.TrimSpace(t.Genre()), Year: t.Year()}
+			if md.Artist == "" {
+				md.Artist = strings.TrimSpace(t.AlbumArtist())
+			}
+		}
+		f.Close()
+	}
+	if md.Artist == "" || md.Title == "" {
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		if a, t := SplitArtistTitle(name); a != "" {
+			if md.Artist == "" {
+				md.Artist = a
+			}
+			if md.Title == "" {
+				md.Title = t
+			}
+		}
+	}
+	return md
+}
+
+// SplitArtistTitle splits "Artist - Title", the shape of both ICY stream
+// titles and most hand-named files.
+func SplitArtistTitle(s string) (string, string) {
+	parts := strings.SplitN(s, " - ", 2)
+	if len(parts) != 2 {
+		return "", strings.TrimSpace(s)
+	}
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+}
@@ -0,0 +1,124 @@
+package libindex
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func touch(t *testing.T, path string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("not audio"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestTwoPlayers has cy and cyan keep the same file open and save in turn.
+func TestTwoPlayers(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "library.json")
+	a, b, c := filepath.Join(dir, "a.mp3"), filepath.Join(dir, "Кино - Группа крови.mp3"), filepath.Join(dir, "c.mp3")
+	for _, p := range []string{a, b, c} {
+		touch(t, p)
+	}
+	seed := Load(file)
+	seed.Update(a, func(t *Track) {})
+	seed.Update(c, func(t *Track) {})
+	if err := seed.Save(file); err != nil {
+		t.Fatal(err)
+	}
+
+	cy, cyan := Load(file), Load(file)
+	cy.Update(a, func(t *Track) { t.Rating = 3 })
+	if err := cy.Save(file); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	cyan.Update(a, func(t *Track) { t.Loved = true })
+	cyan.Update(b, func(t *Track) { t.Rating = 5 })
+	delete(cyan.Tracks, c)
+	if err := cyan.Save(file); err != nil {
+		t.Fatal(err)
+	}
+	if !cy.Sync(file) {
+		t.Fatal("Sync did not see cyan's save")
+	}
+	if cy.Sync(file) {
+		t.Error("Sync merged an unchanged file")
+	}
+
+	for name, x := range map[string]*Index{"cy": cy, "cyan": cyan, "disk": Load(file)} {
+		if got := x.Tracks[a]; got.Rating != 0 || !got.Loved {
+			t.Errorf("%s: a = %d/%v, want the newer change 0/true", name, got.Rating, got.Loved)
+		}
+		if got := x.Tracks[b]; got.Rating != 5 || got.Artist != "Кино" || got.Title != "Группа крови" {
+			t.Errorf("%s: b = %+v", name, got)
+		}
+		if name != "cy" {
+			if _, ok := x.Tracks[c]; ok {
+				t.Errorf("%s: c is back after cyan dropped it", name)
+			}
+		}
+	}
+
+	// each save writes its own temp file and leaves none behind
+	left, _ := filepath.Glob(filepath.Join(dir, ".library-*"))
+	if len(left) > 0 {
+		t.Errorf("temp files left: %v", left)
+	}
+}
+
+func TestLoadOldCyIndex(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "library.json")
+	old := `{"scanned":"2025-01-02T03:04:05Z","tracks":{"/music/a.flac":{"title":"Звезда","artist":"Кино","size":10,"mtime":20}}}`
+	if err := os.WriteFile(file, []byte(old), 0644); err != nil {
+		t.Fatal(err)
+	}
+	x := Load(file)
+	got := x.Tracks["/music/a.flac"]
+	if got.Path != "/music/a.flac" || got.Title != "Звезда" || got.Size != 10 || got.ModTime != 20 {
+		t.Errorf("track %+v", got)
+	}
+	if x.Scanned.IsZero() {
+		t.Error("scanned time lost")
+	}
+}
+
+func TestScanAndApply(t *testing.T) {
+	dir := t.TempDir()
+	music, other := filepath.Join(dir, "music"), filepath.Join(dir, "other")
+	kept, gone, hidden := filepath.Join(music, "a.mp3"), filepath.Join(music, "b.mp3"), filepath.Join(music, ".cache", "c.mp3")
+	outside, rated := filepath.Join(other, "d.mp3"), filepath.Join(other, "e.mp3")
+	for _, p := range []string{kept, gone, hidden, outside, rated, filepath.Join(music, "cover.jpg")} {
+		touch(t, p)
+	}
+	isAudio := func(p string) bool { return filepath.Ext(p) == ".mp3" }
+
+	x := Load(filepath.Join(dir, "library.json"))
+	x.Apply(nil, Scan([]string{music, other}, nil, isAudio), time.Now())
+	if len(x.Tracks) != 4 {
+		t.Fatalf("scanned %d tracks, want 4", len(x.Tracks))
+	}
+	x.Update(kept, func(t *Track) { t.Rating, t.Length = 4, 215 })
+	x.Update(rated, func(t *Track) { t.Loved = true })
+
+	_ = os.Remove(gone)
+	_ = os.Remove(rated)
+	x.Apply([]string{music}, Scan([]string{music}, x.Snapshot(), isAudio), time.Now())
+	if got := x.Tracks[kept]; got.Rating != 4 || got.Length != 215 {
+		t.Errorf("unchanged track lost its rating or length: %+v", got)
+	}
+	if _, ok := x.Tracks[gone]; ok {
+		t.Error("deleted file still indexed")
+	}
+	if _, ok := x.Tracks[outside]; !ok {
+		t.Error("track outside the scanned folders dropped")
+	}
+	if _, ok := x.Tracks[rated]; ok {
+		t.Error("deleted rated file still indexed")
+	}
+}
@@ -0,0 +1,292 @@
+// Package mpris publishes a player on the session bus as an MPRIS2
+// media player, for media keys and desktop widgets.
+package mpris
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"github.com/godbus/dbus/v5/prop"
+)
+
+const (
+	objectPath  = dbus.ObjectPath("/org/mpris/MediaPlayer2")
+	rootIface   = "org.mpris.MediaPlayer2"
+	playerIface = "org.mpris.MediaPlayer2.Player"
+	noTrack     = dbus.ObjectPath("/org/mpris/MediaPlayer2/TrackList/NoTrack")
+)
+
+// Status is the part of a player's state that MPRIS shows. Index is the
+// position in the playlist, -1 when there is none.
+type Status struct {
+	Title       string
+	Path        string
+	Index       int
+	Position    float64
+	Duration    float64
+	Paused      bool
+	Volume      int
+	StreamTitle string
+}
+
+// Backend is the player as seen from D-Bus: a status snapshot and the
+// control-socket commands (toggle, next, seek, volume ...).
+type Backend interface {
+	Status() Status
+	Do(cmd string, args ...string) error
+}
+
+type root struct{}
+
+func (root) Raise() *dbus.Error { return nil }
+func (root) Quit() *dbus.Error  { return nil }
+
+// Player is the object published on the bus; its exported methods are
+// the MPRIS player methods.
+type Player struct {
+	b       Backend
+	conn    *dbus.Conn
+	props   *prop.Properties
+	mu      sync.Mutex
+	last    Status
+	lastAt  time.Time
+	stopped chan struct{}
+}
+
+func dbusErr(err error) *dbus.Error {
+	if err == nil {
+		return nil
+	}
+	return dbus.MakeFailedError(err)
+}
+
+func (p *Player) Next() *dbus.Error      { return dbusErr(p.b.Do("next")) }
+func (p *Player) Previous() *dbus.Error  { return dbusErr(p.b.Do("prev")) }
+func (p *Player) Pause() *dbus.Error     { return dbusErr(p.b.Do("pause")) }
+func (p *Player) PlayPause() *dbus.Error { return dbusErr(p.b.Do("toggle")) }
+func (p *Player) Stop() *dbus.Error      { return dbusErr(p.b.Do("pause")) }
+func (p *Player) Play() *dbus.Error      { return dbusErr(p.b.Do("play")) }
+
+// SeekBy is exported as Seek; the Go name would clash with io.Seeker.
+func (p *Player) SeekBy(offset int64) *dbus.Error {
+	return dbusErr(p.b.Do("seek", fmt.Sprintf("%+.3f", float64(offset)/1e6)))
+}
+
+func (p *Player) SetPosition(track dbus.ObjectPath, pos int64) *dbus.Error {
+	p.mu.Lock()
+	cur := trackID(p.last)
+	p.mu.Unlock()
+	if track != cur || pos < 0 {
+		return nil
+	}
+	return dbusErr(p.b.Do("seek", strconv.FormatFloat(float64(pos)/1e6, 'f', 3, 64)))
+}
+
+func (p *Player) OpenUri(uri string) *dbus.Error {
+	if u, err := url.Parse(uri); err == nil && u.Scheme == "file" {
+		uri = u.Path
+	}
+	return dbusErr(p.b.Do("play", uri))
+}
+
+func trackID(st Status) dbus.ObjectPath {
+	if st.Path == "" {
+		return noTrack
+	}
+	if st.Index < 0 {
+		h := fnv.New32a()
+		h.Write([]byte(st.Path))
+		return dbus.ObjectPath(fmt.Sprintf("/org/mpris/MediaPlayer2/Track/f%08x", h.Sum32()))
+	}
+	return dbus.ObjectPath(fmt.Sprintf("/org/mpris/MediaPlayer2/Track/%d", st.Index))
+}
+
+func playbackStatus(st Status) string {
+	switch {
+	case st.Path == "":
+		return "Stopped"
+	case st.Paused:
+		return "Paused"
+	}
+	return "Playing"
+}
+
+func metadata(st Status) map[string]dbus.Variant {
+	md := map[string]dbus.Variant{"mpris:trackid": dbus.MakeVariant(trackID(st))}
+	if st.Path == "" {
+		return md
+	}
+	u := st.Path
+	if !strings.Contains(u, "://") {
+		u = (&url.URL{Scheme: "file", Path: u}).String()
+	}
+	md["xesam:url"] = dbus.MakeVariant(u)
+	md["xesam:title"] = dbus.MakeVariant(st.Title)
+	if st.StreamTitle != "" {
+		md["xesam:title"] = dbus.MakeVariant(st.StreamTitle)
+		md["xesam:album"] = dbus.MakeVariant(st.Title)
+	}
+	if st.Duration > 0 {
+		md["mpris:length"] = dbus.MakeVariant(int64(st.Duration * 1e6))
+	}
+	return md
+}
+
+// Start publishes the player on conn as org.mpris.MediaPlayer2.<name>
+// and keeps its properties in sync until Close. The backend is first asked
+// for status on the first tick, so it may not be serving yet.
+func Start(conn *dbus.Conn, name string, b Backend) (*Player, error) {
+	p := &Player{b: b, conn: conn, stopped: make(chan struct{}), lastAt: time.Now()}
+	st := p.last
+
+	props, err := prop.Export(conn, objectPath, prop.Map{
+		rootIface: {
+			"CanQuit":             {Value: false, Emit: prop.EmitTrue},
+			"CanRaise":            {Value: false, Emit: prop.EmitTrue},
+			"HasTrackList":        {Value: false, Emit: prop.EmitTrue},
+			"Identity":            {Value: name, Emit: prop.EmitTrue},
+			"SupportedUriSchemes": {Value: []string{"file", "http", "https"}, Emit: prop.EmitTrue},
+			"SupportedMimeTypes":  {Value: []string{"audio/mpeg", "audio/flac", "audio/ogg", "audio/x-wav", "audio/mp4", "audio/aac", "audio/opus"}, Emit: prop.EmitTrue},
+		},
+		playerIface: {
+			"PlaybackStatus": {Value: playbackStatus(st), Emit: prop.EmitTrue},
+			"Rate":           {Value: 1.0, Emit: prop.EmitTrue},
+			"MinimumRate":    {Value: 1.0, Emit: prop.EmitTrue},
+			"MaximumRate":    {Value: 1.0, Emit: prop.EmitTrue},
+			"Metadata":       {Value: metadata(st), Emit: prop.EmitTrue},
+			"Volume": {Value: float64(st.Volume) / 100, Writable: true, Emit: prop.EmitTrue, Callback: func(c *prop.Change) *dbus.Error {
+				v, ok := c.Value.(float64)
+				if !ok {
+					return dbus.MakeFailedError(fmt.Errorf("volume must be a double"))
+				}
+				if v < 0 {
+					v = 0
+				}
+				return dbusErr(b.Do("volume", strconv.Itoa(int(v*100+0.5))))
+			}},
+			"Position":      {Value: int64(st.Position * 1e6), Emit: prop.EmitFalse},
+			"CanGoNext":     {Value: true, Emit: prop.EmitTrue},
+			"CanGoPrevious": {Value: true, Emit: prop.EmitTrue},
+			"CanPlay":       {Value: true, Emit: prop.EmitTrue},
+			"CanPause":      {Value: true, Emit: prop.EmitTrue},
+			"CanSeek":       {Value: true, Emit: prop.EmitTrue},
+			"CanControl":    {Value: true, Emit: prop.EmitFalse},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	p.props = props
+	if err := conn.Export(root{}, objectPath, rootIface); err != nil {
+		return nil, err
+	}
+	if err := conn.ExportWithMap(p, map[string]string{"SeekBy": "Seek"}, objectPath, playerIface); err != nil {
+		return nil, err
+	}
+	methods := introspect.Methods(p)
+	for i := range methods {
+		if methods[i].Name == "SeekBy" {
+			methods[i].Name = "Seek"
+		}
+	}
+	node := &introspect.Node{
+		Name: string(objectPath),
+		Interfaces: []introspect.Interface{
+			introspect.IntrospectData,
+			prop.IntrospectData,
+			{Name: rootIface, Methods: introspect.Methods(root{}), Properties: props.Introspection(rootIface)},
+			{
+				Name:       playerIface,
+				Methods:    methods,
+				Properties: props.Introspection(playerIface),
+				Signals:    []introspect.Signal{{Name: "Seeked", Args: []introspect.Arg{{Name: "Position", Type: "x"}}}},
+			},
+		},
+	}
+	if err := conn.Export(introspect.NewIntrospectable(node), objectPath, "org.freedesktop.DBus.Introspectable"); err != nil {
+		return nil, err
+	}
+
+	busName := rootIface + "." + name
+	reply, err := conn.RequestName(busName, dbus.NameFlagDoNotQueue)
+	if err == nil && reply != dbus.RequestNameReplyPrimaryOwner {
+		reply, err = conn.RequestName(fmt.Sprintf("%s.instance%d", busName, os.Getpid()), dbus.NameFlagDoNotQueue)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		return nil, fmt.Errorf("cannot own %s", busName)
+	}
+
+	go p.watch()
+	return p, nil
+}
+
+func (p *Player) watch() {
+	t := time.NewTicker(500 * time.Millisecond)
+	defer t.Stop()
+	for {
+		select {
+		case <-p.stopped:
+			return
+		case now := <-t.C:
+			p.update(p.b.Status(), now)
+		}
+	}
+}
+
+func (p *Player) update(st Status, now time.Time) {
+	prev := p.last
+	if s := playbackStatus(st); s != playbackStatus(prev) {
+		p.props.SetMust(playerIface, "PlaybackStatus", s)
+	}
+	if md := metadata(st); !reflect.DeepEqual(md, metadata(prev)) {
+		p.props.SetMust(playerIface, "Metadata", md)
+	}
+	if st.Volume != prev.Volume {
+		p.props.SetMust(playerIface, "Volume", float64(st.Volume)/100)
+	}
+	pos := int64(st.Position * 1e6)
+	p.props.SetMust(playerIface, "Position", pos)
+
+	expected := prev.Position
+	if !prev.Paused {
+		expected += now.Sub(p.lastAt).Seconds()
+	}
+	if st.Path == prev.Path && st.Path != "" && (st.Position-expected > 2 || expected-st.Position > 2) {
+		p.conn.Emit(objectPath, playerIface+".Seeked", pos)
+	}
+	p.mu.Lock()
+	p.last, p.lastAt = st, now
+	p.mu.Unlock()
+}
+
+func (p *Player) Close() {
+	close(p.stopped)
+	p.conn.Close()
+}
+
+// Serve is a no-op when there is no session bus (ssh, tty, daemon
+// started before login); the returned func always releases whatever it got.
+func Serve(name string, b Backend) func() {
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return func() {}
+	}
+	p, err := Start(conn, name, b)
+	if err != nil {
+		conn.Close()
+		return func() {}
+	}
+	return p.Close
+}
@@ -0,0 +1,170 @@
+package mpris
+
+import (
+	"bufio"
+	"os/exec"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// privateBus starts a session bus of its own for the test and returns its
+// address.
+func privateBus(t *testing.T) string {
+	bin, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon is not installed")
+	}
+	cmd := exec.Command(bin, "--session", "--nofork", "--print-address")
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+	addr, err := bufio.NewReader(out).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(addr)
+}
+
+type fakeBackend struct {
+	mu    sync.Mutex
+	st    Status
+	calls []string
+}
+
+func (b *fakeBackend) Status() Status {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.st
+}
+
+func (b *fakeBackend) Do(cmd string, args ...string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.calls = append(b.calls, strings.TrimSpace(cmd+" "+strings.Join(args, " ")))
+	if cmd == "toggle" {
+		b.st.Paused = !b.st.Paused
+	}
+	return nil
+}
+
+func (b *fakeBackend) called() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.calls...)
+}
+
+// waitProp polls a property until it has the wanted value; the player
+// picks up status changes on its own ticker.
+func waitProp(t *testing.T, obj dbus.BusObject, name string, want interface{}) {
+	t.Helper()
+	var got interface{}
+	for end := time.Now().Add(3 * time.Second); time.Now().Before(end); time.Sleep(50 * time.Millisecond) {
+		v, err := obj.GetProperty(playerIface + "." + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got = v.Value(); reflect.DeepEqual(got, want) {
+			return
+		}
+	}
+	t.Fatalf("%s = %v, want %v", name, got, want)
+}
+
+func TestPlayerOnPrivateBus(t *testing.T) {
+	addr := privateBus(t)
+	server, err := dbus.Connect(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &fakeBackend{st: Status{Title: "Звезда по имени Солнце", Path: "/music/kino/star.mp3", Index: 2, Duration: 225.5, Volume: 40, Position: 10}}
+	p, err := Start(server, "test", b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	client, err := dbus.Connect(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	obj := client.Object(rootIface+".test", objectPath)
+
+	waitProp(t, obj, "PlaybackStatus", "Playing")
+
+	v, err := obj.GetProperty(playerIface + ".Metadata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	md, ok := v.Value().(map[string]dbus.Variant)
+	if !ok {
+		t.Fatalf("Metadata is %T", v.Value())
+	}
+	want := map[string]interface{}{
+		"mpris:trackid": dbus.ObjectPath("/org/mpris/MediaPlayer2/Track/2"),
+		"xesam:title":   "Звезда по имени Солнце",
+		"xesam:url":     "file:///music/kino/star.mp3",
+		"mpris:length":  int64(225500000),
+	}
+	for k, w := range want {
+		if got := md[k].Value(); !reflect.DeepEqual(got, w) {
+			t.Errorf("Metadata[%s] = %#v, want %#v", k, got, w)
+		}
+	}
+	waitProp(t, obj, "Volume", 0.4)
+
+	if err := obj.Call(playerIface+".PlayPause", 0).Err; err != nil {
+		t.Fatal(err)
+	}
+	waitProp(t, obj, "PlaybackStatus", "Paused")
+	if err := obj.Call(playerIface+".Seek", 0, int64(-5000000)).Err; err != nil {
+		t.Fatal(err)
+	}
+	if got := b.called(); !reflect.DeepEqual(got, []string{"toggle", "seek -5.000"}) {
+		t.Errorf("backend got %q", got)
+	}
+}
+
+func TestPlaybackStatus(t *testing.T) {
+	for _, c := range []struct {
+		st   Status
+		want string
+	}{
+		{Status{}, "Stopped"},
+		{Status{Path: "/a.mp3"}, "Playing"},
+		{Status{Path: "/a.mp3", Paused: true}, "Paused"},
+	} {
+		if got := playbackStatus(c.st); got != c.want {
+			t.Errorf("playbackStatus(%+v) = %s, want %s", c.st, got, c.want)
+		}
+	}
+}
+
+func TestStreamMetadata(t *testing.T) {
+	md := metadata(Status{Title: "Radio", Path: "http://radio.example/live", Index: -1, StreamTitle: "Artist - Song"})
+	if md["xesam:title"].Value() != "Artist - Song" || md["xesam:album"].Value() != "Radio" {
+		t.Errorf("stream metadata %v", md)
+	}
+	if md["xesam:url"].Value() != "http://radio.example/live" {
+		t.Errorf("url %v", md["xesam:url"])
+	}
+	if _, ok := md["mpris:length"]; ok {
+		t.Error("a stream has no length")
+	}
+	if id := md["mpris:trackid"].Value().(dbus.ObjectPath); !strings.HasPrefix(string(id), "/org/mpris/MediaPlayer2/Track/f") {
+		t.Errorf("trackid %s", id)
+	}
+}
@@ -0,0 +1,164 @@
+// Package stream is what both players know about internet streams: why
+// mpv ended a file and when to load a dropped stream again.
+package stream
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	reconnectBase   = time.Second
+	reconnectMax    = time.Minute
+	reconnectStable = 30 * time.Second
+)
+
+// EndReason mirrors mpv_end_file_reason.
+type EndReason int
+
+const (
+	EndEOF      EndReason = 0
+	EndStop     EndReason = 2
+	EndQuit     EndReason = 3
+	EndError    EndReason = 4
+	EndRedirect EndReason = 5
+)
+
+func (r EndReason) String() string {
+	switch r {
+	case EndEOF:
+		return "eof"
+	case EndStop:
+		return "stopped"
+	case EndQuit:
+		return "quit"
+	case EndError:
+		return "error"
+	case EndRedirect:
+		return "redirect"
+	}
+	return "unknown"
+}
+
+// EndEvent is an MPV_EVENT_END_FILE; Err is mpv's text for EndError.
+type EndEvent struct {
+	Reason EndReason
+	Err    string
+}
+
+func (e EndEvent) String() string {
+	if e.Err != "" {
+		return e.Reason.String() + ": " + e.Err
+	}
+	return e.Reason.String()
+}
+
+// IsStream tells a URL from a file path.
+func IsStream(path string) bool {
+	return strings.Contains(path, "://")
+}
+
+// Reconnector schedules stream reloads with exponential backoff. The backoff
+// starts over once a stream has stayed up for reconnectStable.
+type Reconnector struct {
+	Attempt  int
+	at       time.Time
+	loadedAt time.Time
+}
+
+// Loaded records that the stream started playing at now.
+func (r *Reconnector) Loaded(now time.Time) {
+	r.loadedAt = now
+}
+
+// Schedule plans the next reload after a drop at now and returns the wait.
+func (r *Reconnector) Schedule(now time.Time) time.Duration {
+	if !r.loadedAt.IsZero() && now.Sub(r.loadedAt) >= reconnectStable {
+		r.Attempt = 0
+	}
+	d := reconnectMax
+	if r.Attempt < 16 && reconnectBase<<r.Attempt < reconnectMax {
+		d = reconnectBase << r.Attempt
+	}
+	r.Attempt++
+	r.at = now.Add(d)
+	r.loadedAt = time.Time{}
+	return d
+}
+
+// Pending reports whether a reload is scheduled.
+func (r *Reconnector) Pending() bool {
+	return !r.at.IsZero()
+}
+
+// Due reports whether the scheduled reload should happen at now.
+func (r *Reconnector) Due(now time.Time) bool {
+	return r.Pending() && !now.Before(r.at)
+}
+
+// Cancel drops the scheduled reload but keeps the backoff.
+func (r *Reconnector) Cancel() {
+	r.at = time.Time{}
+}
+
+// Reset forgets the backoff as well.
+func (r *Reconnector) Reset() {
+	*r = Reconnector{}
+}
+
+// Watch is what a player does when mpv ends a file: a dropped stream is
+// loaded again with backoff, unless the player has moved to another track
+// by the time the reload is due.
+type Watch struct {
+	rc    Reconnector
+	track string
+}
+
+// Loaded records that the current track started playing at now.
+func (w *Watch) Loaded(now time.Time) {
+	w.rc.Loaded(now)
+}
+
+// Ended handles mpv ending track at now. For a stream it schedules a reload
+// and returns the wait and true; for a file it forgets the backoff and
+// returns false, leaving the error or the next track to the player. Ends
+// other than EOF and errors are the player's own doing and are ignored.
+func (w *Watch) Ended(track string, e EndEvent, now time.Time) (time.Duration, bool) {
+	if e.Reason != EndEOF && e.Reason != EndError {
+		return 0, false
+	}
+	if !IsStream(track) {
+		w.rc.Reset()
+		return 0, false
+	}
+	w.track = track
+	return w.rc.Schedule(now), true
+}
+
+// Due reports whether the reload should happen now that the player is on
+// track; it is reported once. Another track drops the reload and the
+// backoff.
+func (w *Watch) Due(track string, now time.Time) bool {
+	if !w.rc.Pending() {
+		return false
+	}
+	if track != w.track {
+		w.rc.Reset()
+		return false
+	}
+	if !w.rc.Due(now) {
+		return false
+	}
+	w.rc.Cancel()
+	return true
+}
+
+// Pending reports whether a reload is scheduled.
+func (w *Watch) Pending() bool {
+	return w.rc.Pending()
+}
+
+// Attempt is the number of the last scheduled reload.
+func (w *Watch) Attempt() int {
+	return w.rc.Attempt
+}
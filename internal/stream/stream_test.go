@@ -0,0 +1,153 @@
+package stream
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// dropServer serves the start of an MP3 and then drops the connection
+// without finishing the body, the way a radio server does when it dies.
+func dropServer(t *testing.T) (*httptest.Server, *int32) {
+	var conns int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&conns, 1)
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Header().Set("Content-Length", "1048576")
+		frame := append([]byte{0xff, 0xfb, 0x90, 0x64}, make([]byte, 413)...)
+		for i := 0; i < 8; i++ {
+			_, _ = w.Write(frame)
+		}
+		w.(http.Flusher).Flush()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		_ = conn.Close()
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &conns
+}
+
+// play reads the stream until it drops and returns the end mpv reports
+// for it: a server that goes away looks like the end of the file.
+func play(t *testing.T, url string) EndEvent {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	n, err := io.Copy(io.Discard, resp.Body)
+	if err == nil {
+		t.Fatal("the stream ended cleanly, want a drop")
+	}
+	if n == 0 {
+		t.Fatal("no audio before the drop")
+	}
+	return EndEvent{Reason: EndEOF}
+}
+
+func TestReconnectAfterDrop(t *testing.T) {
+	srv, conns := dropServer(t)
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	var w Watch
+	var waits []time.Duration
+	// each due reload connects again and the stream drops again
+	for i := 0; i < 8; i++ {
+		w.Loaded(now)
+		e := play(t, srv.URL)
+		now = now.Add(time.Second)
+		d, ok := w.Ended(srv.URL, e, now)
+		if !ok {
+			t.Fatalf("drop #%d not reloaded", i+1)
+		}
+		waits = append(waits, d)
+		if w.Due(srv.URL, now.Add(d-time.Millisecond)) {
+			t.Fatalf("reload #%d due before %s", w.Attempt(), d)
+		}
+		now = now.Add(d)
+		if !w.Due(srv.URL, now) {
+			t.Fatalf("reload #%d not due after %s", w.Attempt(), d)
+		}
+		if w.Due(srv.URL, now) || w.Pending() {
+			t.Fatalf("reload #%d reported twice", w.Attempt())
+		}
+	}
+	want := []time.Duration{1, 2, 4, 8, 16, 32, 60, 60}
+	for i := range want {
+		want[i] *= time.Second
+	}
+	if !reflect.DeepEqual(waits, want) {
+		t.Errorf("backoff %v, want %v", waits, want)
+	}
+	if got := atomic.LoadInt32(conns); got != 8 {
+		t.Errorf("%d connections, want 8", got)
+	}
+
+	// moving to another track before the reload drops it with the backoff
+	d, _ := w.Ended(srv.URL, play(t, srv.URL), now)
+	if w.Due("http://radio.example/jazz", now.Add(d)) || w.Pending() || w.Attempt() != 0 {
+		t.Errorf("track change left pending=%v attempt=%d", w.Pending(), w.Attempt())
+	}
+	if d, ok := w.Ended(srv.URL, play(t, srv.URL), now); !ok || d != time.Second {
+		t.Errorf("first drop after a track change waits %s, want 1s", d)
+	}
+
+	// a file that ends or fails is the player's to handle
+	for _, e := range []EndEvent{{Reason: EndEOF}, {Reason: EndError, Err: "loading failed"}} {
+		if _, ok := w.Ended("/music/a.mp3", e, now); ok || w.Pending() || w.Attempt() != 0 {
+			t.Errorf("%s of a file scheduled a reload", e)
+		}
+	}
+	// and so is a stream the player stopped itself
+	if _, ok := w.Ended(srv.URL, EndEvent{Reason: EndStop}, now); ok || w.Pending() {
+		t.Error("stopping a stream scheduled a reload")
+	}
+}
+
+func TestReconnectorStable(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	var r Reconnector
+	for i := 0; i < 3; i++ {
+		r.Schedule(now)
+	}
+	r.Cancel()
+	if r.Pending() || r.Attempt != 3 {
+		t.Fatalf("Cancel left pending=%v attempt=%d", r.Pending(), r.Attempt)
+	}
+	r.Loaded(now)
+	if d := r.Schedule(now.Add(reconnectStable - time.Second)); d != 8*time.Second {
+		t.Errorf("a short run waits %s, want 8s", d)
+	}
+	r.Loaded(now)
+	if d := r.Schedule(now.Add(reconnectStable)); d != time.Second {
+		t.Errorf("after a stable run the wait is %s, want 1s", d)
+	}
+	r.Reset()
+	if r.Pending() || r.Attempt != 0 {
+		t.Errorf("Reset left %+v", r)
+	}
+}
+
+func TestEndEvent(t *testing.T) {
+	for _, c := range []struct {
+		e    EndEvent
+		want string
+	}{
+		{EndEvent{Reason: EndEOF}, "eof"},
+		{EndEvent{Reason: EndError, Err: "loading failed"}, "error: loading failed"},
+		{EndEvent{Reason: 9}, "unknown"},
+	} {
+		if got := c.e.String(); got != c.want {
+			t.Errorf("%#v = %q, want %q", c.e, got, c.want)
+		}
+	}
+	if !IsStream("https://radio.example/live") || IsStream("/music/a.mp3") {
+		t.Error("IsStream")
+	}
+}
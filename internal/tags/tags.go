@@ -0,0 +1,814 @@
+// Package tags reads and rewrites the tag blocks of MP3 (ID3v2), FLAC and
+// Ogg files in place, for the tag editor and rating write-back.
+package tags
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+var ErrFormat = errors.New("cannot write tags to this kind of file")
+
+// ---- ID3v2 ----------------------------------------------------------------
+
+type id3Frame struct {
+	ID    string
+	Flags uint16
+	Data  []byte
+}
+
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
+
+func putSyncsafe(b []byte, n int) {
+	b[0], b[1], b[2], b[3] = byte(n>>21&0x7f), byte(n>>14&0x7f), byte(n>>7&0x7f), byte(n&0x7f)
+}
+
+func deunsync(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		out = append(out, b[i])
+		if b[i] == 0xff && i+1 < len(b) && b[i+1] == 0 {
+			i++
+		}
+	}
+	return out
+}
+
+// readID3 parses the ID3v2.3/2.4 tag at the start of r. It returns the
+// frames and the length of the whole tag in the file (0 when there is none).
+// v2.3 frames come back in their v2.4 form so they can be written out again.
+func readID3(r io.ReaderAt) ([]id3Frame, int64, error) {
+	hdr := make([]byte, 10)
+	if _, err := r.ReadAt(hdr, 0); err != nil || string(hdr[:3]) != "ID3" {
+		return nil, 0, nil
+	}
+	ver, flags, size := hdr[3], hdr[5], syncsafe(hdr[6:10])
+	total := int64(10 + size)
+	if flags&0x10 != 0 {
+		total += 10
+	}
+	if ver != 3 && ver != 4 {
+		return nil, 0, fmt.Errorf("ID3v2.%d tags are not supported", ver)
+	}
+	body := make([]byte, size)
+	if _, err := r.ReadAt(body, 10); err != nil {
+		return nil, 0, err
+	}
+	if ver == 3 && flags&0x80 != 0 {
+		body = deunsync(body)
+	}
+	if flags&0x40 != 0 && len(body) >= 4 {
+		n := int(binary.BigEndian.Uint32(body)) + 4
+		if ver == 4 {
+			n = syncsafe(body)
+		}
+		if n > len(body) {
+			return nil, 0, errors.New("corrupt ID3 extended header")
+		}
+		body = body[n:]
+	}
+
+	var frames []id3Frame
+	for len(body) >= 10 && body[0] != 0 {
+		id := string(body[:4])
+		n := int(binary.BigEndian.Uint32(body[4:8]))
+		if ver == 4 {
+			n = syncsafe(body[4:8])
+		}
+		fl := binary.BigEndian.Uint16(body[8:10])
+		if n < 0 || 10+n > len(body) {
+			return nil, 0, fmt.Errorf("corrupt ID3 frame %q", id)
+		}
+		data := append([]byte(nil), body[10:10+n]...)
+		body = body[10+n:]
+		if ver == 3 {
+			if fl&0x00e0 != 0 {
+				return nil, 0, fmt.Errorf("compressed or encrypted ID3v2.3 frame %q", id)
+			}
+			fl = 0
+			switch id {
+			case "TYER":
+				id = "TDRC"
+			case "TDAT", "TIME", "TRDA", "TSIZ":
+				continue
+			}
+		}
+		frames = append(frames, id3Frame{id, fl, data})
+	}
+	return frames, total, nil
+}
+
+// encodeID3 builds an ID3v2.4 tag with some padding so later edits by other
+// tools can happen in place.
+func encodeID3(frames []id3Frame) []byte {
+	var body bytes.Buffer
+	for _, f := range frames {
+		h := make([]byte, 10)
+		copy(h, f.ID)
+		putSyncsafe(h[4:8], len(f.Data))
+		binary.BigEndian.PutUint16(h[8:], f.Flags)
+		body.Write(h)
+		body.Write(f.Data)
+	}
+	body.Write(make([]byte, 1024))
+	hdr := []byte{'I', 'D', '3', 4, 0, 0, 0, 0, 0, 0}
+	putSyncsafe(hdr[6:], body.Len())
+	return append(hdr, body.Bytes()...)
+}
+
+// DecodeText decodes an ID3 text payload in encoding enc.
+func DecodeText(enc byte, b []byte) string {
+	switch enc {
+	case 1, 2:
+		if len(b) < 2 {
+			return ""
+		}
+		be := enc == 2
+		if b[0] == 0xff && b[1] == 0xfe {
+			b, be = b[2:], false
+		} else if b[0] == 0xfe && b[1] == 0xff {
+			b, be = b[2:], true
+		}
+		u := make([]uint16, 0, len(b)/2)
+		for i := 0; i+1 < len(b); i += 2 {
+			if be {
+				u = append(u, uint16(b[i])<<8|uint16(b[i+1]))
+			} else {
+				u = append(u, uint16(b[i+1])<<8|uint16(b[i]))
+			}
+		}
+		return strings.TrimRight(string(utf16.Decode(u)), "\x00")
+	case 0:
+		r := make([]rune, len(b))
+		for i, c := range b {
+			r[i] = rune(c)
+		}
+		return strings.TrimRight(string(r), "\x00")
+	}
+	return strings.TrimRight(string(b), "\x00")
+}
+
+// SplitText splits a TXXX-style "description\0value" payload.
+func SplitText(data []byte) (string, string) {
+	if len(data) == 0 {
+		return "", ""
+	}
+	enc, b := data[0], data[1:]
+	term := []byte{0}
+	if enc == 1 || enc == 2 {
+		for i := 0; i+1 < len(b); i += 2 {
+			if b[i] == 0 && b[i+1] == 0 {
+				return DecodeText(enc, b[:i]), DecodeText(enc, b[i+2:])
+			}
+		}
+		return DecodeText(enc, b), ""
+	}
+	if i := bytes.Index(b, term); i >= 0 {
+		return DecodeText(enc, b[:i]), DecodeText(enc, b[i+1:])
+	}
+	return DecodeText(enc, b), ""
+}
+
+func txxxFrame(desc, value string) id3Frame {
+	return id3Frame{ID: "TXXX", Data: []byte("\x03" + desc + "\x00" + value)}
+}
+
+// setTXXX replaces the user text frame desc; an empty value removes it.
+func setTXXX(frames []id3Frame, desc, value string) []id3Frame {
+	out := frames[:0:0]
+	for _, f := range frames {
+		if f.ID == "TXXX" {
+			if d, _ := SplitText(f.Data); strings.EqualFold(d, desc) {
+				continue
+			}
+		}
+		out = append(out, f)
+	}
+	if value != "" {
+		out = append(out, txxxFrame(desc, value))
+	}
+	return out
+}
+
+// popmByte maps 0–5 stars to the POPM scale most players agree on.
+var popmByte = [6]byte{0, 1, 64, 128, 196, 255}
+
+func setPOPM(frames []id3Frame, rating int) []id3Frame {
+	found := false
+	for i, f := range frames {
+		if f.ID != "POPM" {
+			continue
+		}
+		if j := bytes.IndexByte(f.Data, 0); j >= 0 && j+1 < len(f.Data) {
+			d := append([]byte(nil), f.Data...)
+			d[j+1] = popmByte[rating]
+			frames[i].Data, found = d, true
+		}
+	}
+	if !found && rating > 0 {
+		frames = append(frames, id3Frame{ID: "POPM", Data: append([]byte("cyan\x00"), popmByte[rating])})
+	}
+	return frames
+}
+
+// ---- FLAC -----------------------------------------------------------------
+
+type flacBlock struct {
+	Type byte
+	Data []byte
+}
+
+const flacVorbisComment = 4
+
+func readFLAC(r io.ReaderAt) ([]flacBlock, int64, error) {
+	magic := make([]byte, 4)
+	if _, err := r.ReadAt(magic, 0); err != nil || string(magic) != "fLaC" {
+		return nil, 0, errors.New("not a FLAC file")
+	}
+	var blocks []flacBlock
+	off := int64(4)
+	for {
+		h := make([]byte, 4)
+		if _, err := r.ReadAt(h, off); err != nil {
+			return nil, 0, err
+		}
+		n := int(h[1])<<16 | int(h[2])<<8 | int(h[3])
+		b := flacBlock{Type: h[0] & 0x7f, Data: make([]byte, n)}
+		if _, err := r.ReadAt(b.Data, off+4); err != nil {
+			return nil, 0, err
+		}
+		blocks = append(blocks, b)
+		off += 4 + int64(n)
+		if h[0]&0x80 != 0 {
+			return blocks, off, nil
+		}
+	}
+}
+
+func encodeFLAC(blocks []flacBlock) []byte {
+	out := []byte("fLaC")
+	for i, b := range blocks {
+		t := b.Type
+		if i == len(blocks)-1 {
+			t |= 0x80
+		}
+		n := len(b.Data)
+		out = append(out, t, byte(n>>16), byte(n>>8), byte(n))
+		out = append(out, b.Data...)
+	}
+	return out
+}
+
+// vorbisComments is the comment header shared by FLAC and Ogg streams.
+type vorbisComments struct {
+	Vendor string
+	Items  []string
+}
+
+func parseVorbisComments(b []byte) (vorbisComments, error) {
+	var vc vorbisComments
+	bad := errors.New("corrupt Vorbis comment block")
+	next := func() (string, bool) {
+		if len(b) < 4 {
+			return "", false
+		}
+		n := int(binary.LittleEndian.Uint32(b))
+		if n < 0 || 4+n > len(b) {
+			return "", false
+		}
+		s := string(b[4 : 4+n])
+		b = b[4+n:]
+		return s, true
+	}
+	var ok bool
+	if vc.Vendor, ok = next(); !ok || len(b) < 4 {
+		return vc, bad
+	}
+	count := int(binary.LittleEndian.Uint32(b))
+	b = b[4:]
+	for i := 0; i < count; i++ {
+		s, ok := next()
+		if !ok {
+			return vc, bad
+		}
+		vc.Items = append(vc.Items, s)
+	}
+	return vc, nil
+}
+
+func (vc vorbisComments) encode() []byte {
+	var out bytes.Buffer
+	w := func(s string) {
+		_ = binary.Write(&out, binary.LittleEndian, uint32(len(s)))
+		out.WriteString(s)
+	}
+	w(vc.Vendor)
+	_ = binary.Write(&out, binary.LittleEndian, uint32(len(vc.Items)))
+	for _, it := range vc.Items {
+		w(it)
+	}
+	return out.Bytes()
+}
+
+func (vc vorbisComments) get(key string) string {
+	for _, it := range vc.Items {
+		if k, v, ok := strings.Cut(it, "="); ok && strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return ""
+}
+
+// set replaces every KEY=... entry; an empty value just removes them.
+func (vc *vorbisComments) set(key, value string) {
+	out := vc.Items[:0:0]
+	for _, it := range vc.Items {
+		if k, _, _ := strings.Cut(it, "="); !strings.EqualFold(k, key) {
+			out = append(out, it)
+		}
+	}
+	if value != "" {
+		out = append(out, strings.ToUpper(key)+"="+value)
+	}
+	vc.Items = out
+}
+
+func flacComments(blocks []flacBlock) (vorbisComments, int, error) {
+	for i, b := range blocks {
+		if b.Type == flacVorbisComment {
+			vc, err := parseVorbisComments(b.Data)
+			return vc, i, err
+		}
+	}
+	return vorbisComments{Vendor: "cyan"}, -1, nil
+}
+
+func setFLACComments(blocks []flacBlock, idx int, vc vorbisComments) []flacBlock {
+	b := flacBlock{Type: flacVorbisComment, Data: vc.encode()}
+	if idx >= 0 {
+		blocks[idx] = b
+		return blocks
+	}
+	// right after STREAMINFO, which must stay first
+	return append(blocks[:1], append([]flacBlock{b}, blocks[1:]...)...)
+}
+
+// ---- Ogg ------------------------------------------------------------------
+
+type oggPage struct {
+	Flags   byte
+	Granule uint64
+	Serial  uint32
+	Seq     uint32
+	Lacing  []byte
+	Data    []byte
+}
+
+var oggCRC = func() (t [256]uint32) {
+	for i := range t {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+		t[i] = r
+	}
+	return t
+}()
+
+func readOggPage(r io.ReaderAt, off int64) (oggPage, int64, error) {
+	h := make([]byte, 27)
+	if _, err := r.ReadAt(h, off); err != nil {
+		return oggPage{}, 0, err
+	}
+	if string(h[:4]) != "OggS" {
+		return oggPage{}, 0, errors.New("corrupt Ogg page")
+	}
+	p := oggPage{Flags: h[5], Granule: binary.LittleEndian.Uint64(h[6:]), Serial: binary.LittleEndian.Uint32(h[14:]),
+		Seq: binary.LittleEndian.Uint32(h[18:]), Lacing: make([]byte, h[26])}
+	if _, err := r.ReadAt(p.Lacing, off+27); err != nil {
+		return oggPage{}, 0, err
+	}
+	n := 0
+	for _, l := range p.Lacing {
+		n += int(l)
+	}
+	p.Data = make([]byte, n)
+	if _, err := r.ReadAt(p.Data, off+27+int64(len(p.Lacing))); err != nil {
+		return oggPage{}, 0, err
+	}
+	return p, 27 + int64(len(p.Lacing)) + int64(n), nil
+}
+
+func (p oggPage) encode() []byte {
+	b := make([]byte, 27, 27+len(p.Lacing)+len(p.Data))
+	copy(b, "OggS")
+	b[5] = p.Flags
+	binary.LittleEndian.PutUint64(b[6:], p.Granule)
+	binary.LittleEndian.PutUint32(b[14:], p.Serial)
+	binary.LittleEndian.PutUint32(b[18:], p.Seq)
+	b[26] = byte(len(p.Lacing))
+	b = append(append(b, p.Lacing...), p.Data...)
+	var crc uint32
+	for _, c := range b {
+		crc = crc<<8 ^ oggCRC[byte(crc>>24)^c]
+	}
+	binary.LittleEndian.PutUint32(b[22:], crc)
+	return b
+}
+
+// oggHeaders holds the header packets at the start of an Ogg Vorbis or Opus
+// stream; the second one is the comment packet. Both formats end the
+// headers on a page boundary, so they can be repaginated on their own.
+type oggHeaders struct {
+	Serial  uint32
+	Packets [][]byte
+	Pages   int
+	Len     int64
+}
+
+func readOggHeaders(r io.ReaderAt) (oggHeaders, error) {
+	var h oggHeaders
+	var pkt []byte
+	want := 0
+	for want == 0 || len(h.Packets) < want {
+		p, n, err := readOggPage(r, h.Len)
+		if err != nil {
+			return h, err
+		}
+		if h.Pages > 0 && p.Serial != h.Serial {
+			return h, errors.New("multiplexed Ogg streams are not supported")
+		}
+		h.Serial, h.Len, h.Pages = p.Serial, h.Len+n, h.Pages+1
+		data := p.Data
+		for _, l := range p.Lacing {
+			pkt, data = append(pkt, data[:l]...), data[l:]
+			if l == 255 {
+				continue
+			}
+			h.Packets, pkt = append(h.Packets, pkt), nil
+			if want == 0 {
+				switch {
+				case bytes.HasPrefix(h.Packets[0], []byte("\x01vorbis")):
+					want = 3
+				case bytes.HasPrefix(h.Packets[0], []byte("OpusHead")):
+					want = 2
+				default:
+					return h, ErrFormat
+				}
+			}
+		}
+	}
+	if len(h.Packets) != want || pkt != nil {
+		return h, errors.New("audio data shares a page with the Ogg headers")
+	}
+	return h, nil
+}
+
+func (h oggHeaders) commentPrefix() string {
+	if bytes.HasPrefix(h.Packets[0], []byte("OpusHead")) {
+		return "OpusTags"
+	}
+	return "\x03vorbis"
+}
+
+// comments parses the comment packet; the bytes after the comment list (the
+// Vorbis framing bit, Opus padding) are returned so they survive a rewrite.
+func (h oggHeaders) comments() (vorbisComments, []byte, error) {
+	pre := h.commentPrefix()
+	if !bytes.HasPrefix(h.Packets[1], []byte(pre)) {
+		return vorbisComments{}, nil, errors.New("Ogg comment header is missing")
+	}
+	b := h.Packets[1][len(pre):]
+	vc, err := parseVorbisComments(b)
+	if err != nil {
+		return vc, nil, err
+	}
+	return vc, b[len(vc.encode()):], nil
+}
+
+// pages lays the header packets out again: the identification packet alone
+// on the first page, the rest packed into as few pages as they need.
+func (h oggHeaders) pages() []oggPage {
+	out := []oggPage{{Flags: 2, Serial: h.Serial, Lacing: oggLacing(len(h.Packets[0])), Data: h.Packets[0]}}
+	cur := oggPage{}
+	flush := func(cont bool) {
+		cur.Serial, cur.Seq = h.Serial, uint32(len(out))
+		// a page on which no packet ends carries no granule position
+		cur.Granule = ^uint64(0)
+		for _, l := range cur.Lacing {
+			if l < 255 {
+				cur.Granule = 0
+			}
+		}
+		out = append(out, cur)
+		cur = oggPage{}
+		if cont {
+			cur.Flags = 1
+		}
+	}
+	for _, pkt := range h.Packets[1:] {
+		lace := oggLacing(len(pkt))
+		for len(lace) > 0 {
+			if len(cur.Lacing) == 255 {
+				// the next page continues a packet only if this one ends
+				// inside it
+				flush(cur.Lacing[254] == 255)
+			}
+			take := 255 - len(cur.Lacing)
+			if take > len(lace) {
+				take = len(lace)
+			}
+			n := 0
+			for _, l := range lace[:take] {
+				n += int(l)
+			}
+			cur.Lacing, cur.Data = append(cur.Lacing, lace[:take]...), append(cur.Data, pkt[:n]...)
+			lace, pkt = lace[take:], pkt[n:]
+		}
+	}
+	flush(false)
+	return out
+}
+
+func oggLacing(n int) []byte {
+	l := bytes.Repeat([]byte{255}, n/255)
+	return append(l, byte(n%255))
+}
+
+// writeOggHeaders swaps the header pages of path for h. When the page count
+// changes, every later page of the stream is renumbered and re-checksummed.
+func writeOggHeaders(path string, h oggHeaders) error {
+	var head []byte
+	pages := h.pages()
+	for _, p := range pages {
+		head = append(head, p.encode()...)
+	}
+	delta := len(pages) - h.Pages
+	if delta == 0 {
+		return ReplaceHead(path, h.Len, head)
+	}
+	return rewriteFile(path, func(w io.Writer, src io.ReaderAt, size int64) error {
+		if _, err := w.Write(head); err != nil {
+			return err
+		}
+		for off := h.Len; off < size; {
+			p, n, err := readOggPage(src, off)
+			if err != nil {
+				return err
+			}
+			if p.Serial == h.Serial {
+				p.Seq = uint32(int64(p.Seq) + int64(delta))
+			}
+			if _, err := w.Write(p.encode()); err != nil {
+				return err
+			}
+			off += n
+		}
+		return nil
+	})
+}
+
+// ---- editing ----------------------------------------------------------------
+
+// Field is a tag the editor offers, with its ID3v2.4 frame and Vorbis
+// comment names.
+type Field struct{ Name, ID3, Vorbis string }
+
+// Fields are the fields the tag editor offers.
+var Fields = []Field{
+	{"title", "TIT2", "TITLE"},
+	{"artist", "TPE1", "ARTIST"},
+	{"album", "TALB", "ALBUM"},
+	{"albumartist", "TPE2", "ALBUMARTIST"},
+	{"track", "TRCK", "TRACKNUMBER"},
+	{"disc", "TPOS", "DISCNUMBER"},
+	{"year", "TDRC", "DATE"},
+	{"genre", "TCON", "GENRE"},
+}
+
+func fieldNames(name string) (string, string, bool) {
+	for _, f := range Fields {
+		if f.Name == name {
+			return f.ID3, f.Vorbis, true
+		}
+	}
+	return "", "", false
+}
+
+// File is a track's tag block read for editing; Save writes it back.
+type File struct {
+	path   string
+	head   int64
+	frames []id3Frame
+	blocks []flacBlock
+	ogg    *oggHeaders
+	vc     vorbisComments
+	vcIdx  int
+	vcTail []byte
+}
+
+func Open(path string) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	t := &File{path: path}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mp3":
+		t.frames, t.head, err = readID3(f)
+	case ".flac":
+		if t.blocks, t.head, err = readFLAC(f); err == nil {
+			t.vc, t.vcIdx, err = flacComments(t.blocks)
+		}
+	case ".ogg", ".oga", ".opus":
+		var h oggHeaders
+		if h, err = readOggHeaders(f); err == nil {
+			t.ogg, t.head = &h, h.Len
+			t.vc, t.vcTail, err = h.comments()
+		}
+	default:
+		return nil, ErrFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Frames is the data of the ID3 frames with id, nil for other formats.
+func (t *File) Frames(id string) [][]byte {
+	var out [][]byte
+	for _, f := range t.frames {
+		if f.ID == id {
+			out = append(out, f.Data)
+		}
+	}
+	return out
+}
+
+// Comment is a Vorbis comment of a FLAC or Ogg file.
+func (t *File) Comment(key string) string {
+	return t.vc.get(key)
+}
+
+func (t *File) Path() string {
+	return t.path
+}
+
+func (t *File) IsID3() bool {
+	return t.blocks == nil && t.ogg == nil
+}
+
+func (t *File) Get(field string) string {
+	id, key, ok := fieldNames(field)
+	if !ok {
+		return ""
+	}
+	if !t.IsID3() {
+		return t.vc.get(key)
+	}
+	for _, f := range t.frames {
+		if f.ID == id && len(f.Data) > 0 {
+			return strings.ReplaceAll(DecodeText(f.Data[0], f.Data[1:]), "\x00", "; ")
+		}
+	}
+	return ""
+}
+
+// set replaces a field; an empty value removes it.
+func (t *File) Set(field, value string) {
+	id, key, ok := fieldNames(field)
+	if !ok {
+		return
+	}
+	if !t.IsID3() {
+		t.vc.set(key, value)
+		return
+	}
+	out := t.frames[:0:0]
+	for _, f := range t.frames {
+		if f.ID != id {
+			out = append(out, f)
+		}
+	}
+	if value != "" {
+		out = append(out, id3Frame{ID: id, Data: append([]byte{3}, value...)})
+	}
+	t.frames = out
+}
+
+func (t *File) Save() error {
+	switch {
+	case t.ogg != nil:
+		h := *t.ogg
+		h.Packets = append([][]byte(nil), h.Packets...)
+		h.Packets[1] = append(append([]byte(h.commentPrefix()), t.vc.encode()...), t.vcTail...)
+		return writeOggHeaders(t.path, h)
+	case t.blocks != nil:
+		blocks := setFLACComments(append([]flacBlock(nil), t.blocks...), t.vcIdx, t.vc)
+		return ReplaceHead(t.path, t.head, encodeFLAC(blocks))
+	}
+	return ReplaceHead(t.path, t.head, encodeID3(t.frames))
+}
+
+// ---- writing ----------------------------------------------------------------
+
+// rewriteFile atomically replaces path with what write produces from the
+// old contents: the new file is written next to the old one and renamed over
+// it, so a crash never leaves a half-written track behind.
+func rewriteFile(path string, write func(w io.Writer, src io.ReaderAt, size int64) error) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	fi, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	ok := false
+	defer func() {
+		if !ok {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	w := bufio.NewWriter(tmp)
+	if err := write(w, src, fi.Size()); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := tmp.Chmod(fi.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	ok = true
+	return nil
+}
+
+// ReplaceHead swaps the first skip bytes of path for head.
+func ReplaceHead(path string, skip int64, head []byte) error {
+	return rewriteFile(path, func(w io.Writer, src io.ReaderAt, size int64) error {
+		if _, err := w.Write(head); err != nil {
+			return err
+		}
+		_, err := io.Copy(w, io.NewSectionReader(src, skip, size-skip))
+		return err
+	})
+}
+
+func fmpsRating(rating int) string {
+	if rating <= 0 {
+		return ""
+	}
+	return strconv.FormatFloat(float64(rating)/5, 'f', 1, 64)
+}
+
+// WriteRating stores 0–5 stars in the file itself: POPM plus
+// TXXX:FMPS_Rating in MP3s, FMPS_RATING in FLAC and Ogg. 0 clears the rating.
+func WriteRating(path string, rating int) error {
+	if rating < 0 || rating > 5 {
+		return fmt.Errorf("rating %d out of range", rating)
+	}
+	t, err := Open(path)
+	if err != nil {
+		return err
+	}
+	if t.IsID3() {
+		t.frames = setPOPM(t.frames, rating)
+		t.frames = setTXXX(t.frames, "FMPS_Rating", fmpsRating(rating))
+	} else {
+		t.vc.set("FMPS_RATING", fmpsRating(rating))
+	}
+	return t.Save()
+}
@@ -0,0 +1,391 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// audio stands in for the frames after the tags; it must come through
+// every rewrite unchanged.
+var audio = bytes.Repeat([]byte{0xff, 0xfb, 0x90, 0x64, 0x00, 0x0f}, 500)
+
+func writeTemp(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func readAll(t *testing.T, path string) []byte {
+	t.Helper()
+	d, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// id3v23 builds an ID3v2.3 tag, whose frame sizes are plain big-endian.
+func id3v23(frames ...id3Frame) []byte {
+	var body bytes.Buffer
+	for _, f := range frames {
+		body.WriteString(f.ID)
+		_ = binary.Write(&body, binary.BigEndian, uint32(len(f.Data)))
+		body.Write([]byte{0, 0})
+		body.Write(f.Data)
+	}
+	body.Write(make([]byte, 16))
+	hdr := []byte{'I', 'D', '3', 3, 0, 0, 0, 0, 0, 0}
+	putSyncsafe(hdr[6:], body.Len())
+	return append(hdr, body.Bytes()...)
+}
+
+func utf16Frame(id, s string) id3Frame {
+	d := []byte{1, 0xff, 0xfe}
+	for _, r := range s {
+		d = append(d, byte(r), byte(r>>8))
+	}
+	return id3Frame{ID: id, Data: d}
+}
+
+func TestID3v23Rewrite(t *testing.T) {
+	path := writeTemp(t, "song.mp3", append(id3v23(
+		utf16Frame("TIT2", "Группа крови"),
+		id3Frame{ID: "TPE1", Data: []byte("\x00Kino")},
+		id3Frame{ID: "TYER", Data: []byte("\x001988")},
+		id3Frame{ID: "TDAT", Data: []byte("\x000101")},
+	), audio...))
+
+	f, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Get("title") != "Группа крови" || f.Get("year") != "1988" {
+		t.Fatalf("read title %q year %q", f.Get("title"), f.Get("year"))
+	}
+	f.Set("album", "Группа крови")
+	f.Set("artist", "Кино")
+	if err := f.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	d := readAll(t, path)
+	if d[3] != 4 {
+		t.Errorf("tag version 2.%d, want 2.4", d[3])
+	}
+	if !bytes.HasSuffix(d, audio) {
+		t.Error("audio changed")
+	}
+	frames, head, err := readID3(bytes.NewReader(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int(head)+len(audio) != len(d) {
+		t.Errorf("tag is %d bytes of %d, audio %d", head, len(d), len(audio))
+	}
+	var ids []string
+	for _, fr := range frames {
+		ids = append(ids, fr.ID)
+	}
+	if want := []string{"TIT2", "TDRC", "TALB", "TPE1"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("frames %v, want %v", ids, want)
+	}
+	g, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for field, want := range map[string]string{"title": "Группа крови", "artist": "Кино", "album": "Группа крови", "year": "1988"} {
+		if got := g.Get(field); got != want {
+			t.Errorf("%s = %q, want %q", field, got, want)
+		}
+	}
+}
+
+func flacFile(blocks ...flacBlock) []byte {
+	info := flacBlock{Type: 0, Data: bytes.Repeat([]byte{0x12, 0x34}, 17)}
+	return append(encodeFLAC(append([]flacBlock{info}, blocks...)), audio...)
+}
+
+func TestFLACComments(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		blocks []flacBlock
+		types  []byte
+	}{
+		{"added after STREAMINFO", []flacBlock{{Type: 1, Data: make([]byte, 100)}}, []byte{0, flacVorbisComment, 1}},
+		{"replaced in place", []flacBlock{
+			{Type: 3, Data: make([]byte, 18)},
+			{Type: flacVorbisComment, Data: vorbisComments{"ref", []string{"ARTIST=Aquarium", "artist=Аквариум", "TITLE=Город золотой"}}.encode()},
+			{Type: 1, Data: make([]byte, 10)},
+		}, []byte{0, 3, flacVorbisComment, 1}},
+	} {
+		path := writeTemp(t, "song.flac", flacFile(c.blocks...))
+		f, err := Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Set("artist", "Кино")
+		f.Set("year", "1988")
+		if err := f.Save(); err != nil {
+			t.Fatal(err)
+		}
+
+		d := readAll(t, path)
+		blocks, head, err := readFLAC(bytes.NewReader(d))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var types []byte
+		for _, b := range blocks {
+			types = append(types, b.Type)
+		}
+		if !reflect.DeepEqual(types, c.types) {
+			t.Errorf("%s: blocks %v, want %v", c.name, types, c.types)
+		}
+		if !bytes.Equal(blocks[0].Data, bytes.Repeat([]byte{0x12, 0x34}, 17)) || !bytes.Equal(d[head:], audio) {
+			t.Errorf("%s: STREAMINFO or audio changed", c.name)
+		}
+		vc, _, _ := flacComments(blocks)
+		var artists []string
+		for _, it := range vc.Items {
+			if k, v, _ := strings.Cut(it, "="); strings.EqualFold(k, "artist") {
+				artists = append(artists, v)
+			}
+		}
+		if !reflect.DeepEqual(artists, []string{"Кино"}) || vc.get("DATE") != "1988" {
+			t.Errorf("%s: comments %q", c.name, vc.Items)
+		}
+		if c.name == "replaced in place" && (vc.Vendor != "ref" || vc.get("title") != "Город золотой") {
+			t.Errorf("%s: other comments lost: %q %q", c.name, vc.Vendor, vc.Items)
+		}
+	}
+}
+
+// oggStream lays packets out one after another, each starting a page of
+// its own and spanning as many pages as it needs.
+func oggStream(serial uint32, seq *uint32, packets [][]byte, last bool) []byte {
+	var out []byte
+	for i, pkt := range packets {
+		lace := oggLacing(len(pkt))
+		for first := true; len(lace) > 0; first = false {
+			n := len(lace)
+			if n > 255 {
+				n = 255
+			}
+			size := 0
+			for _, l := range lace[:n] {
+				size += int(l)
+			}
+			p := oggPage{Serial: serial, Seq: *seq, Lacing: lace[:n], Data: pkt[:size], Granule: ^uint64(0)}
+			if lace[n-1] < 255 {
+				p.Granule = uint64(*seq) * 1024
+			}
+			if *seq == 0 {
+				p.Flags |= 2
+			}
+			if !first {
+				p.Flags |= 1
+			}
+			if last && i == len(packets)-1 && n == len(lace) {
+				p.Flags |= 4
+			}
+			out = append(out, p.encode()...)
+			lace, pkt = lace[n:], pkt[size:]
+			*seq++
+		}
+	}
+	return out
+}
+
+func oggFile(comment vorbisComments) (data []byte, headers, audioPackets [][]byte) {
+	headers = [][]byte{
+		append([]byte("\x01vorbis"), bytes.Repeat([]byte{2}, 23)...),
+		append(append([]byte("\x03vorbis"), comment.encode()...), 1),
+		append([]byte("\x05vorbis"), bytes.Repeat([]byte{5}, 3000)...),
+	}
+	audioPackets = [][]byte{bytes.Repeat([]byte{7}, 1000), bytes.Repeat([]byte{8}, 70000), bytes.Repeat([]byte{9}, 300)}
+	var seq uint32
+	data = oggStream(42, &seq, headers[:1], false)
+	data = append(data, oggStream(42, &seq, headers[1:], false)...)
+	data = append(data, oggStream(42, &seq, audioPackets, true)...)
+	return data, headers, audioPackets
+}
+
+// readOgg checks every page of path, its checksum, sequence number and
+// continuation flag, and returns the packets.
+func readOgg(t *testing.T, path string) [][]byte {
+	t.Helper()
+	d := readAll(t, path)
+	r := bytes.NewReader(d)
+	var packets [][]byte
+	var pkt []byte
+	open := false
+	for off, i := int64(0), uint32(0); off < int64(len(d)); i++ {
+		p, n, err := readOggPage(r, off)
+		if err != nil {
+			t.Fatalf("page %d: %v", i, err)
+		}
+		if !bytes.Equal(p.encode(), d[off:off+n]) {
+			t.Errorf("page %d: bad checksum", i)
+		}
+		if p.Seq != i || p.Serial != 42 {
+			t.Errorf("page %d: sequence %d serial %d", i, p.Seq, p.Serial)
+		}
+		if (p.Flags&1 != 0) != open {
+			t.Fatalf("page %d: continued flag %v, but a packet is open: %v", i, p.Flags&1 != 0, open)
+		}
+		data := p.Data
+		for _, l := range p.Lacing {
+			pkt, data = append(pkt, data[:l]...), data[l:]
+			if open = l == 255; !open {
+				packets, pkt = append(packets, pkt), nil
+			}
+		}
+		off += n
+	}
+	if open {
+		t.Error("the stream ends inside a packet")
+	}
+	return packets
+}
+
+func TestOggRepaginate(t *testing.T) {
+	vc := vorbisComments{"Xiph.Org libVorbis I 20200704", []string{"TITLE=Кукушка", "ARTIST=Кино"}}
+	for _, c := range []struct {
+		name string
+		size int // of the comment packet
+	}{
+		{"over 64 KiB", 70000},
+		// 254*255 bytes and up need exactly 255 lacing values, the last
+		// one ending the packet on the page's final segment
+		{"ends on the 255th segment", 254*255 + 100},
+	} {
+		data, headers, audioPackets := oggFile(vc)
+		path := writeTemp(t, "song.ogg", data)
+		f, err := Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Get("title") != "Кукушка" {
+			t.Fatalf("title %q", f.Get("title"))
+		}
+		// the album comment adds its value plus 4+len("ALBUM=") bytes
+		base := len(f.ogg.commentPrefix()) + len(f.vc.encode()) + len(f.vcTail) + 4 + len("ALBUM=")
+		long := strings.Repeat("x", c.size-base)
+		f.Set("album", long)
+		if err := f.Save(); err != nil {
+			t.Fatal(err)
+		}
+
+		packets := readOgg(t, path)
+		if len(packets) != 6 {
+			t.Fatalf("%s: %d packets, want 6", c.name, len(packets))
+		}
+		if got := len(packets[1]); got != c.size {
+			t.Errorf("%s: comment packet is %d bytes, want %d", c.name, got, c.size)
+		}
+		if !bytes.Equal(packets[0], headers[0]) || !bytes.Equal(packets[2], headers[2]) {
+			t.Errorf("%s: identification or setup header changed", c.name)
+		}
+		for i, p := range audioPackets {
+			if !bytes.Equal(packets[3+i], p) {
+				t.Errorf("%s: audio packet %d changed", c.name, i)
+			}
+		}
+		g, err := Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if g.Get("album") != long || g.Get("artist") != "Кино" {
+			t.Errorf("%s: album %d bytes, artist %q", c.name, len(g.Get("album")), g.Get("artist"))
+		}
+	}
+}
+
+func TestOggPagesBoundary(t *testing.T) {
+	h := oggHeaders{Serial: 1, Packets: [][]byte{
+		[]byte("\x01vorbis"),
+		bytes.Repeat([]byte{3}, 254*255),
+		[]byte("\x05vorbis"),
+	}}
+	pages := h.pages()
+	if len(pages) != 3 {
+		t.Fatalf("%d pages, want 3", len(pages))
+	}
+	if len(pages[1].Lacing) != 255 || pages[1].Lacing[254] != 0 {
+		t.Errorf("comment page lacing ends %d after %d values", pages[1].Lacing[len(pages[1].Lacing)-1], len(pages[1].Lacing))
+	}
+	if pages[2].Flags&1 != 0 {
+		t.Error("the setup header's page is marked as a continuation")
+	}
+
+	h.Packets[1] = bytes.Repeat([]byte{3}, 255*255)
+	if pages = h.pages(); len(pages) != 3 || pages[2].Flags&1 == 0 {
+		t.Errorf("a packet going on past the page is not continued: %d pages", len(pages))
+	}
+}
+
+func TestWriteRating(t *testing.T) {
+	popm := id3Frame{ID: "POPM", Data: []byte("foo@example.org\x00\x32\x00\x00\x00\x07")}
+	mp3 := writeTemp(t, "rated.mp3", append(encodeID3([]id3Frame{popm, txxxFrame("FMPS_Rating", "0.2")}), audio...))
+	bare := writeTemp(t, "bare.mp3", audio)
+	flac := writeTemp(t, "rated.flac", flacFile())
+
+	id3 := func(path string) (popm [][]byte, fmps []string) {
+		f, err := Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, d := range f.Frames("TXXX") {
+			if desc, v := SplitText(d); desc == "FMPS_Rating" {
+				fmps = append(fmps, v)
+			}
+		}
+		return f.Frames("POPM"), fmps
+	}
+
+	if err := WriteRating(mp3, 4); err != nil {
+		t.Fatal(err)
+	}
+	p, fmps := id3(mp3)
+	if len(p) != 1 || !bytes.Equal(p[0], []byte("foo@example.org\x00\xc4\x00\x00\x00\x07")) || !reflect.DeepEqual(fmps, []string{"0.8"}) {
+		t.Errorf("rated 4: POPM %q, FMPS %q", p, fmps)
+	}
+	if err := WriteRating(mp3, 0); err != nil {
+		t.Fatal(err)
+	}
+	if p, fmps = id3(mp3); len(p) != 1 || p[0][16] != 0 || fmps != nil {
+		t.Errorf("cleared: POPM %q, FMPS %q", p, fmps)
+	}
+	if !bytes.HasSuffix(readAll(t, mp3), audio) {
+		t.Error("audio changed")
+	}
+
+	if err := WriteRating(bare, 5); err != nil {
+		t.Fatal(err)
+	}
+	if p, fmps = id3(bare); len(p) != 1 || string(p[0]) != "cyan\x00\xff" || !reflect.DeepEqual(fmps, []string{"1.0"}) {
+		t.Errorf("untagged file: POPM %q, FMPS %q", p, fmps)
+	}
+
+	if err := WriteRating(flac, 3); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Open(flac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := f.Comment("FMPS_RATING"); got != "0.6" {
+		t.Errorf("FLAC FMPS_RATING %q", got)
+	}
+	if err := WriteRating(flac, 6); err == nil {
+		t.Error("rating 6 accepted")
+	}
+}
@@ -0,0 +1,224 @@
+// Package theme is the look both players share.
+//
+// Both players read ~/.config/cy/theme.json, next to the shared library
+// index, and reload it when it changes. "preset" picks one of Presets and
+// every other key overrides it; colours are #RRGGBB, #RGB, an ANSI number
+// 0-255 or one of colorNames.
+package theme
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Glyphs are the symbols drawn in lists and bars.
+type Glyphs struct {
+	Up        string `json:"up,omitempty"`
+	Dir       string `json:"dir,omitempty"`
+	File      string `json:"file,omitempty"`
+	Playlist  string `json:"playlist,omitempty"`
+	Playing   string `json:"playing,omitempty"`
+	Mark      string `json:"mark,omitempty"`
+	Bar       string `json:"bar,omitempty"`
+	Star      string `json:"star,omitempty"`
+	StarEmpty string `json:"star_empty,omitempty"`
+	Loved     string `json:"loved,omitempty"`
+}
+
+// Theme is a set of colours, a border style and glyphs.
+type Theme struct {
+	Preset      string `json:"preset,omitempty"`
+	Accent      string `json:"accent,omitempty"`
+	AccentText  string `json:"accent_text,omitempty"`
+	Text        string `json:"text,omitempty"`
+	Background  string `json:"background,omitempty"`
+	Dim         string `json:"dim,omitempty"`
+	Border      string `json:"border,omitempty"`
+	Title       string `json:"title,omitempty"`
+	CursorBg    string `json:"cursor_bg,omitempty"`
+	CursorText  string `json:"cursor_text,omitempty"`
+	BarEmpty    string `json:"bar_empty,omitempty"`
+	Warm        string `json:"warm,omitempty"`
+	Hot         string `json:"hot,omitempty"`
+	InputBg     string `json:"input_bg,omitempty"`
+	InputText   string `json:"input_text,omitempty"`
+	StatusBg    string `json:"status_bg,omitempty"`
+	StatusText  string `json:"status_text,omitempty"`
+	BorderStyle string `json:"border_style,omitempty"`
+	Glyphs      Glyphs `json:"glyphs"`
+}
+
+// Presets are the themes a "preset" key can name.
+var Presets = map[string]Theme{
+	"cyan": {
+		Accent: "#00FFFF", AccentText: "#000000", Dim: "#666666", Border: "#333333",
+		CursorBg: "#005555", CursorText: "#FFFFFF", BarEmpty: "#444444", Warm: "#FFCC00", Hot: "#FF5555",
+		InputBg: "0", InputText: "15", StatusText: "#00FFFF", BorderStyle: "rounded",
+	},
+	"fzi": {
+		Accent: "11", AccentText: "0", Dim: "8", Border: "8",
+		CursorBg: "12", CursorText: "15", BarEmpty: "8", Warm: "11", Hot: "9",
+		InputBg: "0", InputText: "15", StatusText: "11", BorderStyle: "normal",
+	},
+	"nord": {
+		Accent: "#88C0D0", AccentText: "#2E3440", Text: "#D8DEE9", Dim: "#4C566A", Border: "#3B4252",
+		CursorBg: "#434C5E", CursorText: "#ECEFF4", BarEmpty: "#3B4252", Warm: "#EBCB8B", Hot: "#BF616A",
+		InputBg: "#3B4252", InputText: "#ECEFF4", StatusText: "#88C0D0", BorderStyle: "rounded",
+	},
+	"gruvbox": {
+		Accent: "#FABD2F", AccentText: "#282828", Text: "#EBDBB2", Dim: "#928374", Border: "#504945",
+		CursorBg: "#504945", CursorText: "#FBF1C7", BarEmpty: "#3C3836", Warm: "#FE8019", Hot: "#FB4934",
+		InputBg: "#3C3836", InputText: "#EBDBB2", StatusText: "#FABD2F", BorderStyle: "normal",
+	},
+	"dracula": {
+		Accent: "#BD93F9", AccentText: "#282A36", Text: "#F8F8F2", Dim: "#6272A4", Border: "#44475A",
+		CursorBg: "#44475A", CursorText: "#F8F8F2", BarEmpty: "#44475A", Warm: "#F1FA8C", Hot: "#FF5555",
+		InputBg: "#282A36", InputText: "#F8F8F2", StatusText: "#FF79C6", BorderStyle: "rounded",
+	},
+	"solarized": {
+		Accent: "#268BD2", AccentText: "#FDF6E3", Text: "#839496", Dim: "#586E75", Border: "#073642",
+		CursorBg: "#073642", CursorText: "#EEE8D5", BarEmpty: "#073642", Warm: "#B58900", Hot: "#DC322F",
+		InputBg: "#002B36", InputText: "#93A1A1", StatusText: "#2AA198", BorderStyle: "normal",
+	},
+	// mono suits terminals without colour or emoji fonts
+	"mono": {
+		Accent: "15", AccentText: "0", Dim: "8", Border: "8",
+		CursorBg: "15", CursorText: "0", BarEmpty: "8", Warm: "7", Hot: "15",
+		InputBg: "0", InputText: "15", StatusText: "7", BorderStyle: "normal",
+		Glyphs: Glyphs{Up: ".. ", Dir: "+ ", File: "-", Playlist: "= ", Playing: ">", Mark: "* ",
+			Bar: "=", Star: "*", StarEmpty: ".", Loved: "<3"},
+	},
+}
+
+// colorNames are the names cy has always accepted, as ANSI colours.
+var colorNames = map[string]string{
+	"black": "0", "white": "15", "red": "9", "green": "2", "yellow": "11",
+	"blue": "12", "teal": "6", "aqua": "14", "gray": "8", "grey": "8", "purple": "5",
+}
+
+// PresetNames lists the presets in order.
+func PresetNames() []string {
+	var names []string
+	for n := range Presets {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NormalizeColor turns a theme colour into "#RRGGBB" or an ANSI number;
+// "" means the terminal's own colour.
+func NormalizeColor(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if n, ok := colorNames[s]; ok {
+		return n
+	}
+	if n, err := strconv.Atoi(s); err == nil && n >= 0 && n <= 255 {
+		return s
+	}
+	if len(s) == 4 && s[0] == '#' {
+		s = "#" + s[1:2] + s[1:2] + s[2:3] + s[2:3] + s[3:4] + s[3:4]
+	}
+	if len(s) == 7 && s[0] == '#' {
+		if _, err := strconv.ParseUint(s[1:], 16, 32); err == nil {
+			return strings.ToUpper(s)
+		}
+	}
+	return ""
+}
+
+// overlay copies the keys set in src onto t.
+func (t *Theme) overlay(src Theme) {
+	d, _ := json.Marshal(src)
+	_ = json.Unmarshal(d, t)
+}
+
+// WithPreset lays a preset over t; an unknown name is an error.
+func (t Theme) WithPreset(name string) (Theme, error) {
+	if name == "" {
+		return t, nil
+	}
+	p, ok := Presets[name]
+	if !ok {
+		return t, fmt.Errorf("unknown theme %q (have %s)", name, strings.Join(PresetNames(), ", "))
+	}
+	t.overlay(p)
+	t.Preset = name
+	return t, nil
+}
+
+// File is the shared theme file.
+func File() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "cy", "theme.json")
+}
+
+// Load lays the theme file over base: first its preset, then its own
+// keys. A missing file leaves base as it is.
+func Load(base Theme) (Theme, error) {
+	d, err := os.ReadFile(File())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return base, nil
+		}
+		return base, err
+	}
+	var file Theme
+	if err := json.Unmarshal(d, &file); err != nil {
+		return base, fmt.Errorf("%s: %v", filepath.Base(File()), err)
+	}
+	t, err := base.WithPreset(file.Preset)
+	t.overlay(file)
+	return t, err
+}
+
+// SavePreset switches the theme file to a preset and keeps the other
+// keys in it; every running player picks the change up.
+func SavePreset(name string) error {
+	if _, ok := Presets[name]; !ok {
+		_, err := Theme{}.WithPreset(name)
+		return err
+	}
+	raw := map[string]json.RawMessage{}
+	p := File()
+	if d, err := os.ReadFile(p); err == nil {
+		if err := json.Unmarshal(d, &raw); err != nil {
+			return err
+		}
+	}
+	raw["preset"], _ = json.Marshal(name)
+	d, _ := json.MarshalIndent(raw, "", "  ")
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(p, append(d, '\n'), 0644)
+}
+
+// Watcher notices when the theme file is written, created or removed.
+type Watcher struct {
+	mod  time.Time
+	size int64
+}
+
+// Changed reports whether the file differs from the last call.
+func (w *Watcher) Changed() bool {
+	var mod time.Time
+	var size int64 = -1
+	if fi, err := os.Stat(File()); err == nil {
+		mod, size = fi.ModTime(), fi.Size()
+	}
+	if mod.Equal(w.mod) && size == w.size {
+		return false
+	}
+	w.mod, w.size = mod, size
+	return true
+}